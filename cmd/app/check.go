@@ -0,0 +1,48 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/spf13/cobra"
+
+	"prometheus-deepflow-adapter/cmd/app/options"
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+func newCheckConfigCommand(s *options.Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "check-config",
+		Short: "validate config and print the effective config",
+		Long: "check-config loads config file, command-line args and env the same way as adapter does, " +
+			"prints the effective config and exits non-zero if config is invalid",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newConfigLoader(s.Conf, cmd.Flags()).load()
+			if c != nil {
+				out, merr := yaml.Parser().Marshal(config.Redact(c.Raw))
+				if merr != nil {
+					return merr
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s", out)
+			}
+			if c != nil {
+				for _, key := range sortedKeys(c.RenamedKeys) {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s: renamed to %s\n", key, c.RenamedKeys[key])
+				}
+			}
+			if err != nil {
+				if errs, ok := err.(config.ValidationErrors); ok {
+					for _, e := range errs {
+						fmt.Fprintln(cmd.ErrOrStderr(), e)
+					}
+					return fmt.Errorf("found %d error(s) in config %s", len(errs), s.Conf)
+				}
+				return err
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "config %s is valid\n", s.Conf)
+			return nil
+		},
+		SilenceUsage: true,
+	}
+}
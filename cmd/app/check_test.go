@@ -0,0 +1,61 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"prometheus-deepflow-adapter/cmd/app/options"
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+// checkConfig runs check-config on the config file, it returns stdout, stderr and the error of the command
+func checkConfig(t *testing.T, file string, args ...string) (string, string, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := newCheckConfigCommand(&options.Options{Conf: path})
+	cmd.Flags().AddFlagSet(config.NewConfig().ToOptions())
+	var stdout, stderr bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), stderr.String(), err
+}
+
+func TestCheckConfig(t *testing.T) {
+	stdout, stderr, err := checkConfig(t, testConfigFile+"admin: {username: admin, passwd: hunter2}\n", "--log-level", "warn")
+	if err != nil {
+		t.Fatalf("valid config: %v, stderr: %s", err, stderr)
+	}
+	if !strings.Contains(stderr, "is valid") {
+		t.Errorf("stderr = %q, want the config is valid", stderr)
+	}
+	if !strings.Contains(stdout, "log-level: warn") || !strings.Contains(stdout, "username: admin") {
+		t.Errorf("stdout doesn't print the effective config:\n%s", stdout)
+	}
+	if strings.Contains(stdout, "hunter2") {
+		t.Errorf("stdout exposes admin.passwd:\n%s", stdout)
+	}
+}
+
+func TestCheckConfigInvalid(t *testing.T) {
+	stdout, stderr, err := checkConfig(t, testConfigFile+"trace: {tlsconfig: {ca-file: /ca.pem}}\n", "--port", "0", "--log-level", "trace")
+	if err == nil || !strings.HasPrefix(err.Error(), "found 2 error(s) in config ") {
+		t.Fatalf("error = %v, want 2 errors found", err)
+	}
+	for _, want := range []string{"port", "log-level", "trace.tlsconfig.ca-file: renamed to trace.tls-config.ca-file"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr doesn't report %s:\n%s", want, stderr)
+		}
+	}
+	// the invalid config is still printed to be inspected
+	if !strings.Contains(stdout, "log-level: trace") {
+		t.Errorf("stdout doesn't print the invalid config:\n%s", stdout)
+	}
+}
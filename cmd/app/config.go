@@ -0,0 +1,217 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/env"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/providers/posflag"
+	"github.com/spf13/pflag"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/log"
+)
+
+/*
+	config layers, latter overrides former:
+	1. defaults: default value of command-line flags
+	2. config file: --conf/-c, yaml
+	3. env: DF_ADAPTER_ prefixed, `__` separates nested keys and `_` stands for `-`
+	4. command-line flags: only the explicitly set ones
+
+	example config key: remote-write.tls-config.ca-file
+	config file:  remote-write: {tls-config: {ca-file: ...}}
+	env:          DF_ADAPTER_REMOTE_WRITE__TLS_CONFIG__CA_FILE
+	command-line: --remote-write-ca-file
+
+	secrets: every key `<name>_file` loads value of `<name>` from the file, e.g. `redis.passwd_file`,
+	in env it can be written as DF_ADAPTER_REDIS__PASSWD_FILE since `passwd-file` is not a config key
+*/
+
+const (
+	envPrefix    = "DF_ADAPTER_"
+	secretSuffix = "_file"
+)
+
+// configLoader loads config from defaults, config file, env and command-line args,
+// it's called on start up and every time config reloads
+type configLoader struct {
+	path string
+	fs   *pflag.FlagSet
+}
+
+func newConfigLoader(path string, fs *pflag.FlagSet) *configLoader {
+	return &configLoader{path: path, fs: fs}
+}
+
+// load returns the loaded config even if it's invalid, so it can be inspected,
+// validation errors are returned as config.ValidationErrors
+func (l *configLoader) load() (*config.Config, error) {
+	c := config.NewConfig()
+	// flags of a new config instance are never parsed, they hold defaults
+	defaults := c.ToOptions()
+
+	k := koanf.New(".")
+	if err := k.Load(posflag.ProviderWithFlag(defaults, ".", k, func(f *pflag.Flag) (string, interface{}) {
+		return config.FlagKey(f), posflag.FlagVal(defaults, f)
+	}), nil); err != nil {
+		return nil, err
+	}
+
+	// keys from config file and env are checked, flags are always known
+	user := koanf.New(".")
+	if err := user.Load(file.Provider(l.path), yaml.Parser()); err != nil {
+		return nil, err
+	}
+	if err := user.Load(env.Provider(envPrefix, ".", envKey), nil); err != nil {
+		return nil, err
+	}
+	renamed, err := renameKeys(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.Merge(user); err != nil {
+		return nil, err
+	}
+
+	if err := k.Load(posflag.ProviderWithFlag(l.fs, ".", nil, func(f *pflag.Flag) (string, interface{}) {
+		// flags like --conf are not config
+		if defaults.Lookup(f.Name) == nil {
+			return "", nil
+		}
+		return config.FlagKey(f), posflag.FlagVal(l.fs, f)
+	}), nil); err != nil {
+		return nil, err
+	}
+
+	secrets, err := loadSecrets(k, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := k.UnmarshalWithConf("", c, koanf.UnmarshalConf{Tag: "mapstructure"}); err != nil {
+		return nil, err
+	}
+	for key, extra := range c.ExtraConfigs {
+		if err := k.UnmarshalWithConf(key, extra, koanf.UnmarshalConf{Tag: "mapstructure"}); err != nil {
+			return nil, err
+		}
+	}
+	c.Raw = k.Raw()
+	c.RenamedKeys = renamed
+
+	var errs config.ValidationErrors
+	for _, key := range c.UnknownKeys(userKeys(user, secrets)) {
+		errs = append(errs, config.NewFieldError(key, "unknown config key"))
+	}
+	errs = append(errs, c.Validate()...)
+	if len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
+
+// renameKeys moves keys of old releases to their current names, values set with current names win.
+// It returns the renamed keys, which are logged once logger is ready
+func renameKeys(k *koanf.Koanf) (map[string]string, error) {
+	renamed := map[string]string{}
+	for _, key := range k.Keys() {
+		name, ok := config.RenamedKey(key)
+		if !ok {
+			continue
+		}
+		renamed[key] = name
+		if !k.Exists(name) {
+			if err := k.Set(name, k.Get(key)); err != nil {
+				return nil, err
+			}
+		}
+		k.Delete(key)
+	}
+	return renamed, nil
+}
+
+// logRenamedKeys warns about keys of old releases in config
+func logRenamedKeys(c *config.Config) {
+	for _, key := range sortedKeys(c.RenamedKeys) {
+		log.Logger.Info("msg", "config key is renamed, the old key will be removed in a future release", "key", key, "new", c.RenamedKeys[key])
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// envKey maps DF_ADAPTER_REMOTE_WRITE__URL to remote-write.url
+func envKey(s string) string {
+	s = strings.ToLower(strings.TrimPrefix(s, envPrefix))
+	parts := strings.Split(s, "__")
+	for i := range parts {
+		parts[i] = strings.ReplaceAll(parts[i], "_", "-")
+	}
+	return strings.Join(parts, ".")
+}
+
+// loadSecrets replaces `<name>_file` keys with content of the file,
+// returns the secret keys mapping from `<name>_file` to `<name>`
+func loadSecrets(k *koanf.Koanf, c *config.Config) (map[string]string, error) {
+	secrets := map[string]string{}
+	for _, key := range k.Keys() {
+		var name string
+		switch {
+		case strings.HasSuffix(key, secretSuffix):
+			name = strings.TrimSuffix(key, secretSuffix)
+		case strings.HasSuffix(key, "-file") && !c.IsKnownKey(key):
+			// env can't express `_`, `passwd-file` is accepted as long as it's not a config key itself
+			name = strings.TrimSuffix(key, "-file")
+		default:
+			continue
+		}
+		if !c.IsKnownKey(name) {
+			continue
+		}
+
+		secrets[key] = name
+		path := k.String(key)
+		k.Delete(key)
+		if path == "" {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("load secret %s failed: %w", key, err)
+		}
+		if err := k.Set(name, strings.TrimRight(string(content), "\r\n")); err != nil {
+			return nil, err
+		}
+	}
+	return secrets, nil
+}
+
+func userKeys(user *koanf.Koanf, secrets map[string]string) []string {
+	keys := user.Keys()
+	for i, key := range keys {
+		if name, ok := secrets[key]; ok {
+			keys[i] = name
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// watch calls onChange when config file changes, watching stops after an error is passed to onChange
+func (l *configLoader) watch(onChange func(error)) error {
+	return file.Provider(l.path).Watch(func(event interface{}, err error) {
+		onChange(err)
+	})
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+const testConfigFile = `
+election-enabled: false
+port: 1000
+log-level: debug
+remote-write:
+  url: http://file/write
+  timeout: 5s
+`
+
+func loadTestConfig(t *testing.T, file string, env map[string]string, args ...string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	fs := config.NewConfig().ToOptions()
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newConfigLoader(path, fs).load()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	return c
+}
+
+func TestConfigLayers(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		port     int
+		logLevel string
+		url      string
+	}{
+		{
+			name:     "file overrides defaults",
+			port:     1000,
+			logLevel: "debug",
+			url:      "http://file/write",
+		},
+		{
+			name:     "env overrides file",
+			env:      map[string]string{"DF_ADAPTER_PORT": "2000", "DF_ADAPTER_REMOTE_WRITE__URL": "http://env/write"},
+			port:     2000,
+			logLevel: "debug",
+			url:      "http://env/write",
+		},
+		{
+			name:     "flags override env",
+			env:      map[string]string{"DF_ADAPTER_PORT": "2000", "DF_ADAPTER_LOG_LEVEL": "error"},
+			args:     []string{"--port", "3000", "--remote-write-url", "http://flag/write"},
+			port:     3000,
+			logLevel: "error",
+			url:      "http://flag/write",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := loadTestConfig(t, testConfigFile, tt.env, tt.args...)
+			if c.Port != tt.port {
+				t.Errorf("port = %d, want %d", c.Port, tt.port)
+			}
+			if c.LogLevel != tt.logLevel {
+				t.Errorf("log-level = %q, want %q", c.LogLevel, tt.logLevel)
+			}
+			if c.RemoteWriteConfig.Url != tt.url {
+				t.Errorf("remote-write.url = %q, want %q", c.RemoteWriteConfig.Url, tt.url)
+			}
+			// set in file only
+			if c.RemoteWriteConfig.Timeout != 5*time.Second {
+				t.Errorf("remote-write.timeout = %s, want 5s", c.RemoteWriteConfig.Timeout)
+			}
+			// set nowhere
+			if c.PrometheusScrapeInterval != 10*time.Second {
+				t.Errorf("prometheus-scrape-interval = %s, want the default 10s", c.PrometheusScrapeInterval)
+			}
+		})
+	}
+}
+
+func TestConfigRenamedKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		trace  string
+		caFile string
+	}{
+		{name: "old key", trace: "trace: {tlsconfig: {ca-file: /old/ca.pem}}", caFile: "/old/ca.pem"},
+		{name: "current key wins", trace: "trace: {tlsconfig: {ca-file: /old/ca.pem}, tls-config: {ca-file: /new/ca.pem}}", caFile: "/new/ca.pem"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := loadTestConfig(t, testConfigFile+tt.trace+"\n", nil)
+			if got := c.TraceConfig.TLSConfig.CAFile; got != tt.caFile {
+				t.Errorf("trace.tls-config.ca-file = %q, want %q", got, tt.caFile)
+			}
+			if got := c.RenamedKeys["trace.tlsconfig.ca-file"]; got != "trace.tls-config.ca-file" {
+				t.Errorf("renamed key = %q, want trace.tls-config.ca-file", got)
+			}
+		})
+	}
+}
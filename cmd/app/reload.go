@@ -0,0 +1,166 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/knadh/koanf/maps"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	plog "prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/service"
+)
+
+const (
+	reloadSuccess  = "success"
+	reloadFailure  = "failure"
+	reloadRejected = "rejected"
+)
+
+var (
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_config_reloads_total",
+		Help: "Total number of config reloads by result.",
+	}, []string{"result"})
+	configLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "deepflow_adapter_config_last_reload_successful",
+		Help: "Whether the last config reload attempt was successful.",
+	})
+	configLastReloadSuccessTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "deepflow_adapter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful config reload.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(configReloads, configLastReloadSuccessful, configLastReloadSuccessTimestamp)
+}
+
+// restartRequiredKeys are config keys(and their children) can't be changed at runtime
+var restartRequiredKeys = []string{"port", "server", "election-enabled", "elector", "admin", "wal", "otlp.grpc-listen-address",
+	"graphite.enabled", "graphite.tcp-listen-address", "graphite.udp-listen-address"}
+
+type reloader struct {
+	mu      sync.Mutex
+	loader  *configLoader
+	svc     *service.Service
+	current *config.Config
+}
+
+func newReloader(loader *configLoader, svc *service.Service, c *config.Config) *reloader {
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.SetToCurrentTime()
+	return &reloader{loader: loader, svc: svc, current: c}
+}
+
+func (r *reloader) watch() {
+	err := r.loader.watch(func(err error) {
+		if err != nil {
+			plog.Logger.Error("msg", "config file watch stopped, send SIGHUP to reload config", "err", err)
+			return
+		}
+		r.reload("file")
+	})
+	if err != nil {
+		plog.Logger.Error("msg", "watch config file failed, send SIGHUP to reload config", "err", err)
+	}
+}
+
+func (r *reloader) reload(trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changes, err := r.apply()
+	result := reloadSuccess
+	switch {
+	case err == nil:
+		configLastReloadSuccessTimestamp.SetToCurrentTime()
+		plog.Logger.Info("msg", "config reloaded", "trigger", trigger, "changes", changes)
+	case isRestartRequired(err):
+		result = reloadRejected
+		plog.Logger.Error("msg", "config reload rejected", "trigger", trigger, "err", err)
+	default:
+		result = reloadFailure
+		plog.Logger.Error("msg", "config reload failed", "trigger", trigger, "err", err)
+	}
+
+	configReloads.WithLabelValues(result).Inc()
+	if result == reloadSuccess {
+		configLastReloadSuccessful.Set(1)
+	} else {
+		configLastReloadSuccessful.Set(0)
+	}
+}
+
+func (r *reloader) apply() ([]string, error) {
+	c, err := r.loader.load()
+	if err != nil {
+		return nil, fmt.Errorf("load config failed: %w", err)
+	}
+
+	changes := diffConfig(r.current.Raw, c.Raw)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if keys := r.restartRequired(changes); len(keys) > 0 {
+		return nil, restartRequiredError(keys)
+	}
+
+	if err := r.svc.ApplyConfig(c); err != nil {
+		return nil, err
+	}
+	logRenamedKeys(c)
+	r.current = c
+	return changes, nil
+}
+
+// diffConfig returns the flattened keys whose value changed
+func diffConfig(old, new map[string]interface{}) []string {
+	oldFlat, _ := maps.Flatten(old, nil, ".")
+	newFlat, _ := maps.Flatten(new, nil, ".")
+
+	var changes []string
+	for k, v := range newFlat {
+		if ov, ok := oldFlat[k]; !ok || !reflect.DeepEqual(ov, v) {
+			changes = append(changes, k)
+		}
+	}
+	for k := range oldFlat {
+		if _, ok := newFlat[k]; !ok {
+			changes = append(changes, k)
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func (r *reloader) restartRequired(changes []string) []string {
+	// elector config is only read when election starts
+	prefixes := append([]string{string(r.current.Elector)}, restartRequiredKeys...)
+
+	var keys []string
+	for _, change := range changes {
+		for _, prefix := range prefixes {
+			if change == prefix || strings.HasPrefix(change, prefix+".") {
+				keys = append(keys, change)
+				break
+			}
+		}
+	}
+	return keys
+}
+
+type restartRequiredError []string
+
+func (e restartRequiredError) Error() string {
+	return fmt.Sprintf("changes of %s require restart", strings.Join(e, ", "))
+}
+
+func isRestartRequired(err error) bool {
+	_, ok := err.(restartRequiredError)
+	return ok
+}
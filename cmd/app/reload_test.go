@@ -0,0 +1,70 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+func TestDiffConfig(t *testing.T) {
+	old := map[string]interface{}{
+		"port":         8080,
+		"log-level":    "info",
+		"remote-write": map[string]interface{}{"url": "http://a/write", "timeout": "5s"},
+		"relabel-configs": []interface{}{
+			map[string]interface{}{"action": "drop"},
+		},
+	}
+	new := map[string]interface{}{
+		"port":         8080,
+		"log-level":    "debug",
+		"remote-write": map[string]interface{}{"url": "http://a/write", "headers": map[string]interface{}{"X-Org": "a"}},
+		"relabel-configs": []interface{}{
+			map[string]interface{}{"action": "keep"},
+		},
+	}
+	want := []string{"log-level", "relabel-configs", "remote-write.headers.X-Org", "remote-write.timeout"}
+	if got := diffConfig(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %v, want %v", got, want)
+	}
+	if got := diffConfig(old, old); len(got) != 0 {
+		t.Errorf("changes of the same config = %v, want none", got)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	r := &reloader{current: &config.Config{Elector: config.Redis}}
+	changes := []string{
+		"port",
+		"server.read-timeout",
+		"server-name",
+		"election-enabled",
+		"elector",
+		"redis.addr",
+		"redis-cluster.addr",
+		"admin.port",
+		"wal.dir",
+		"wal-dir",
+		"otlp.grpc-listen-address",
+		"otlp.http-listen-address",
+		"graphite.enabled",
+		"graphite.templates",
+		"log-level",
+		"portal.url",
+	}
+	want := []string{
+		"port",
+		"server.read-timeout",
+		"election-enabled",
+		"elector",
+		"redis.addr",
+		"admin.port",
+		"wal.dir",
+		"otlp.grpc-listen-address",
+		"graphite.enabled",
+	}
+	if got := r.restartRequired(changes); !reflect.DeepEqual(got, want) {
+		t.Errorf("restart required keys = %v, want %v", got, want)
+	}
+}
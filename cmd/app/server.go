@@ -2,18 +2,12 @@ package app
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/knadh/koanf"
-	"github.com/knadh/koanf/parsers/yaml"
-	"github.com/knadh/koanf/providers/env"
-	"github.com/knadh/koanf/providers/file"
-	"github.com/knadh/koanf/providers/posflag"
 	"github.com/spf13/cobra"
 
 	"prometheus-deepflow-adapter/cmd/app/options"
@@ -22,7 +16,6 @@ import (
 	"prometheus-deepflow-adapter/pkg/service"
 )
 
-var k = koanf.New(".")
 var conf = config.NewConfig()
 
 func NewAdapter() *cobra.Command {
@@ -31,66 +24,55 @@ func NewAdapter() *cobra.Command {
 		Use:  "deepflow-adapter",
 		Long: "deepflow adapter for prometheus remote write to deepflow",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(context.Background(), conf)
+			loader := newConfigLoader(s.Conf, cmd.Flags())
+			c, err := loader.load()
+			if err != nil {
+				return err
+			}
+			return run(context.Background(), loader, c)
 		},
 		SilenceUsage: true,
 	}
 
-	fs := cmd.Flags()
+	fs := cmd.PersistentFlags()
 	namedFlagSets := s.Flags(conf)
 	for _, set := range namedFlagSets.FlagSets {
 		fs.AddFlagSet(set)
 	}
-	fs.Parse(os.Args[1:])
-
-	/*
-		example config arg: k8s-kube-config
-		parse in commandline: --k8s-kube-config
-		parse in env: k8s.kube-config
-		eventually: env
-	*/
-
-	// priority: env > command-line args > config file
-
-	if err := k.Load(file.Provider(s.Conf), yaml.Parser()); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := k.Load(posflag.Provider(fs, ".", k), nil); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := k.Load(env.Provider("", ".", func(s string) string {
-		return s
-	}), nil); err != nil {
-		log.Fatal(err)
-	}
-
-	// whatever gets in `k`, it will unmarshals to &conf
-	if err := k.UnmarshalWithConf("", &conf, koanf.UnmarshalConf{Tag: "mapstructure"}); err != nil {
-		log.Fatal(err)
-	}
+	cmd.AddCommand(newCheckConfigCommand(s))
 
 	return cmd
 }
 
-func run(ctx context.Context, c *config.Config) error {
+func run(ctx context.Context, loader *configLoader, c *config.Config) error {
 	plog.Logger = plog.NewLogger(c.LogLevel)
+	logRenamedKeys(c)
 	httpService, err := service.NewService(c)
 	if err != nil {
 		return err
 	}
 	go func() {
-		plog.Logger.Info("msg", "http server start up")
 		if err := httpService.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			plog.Logger.Error("msg", "http server start up error", "err", err)
 		}
 	}()
 
+	r := newReloader(loader, httpService, c)
+	r.watch()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			r.reload("sighup")
+		}
+	}()
+
 	gracefullyQuit := make(chan os.Signal, 1)
 	signal.Notify(gracefullyQuit, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 	<-gracefullyQuit
 	close(gracefullyQuit)
+	signal.Stop(hup)
 
 	ctx, done := context.WithTimeout(ctx, 10*time.Second)
 	defer done()
@@ -0,0 +1,372 @@
+// Package aggregate aggregates samples of matching series over tumbling windows, windows are by arrival time
+// and aggregates are emitted as new series when windows end
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+	"prometheus-deepflow-adapter/pkg/selector"
+)
+
+var (
+	// maxValues bounds values kept by a group for quantiles and histogram_bucket, values beyond it are reservoir
+	// sampled
+	maxValues = 10000
+
+	defaultQuantiles = []float64{0.5, 0.9, 0.99}
+	// defaultBuckets are the default buckets of prometheus client
+	defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// Aggregator aggregates series by rules, a series may match several rules
+type Aggregator struct {
+	rules []*rule
+}
+
+type rule struct {
+	match     *selector.Selector
+	interval  time.Duration
+	by        map[string]bool
+	without   map[string]bool
+	outputs   []string
+	quantiles []float64
+	buckets   []float64
+	keepInput bool
+	// suffix is appended to metric names of aggregates, before output names
+	suffix string
+	// counters is set if rate or increase is an output
+	counters bool
+	// values is set if quantiles or histogram_bucket is an output, values of windows are kept for them
+	values bool
+
+	mu sync.Mutex
+	// windowStart is when the current window started, windows after New and forced flushes are partial
+	windowStart time.Time
+	windowEnd   time.Time
+	// window is the sequence number of the current window
+	window int64
+	groups map[string]*group
+	// series tracks last values of input series for rate and increase, they're kept across windows
+	series map[string]*counter
+}
+
+type group struct {
+	labels   []prompb.Label
+	count    int
+	sum      float64
+	min, max float64
+	// increase is summed over input series, hasIncrease is set once any series has a previous value
+	increase    float64
+	hasIncrease bool
+	// values are a uniform sample of at most maxValues values of the window
+	values []float64
+}
+
+type counter struct {
+	last      float64
+	timestamp int64
+	window    int64
+}
+
+// New compiles rules, windows are aligned to multiples of their intervals
+func New(rules []config.AggregationRule, now time.Time) (*Aggregator, error) {
+	a := &Aggregator{}
+	for i := range rules {
+		c := &rules[i]
+		match, err := selector.Parse(c.Match)
+		if err != nil {
+			return nil, fmt.Errorf("stream aggregation rule %d: %w", i, err)
+		}
+		r := &rule{
+			match:       match,
+			interval:    c.Interval,
+			by:          set(c.By),
+			without:     set(c.Without),
+			outputs:     c.Outputs,
+			quantiles:   c.Quantiles,
+			buckets:     c.Buckets,
+			keepInput:   c.KeepInput,
+			suffix:      ":" + formatInterval(c.Interval),
+			windowStart: now,
+			windowEnd:   now.Truncate(c.Interval).Add(c.Interval),
+			groups:      map[string]*group{},
+			series:      map[string]*counter{},
+		}
+		if len(r.quantiles) == 0 {
+			r.quantiles = defaultQuantiles
+		}
+		if len(r.buckets) == 0 {
+			r.buckets = defaultBuckets
+		}
+		if len(c.By) > 0 {
+			r.suffix += "_by_" + strings.Join(c.By, "_")
+		} else if len(c.Without) > 0 {
+			r.suffix += "_without_" + strings.Join(c.Without, "_")
+		}
+		for _, o := range c.Outputs {
+			r.counters = r.counters || o == config.AggregateRate || o == config.AggregateIncrease
+			r.values = r.values || o == config.AggregateQuantiles || o == config.AggregateHistogramBucket
+		}
+		a.rules = append(a.rules, r)
+	}
+	return a, nil
+}
+
+// Match reports whether any rule matches the series, and whether the series should be forwarded, which is false
+// if any matching rule doesn't keep input. Native histograms and series without metric name are not aggregated
+func (a *Aggregator) Match(ts *prompb.TimeSeries) (matched, keep bool) {
+	if len(ts.Samples) == 0 || ts.MetricName() == "" {
+		return false, true
+	}
+	keep = true
+	for _, r := range a.rules {
+		if r.match.Matches(ts.Labels) {
+			matched = true
+			keep = keep && r.keepInput
+		}
+	}
+	return matched, keep
+}
+
+// Push aggregates float samples of the series by matching rules
+func (a *Aggregator) Push(ts *prompb.TimeSeries) {
+	if len(ts.Samples) == 0 || ts.MetricName() == "" {
+		return
+	}
+	for _, r := range a.rules {
+		if r.match.Matches(ts.Labels) {
+			r.push(ts)
+		}
+	}
+}
+
+// Flush returns aggregates of rules whose windows have ended by now, aggregates are timestamped at the end of
+// windows. With force, current windows of all rules are flushed and timestamped at now
+func (a *Aggregator) Flush(now time.Time, force bool) *prompb.WriteRequest {
+	req := &prompb.WriteRequest{}
+	for _, r := range a.rules {
+		req.Timeseries = append(req.Timeseries, r.flush(now, force)...)
+	}
+	return req
+}
+
+func (r *rule) push(ts *prompb.TimeSeries) {
+	labels := r.groupLabels(ts.Labels)
+	groupKey := key(labels)
+	var seriesKey string
+	if r.counters {
+		seriesKey = key(ts.Labels)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.groups[groupKey]
+	if !ok {
+		g = &group{labels: labels, min: math.Inf(1), max: math.Inf(-1)}
+		r.groups[groupKey] = g
+	}
+	for _, s := range ts.Samples {
+		g.add(s.Value, r.values)
+	}
+	if !r.counters {
+		return
+	}
+	c, ok := r.series[seriesKey]
+	if !ok {
+		c = &counter{timestamp: math.MinInt64}
+		r.series[seriesKey] = c
+	}
+	for _, s := range ts.Samples {
+		if s.Timestamp <= c.timestamp {
+			continue
+		}
+		if c.timestamp != math.MinInt64 {
+			delta := s.Value - c.last
+			if delta < 0 {
+				// counter reset
+				delta = s.Value
+			}
+			g.increase += delta
+			g.hasIncrease = true
+		}
+		c.last, c.timestamp, c.window = s.Value, s.Timestamp, r.window
+	}
+}
+
+func (g *group) add(v float64, keepValue bool) {
+	g.count++
+	g.sum += v
+	g.min = math.Min(g.min, v)
+	g.max = math.Max(g.max, v)
+	if !keepValue {
+		return
+	}
+	if len(g.values) < maxValues {
+		g.values = append(g.values, v)
+	} else if i := rand.Intn(g.count); i < maxValues {
+		g.values[i] = v
+	}
+}
+
+func (r *rule) flush(now time.Time, force bool) []prompb.TimeSeries {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !force && now.Before(r.windowEnd) {
+		return nil
+	}
+	timestamp := r.windowEnd
+	if now.Before(timestamp) {
+		timestamp = now
+	}
+	var out []prompb.TimeSeries
+	covered := timestamp.Sub(r.windowStart)
+	for _, g := range r.groups {
+		out = append(out, r.aggregates(g, timestamp.UnixMilli(), covered)...)
+	}
+	r.groups = map[string]*group{}
+	// series not received in the last two windows are forgotten
+	for k, c := range r.series {
+		if c.window < r.window-1 {
+			delete(r.series, k)
+		}
+	}
+	r.window++
+	r.windowEnd = now.Truncate(r.interval).Add(r.interval)
+	// a forced flush starts a partial window at now
+	r.windowStart = r.windowEnd.Add(-r.interval)
+	if timestamp.After(r.windowStart) {
+		r.windowStart = timestamp
+	}
+	return out
+}
+
+// aggregates returns outputs of the group, rate is the increase per second of the time window covered, which is
+// shorter than interval for partial windows
+func (r *rule) aggregates(g *group, timestamp int64, covered time.Duration) []prompb.TimeSeries {
+	var out []prompb.TimeSeries
+	sort.Float64s(g.values)
+	name := prompb.LabelValue(g.labels, prompb.MetricNameLabel) + r.suffix + "_"
+	emit := func(output string, v float64, extra ...prompb.Label) {
+		out = append(out, prompb.TimeSeries{
+			Labels:  withName(g.labels, name+output, extra...),
+			Samples: []prompb.Sample{{Value: v, Timestamp: timestamp}},
+		})
+	}
+	for _, o := range r.outputs {
+		switch o {
+		case config.AggregateSum:
+			emit(o, g.sum)
+		case config.AggregateCount:
+			emit(o, float64(g.count))
+		case config.AggregateMin:
+			emit(o, g.min)
+		case config.AggregateMax:
+			emit(o, g.max)
+		case config.AggregateAvg:
+			emit(o, g.sum/float64(g.count))
+		case config.AggregateIncrease:
+			if g.hasIncrease {
+				emit(o, g.increase)
+			}
+		case config.AggregateRate:
+			if g.hasIncrease && covered > 0 {
+				emit(o, g.increase/covered.Seconds())
+			}
+		case config.AggregateQuantiles:
+			for _, q := range r.quantiles {
+				emit(o, quantile(g.values, q), prompb.Label{Name: "quantile", Value: formatFloat(q)})
+			}
+		case config.AggregateHistogramBucket:
+			for _, b := range r.buckets {
+				cumulative := sort.Search(len(g.values), func(i int) bool { return g.values[i] > b })
+				// sampled values are scaled to the count of the window
+				emit(o, math.Round(float64(cumulative)*float64(g.count)/float64(len(g.values))), prompb.Label{Name: "le", Value: formatFloat(b)})
+			}
+			emit(o, float64(g.count), prompb.Label{Name: "le", Value: "+Inf"})
+		}
+	}
+	return out
+}
+
+func (r *rule) groupLabels(labels []prompb.Label) []prompb.Label {
+	if len(r.by) == 0 && len(r.without) == 0 {
+		return labels
+	}
+	out := make([]prompb.Label, 0, len(labels))
+	for _, l := range labels {
+		if l.Name == prompb.MetricNameLabel || (len(r.by) > 0 && r.by[l.Name]) || (len(r.without) > 0 && !r.without[l.Name]) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// quantile interpolates between the closest ranks like promql quantile, values are sorted
+func quantile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+	return values[lower]*(1-weight) + values[upper]*weight
+}
+
+func withName(labels []prompb.Label, name string, extra ...prompb.Label) []prompb.Label {
+	out := make([]prompb.Label, 0, len(labels)+len(extra))
+	for _, l := range labels {
+		if l.Name == prompb.MetricNameLabel {
+			l.Value = name
+		}
+		out = append(out, l)
+	}
+	out = append(out, extra...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func key(labels []prompb.Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte(0xff)
+		b.WriteString(l.Value)
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
+
+// formatInterval formats interval in the largest unit dividing it, e.g. 5m, since metric names can't have dots
+func formatInterval(d time.Duration) string {
+	for _, unit := range []struct {
+		d    time.Duration
+		name string
+	}{{time.Hour, "h"}, {time.Minute, "m"}, {time.Second, "s"}} {
+		if d%unit.d == 0 {
+			return strconv.FormatInt(int64(d/unit.d), 10) + unit.name
+		}
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func set(labels []string) map[string]bool {
+	m := make(map[string]bool, len(labels))
+	for _, l := range labels {
+		m[l] = true
+	}
+	return m
+}
@@ -0,0 +1,271 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// t0 is aligned to minutes
+var t0 = time.Unix(1700000040, 0)
+
+func newTestAggregator(t *testing.T, now time.Time, rules ...config.AggregationRule) *Aggregator {
+	t.Helper()
+	a, err := New(rules, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// series returns a cpu series of the instance, samples are values at offsets of seconds from t0
+func series(instance string, samples ...[2]float64) *prompb.TimeSeries {
+	ts := &prompb.TimeSeries{Labels: []prompb.Label{
+		{Name: "__name__", Value: "cpu"}, {Name: "instance", Value: instance}, {Name: "job", Value: "node"},
+	}}
+	for _, s := range samples {
+		ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: t0.Add(time.Duration(s[0]) * time.Second).UnixMilli(), Value: s[1]})
+	}
+	return ts
+}
+
+// flatten formats aggregates as name{labels} @timestamp offset => value, the metric name is omitted from labels
+func flatten(req *prompb.WriteRequest) map[string]float64 {
+	out := map[string]float64{}
+	for _, ts := range req.Timeseries {
+		var labels []string
+		for _, l := range ts.Labels {
+			if l.Name != prompb.MetricNameLabel {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.Name, l.Value))
+			}
+		}
+		for _, s := range ts.Samples {
+			offset := time.UnixMilli(s.Timestamp).Sub(t0)
+			out[fmt.Sprintf("%s{%s} @%s", ts.MetricName(), strings.Join(labels, ","), offset)] = math.Round(s.Value*1e6) / 1e6
+		}
+	}
+	return out
+}
+
+func TestOutputs(t *testing.T) {
+	input := []*prompb.TimeSeries{
+		series("a", [2]float64{1, 1}, [2]float64{2, 3}),
+		series("b", [2]float64{1, 10}, [2]float64{2, 14}),
+	}
+	tests := []struct {
+		output string
+		want   map[string]float64
+	}{
+		{config.AggregateSum, map[string]float64{`cpu:1m_by_job_sum{job="node"} @1m0s`: 28}},
+		{config.AggregateCount, map[string]float64{`cpu:1m_by_job_count{job="node"} @1m0s`: 4}},
+		{config.AggregateMin, map[string]float64{`cpu:1m_by_job_min{job="node"} @1m0s`: 1}},
+		{config.AggregateMax, map[string]float64{`cpu:1m_by_job_max{job="node"} @1m0s`: 14}},
+		{config.AggregateAvg, map[string]float64{`cpu:1m_by_job_avg{job="node"} @1m0s`: 7}},
+		{config.AggregateIncrease, map[string]float64{`cpu:1m_by_job_increase{job="node"} @1m0s`: 6}},
+		{config.AggregateRate, map[string]float64{`cpu:1m_by_job_rate{job="node"} @1m0s`: 0.1}},
+		{config.AggregateQuantiles, map[string]float64{
+			`cpu:1m_by_job_quantiles{job="node",quantile="0"} @1m0s`:   1,
+			`cpu:1m_by_job_quantiles{job="node",quantile="0.5"} @1m0s`: 6.5,
+			`cpu:1m_by_job_quantiles{job="node",quantile="0.9"} @1m0s`: 12.8,
+		}},
+		{config.AggregateHistogramBucket, map[string]float64{
+			`cpu:1m_by_job_histogram_bucket{job="node",le="2"} @1m0s`:    1,
+			`cpu:1m_by_job_histogram_bucket{job="node",le="10"} @1m0s`:   3,
+			`cpu:1m_by_job_histogram_bucket{job="node",le="+Inf"} @1m0s`: 4,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			a := newTestAggregator(t, t0, config.AggregationRule{
+				Match:     "cpu",
+				Interval:  time.Minute,
+				By:        []string{"job"},
+				Outputs:   []string{tt.output},
+				Quantiles: []float64{0, 0.5, 0.9},
+				Buckets:   []float64{2, 10},
+			})
+			for _, ts := range input {
+				a.Push(ts)
+			}
+			if got := flatten(a.Flush(t0.Add(time.Minute), false)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("aggregates = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		by      []string
+		without []string
+		want    map[string]float64
+	}{
+		{
+			name: "all labels",
+			want: map[string]float64{
+				`cpu:1m_sum{instance="a",job="node"} @1m0s`: 1,
+				`cpu:1m_sum{instance="b",job="node"} @1m0s`: 2,
+			},
+		},
+		{name: "by", by: []string{"job"}, want: map[string]float64{`cpu:1m_by_job_sum{job="node"} @1m0s`: 3}},
+		{
+			name:    "without",
+			without: []string{"job"},
+			want: map[string]float64{
+				`cpu:1m_without_job_sum{instance="a"} @1m0s`: 1,
+				`cpu:1m_without_job_sum{instance="b"} @1m0s`: 2,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAggregator(t, t0, config.AggregationRule{
+				Match: "cpu", Interval: time.Minute, By: tt.by, Without: tt.without, Outputs: []string{config.AggregateSum},
+			})
+			a.Push(series("a", [2]float64{1, 1}))
+			a.Push(series("b", [2]float64{1, 2}))
+			if got := flatten(a.Flush(t0.Add(time.Minute), false)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("aggregates = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCounterReset(t *testing.T) {
+	a := newTestAggregator(t, t0, config.AggregationRule{
+		Match: "cpu", Interval: time.Minute, Outputs: []string{config.AggregateIncrease},
+	})
+	// the counter resets after 8, samples not after the last one are ignored
+	a.Push(series("a", [2]float64{1, 5}, [2]float64{2, 8}, [2]float64{3, 2}, [2]float64{3, 100}, [2]float64{4, 4}))
+	a.Push(series("a", [2]float64{2, 100}))
+	want := map[string]float64{`cpu:1m_increase{instance="a",job="node"} @1m0s`: 7}
+	if got := flatten(a.Flush(t0.Add(time.Minute), false)); !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregates = %v, want %v", got, want)
+	}
+}
+
+func TestWindowRollover(t *testing.T) {
+	a := newTestAggregator(t, t0, config.AggregationRule{
+		Match: "cpu", Interval: time.Minute, Outputs: []string{config.AggregateSum, config.AggregateIncrease},
+	})
+	steps := []struct {
+		push  *prompb.TimeSeries
+		flush time.Duration
+		want  map[string]float64
+	}{
+		// the first sample of a counter has no increase
+		{push: series("a", [2]float64{10, 10}), flush: 30 * time.Second, want: map[string]float64{}},
+		{flush: time.Minute, want: map[string]float64{`cpu:1m_sum{instance="a",job="node"} @1m0s`: 10}},
+		// last values of counters are kept across windows
+		{push: series("a", [2]float64{70, 15}), flush: 2*time.Minute + 500*time.Millisecond, want: map[string]float64{
+			`cpu:1m_sum{instance="a",job="node"} @2m0s`:      15,
+			`cpu:1m_increase{instance="a",job="node"} @2m0s`: 5,
+		}},
+		// empty windows emit nothing, counters not received in two windows are forgotten
+		{flush: 3 * time.Minute, want: map[string]float64{}},
+		{flush: 4 * time.Minute, want: map[string]float64{}},
+		{push: series("a", [2]float64{250, 30}), flush: 5 * time.Minute, want: map[string]float64{
+			`cpu:1m_sum{instance="a",job="node"} @5m0s`: 30,
+		}},
+	}
+	for i, step := range steps {
+		if step.push != nil {
+			a.Push(step.push)
+		}
+		if got := flatten(a.Flush(t0.Add(step.flush), false)); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("step %d: aggregates = %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+func TestForcedFlush(t *testing.T) {
+	// the first window starts at 15s
+	a := newTestAggregator(t, t0.Add(15*time.Second), config.AggregationRule{
+		Match: "cpu", Interval: time.Minute, Outputs: []string{config.AggregateIncrease, config.AggregateRate},
+	})
+	a.Push(series("a", [2]float64{20, 0}, [2]float64{40, 30}))
+	// rate is the increase over the 30s covered, timestamped at now
+	want := map[string]float64{
+		`cpu:1m_increase{instance="a",job="node"} @45s`: 30,
+		`cpu:1m_rate{instance="a",job="node"} @45s`:     1,
+	}
+	if got := flatten(a.Flush(t0.Add(45*time.Second), true)); !reflect.DeepEqual(got, want) {
+		t.Errorf("forced flush: aggregates = %v, want %v", got, want)
+	}
+
+	// the rest of the window covers 15s
+	a.Push(series("a", [2]float64{50, 60}))
+	want = map[string]float64{
+		`cpu:1m_increase{instance="a",job="node"} @1m0s`: 30,
+		`cpu:1m_rate{instance="a",job="node"} @1m0s`:     2,
+	}
+	if got := flatten(a.Flush(t0.Add(time.Minute), false)); !reflect.DeepEqual(got, want) {
+		t.Errorf("flush after forced flush: aggregates = %v, want %v", got, want)
+	}
+
+	// full windows cover the interval
+	a.Push(series("a", [2]float64{90, 120}))
+	want = map[string]float64{
+		`cpu:1m_increase{instance="a",job="node"} @2m0s`: 60,
+		`cpu:1m_rate{instance="a",job="node"} @2m0s`:     1,
+	}
+	if got := flatten(a.Flush(t0.Add(2*time.Minute), false)); !reflect.DeepEqual(got, want) {
+		t.Errorf("full window: aggregates = %v, want %v", got, want)
+	}
+}
+
+func TestValuesBounded(t *testing.T) {
+	defer func(n int) { maxValues = n }(maxValues)
+	maxValues = 10
+
+	a := newTestAggregator(t, t0, config.AggregationRule{
+		Match: "cpu", Interval: time.Minute, Outputs: []string{config.AggregateHistogramBucket}, Buckets: []float64{500},
+	})
+	for i := 1; i <= 1000; i++ {
+		a.Push(series("a", [2]float64{1, float64(i)}))
+	}
+	for _, g := range a.rules[0].groups {
+		if len(g.values) != maxValues || g.count != 1000 {
+			t.Fatalf("group keeps %d values of %d, want %d", len(g.values), g.count, maxValues)
+		}
+	}
+	got := flatten(a.Flush(t0.Add(time.Minute), false))
+	if n := got[`cpu:1m_histogram_bucket{instance="a",job="node",le="+Inf"} @1m0s`]; n != 1000 {
+		t.Errorf("+Inf bucket = %v, want 1000", n)
+	}
+	// sampled buckets are scaled to the count
+	if n := got[`cpu:1m_histogram_bucket{instance="a",job="node",le="500"} @1m0s`]; math.Mod(n, 100) != 0 {
+		t.Errorf("le=500 bucket = %v, want a multiple of 100", n)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	a := newTestAggregator(t, t0,
+		config.AggregationRule{Match: `cpu{instance="a"}`, Interval: time.Minute, Outputs: []string{config.AggregateSum}, KeepInput: true},
+		config.AggregationRule{Match: `cpu{instance="b"}`, Interval: time.Minute, Outputs: []string{config.AggregateSum}},
+		config.AggregationRule{Match: `cpu{instance=~"b|c"}`, Interval: time.Minute, Outputs: []string{config.AggregateSum}, KeepInput: true},
+	)
+	tests := []struct {
+		name          string
+		ts            *prompb.TimeSeries
+		matched, keep bool
+	}{
+		{name: "rule keeping input", ts: series("a", [2]float64{1, 1}), matched: true, keep: true},
+		{name: "any rule consuming input", ts: series("b", [2]float64{1, 1}), matched: true, keep: false},
+		{name: "no rule", ts: series("d", [2]float64{1, 1}), keep: true},
+		{name: "no float samples", ts: series("b"), keep: true},
+	}
+	for _, tt := range tests {
+		matched, keep := a.Match(tt.ts)
+		if matched != tt.matched || keep != tt.keep {
+			t.Errorf("%s: Match() = %v, %v, want %v, %v", tt.name, matched, keep, tt.matched, tt.keep)
+		}
+	}
+}
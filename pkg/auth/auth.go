@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+// ErrNoCredentials means request carries no credentials for the method, next method should be tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is the identity of an authenticated client
+type Principal struct {
+	Name   string
+	Method string
+}
+
+func (p *Principal) String() string {
+	return fmt.Sprintf("%s:%s", p.Method, p.Name)
+}
+
+type Authenticator interface {
+	// Authenticate returns ErrNoCredentials if request carries no credentials for the method,
+	// other errors mean credentials are invalid
+	Authenticate(*http.Request) (*Principal, error)
+}
+
+type authenticatorConstructor func(*config.AuthConfig) (Authenticator, error)
+
+var authenticators = map[string]authenticatorConstructor{}
+
+func RegisterAuthenticator(method string, f authenticatorConstructor) {
+	authenticators[method] = f
+}
+
+// Chain tries authenticators in order, an empty chain accepts every request as anonymous
+type Chain []Authenticator
+
+func NewChain(conf *config.AuthConfig) (Chain, error) {
+	chain := make(Chain, 0, len(conf.Methods))
+	for _, method := range conf.Methods {
+		f := authenticators[method]
+		if f == nil {
+			return nil, fmt.Errorf("unknown authentication method: %s", method)
+		}
+		a, err := f(conf)
+		if err != nil {
+			return nil, fmt.Errorf("create %s authenticator failed: %w", method, err)
+		}
+		chain = append(chain, a)
+	}
+	return chain, nil
+}
+
+// Authenticate returns nil principal if chain is empty
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if err == ErrNoCredentials {
+			continue
+		}
+		return p, err
+	}
+	return nil, ErrNoCredentials
+}
@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+func newBasicAuthenticator(t *testing.T, users map[string]string) *basicAuthenticator {
+	t.Helper()
+	hashed := map[string]string{}
+	for user, passwd := range users {
+		hash, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashed[user] = string(hash)
+	}
+	a, err := NewBasicAuthenticator(&config.AuthConfig{BasicUsers: hashed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.(*basicAuthenticator)
+}
+
+func basicRequest(user, passwd string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/receive", nil)
+	r.SetBasicAuth(user, passwd)
+	return r
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+	a := newBasicAuthenticator(t, map[string]string{"alice": "secret"})
+	tests := []struct {
+		name string
+		r    *http.Request
+		err  bool
+	}{
+		{name: "valid", r: basicRequest("alice", "secret")},
+		{name: "wrong password", r: basicRequest("alice", "wrong"), err: true},
+		{name: "unknown user", r: basicRequest("bob", "secret"), err: true},
+	}
+	for _, tt := range tests {
+		p, err := a.Authenticate(tt.r)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.err)
+		}
+		if !tt.err && (p.Name != "alice" || p.Method != config.AuthBasic) {
+			t.Errorf("%s: principal = %v", tt.name, p)
+		}
+	}
+	if _, err := a.Authenticate(httptest.NewRequest(http.MethodPost, "/receive", nil)); err != ErrNoCredentials {
+		t.Errorf("request without credentials: error = %v, want ErrNoCredentials", err)
+	}
+	if _, err := NewBasicAuthenticator(&config.AuthConfig{}); err == nil {
+		t.Error("authenticator without users: no error")
+	}
+}
+
+func TestBasicAuthenticatorCache(t *testing.T) {
+	a := newBasicAuthenticator(t, map[string]string{"alice": "secret"})
+	if _, err := a.Authenticate(basicRequest("alice", "secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.verified["alice"]; !ok {
+		t.Fatal("verified password is not cached")
+	}
+
+	// the cached password is accepted without bcrypt, other passwords are still compared with the hash
+	a.users["alice"] = "not a bcrypt hash"
+	if _, err := a.Authenticate(basicRequest("alice", "secret")); err != nil {
+		t.Errorf("cached password: %v", err)
+	}
+	if _, err := a.Authenticate(basicRequest("alice", "wrong")); err == nil {
+		t.Error("wrong password is accepted after a cached password")
+	}
+}
+
+func TestBearerAuthenticator(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	write := func(token string) {
+		if err := os.WriteFile(file, []byte(token), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("token-1\n")
+	a, err := NewBearerAuthenticator(&config.AuthConfig{BearerTokenFile: file, BearerPrincipal: "prometheus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := func(header string) (*Principal, error) {
+		r := httptest.NewRequest(http.MethodPost, "/receive", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		return a.Authenticate(r)
+	}
+
+	if p, err := auth("Bearer token-1"); err != nil || p.Name != "prometheus" || p.Method != config.AuthBearer {
+		t.Errorf("valid token: principal = %v, error = %v", p, err)
+	}
+	if _, err := auth("Token token-1"); err != nil {
+		t.Errorf("token scheme: %v", err)
+	}
+	if _, err := auth("Bearer token-2"); err == nil {
+		t.Error("wrong token is accepted")
+	}
+	if _, err := auth(""); err != ErrNoCredentials {
+		t.Errorf("no header: error = %v, want ErrNoCredentials", err)
+	}
+	if _, err := auth("Basic YTpi"); err != ErrNoCredentials {
+		t.Errorf("basic header: error = %v, want ErrNoCredentials", err)
+	}
+
+	// rotated tokens take effect on the next request
+	write("token-2")
+	if _, err := auth("Bearer token-1"); err == nil {
+		t.Error("rotated token is accepted")
+	}
+	if _, err := auth("Bearer token-2"); err != nil {
+		t.Errorf("new token: %v", err)
+	}
+
+	// an empty token file accepts nothing
+	write("\n")
+	if _, err := auth("Bearer "); err == nil {
+		t.Error("empty token is accepted")
+	}
+	os.Remove(file)
+	if _, err := auth("Bearer token-2"); err == nil {
+		t.Error("token is accepted without token file")
+	}
+}
+
+func TestMTLSAuthenticator(t *testing.T) {
+	uri, _ := url.Parse("spiffe://cluster/ns/monitoring/sa/prometheus")
+	cert := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "prometheus", Organization: []string{"monitoring"}},
+		DNSNames:    []string{"prometheus.monitoring.svc"},
+		URIs:        []*url.URL{uri},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	request := func(state *tls.ConnectionState) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/receive", nil)
+		r.TLS = state
+		return r
+	}
+	verified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	tests := []struct {
+		name      string
+		conf      config.ClientCertConfig
+		state     *tls.ConnectionState
+		principal string
+		err       error
+	}{
+		{name: "plain http", err: ErrNoCredentials},
+		{name: "no client certificate", state: &tls.ConnectionState{}, err: ErrNoCredentials},
+		{
+			name:  "unverified certificate",
+			state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+			err:   errors.New("client certificate is not verified"),
+		},
+		{name: "any verified certificate", state: verified, principal: "prometheus"},
+		{name: "allowed common name", conf: config.ClientCertConfig{AllowedSubjects: []string{"prometheus"}}, state: verified, principal: "prometheus"},
+		{
+			name:      "allowed distinguished name",
+			conf:      config.ClientCertConfig{AllowedSubjects: []string{"CN=prometheus,O=monitoring"}},
+			state:     verified,
+			principal: "CN=prometheus,O=monitoring",
+		},
+		{
+			name:      "allowed uri",
+			conf:      config.ClientCertConfig{AllowedSANs: []string{uri.String()}},
+			state:     verified,
+			principal: uri.String(),
+		},
+		{name: "allowed ip", conf: config.ClientCertConfig{AllowedSANs: []string{"10.0.0.1"}}, state: verified, principal: "10.0.0.1"},
+		{
+			name:  "not allowed",
+			conf:  config.ClientCertConfig{AllowedSubjects: []string{"grafana"}, AllowedSANs: []string{"grafana.monitoring.svc"}},
+			state: verified,
+			err:   errors.New("client certificate subject is not allowed"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewMTLSAuthenticator(&config.AuthConfig{ClientCert: tt.conf})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := a.Authenticate(request(tt.state))
+			if tt.err != nil {
+				if err == nil || err.Error() != tt.err.Error() {
+					t.Errorf("error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil || p.Name != tt.principal || p.Method != config.AuthMTLS {
+				t.Errorf("principal = %v, error = %v, want %s", p, err, tt.principal)
+			}
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	chain, err := NewChain(&config.AuthConfig{
+		Methods:         []string{config.AuthMTLS, config.AuthBearer, config.AuthBasic},
+		BearerTokenFile: file,
+		BearerPrincipal: "bearer",
+		BasicUsers:      map[string]string{"alice": string(hash)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bearer := httptest.NewRequest(http.MethodPost, "/receive", nil)
+	bearer.Header.Set("Authorization", "Bearer token")
+	if p, err := chain.Authenticate(bearer); err != nil || p.Method != config.AuthBearer {
+		t.Errorf("bearer: principal = %v, error = %v", p, err)
+	}
+	if p, err := chain.Authenticate(basicRequest("alice", "secret")); err != nil || p.Method != config.AuthBasic {
+		t.Errorf("basic: principal = %v, error = %v", p, err)
+	}
+	// a tls client without certificate and credentials of any other method is rejected
+	anonymous := httptest.NewRequest(http.MethodPost, "/receive", nil)
+	anonymous.TLS = &tls.ConnectionState{}
+	if _, err := chain.Authenticate(anonymous); err != ErrNoCredentials {
+		t.Errorf("anonymous: error = %v, want ErrNoCredentials", err)
+	}
+	// invalid credentials of a method are not passed to later methods
+	wrong := basicRequest("alice", "wrong")
+	wrong.Header.Set("Authorization", "Bearer wrong")
+	if _, err := chain.Authenticate(wrong); err == nil || err == ErrNoCredentials {
+		t.Errorf("wrong bearer token: error = %v", err)
+	}
+
+	if p, err := (Chain{}).Authenticate(anonymous); p != nil || err != nil {
+		t.Errorf("empty chain: principal = %v, error = %v, want anonymous", p, err)
+	}
+	if _, err := NewChain(&config.AuthConfig{Methods: []string{"ldap"}}); err == nil {
+		t.Error("unknown method: no error")
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+type basicAuthenticator struct {
+	users map[string]string
+	// unknown users are compared with dummy, so they take the same time as wrong passwords
+	dummy []byte
+
+	// bcrypt is slow by design, verified passwords are cached by sha256
+	mu       sync.RWMutex
+	verified map[string][sha256.Size]byte
+}
+
+func NewBasicAuthenticator(conf *config.AuthConfig) (Authenticator, error) {
+	if len(conf.BasicUsers) == 0 {
+		return nil, errors.New("no basic auth users")
+	}
+	dummy, err := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return &basicAuthenticator{
+		users:    conf.BasicUsers,
+		dummy:    dummy,
+		verified: map[string][sha256.Size]byte{},
+	}, nil
+}
+
+func (b *basicAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	user, passwd, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	hash, ok := b.users[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(b.dummy, []byte(passwd))
+		return nil, errors.New("invalid username or password")
+	}
+
+	sum := sha256.Sum256([]byte(passwd))
+	b.mu.RLock()
+	cached, hit := b.verified[user]
+	b.mu.RUnlock()
+	if hit && cached == sum {
+		return &Principal{Name: user, Method: config.AuthBasic}, nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(passwd)); err != nil {
+		return nil, errors.New("invalid username or password")
+	}
+	b.mu.Lock()
+	b.verified[user] = sum
+	b.mu.Unlock()
+	return &Principal{Name: user, Method: config.AuthBasic}, nil
+}
+
+func init() {
+	RegisterAuthenticator(config.AuthBasic, NewBasicAuthenticator)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+type bearerAuthenticator struct {
+	tokenFile string
+	principal string
+}
+
+func NewBearerAuthenticator(conf *config.AuthConfig) (Authenticator, error) {
+	if conf.BearerTokenFile == "" {
+		return nil, errors.New("no bearer token file")
+	}
+	return &bearerAuthenticator{tokenFile: conf.BearerTokenFile, principal: conf.BearerPrincipal}, nil
+}
+
+func (b *bearerAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	// InfluxDB clients send the token in Token scheme
+	var credentials string
+	switch {
+	case strings.HasPrefix(header, "Bearer "):
+		credentials = strings.TrimPrefix(header, "Bearer ")
+	case strings.HasPrefix(header, "Token "):
+		credentials = strings.TrimPrefix(header, "Token ")
+	default:
+		return nil, ErrNoCredentials
+	}
+	// read token every time, so rotated token takes effect without restart
+	token, err := os.ReadFile(b.tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	expected := strings.TrimSpace(string(token))
+	if expected == "" || subtle.ConstantTimeCompare([]byte(credentials), []byte(expected)) != 1 {
+		return nil, errors.New("invalid bearer token")
+	}
+	return &Principal{Name: b.principal, Method: config.AuthBearer}, nil
+}
+
+func init() {
+	RegisterAuthenticator(config.AuthBearer, NewBearerAuthenticator)
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+type mtlsAuthenticator struct {
+	subjects map[string]bool
+	sans     map[string]bool
+}
+
+func NewMTLSAuthenticator(conf *config.AuthConfig) (Authenticator, error) {
+	m := &mtlsAuthenticator{subjects: map[string]bool{}, sans: map[string]bool{}}
+	for _, s := range conf.ClientCert.AllowedSubjects {
+		m.subjects[s] = true
+	}
+	for _, s := range conf.ClientCert.AllowedSANs {
+		m.sans[s] = true
+	}
+	return m, nil
+}
+
+// Authenticate accepts certificates verified by tls server only, see server tls client-ca-file
+func (m *mtlsAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, ErrNoCredentials
+	}
+	if len(r.TLS.VerifiedChains) == 0 {
+		return nil, errors.New("client certificate is not verified")
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	sans := certSANs(cert)
+	if len(m.subjects) == 0 && len(m.sans) == 0 {
+		return &Principal{Name: principalName(cert, sans), Method: config.AuthMTLS}, nil
+	}
+
+	if m.subjects[cert.Subject.CommonName] {
+		return &Principal{Name: cert.Subject.CommonName, Method: config.AuthMTLS}, nil
+	}
+	if m.subjects[cert.Subject.String()] {
+		return &Principal{Name: cert.Subject.String(), Method: config.AuthMTLS}, nil
+	}
+	for _, san := range sans {
+		if m.sans[san] {
+			return &Principal{Name: san, Method: config.AuthMTLS}, nil
+		}
+	}
+	return nil, errors.New("client certificate subject is not allowed")
+}
+
+func certSANs(cert *x509.Certificate) []string {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.URIs)+len(cert.IPAddresses))
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+func principalName(cert *x509.Certificate, sans []string) string {
+	if cert.Subject.CommonName != "" || len(sans) == 0 {
+		return cert.Subject.CommonName
+	}
+	return sans[0]
+}
+
+func init() {
+	RegisterAuthenticator(config.AuthMTLS, NewMTLSAuthenticator)
+}
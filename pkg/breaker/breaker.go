@@ -0,0 +1,128 @@
+// Package breaker is a circuit breaker with closed, open and half-open states
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker counts requests and failures in fixed windows while it's closed, it opens when failure ratio
+// reaches the threshold, after cool down it allows a few probes and closes if all of them succeed
+type Breaker struct {
+	conf     config.CircuitBreakerConfig
+	onChange func(from, to State)
+	now      func() time.Time
+
+	mu          sync.Mutex
+	state       State
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	// probes are allowed requests in half-open state, successes are the succeeded ones of them
+	probes    int
+	successes int
+}
+
+// New returns a closed breaker, onChange is called with state transitions if it's not nil
+func New(conf *config.CircuitBreakerConfig, onChange func(from, to State)) *Breaker {
+	return &Breaker{conf: *conf, onChange: onChange, now: time.Now, windowStart: time.Now()}
+}
+
+// Allow reports whether a request can be sent, a request allowed must be recorded by Record
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.conf.CoolDown {
+			return false
+		}
+		b.transit(HalfOpen)
+		fallthrough
+	case HalfOpen:
+		if b.probes >= b.conf.HalfOpenRequests {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// Record records result of an allowed request
+func (b *Breaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case Closed:
+		if now.Sub(b.windowStart) >= b.conf.Window {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+		b.requests++
+		if !success {
+			b.failures++
+		}
+		if b.requests >= b.conf.MinRequests && float64(b.failures)/float64(b.requests) >= b.conf.FailureRatio {
+			b.transit(Open)
+		}
+	case HalfOpen:
+		if !success {
+			b.transit(Open)
+			return
+		}
+		b.successes++
+		if b.successes >= b.conf.HalfOpenRequests {
+			b.transit(Closed)
+		}
+	}
+}
+
+// State returns current state, open breaker becomes half-open after cool down
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.conf.CoolDown {
+		b.transit(HalfOpen)
+	}
+	return b.state
+}
+
+func (b *Breaker) transit(to State) {
+	from := b.state
+	b.state = to
+	switch to {
+	case Open:
+		b.openedAt = b.now()
+	case HalfOpen:
+		b.probes, b.successes = 0, 0
+	case Closed:
+		b.windowStart, b.requests, b.failures = b.now(), 0, 0
+	}
+	if b.onChange != nil && from != to {
+		b.onChange(from, to)
+	}
+}
@@ -0,0 +1,135 @@
+package breaker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+// testBreaker is a breaker on a fake clock, transitions are recorded
+type testBreaker struct {
+	*Breaker
+	clock       time.Time
+	transitions []string
+}
+
+func newTestBreaker() *testBreaker {
+	tb := &testBreaker{clock: time.Unix(1700000000, 0)}
+	tb.Breaker = New(&config.CircuitBreakerConfig{
+		Window:           time.Minute,
+		MinRequests:      4,
+		FailureRatio:     0.5,
+		CoolDown:         30 * time.Second,
+		HalfOpenRequests: 2,
+	}, func(from, to State) {
+		tb.transitions = append(tb.transitions, from.String()+"->"+to.String())
+	})
+	tb.now = func() time.Time { return tb.clock }
+	tb.windowStart = tb.clock
+	return tb
+}
+
+func (tb *testBreaker) advance(d time.Duration) {
+	tb.clock = tb.clock.Add(d)
+}
+
+// send records results of allowed requests, it fails if any is not allowed
+func (tb *testBreaker) send(t *testing.T, results ...bool) {
+	t.Helper()
+	for _, success := range results {
+		if !tb.Allow() {
+			t.Fatalf("request is not allowed in %s state", tb.State())
+		}
+		tb.Record(success)
+	}
+}
+
+func (tb *testBreaker) expect(t *testing.T, want State) {
+	t.Helper()
+	if got := tb.State(); got != want {
+		t.Fatalf("state = %s, want %s", got, want)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	b := newTestBreaker()
+	// failures under min requests don't open the breaker
+	b.send(t, false, false, false)
+	b.expect(t, Closed)
+
+	// a new window forgets failures of the last one
+	b.advance(time.Minute)
+	b.send(t, false, true, true, true)
+	b.expect(t, Closed)
+
+	// the breaker opens once the failure ratio reaches the threshold
+	b.send(t, false)
+	b.expect(t, Closed)
+	b.send(t, false)
+	b.expect(t, Open)
+	if want := []string{"closed->open"}; !reflect.DeepEqual(b.transitions, want) {
+		t.Errorf("transitions = %v, want %v", b.transitions, want)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	b := newTestBreaker()
+	b.send(t, false, false, false, false)
+	b.expect(t, Open)
+
+	b.advance(29 * time.Second)
+	if b.Allow() {
+		t.Fatal("request is allowed before cool down")
+	}
+	b.expect(t, Open)
+
+	// after cool down, half-open allows probes only
+	b.advance(time.Second)
+	if !b.Allow() || !b.Allow() || b.Allow() {
+		t.Fatal("half-open breaker doesn't allow exactly 2 probes")
+	}
+	b.expect(t, HalfOpen)
+}
+
+func TestHalfOpenCloses(t *testing.T) {
+	b := newTestBreaker()
+	b.send(t, false, false, false, false)
+	b.advance(30 * time.Second)
+	b.expect(t, HalfOpen)
+
+	b.send(t, true)
+	b.expect(t, HalfOpen)
+	b.send(t, true)
+	b.expect(t, Closed)
+
+	// the closed breaker starts a new window
+	b.send(t, false, false, false)
+	b.expect(t, Closed)
+	if want := []string{"closed->open", "open->half-open", "half-open->closed"}; !reflect.DeepEqual(b.transitions, want) {
+		t.Errorf("transitions = %v, want %v", b.transitions, want)
+	}
+}
+
+func TestHalfOpenReopens(t *testing.T) {
+	b := newTestBreaker()
+	b.send(t, false, false, false, false)
+	b.advance(30 * time.Second)
+
+	b.send(t, true, false)
+	b.expect(t, Open)
+
+	// the cool down restarts when the breaker reopens
+	b.advance(29 * time.Second)
+	b.expect(t, Open)
+	b.advance(time.Second)
+	b.expect(t, HalfOpen)
+	// probes are reset for every half-open state
+	b.send(t, true, true)
+	b.expect(t, Closed)
+	want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if !reflect.DeepEqual(b.transitions, want) {
+		t.Errorf("transitions = %v, want %v", b.transitions, want)
+	}
+}
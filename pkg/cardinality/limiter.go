@@ -0,0 +1,247 @@
+// Package cardinality limits active series per metric name and per label value combination
+package cardinality
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// MetricLimit is the name of limit of max-series-per-metric
+const MetricLimit = prompb.MetricNameLabel
+
+// sampleBase is the resolution of sample rate
+const sampleBase = 10000
+
+// overflowKey is the key of the group shared by series of groups over max groups, group keys are never empty
+const overflowKey = ""
+
+// OverflowGroup is the name of the group shared by series of groups over max groups
+const OverflowGroup = "__overflow__"
+
+// Limiter tracks series hashes of every group in two generations which are rotated every window,
+// a series is active if it's in either generation, so it's kept active for 1-2 windows after it's last seen.
+// Only admitted series are tracked, memory of a group is bounded by its limit, and groups of a limit are bounded
+// by max groups, series of further groups are counted into one overflow group
+type Limiter struct {
+	mu         sync.Mutex
+	limits     []*limit
+	maxGroups  int
+	window     time.Duration
+	lastRotate time.Time
+	sample     bool
+	sampleRate uint64
+}
+
+type limit struct {
+	name   string
+	labels []string
+	max    int
+	groups map[string]*group
+	// active is the total active series of all groups
+	active int
+	hits   uint64
+}
+
+type group struct {
+	name     string
+	current  map[uint64]struct{}
+	previous map[uint64]struct{}
+}
+
+func (g *group) active() int {
+	return len(g.current) + len(g.previous)
+}
+
+// Result is the decision of a series
+type Result struct {
+	Admitted bool
+	// Limit is the name of the limit which is hit, empty if no limit is hit
+	Limit string
+}
+
+func NewLimiter(conf *config.CardinalityConfig) *Limiter {
+	l := &Limiter{
+		maxGroups:  conf.MaxGroups,
+		window:     conf.Window,
+		lastRotate: time.Now(),
+		sample:     conf.Policy == config.CardinalitySample,
+		sampleRate: uint64(conf.SampleRate * sampleBase),
+	}
+	if conf.MaxSeriesPerMetric > 0 {
+		l.limits = append(l.limits, newLimit(MetricLimit, []string{prompb.MetricNameLabel}, conf.MaxSeriesPerMetric))
+	}
+	for _, ll := range conf.LabelLimits {
+		l.limits = append(l.limits, newLimit(strings.Join(ll.Labels, ","), ll.Labels, ll.MaxSeries))
+	}
+	return l
+}
+
+func newLimit(name string, labels []string, max int) *limit {
+	return &limit{name: name, labels: labels, max: max, groups: map[string]*group{}}
+}
+
+// Admit checks series against all limits, active series are always admitted,
+// new series are admitted only if no limit is exceeded or it's sampled
+func (l *Limiter) Admit(labels []prompb.Label) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rotate(time.Now())
+
+	h := hash(labels)
+	keys := make([]string, len(l.limits))
+	var hit *limit
+	for i, lim := range l.limits {
+		key := groupKey(labels, lim.labels)
+		g, ok := lim.groups[key]
+		if !ok && l.maxGroups > 0 && len(lim.groups) >= l.maxGroups {
+			key = overflowKey
+			g = lim.groups[key]
+		}
+		keys[i] = key
+		// a missing group has no active series, and limits are positive
+		if hit == nil && g != nil && !g.has(h) && g.active() >= lim.max {
+			hit = lim
+		}
+	}
+
+	if hit != nil {
+		hit.hits++
+		// sampling by hash keeps the decision of a series stable
+		return Result{Admitted: l.sample && h%sampleBase < l.sampleRate, Limit: hit.name}
+	}
+	// groups are created by admitted series only
+	for i, lim := range l.limits {
+		g, ok := lim.groups[keys[i]]
+		if !ok {
+			name := OverflowGroup
+			if keys[i] != overflowKey {
+				name = groupName(labels, lim.labels)
+			}
+			g = &group{name: name, current: map[uint64]struct{}{}, previous: map[uint64]struct{}{}}
+			lim.groups[keys[i]] = g
+		}
+		if g.touch(h) {
+			lim.active++
+		}
+	}
+	return Result{Admitted: true}
+}
+
+func (g *group) has(h uint64) bool {
+	if _, ok := g.current[h]; ok {
+		return true
+	}
+	_, ok := g.previous[h]
+	return ok
+}
+
+// touch moves series to current generation, returns true if it's a new series
+func (g *group) touch(h uint64) bool {
+	if _, ok := g.current[h]; ok {
+		return false
+	}
+	g.current[h] = struct{}{}
+	if _, ok := g.previous[h]; ok {
+		delete(g.previous, h)
+		return false
+	}
+	return true
+}
+
+func (l *Limiter) rotate(now time.Time) {
+	if now.Sub(l.lastRotate) < l.window {
+		return
+	}
+	// nothing is active if no series is received in the last two windows
+	expireAll := now.Sub(l.lastRotate) >= 2*l.window
+	l.lastRotate = now
+	for _, lim := range l.limits {
+		lim.active = 0
+		for key, g := range lim.groups {
+			if expireAll || len(g.current) == 0 {
+				delete(lim.groups, key)
+				continue
+			}
+			g.previous = g.current
+			g.current = make(map[uint64]struct{}, len(g.previous))
+			lim.active += len(g.previous)
+		}
+	}
+}
+
+// LimitStats is the snapshot of a limit
+type LimitStats struct {
+	Limit     string       `json:"limit"`
+	MaxSeries int          `json:"max-series"`
+	Active    int          `json:"active"`
+	Groups    int          `json:"groups"`
+	Hits      uint64       `json:"hits"`
+	Top       []GroupStats `json:"top,omitempty"`
+}
+
+type GroupStats struct {
+	Group  string `json:"group"`
+	Active int    `json:"active"`
+}
+
+// Stats returns snapshot of all limits with top n groups by active series
+func (l *Limiter) Stats(n int) []LimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rotate(time.Now())
+
+	stats := make([]LimitStats, 0, len(l.limits))
+	for _, lim := range l.limits {
+		s := LimitStats{Limit: lim.name, MaxSeries: lim.max, Active: lim.active, Groups: len(lim.groups), Hits: lim.hits}
+		if n > 0 {
+			for _, g := range lim.groups {
+				s.Top = append(s.Top, GroupStats{Group: g.name, Active: g.active()})
+			}
+			sort.Slice(s.Top, func(i, j int) bool {
+				if s.Top[i].Active != s.Top[j].Active {
+					return s.Top[i].Active > s.Top[j].Active
+				}
+				return s.Top[i].Group < s.Top[j].Group
+			})
+			if len(s.Top) > n {
+				s.Top = s.Top[:n]
+			}
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+func hash(labels []prompb.Label) uint64 {
+	h := fnv.New64a()
+	for _, l := range labels {
+		h.Write([]byte(l.Name))
+		h.Write([]byte{0xff})
+		h.Write([]byte(l.Value))
+		h.Write([]byte{0xff})
+	}
+	return h.Sum64()
+}
+
+func groupKey(labels []prompb.Label, names []string) string {
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(prompb.LabelValue(labels, name))
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
+
+func groupName(labels []prompb.Label, names []string) string {
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+prompb.LabelValue(labels, name))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,74 @@
+package cardinality
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+func series(name, instance string) []prompb.Label {
+	return []prompb.Label{{Name: prompb.MetricNameLabel, Value: name}, {Name: "instance", Value: instance}}
+}
+
+func TestLimiterMaxGroups(t *testing.T) {
+	l := NewLimiter(&config.CardinalityConfig{
+		Window:             time.Hour,
+		MaxSeriesPerMetric: 2,
+		MaxGroups:          2,
+		Policy:             config.CardinalityReject,
+	})
+	for i, tt := range []struct {
+		labels   []prompb.Label
+		admitted bool
+	}{
+		{series("a", "1"), true},
+		{series("b", "1"), true},
+		// groups of c and d are over max groups and share the overflow group
+		{series("c", "1"), true},
+		{series("d", "1"), true},
+		{series("e", "1"), false},
+		// active series of the overflow group are admitted
+		{series("c", "1"), true},
+		// tracked groups are limited on their own
+		{series("a", "2"), true},
+		{series("a", "3"), false},
+	} {
+		if got := l.Admit(tt.labels).Admitted; got != tt.admitted {
+			t.Errorf("%d: admitted = %v, want %v", i, got, tt.admitted)
+		}
+	}
+	stats := l.Stats(10)
+	if stats[0].Groups != 3 {
+		t.Errorf("groups = %d, want 3", stats[0].Groups)
+	}
+	for _, g := range stats[0].Top {
+		if g.Group == OverflowGroup && g.Active != 2 {
+			t.Errorf("overflow group has %d active series, want 2", g.Active)
+		}
+	}
+}
+
+func TestLimiterTracksAdmittedSeriesOnly(t *testing.T) {
+	l := NewLimiter(&config.CardinalityConfig{
+		Window:             time.Hour,
+		MaxSeriesPerMetric: 1,
+		LabelLimits:        []config.LabelLimit{{Labels: []string{"instance"}, MaxSeries: 1}},
+		MaxGroups:          10,
+		Policy:             config.CardinalityReject,
+	})
+	// groups of series rejected by the metric limit are never created
+	l.Admit(series("a", "0"))
+	for i := 1; i < 100; i++ {
+		if l.Admit(series("a", strconv.Itoa(i))).Admitted {
+			t.Fatalf("series %d is admitted", i)
+		}
+	}
+	for _, s := range l.Stats(0) {
+		if s.Groups != 1 || s.Active != 1 {
+			t.Errorf("limit %s: groups = %d, active = %d, want 1 and 1", s.Limit, s.Groups, s.Active)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Package chunkenc encodes samples in prometheus tsdb XOR chunks, which are the chunks of streamed remote read
+package chunkenc
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// MaxSamplesPerChunk is the samples prometheus puts in a chunk
+const MaxSamplesPerChunk = 120
+
+// XORChunk holds samples with delta-of-delta encoded timestamps and XOR encoded values,
+// the first 2 bytes are the number of samples in big endian
+type XORChunk struct {
+	b bstream
+
+	t        int64
+	v        float64
+	tDelta   uint64
+	leading  uint8
+	trailing uint8
+}
+
+func NewXORChunk() *XORChunk {
+	return &XORChunk{b: bstream{stream: make([]byte, 2, 128)}, leading: 0xff}
+}
+
+// NumSamples returns number of samples appended
+func (c *XORChunk) NumSamples() int {
+	return int(binary.BigEndian.Uint16(c.b.stream))
+}
+
+// Bytes returns encoded chunk, it's valid until next Append
+func (c *XORChunk) Bytes() []byte {
+	return c.b.stream
+}
+
+// Append appends a sample, timestamps must be increasing
+func (c *XORChunk) Append(t int64, v float64) {
+	var tDelta uint64
+	num := c.NumSamples()
+	switch num {
+	case 0:
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, b := range buf[:binary.PutVarint(buf, t)] {
+			c.b.writeByte(b)
+		}
+		c.b.writeBits(math.Float64bits(v), 64)
+	case 1:
+		tDelta = uint64(t - c.t)
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, b := range buf[:binary.PutUvarint(buf, tDelta)] {
+			c.b.writeByte(b)
+		}
+		c.writeValue(v)
+	default:
+		tDelta = uint64(t - c.t)
+		dod := int64(tDelta - c.tDelta)
+		switch {
+		case dod == 0:
+			c.b.writeBit(false)
+		case bitRange(dod, 14):
+			c.b.writeBits(0b10, 2)
+			c.b.writeBits(uint64(dod), 14)
+		case bitRange(dod, 17):
+			c.b.writeBits(0b110, 3)
+			c.b.writeBits(uint64(dod), 17)
+		case bitRange(dod, 20):
+			c.b.writeBits(0b1110, 4)
+			c.b.writeBits(uint64(dod), 20)
+		default:
+			c.b.writeBits(0b1111, 4)
+			c.b.writeBits(uint64(dod), 64)
+		}
+		c.writeValue(v)
+	}
+
+	c.t, c.v, c.tDelta = t, v, tDelta
+	binary.BigEndian.PutUint16(c.b.stream, uint16(num+1))
+}
+
+// bitRange reports whether x fits in nbits signed bits as prometheus defines it
+func bitRange(x int64, nbits uint8) bool {
+	return -((1<<(nbits-1))-1) <= x && x <= 1<<(nbits-1)
+}
+
+// writeValue writes XOR of the value and the previous one, meaningful bits are written in the
+// previous leading/trailing window if they fit, otherwise a new window is written
+func (c *XORChunk) writeValue(v float64) {
+	delta := math.Float64bits(v) ^ math.Float64bits(c.v)
+	if delta == 0 {
+		c.b.writeBit(false)
+		return
+	}
+	c.b.writeBit(true)
+
+	leading := uint8(bits.LeadingZeros64(delta))
+	trailing := uint8(bits.TrailingZeros64(delta))
+	// leading is written in 5 bits
+	if leading >= 32 {
+		leading = 31
+	}
+	if c.leading != 0xff && leading >= c.leading && trailing >= c.trailing {
+		c.b.writeBit(false)
+		c.b.writeBits(delta>>c.trailing, 64-int(c.leading)-int(c.trailing))
+		return
+	}
+
+	c.leading, c.trailing = leading, trailing
+	c.b.writeBit(true)
+	c.b.writeBits(uint64(leading), 5)
+	// 64 significant bits overflow to 0 in 6 bits, readers take 0 as 64
+	sigbits := 64 - leading - trailing
+	c.b.writeBits(uint64(sigbits), 6)
+	c.b.writeBits(delta>>trailing, int(sigbits))
+}
+
+// bstream is a stream of bits written from the most significant bit of every byte
+type bstream struct {
+	stream []byte
+	// count is the number of bits available in the last byte
+	count uint8
+}
+
+func (b *bstream) writeBit(bit bool) {
+	if b.count == 0 {
+		b.stream = append(b.stream, 0)
+		b.count = 8
+	}
+	if bit {
+		b.stream[len(b.stream)-1] |= 1 << (b.count - 1)
+	}
+	b.count--
+}
+
+func (b *bstream) writeByte(byt byte) {
+	if b.count == 0 {
+		b.stream = append(b.stream, 0)
+		b.count = 8
+	}
+	i := len(b.stream) - 1
+	b.stream[i] |= byt >> (8 - b.count)
+	b.stream = append(b.stream, byt<<b.count)
+}
+
+func (b *bstream) writeBits(u uint64, nbits int) {
+	u <<= 64 - uint(nbits)
+	for nbits >= 8 {
+		b.writeByte(byte(u >> 56))
+		u <<= 8
+		nbits -= 8
+	}
+	for nbits > 0 {
+		b.writeBit(u>>63 == 1)
+		u <<= 1
+		nbits--
+	}
+}
@@ -0,0 +1,191 @@
+package chunkenc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+// breader reads bits written by bstream
+type breader struct {
+	b   []byte
+	pos int
+}
+
+var errEOF = errors.New("unexpected end of chunk")
+
+func (r *breader) readBits(n int) (uint64, error) {
+	var u uint64
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.b)*8 {
+			return 0, errEOF
+		}
+		u = u<<1 | uint64(r.b[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return u, nil
+}
+
+func (r *breader) ReadByte() (byte, error) {
+	u, err := r.readBits(8)
+	return byte(u), err
+}
+
+// decode reads chunk the way xorIterator of prometheus tsdb does
+func decode(chunk []byte) ([]sample, error) {
+	n := int(binary.BigEndian.Uint16(chunk))
+	r := &breader{b: chunk[2:]}
+	var (
+		out               []sample
+		t                 int64
+		vbits             uint64
+		tDelta            uint64
+		leading, trailing uint64
+	)
+	readValue := func() error {
+		changed, err := r.readBits(1)
+		if err != nil || changed == 0 {
+			return err
+		}
+		newWindow, err := r.readBits(1)
+		if err != nil {
+			return err
+		}
+		if newWindow == 1 {
+			if leading, err = r.readBits(5); err != nil {
+				return err
+			}
+			sigbits, err := r.readBits(6)
+			if err != nil {
+				return err
+			}
+			if sigbits == 0 {
+				sigbits = 64
+			}
+			trailing = 64 - leading - sigbits
+		}
+		delta, err := r.readBits(int(64 - leading - trailing))
+		vbits ^= delta << trailing
+		return err
+	}
+	for i := 0; i < n; i++ {
+		switch i {
+		case 0:
+			var err error
+			if t, err = binary.ReadVarint(r); err != nil {
+				return nil, err
+			}
+			if vbits, err = r.readBits(64); err != nil {
+				return nil, err
+			}
+		case 1:
+			var err error
+			if tDelta, err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+			t += int64(tDelta)
+			if err := readValue(); err != nil {
+				return nil, err
+			}
+		default:
+			var d byte
+			for j := 0; j < 4; j++ {
+				d <<= 1
+				bit, err := r.readBits(1)
+				if err != nil {
+					return nil, err
+				}
+				if bit == 0 {
+					break
+				}
+				d |= 1
+			}
+			sz := map[byte]int{0b0: 0, 0b10: 14, 0b110: 17, 0b1110: 20, 0b1111: 64}[d]
+			var dod int64
+			if sz != 0 {
+				u, err := r.readBits(sz)
+				if err != nil {
+					return nil, err
+				}
+				if sz != 64 && u > 1<<(sz-1) {
+					u -= 1 << sz
+				}
+				dod = int64(u)
+			}
+			tDelta = uint64(int64(tDelta) + dod)
+			t += int64(tDelta)
+			if err := readValue(); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, sample{t: t, v: math.Float64frombits(vbits)})
+	}
+	return out, nil
+}
+
+func TestXORChunk(t *testing.T) {
+	staleNaN := math.Float64frombits(0x7ff0000000000002)
+	regular := func(n int, v func(i int) float64) []sample {
+		var out []sample
+		for i := 0; i < n; i++ {
+			out = append(out, sample{t: 1700000000000 + int64(i)*15000, v: v(i)})
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		samples []sample
+	}{
+		{name: "single sample", samples: []sample{{t: 1700000000000, v: 1}}},
+		{name: "two samples", samples: []sample{{t: 1700000000000, v: 1}, {t: 1700000015000, v: 2}}},
+		{name: "constant", samples: regular(MaxSamplesPerChunk, func(int) float64 { return 1 })},
+		{name: "counter", samples: regular(MaxSamplesPerChunk, func(i int) float64 { return float64(i * i * 1000) })},
+		{name: "gauge", samples: regular(MaxSamplesPerChunk, func(i int) float64 { return math.Sin(float64(i)) * 1e6 })},
+		{
+			name: "delta of delta in every bit range",
+			samples: []sample{
+				{t: 0, v: 0}, {t: 15000, v: 0}, {t: 30000, v: 0},
+				{t: 45001, v: 0}, {t: 60000, v: 0},
+				{t: 83192, v: 0}, {t: 90001, v: 0}, {t: 98192, v: 0},
+				{t: 200000, v: 0}, {t: 265536, v: 0},
+				{t: 800000, v: 0}, {t: 1324288, v: 0},
+				{t: 100000000, v: 0}, {t: 100000001, v: 0},
+			},
+		},
+		{name: "negative timestamps", samples: []sample{{t: -30000, v: 1}, {t: -15000, v: 2}, {t: 0, v: 3}, {t: 15000, v: 4}}},
+		{
+			name: "special values",
+			samples: []sample{
+				{t: 1, v: 0}, {t: 2, v: math.Copysign(0, -1)}, {t: 3, v: math.NaN()}, {t: 4, v: staleNaN},
+				{t: 5, v: math.Inf(1)}, {t: 6, v: math.Inf(-1)}, {t: 7, v: math.MaxFloat64},
+				{t: 8, v: math.SmallestNonzeroFloat64}, {t: 9, v: -1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewXORChunk()
+			for _, s := range tt.samples {
+				c.Append(s.t, s.v)
+			}
+			if c.NumSamples() != len(tt.samples) {
+				t.Fatalf("NumSamples() = %d, want %d", c.NumSamples(), len(tt.samples))
+			}
+			got, err := decode(c.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range tt.samples {
+				if got[i].t != s.t || math.Float64bits(got[i].v) != math.Float64bits(s.v) {
+					t.Errorf("sample %d = %v, want %v", i, got[i], s)
+				}
+			}
+		})
+	}
+}
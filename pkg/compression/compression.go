@@ -0,0 +1,284 @@
+// Package compression encodes and decodes remote write payloads, codecs are selected by name in config
+// and by Content-Encoding header of requests
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// names of codecs, none sends payloads uncompressed with identity encoding
+const (
+	Snappy = "snappy"
+	Zstd   = "zstd"
+	Gzip   = "gzip"
+	None   = "none"
+)
+
+// Names are all codecs, snappy is the default one since remote write requires it
+var Names = []string{Snappy, Zstd, Gzip, None}
+
+const identityEncoding = "identity"
+
+// ErrTooLarge is returned by DecodeMax if the decoded payload exceeds the max size
+var ErrTooLarge = errors.New("decoded payload is too large")
+
+// Default is the snappy codec, which every remote write receiver accepts
+var Default Codec = snappyCodec{}
+
+// Codec compresses payloads, codecs are safe for concurrent use
+type Codec interface {
+	Name() string
+	// ContentEncoding is the Content-Encoding header of compressed payloads
+	ContentEncoding() string
+	Encode(src []byte) ([]byte, error)
+	Decode(src []byte) ([]byte, error)
+	// DecodeMax decodes untrusted payloads, ErrTooLarge is returned once the decoded size exceeds max,
+	// before it's allocated
+	DecodeMax(src []byte, max int) ([]byte, error)
+}
+
+// New returns the named codec, level 0 means the default level of the codec
+func New(name string, level int) (Codec, error) {
+	if err := ValidateLevel(name, level); err != nil {
+		return nil, err
+	}
+	switch name {
+	case Snappy, "":
+		return Default, nil
+	case Zstd:
+		return newZstdCodec(level)
+	case Gzip:
+		return newGzipCodec(level), nil
+	default:
+		return noneCodec{}, nil
+	}
+}
+
+// ValidateLevel validates codec name and compression level, snappy and none have no levels
+func ValidateLevel(name string, level int) error {
+	var max int
+	switch name {
+	case Snappy, "", None:
+		max = 0
+	case Zstd:
+		max = int(zstd.SpeedBestCompression)
+	case Gzip:
+		max = gzip.BestCompression
+	default:
+		return fmt.Errorf("unknown compression %q, available: %v", name, Names)
+	}
+	if level < 0 || level > max {
+		if max == 0 {
+			return fmt.Errorf("compression %s has no levels, got %d", name, level)
+		}
+		return fmt.Errorf("level of compression %s must be in range 0-%d, got %d", name, max, level)
+	}
+	return nil
+}
+
+// ForContentEncoding returns the codec decoding payloads of the Content-Encoding header,
+// payloads without Content-Encoding are snappy compressed as remote write requires
+func ForContentEncoding(encoding string) (Codec, error) {
+	switch encoding {
+	case "", Snappy:
+		return Default, nil
+	case Zstd:
+		return zstdDecoder, nil
+	case Gzip:
+		return Gunzip, nil
+	case identityEncoding:
+		return noneCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string            { return Snappy }
+func (snappyCodec) ContentEncoding() string { return Snappy }
+
+func (snappyCodec) Encode(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCodec) Decode(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
+
+// DecodeMax checks the decoded length in the snappy header before decoding
+func (snappyCodec) DecodeMax(src []byte, max int) ([]byte, error) {
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, ErrTooLarge
+	}
+	return snappy.Decode(nil, src)
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string                      { return None }
+func (noneCodec) ContentEncoding() string           { return identityEncoding }
+func (noneCodec) Encode(src []byte) ([]byte, error) { return src, nil }
+func (noneCodec) Decode(src []byte) ([]byte, error) { return src, nil }
+
+func (noneCodec) DecodeMax(src []byte, max int) ([]byte, error) {
+	if len(src) > max {
+		return nil, ErrTooLarge
+	}
+	return src, nil
+}
+
+// zstdDecoder decodes all zstd payloads, its encoder is created on first encode
+var zstdDecoder = &zstdCodec{}
+
+type zstdCodec struct {
+	level zstd.EncoderLevel
+
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+
+	// limited are decoders of DecodeMax by max size, max sizes rarely change as they're configured
+	mu      sync.Mutex
+	limited map[int]*zstd.Decoder
+}
+
+func newZstdCodec(level int) (*zstdCodec, error) {
+	c := &zstdCodec{level: zstd.EncoderLevel(level)}
+	c.init()
+	return c, c.err
+}
+
+func (c *zstdCodec) init() {
+	c.once.Do(func() {
+		if c.level == 0 {
+			c.level = zstd.SpeedDefault
+		}
+		// encoder and decoder without readers and writers only serve EncodeAll and DecodeAll, which are concurrent safe
+		if c.encoder, c.err = zstd.NewWriter(nil, zstd.WithEncoderLevel(c.level)); c.err != nil {
+			return
+		}
+		c.decoder, c.err = zstd.NewReader(nil)
+	})
+}
+
+func (c *zstdCodec) Name() string            { return Zstd }
+func (c *zstdCodec) ContentEncoding() string { return Zstd }
+
+func (c *zstdCodec) Encode(src []byte) ([]byte, error) {
+	if c.init(); c.err != nil {
+		return nil, c.err
+	}
+	return c.encoder.EncodeAll(src, nil), nil
+}
+
+func (c *zstdCodec) Decode(src []byte) ([]byte, error) {
+	if c.init(); c.err != nil {
+		return nil, c.err
+	}
+	return c.decoder.DecodeAll(src, nil)
+}
+
+func (c *zstdCodec) DecodeMax(src []byte, max int) ([]byte, error) {
+	d, err := c.limitedDecoder(max)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := d.DecodeAll(src, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, ErrTooLarge
+	}
+	return raw, err
+}
+
+// limitedDecoder returns the decoder whose memory is bounded by max
+func (c *zstdCodec) limitedDecoder(max int) (*zstd.Decoder, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d, ok := c.limited[max]; ok {
+		return d, nil
+	}
+	d, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(max)))
+	if err != nil {
+		return nil, err
+	}
+	// decoders of stale max sizes may be in use, they're left to gc
+	c.limited = map[int]*zstd.Decoder{max: d}
+	return d, nil
+}
+
+// Gunzip decodes all gzip payloads, payloads of OTLP and influx are gzip compressed
+var Gunzip = newGzipCodec(0)
+
+// gzipCodec pools writers, since they allocate large tables
+type gzipCodec struct {
+	writers sync.Pool
+}
+
+func newGzipCodec(level int) *gzipCodec {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	c := &gzipCodec{}
+	c.writers.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, level)
+		return w
+	}
+	return c
+}
+
+func (c *gzipCodec) Name() string            { return Gzip }
+func (c *gzipCodec) ContentEncoding() string { return Gzip }
+
+func (c *gzipCodec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := c.writers.Get().(*gzip.Writer)
+	defer c.writers.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gzipCodec) Decode(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// DecodeMax reads one byte beyond max to tell whether the payload exceeds it
+func (c *gzipCodec) DecodeMax(src []byte, max int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	raw, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > max {
+		return nil, ErrTooLarge
+	}
+	return raw, nil
+}
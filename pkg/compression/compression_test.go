@@ -0,0 +1,186 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+// codecs are all codecs, zstd at all levels and gzip at its fastest, default and best levels
+var codecs = []struct {
+	name  string
+	codec string
+	level int
+}{
+	{"none", None, 0},
+	{"snappy", Snappy, 0},
+	{"zstd-1", Zstd, 1},
+	{"zstd-2", Zstd, 2},
+	{"zstd-3", Zstd, 3},
+	{"zstd-4", Zstd, 4},
+	{"gzip-1", Gzip, 1},
+	{"gzip-6", Gzip, 6},
+	{"gzip-9", Gzip, 9},
+}
+
+// payloads returns uncompressed remote write payloads of testdata, which are snappy compressed request bodies.
+// Bodies recorded from real traffic can be dropped into testdata to compare codecs on them
+func payloads(tb testing.TB) ([][]byte, int) {
+	tb.Helper()
+	files, err := filepath.Glob(filepath.Join("testdata", "*.snappy"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no payloads in testdata: %v", err)
+	}
+	var out [][]byte
+	size := 0
+	for _, file := range files {
+		body, err := os.ReadFile(file)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		raw, err := snappy.Decode(nil, body)
+		if err != nil {
+			tb.Fatalf("decode %s: %v", file, err)
+		}
+		out = append(out, raw)
+		size += len(raw)
+	}
+	return out, size
+}
+
+func newCodec(tb testing.TB, name string, level int) Codec {
+	tb.Helper()
+	codec, err := New(name, level)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return codec
+}
+
+func TestRoundTrip(t *testing.T) {
+	raw, _ := payloads(t)
+	for _, c := range codecs {
+		t.Run(c.name, func(t *testing.T) {
+			codec := newCodec(t, c.codec, c.level)
+			decoder, err := ForContentEncoding(codec.ContentEncoding())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, p := range raw {
+				encoded, err := codec.Encode(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := decoder.Decode(encoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decoded, p) {
+					t.Errorf("payload %d changed after round trip", i)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeMax(t *testing.T) {
+	raw, _ := payloads(t)
+	p := raw[0]
+	for _, c := range codecs {
+		t.Run(c.name, func(t *testing.T) {
+			codec := newCodec(t, c.codec, c.level)
+			decoder, err := ForContentEncoding(codec.ContentEncoding())
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := codec.Encode(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := decoder.DecodeMax(encoded, len(p))
+			if err != nil {
+				t.Fatalf("payload of max size: %v", err)
+			}
+			if !bytes.Equal(decoded, p) {
+				t.Error("payload changed after round trip")
+			}
+			if _, err := decoder.DecodeMax(encoded, len(p)-1); !errors.Is(err, ErrTooLarge) {
+				t.Errorf("payload over max size: got %v, want ErrTooLarge", err)
+			}
+		})
+	}
+}
+
+// TestDecodeMaxBomb checks that highly compressible payloads are rejected without decoding them in full
+func TestDecodeMaxBomb(t *testing.T) {
+	bomb := make([]byte, 64<<20)
+	for _, name := range []string{Snappy, Zstd, Gzip} {
+		t.Run(name, func(t *testing.T) {
+			codec := newCodec(t, name, 0)
+			encoded, err := codec.Encode(bomb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := codec.DecodeMax(encoded, 1<<20); !errors.Is(err, ErrTooLarge) {
+				t.Errorf("got %v, want ErrTooLarge", err)
+			}
+		})
+	}
+}
+
+// BenchmarkEncode reports throughput of uncompressed bytes and compression ratio of every codec:
+//
+//	go test ./pkg/compression -run '^$' -bench .
+func BenchmarkEncode(b *testing.B) {
+	raw, size := payloads(b)
+	for _, c := range codecs {
+		b.Run(c.name, func(b *testing.B) {
+			codec := newCodec(b, c.codec, c.level)
+			compressed := 0
+			for _, p := range raw {
+				encoded, err := codec.Encode(p)
+				if err != nil {
+					b.Fatal(err)
+				}
+				compressed += len(encoded)
+			}
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, p := range raw {
+					_, _ = codec.Encode(p)
+				}
+			}
+			b.ReportMetric(float64(size)/float64(compressed), "ratio")
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	raw, size := payloads(b)
+	for _, c := range codecs {
+		b.Run(c.name, func(b *testing.B) {
+			codec := newCodec(b, c.codec, c.level)
+			encoded := make([][]byte, len(raw))
+			for i, p := range raw {
+				var err error
+				if encoded[i], err = codec.Encode(p); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, e := range encoded {
+					_, _ = codec.Decode(e)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+	"prometheus-deepflow-adapter/pkg/selector"
+)
+
+// outputs of stream aggregation, rate and increase treat samples as counters
+const (
+	AggregateSum             = "sum"
+	AggregateCount           = "count"
+	AggregateMin             = "min"
+	AggregateMax             = "max"
+	AggregateAvg             = "avg"
+	AggregateRate            = "rate"
+	AggregateIncrease        = "increase"
+	AggregateQuantiles       = "quantiles"
+	AggregateHistogramBucket = "histogram_bucket"
+)
+
+var aggregateOutputs = []string{AggregateSum, AggregateCount, AggregateMin, AggregateMax, AggregateAvg,
+	AggregateRate, AggregateIncrease, AggregateQuantiles, AggregateHistogramBucket}
+
+// AggregationRule aggregates samples of series matching Match over tumbling windows of Interval,
+// aggregates are emitted as <metric>:<interval>[_by_<labels>|_without_<labels>]_<output> on window boundaries.
+// Series are grouped by By labels, or all labels except Without labels, or all labels if neither is set
+type AggregationRule struct {
+	// Match is a promql series selector
+	Match    string        `mapstructure:"match"`
+	Interval time.Duration `mapstructure:"interval"`
+	By       []string      `mapstructure:"by"`
+	Without  []string      `mapstructure:"without"`
+	Outputs  []string      `mapstructure:"outputs"`
+	// Quantiles are phi of quantiles output, default 0.5, 0.9 and 0.99
+	Quantiles []float64 `mapstructure:"quantiles"`
+	// Buckets are upper bounds of histogram_bucket output, default prometheus default buckets
+	Buckets []float64 `mapstructure:"buckets"`
+	// KeepInput forwards matching series as well, otherwise only aggregates are forwarded
+	KeepInput bool `mapstructure:"keep-input"`
+}
+
+func (r *AggregationRule) Validate() []error {
+	var errs []error
+	if _, err := selector.Parse(r.Match); err != nil {
+		errs = append(errs, NewFieldError("match", "%s", err))
+	}
+	if r.Interval < time.Second {
+		errs = append(errs, NewFieldError("interval", "must be at least 1s, got %s", r.Interval))
+	}
+	if len(r.By) > 0 && len(r.Without) > 0 {
+		errs = append(errs, NewFieldError("without", "must not be set with by"))
+	}
+	for _, group := range []struct {
+		field  string
+		labels []string
+	}{{"by", r.By}, {"without", r.Without}} {
+		for i, l := range group.labels {
+			if l == "" || l == prompb.MetricNameLabel {
+				errs = append(errs, NewFieldError(group.field, "label %d must not be empty or %s, got %q", i, prompb.MetricNameLabel, l))
+			}
+		}
+	}
+	if len(r.Outputs) == 0 {
+		errs = append(errs, NewFieldError("outputs", "must not be empty"))
+	}
+	seen := map[string]bool{}
+	for _, o := range r.Outputs {
+		if !contains(aggregateOutputs, o) {
+			errs = append(errs, NewFieldError("outputs", "must be one of %v, got %q", aggregateOutputs, o))
+		} else if seen[o] {
+			errs = append(errs, NewFieldError("outputs", "duplicated output %q", o))
+		}
+		seen[o] = true
+	}
+	for _, q := range r.Quantiles {
+		if q < 0 || q > 1 {
+			errs = append(errs, NewFieldError("quantiles", "must be in range 0-1, got %g", q))
+		}
+	}
+	for i := 1; i < len(r.Buckets); i++ {
+		if r.Buckets[i] <= r.Buckets[i-1] {
+			errs = append(errs, NewFieldError("buckets", "must be in increasing order, got %g after %g", r.Buckets[i], r.Buckets[i-1]))
+		}
+	}
+	return errs
+}
+
+func validateAggregationRules(path string, rules []AggregationRule) []error {
+	var errs []error
+	for i := range rules {
+		errs = append(errs, PrefixErrors(fmt.Sprintf("%s[%d]", path, i), rules[i].Validate())...)
+	}
+	return errs
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthMTLS   = "mtls"
+)
+
+// AuthConfig authenticates requests of ingest endpoints, methods are tried in order
+type AuthConfig struct {
+	Methods []string `mapstructure:"methods"`
+	// BasicUsers maps username to bcrypt hashed password
+	BasicUsers      map[string]string `mapstructure:"basic-users"`
+	BearerTokenFile string            `mapstructure:"bearer-token-file"`
+	// BearerPrincipal is the principal name of requests authenticated by bearer token
+	BearerPrincipal string           `mapstructure:"bearer-principal"`
+	ClientCert      ClientCertConfig `mapstructure:"client-cert"`
+}
+
+// ClientCertConfig restricts verified client certificates, empty lists allow any verified certificate
+type ClientCertConfig struct {
+	// AllowedSubjects matches certificate subject common name or the whole distinguished name
+	AllowedSubjects []string `mapstructure:"allowed-subjects"`
+	// AllowedSANs matches dns names, email addresses, uris and ip addresses of certificate
+	AllowedSANs []string `mapstructure:"allowed-sans"`
+}
+
+func (a *AuthConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("auth", pflag.ContinueOnError)
+	fs.StringSliceVar(&a.Methods, "methods", nil, "authentication methods for ingest endpoints: basic/bearer/mtls, empty means no authentication")
+	fs.StringVar(&a.BearerTokenFile, "bearer-token-file", "", "bearer token file for ingest endpoints")
+	fs.StringVar(&a.BearerPrincipal, "bearer-principal", "bearer", "principal name of bearer token authenticated requests")
+	fs.StringSliceVar(&a.ClientCert.AllowedSubjects, "client-cert-allowed-subjects", nil, "allowed client certificate subjects")
+	fs.StringSliceVar(&a.ClientCert.AllowedSANs, "client-cert-allowed-sans", nil, "allowed client certificate subject alternative names")
+	SetFlagKey(fs, "client-cert-allowed-subjects", "client-cert.allowed-subjects")
+	SetFlagKey(fs, "client-cert-allowed-sans", "client-cert.allowed-sans")
+	PrefixFlags(fs, "auth")
+	return fs
+}
+
+func (a *AuthConfig) Validate() []error {
+	var errs []error
+	for _, method := range a.Methods {
+		switch method {
+		case AuthBasic:
+			if len(a.BasicUsers) == 0 {
+				errs = append(errs, NewFieldError("basic-users", "must not be empty when basic auth is enabled"))
+			}
+			for user, hash := range a.BasicUsers {
+				if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+					errs = append(errs, NewFieldError("basic-users."+user, "invalid bcrypt hash: %s", err))
+				}
+			}
+		case AuthBearer:
+			if a.BearerTokenFile == "" {
+				errs = append(errs, NewFieldError("bearer-token-file", "must not be empty when bearer auth is enabled"))
+			}
+			errs = append(errs, validateFile("bearer-token-file", a.BearerTokenFile)...)
+		case AuthMTLS:
+		default:
+			errs = append(errs, NewFieldError("methods", "unknown method %q, available: [%s %s %s]", method, AuthBasic, AuthBearer, AuthMTLS))
+		}
+	}
+	return errs
+}
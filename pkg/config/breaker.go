@@ -0,0 +1,67 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	BreakerReject = "reject"
+	BreakerSpool  = "spool"
+)
+
+// CircuitBreakerConfig applies to every remote write destination, the breaker opens when failure ratio
+// in the window exceeds the threshold, and tries again with a few requests after cool down
+type CircuitBreakerConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	Window       time.Duration `mapstructure:"window"`
+	MinRequests  int           `mapstructure:"min-requests"`
+	FailureRatio float64       `mapstructure:"failure-ratio"`
+	CoolDown     time.Duration `mapstructure:"cool-down"`
+	// HalfOpenRequests are probes allowed in half-open state, breaker closes if all of them succeed
+	HalfOpenRequests int `mapstructure:"half-open-requests"`
+	// OpenAction is how writes are handled while breaker is open: reject responds 503 immediately,
+	// spool appends them to wal which is replayed after breaker closes
+	OpenAction string `mapstructure:"open-action"`
+}
+
+func (b *CircuitBreakerConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("circuit-breaker", pflag.ContinueOnError)
+	fs.BoolVar(&b.Enabled, "enabled", false, "enable/disable circuit breaker of remote write destinations")
+	fs.DurationVar(&b.Window, "window", time.Minute, "window of failure ratio")
+	fs.IntVar(&b.MinRequests, "min-requests", 10, "min requests in the window before breaker can open")
+	fs.Float64Var(&b.FailureRatio, "failure-ratio", 0.5, "breaker opens when failure ratio in the window reaches it")
+	fs.DurationVar(&b.CoolDown, "cool-down", 30*time.Second, "time breaker stays open before half-open")
+	fs.IntVar(&b.HalfOpenRequests, "half-open-requests", 3, "probe requests in half-open state")
+	fs.StringVar(&b.OpenAction, "open-action", BreakerReject, "how writes are handled while breaker is open: reject/spool")
+	PrefixFlags(fs, "circuit-breaker")
+	return fs
+}
+
+func (b *CircuitBreakerConfig) Validate() []error {
+	if !b.Enabled {
+		return nil
+	}
+	var errs []error
+	errs = append(errs, ValidatePositiveDuration("window", b.Window)...)
+	errs = append(errs, ValidatePositiveDuration("cool-down", b.CoolDown)...)
+	if b.MinRequests <= 0 {
+		errs = append(errs, NewFieldError("min-requests", "must be positive, got %d", b.MinRequests))
+	}
+	if b.FailureRatio <= 0 || b.FailureRatio > 1 {
+		errs = append(errs, NewFieldError("failure-ratio", "must be in range (0, 1], got %g", b.FailureRatio))
+	}
+	if b.HalfOpenRequests <= 0 {
+		errs = append(errs, NewFieldError("half-open-requests", "must be positive, got %d", b.HalfOpenRequests))
+	}
+	if b.OpenAction != BreakerReject && b.OpenAction != BreakerSpool {
+		errs = append(errs, NewFieldError("open-action", "must be one of [%s %s], got %q", BreakerReject, BreakerSpool, b.OpenAction))
+	}
+	return errs
+}
+
+// Spool reports whether writes are spooled to wal while breaker is open
+func (b *CircuitBreakerConfig) Spool() bool {
+	return b.Enabled && b.OpenAction == BreakerSpool
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	CardinalityReject = "reject"
+	CardinalitySample = "sample"
+
+	DefaultCardinalityMaxGroups = 10000
+)
+
+// CardinalityConfig limits active series, a series is active if it's received in the last window.
+// Series over the limit are new ones, series which are already active keep flowing
+type CardinalityConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Window  time.Duration `mapstructure:"window"`
+	// MaxSeriesPerMetric limits active series of every metric name, 0 means no limit
+	MaxSeriesPerMetric int `mapstructure:"max-series-per-metric"`
+	// LabelLimits limit active series of every distinct value combination of the labels
+	LabelLimits []LabelLimit `mapstructure:"label-limits"`
+	// MaxGroups bounds tracked groups of every limit, series of further groups share one overflow group
+	MaxGroups int `mapstructure:"max-groups"`
+	// Policy is how new series over the limit are handled: reject drops all of them,
+	// sample forwards a stable SampleRate fraction of them
+	Policy     string  `mapstructure:"policy"`
+	SampleRate float64 `mapstructure:"sample-rate"`
+}
+
+type LabelLimit struct {
+	Labels    []string `mapstructure:"labels" json:"labels"`
+	MaxSeries int      `mapstructure:"max-series" json:"max-series"`
+}
+
+func (c *CardinalityConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("cardinality", pflag.ContinueOnError)
+	fs.BoolVar(&c.Enabled, "enabled", false, "enable/disable cardinality limit")
+	fs.DurationVar(&c.Window, "window", time.Hour, "series not received in the window are no longer active")
+	fs.IntVar(&c.MaxSeriesPerMetric, "max-series-per-metric", 0, "max active series of every metric name, 0 means no limit")
+	fs.IntVar(&c.MaxGroups, "max-groups", DefaultCardinalityMaxGroups, "max tracked groups of every limit, series of further groups share one overflow group")
+	fs.StringVar(&c.Policy, "policy", CardinalityReject, "policy of new series over the limit: reject/sample")
+	fs.Float64Var(&c.SampleRate, "sample-rate", 0.1, "fraction of new series over the limit forwarded by sample policy")
+	PrefixFlags(fs, "cardinality")
+	return fs
+}
+
+func (c *CardinalityConfig) Validate() []error {
+	if !c.Enabled {
+		return nil
+	}
+	var errs []error
+	errs = append(errs, ValidatePositiveDuration("window", c.Window)...)
+	if c.MaxSeriesPerMetric < 0 {
+		errs = append(errs, NewFieldError("max-series-per-metric", "must not be negative, got %d", c.MaxSeriesPerMetric))
+	}
+	if c.MaxGroups <= 0 {
+		errs = append(errs, NewFieldError("max-groups", "must be positive, got %d", c.MaxGroups))
+	}
+	for i, limit := range c.LabelLimits {
+		path := fmt.Sprintf("label-limits[%d]", i)
+		if len(limit.Labels) == 0 {
+			errs = append(errs, NewFieldError(path+".labels", "must not be empty"))
+		}
+		if limit.MaxSeries <= 0 {
+			errs = append(errs, NewFieldError(path+".max-series", "must be positive, got %d", limit.MaxSeries))
+		}
+	}
+	switch c.Policy {
+	case CardinalityReject:
+	case CardinalitySample:
+		if c.SampleRate < 0 || c.SampleRate > 1 {
+			errs = append(errs, NewFieldError("sample-rate", "must be in range 0-1, got %g", c.SampleRate))
+		}
+	default:
+		errs = append(errs, NewFieldError("policy", "must be one of [%s %s], got %q", CardinalityReject, CardinalitySample, c.Policy))
+	}
+	return errs
+}
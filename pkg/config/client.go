@@ -0,0 +1,105 @@
+package config
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DefaultAuthorizationType is the credentials type of authorization header if it's not set
+const DefaultAuthorizationType = "Bearer"
+
+// DefaultSigV4Service is the service of sigv4 signatures if it's not set, amazon managed prometheus
+const DefaultSigV4Service = "aps"
+
+// BasicAuth sets basic authorization of remote writes, PasswordFile is re-read periodically
+type BasicAuth struct {
+	Username     string `mapstructure:"username"`
+	Password     string `mapstructure:"password"`
+	PasswordFile string `mapstructure:"password-file"`
+}
+
+// Authorization sets authorization header of remote writes to "<type> <credentials>", CredentialsFile
+// is re-read periodically, so rotated tokens take effect without reload
+type Authorization struct {
+	Type            string `mapstructure:"type"`
+	Credentials     string `mapstructure:"credentials"`
+	CredentialsFile string `mapstructure:"credentials-file"`
+}
+
+// OAuth2Config fetches tokens of remote writes by client credentials flow, tokens are cached until they expire
+type OAuth2Config struct {
+	ClientID         string   `mapstructure:"client-id"`
+	ClientSecret     string   `mapstructure:"client-secret"`
+	ClientSecretFile string   `mapstructure:"client-secret-file"`
+	TokenURL         string   `mapstructure:"token-url"`
+	Scopes           []string `mapstructure:"scopes"`
+	// EndpointParams are extra parameters of token requests
+	EndpointParams map[string]string `mapstructure:"endpoint-params"`
+}
+
+// SigV4Config signs remote writes with aws signature version 4. Credentials are access-key and secret-key, or those of
+// the default credential chain of aws sdk: env, shared credentials of the profile, and instance or pod roles
+type SigV4Config struct {
+	// Region defaults to AWS_REGION env or region of the profile
+	Region    string `mapstructure:"region"`
+	AccessKey string `mapstructure:"access-key"`
+	SecretKey string `mapstructure:"secret-key"`
+	// Profile is the profile of aws shared config and credentials files, empty means AWS_PROFILE env or default
+	Profile string `mapstructure:"profile"`
+	// RoleARN is assumed with the credentials if it's set
+	RoleARN string `mapstructure:"role-arn"`
+	Service string `mapstructure:"service"`
+}
+
+func (b *BasicAuth) Validate() []error {
+	var errs []error
+	if b.Username == "" {
+		errs = append(errs, NewFieldError("username", "must not be empty"))
+	}
+	if b.Password != "" && b.PasswordFile != "" {
+		errs = append(errs, NewFieldError("password-file", "must not be set with password"))
+	}
+	errs = append(errs, validateFile("password-file", b.PasswordFile)...)
+	return errs
+}
+
+func (a *Authorization) Validate() []error {
+	var errs []error
+	if strings.EqualFold(a.Type, "basic") {
+		errs = append(errs, NewFieldError("type", "must not be Basic, use basic-auth instead"))
+	}
+	if a.Credentials == "" && a.CredentialsFile == "" {
+		errs = append(errs, NewFieldError("credentials", "one of credentials and credentials-file must be set"))
+	}
+	if a.Credentials != "" && a.CredentialsFile != "" {
+		errs = append(errs, NewFieldError("credentials-file", "must not be set with credentials"))
+	}
+	errs = append(errs, validateFile("credentials-file", a.CredentialsFile)...)
+	return errs
+}
+
+func (o *OAuth2Config) Validate() []error {
+	var errs []error
+	if o.ClientID == "" {
+		errs = append(errs, NewFieldError("client-id", "must not be empty"))
+	}
+	if o.ClientSecret != "" && o.ClientSecretFile != "" {
+		errs = append(errs, NewFieldError("client-secret-file", "must not be set with client-secret"))
+	}
+	errs = append(errs, validateFile("client-secret-file", o.ClientSecretFile)...)
+	if u, err := url.Parse(o.TokenURL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, NewFieldError("token-url", "must be an absolute url, got %q", o.TokenURL))
+	}
+	return errs
+}
+
+func (s *SigV4Config) Validate() []error {
+	var errs []error
+	if (s.AccessKey == "") != (s.SecretKey == "") {
+		errs = append(errs, NewFieldError("secret-key", "access-key and secret-key must be set together"))
+	}
+	if s.AccessKey != "" && s.Profile != "" {
+		errs = append(errs, NewFieldError("profile", "must not be set with access-key"))
+	}
+	return errs
+}
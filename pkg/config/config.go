@@ -2,9 +2,15 @@ package config
 
 import (
 	"fmt"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/spf13/pflag"
+
+	"prometheus-deepflow-adapter/pkg/compression"
+	"prometheus-deepflow-adapter/pkg/prompb"
+	"prometheus-deepflow-adapter/pkg/relabel"
 )
 
 type Configuration interface {
@@ -17,21 +23,51 @@ type Config struct {
 	EventEnabled             bool          `mapstructure:"event-enabled"`
 	ProfileEnabled           bool          `mapstructure:"profile-enabled"`
 	PrometheusScrapeInterval time.Duration `mapstructure:"prometheus-scrape-interval"`
+	// remote write is considered dead after prometheus sends nothing in scrape interval + liveness timeout
+	PrometheusLivenessTimeout time.Duration `mapstructure:"prometheus-liveness-timeout"`
 
-	Port     int    `mapstructure:"port"`
-	LogLevel string `mapstructure:"log-level"`
+	Port         int          `mapstructure:"port"`
+	ServerConfig ServerConfig `mapstructure:"server"`
+	LogLevel     string       `mapstructure:"log-level"`
 
 	Elector Elector `mapstructure:"elector"`
 
 	// functional config
 	RemoteWriteConfig RemoteWriteConfig `mapstructure:"remote-write"`
-	// +WalConfig
+	// AdditionalRemoteWrites receive the same series as remote-write, they're only configurable in config file
+	AdditionalRemoteWrites []RemoteWriteConfig `mapstructure:"additional-remote-writes"`
+	// RelabelConfigs apply to all series before they're forwarded to any remote write destination
+	RelabelConfigs []relabel.Config `mapstructure:"relabel-configs"`
+	// StreamAggregation rules aggregate series after relabeling, they're only configurable in config file
+	StreamAggregation []AggregationRule `mapstructure:"stream-aggregation"`
+	// FilterConfig applies to all series right after they're decoded
+	FilterConfig           FilterConfig           `mapstructure:"filter"`
+	DedupConfig            DedupConfig            `mapstructure:"dedup"`
+	LateSamplesConfig      LateSamplesConfig      `mapstructure:"late-samples"`
+	RemoteReadConfig       RemoteReadConfig       `mapstructure:"remote-read"`
+	OTLPConfig             OTLPConfig             `mapstructure:"otlp"`
+	InfluxConfig           InfluxConfig           `mapstructure:"influx"`
+	GraphiteConfig         GraphiteConfig         `mapstructure:"graphite"`
+	SeriesValidationConfig SeriesValidationConfig `mapstructure:"series-validation"`
+	MetadataConfig         MetadataConfig         `mapstructure:"metadata"`
+	AuthConfig             AuthConfig             `mapstructure:"auth"`
+	TenancyConfig          TenancyConfig          `mapstructure:"tenancy"`
+	CardinalityConfig      CardinalityConfig      `mapstructure:"cardinality"`
+	RateLimitConfig        RateLimitConfig        `mapstructure:"rate-limit"`
+	// CircuitBreakerConfig applies to every remote write destination
+	CircuitBreakerConfig CircuitBreakerConfig `mapstructure:"circuit-breaker"`
+	WalConfig            WalConfig            `mapstructure:"wal"`
 
 	// debug-level config
 	TraceConfig   TraceConfig   `mapstructure:"trace"`
 	ProfileConfig ProfileConfig `mapstructure:"profile"`
+	AdminConfig   AdminConfig   `mapstructure:"admin"`
 
 	ExtraConfigs map[string]Configuration `mapstructure:"-"`
+	// Raw is the merged key-value view of all config sources, it's used for inspection only
+	Raw map[string]interface{} `mapstructure:"-"`
+	// RenamedKeys maps keys of old releases found in config sources to their current names
+	RenamedKeys map[string]string `mapstructure:"-"`
 }
 
 func NewConfig() *Config {
@@ -39,8 +75,10 @@ func NewConfig() *Config {
 		Port:              80,
 		LogLevel:          "info",
 		RemoteWriteConfig: RemoteWriteConfig{},
+		AuthConfig:        AuthConfig{},
 		TraceConfig:       TraceConfig{},
 		ProfileConfig:     ProfileConfig{},
+		AdminConfig:       AdminConfig{},
 	}
 	return cfg
 }
@@ -53,14 +91,32 @@ func (c *Config) ToOptions() *pflag.FlagSet {
 	fs.BoolVar(&c.EventEnabled, "event-enabled", false, "enable/disable tracing event")
 	fs.BoolVar(&c.ProfileEnabled, "profile-enabled", false, "enable/disable go profile")
 	fs.DurationVar(&c.PrometheusScrapeInterval, "prometheus-scrape-interval", 10*time.Second, "timeout calculation for receive promtheus data")
+	fs.DurationVar(&c.PrometheusLivenessTimeout, "prometheus-liveness-timeout", 10*time.Second, "extra timeout before prometheus is considered dead")
 
 	fs.IntVarP(&c.Port, "port", "p", 80, "http listen port")
-	fs.StringVar(&c.LogLevel, "log-level", "info", "log level for adapter")
+	fs.StringVar(&c.LogLevel, "log-level", "info", "log level for adapter, one of debug, info, warn and error")
 	fs.StringVar((*string)(&c.Elector), "elector", "k8s", "choose one election component")
 
+	fs.AddFlagSet(c.ServerConfig.ToOptions())
 	fs.AddFlagSet(c.RemoteWriteConfig.ToOptions())
+	fs.AddFlagSet(c.RemoteReadConfig.ToOptions())
+	fs.AddFlagSet(c.FilterConfig.ToOptions())
+	fs.AddFlagSet(c.DedupConfig.ToOptions())
+	fs.AddFlagSet(c.LateSamplesConfig.ToOptions())
+	fs.AddFlagSet(c.OTLPConfig.ToOptions())
+	fs.AddFlagSet(c.InfluxConfig.ToOptions())
+	fs.AddFlagSet(c.GraphiteConfig.ToOptions())
+	fs.AddFlagSet(c.SeriesValidationConfig.ToOptions())
+	fs.AddFlagSet(c.MetadataConfig.ToOptions())
+	fs.AddFlagSet(c.AuthConfig.ToOptions())
+	fs.AddFlagSet(c.TenancyConfig.ToOptions())
+	fs.AddFlagSet(c.CardinalityConfig.ToOptions())
+	fs.AddFlagSet(c.RateLimitConfig.ToOptions())
+	fs.AddFlagSet(c.CircuitBreakerConfig.ToOptions())
+	fs.AddFlagSet(c.WalConfig.ToOptions())
 	fs.AddFlagSet(c.TraceConfig.ToOptions())
 	fs.AddFlagSet(c.ProfileConfig.ToOptions())
+	fs.AddFlagSet(c.AdminConfig.ToOptions())
 
 	c.ExtraConfigs = make(map[string]Configuration, len(extraConfigs))
 	for k, configInit := range extraConfigs {
@@ -78,35 +134,131 @@ type TLSConfig struct {
 	ServerName string `mapstructure:"server-name"`
 }
 
+// DefaultRemoteWriteName is the name of remote-write destination if it's not named
+const DefaultRemoteWriteName = "default"
+
 type RemoteWriteConfig struct {
+	Name      string        `mapstructure:"name"`
 	Url       string        `mapstructure:"url"`
 	Insecure  bool          `mapstructure:"insecure"`
 	Timeout   time.Duration `mapstructure:"timeout"`
 	TLSConfig TLSConfig     `mapstructure:"tls-config"`
+	// WriteRelabelConfigs apply to series sent to this destination only, after the global relabel-configs
+	WriteRelabelConfigs []relabel.Config `mapstructure:"write-relabel-configs"`
+	// Filter applies to series sent to this destination only, before write-relabel-configs
+	Filter FilterConfig `mapstructure:"filter"`
+	// ProtobufMessage is the remote write version destination accepts, received requests are translated to it,
+	// empty means remote write 1.0
+	ProtobufMessage string `mapstructure:"protobuf-message"`
+	// ConvertNativeHistograms converts native histograms to classic histograms for destinations which can't ingest them
+	ConvertNativeHistograms bool `mapstructure:"convert-native-histograms"`
+	// Transport is http or grpc, grpc streams writes to deepflow ingesters of GRPC addresses
+	Transport ClientType       `mapstructure:"transport"`
+	GRPC      GRPCClientConfig `mapstructure:"grpc"`
+	// Retry retries writes failed with 5xx, 429 and network errors, writes rejected with other 4xx are dropped
+	Retry RetryConfig `mapstructure:"retry"`
+	// Compression is the codec of payloads: snappy, zstd, gzip or none, writes fall back to snappy once
+	// destination responds 415 Unsupported Media Type
+	Compression string `mapstructure:"compression"`
+	// CompressionLevel is 1-4 for zstd and 1-9 for gzip, 0 means the default level
+	CompressionLevel int `mapstructure:"compression-level"`
+	// Headers are added to remote writes, values are go templates of OutboundTemplateData
+	Headers map[string]string `mapstructure:"headers"`
+	// HeaderFiles are headers whose values are read from files on every write, e.g. secrets
+	HeaderFiles map[string]string `mapstructure:"header-files"`
+	// ForwardHeaders are copied from the inbound request
+	ForwardHeaders []string `mapstructure:"forward-headers"`
+	// QueryParams are added to the url, values are go templates of OutboundTemplateData
+	QueryParams map[string]string `mapstructure:"query-params"`
+	// ForwardQueryParams are copied from the inbound request
+	ForwardQueryParams []string `mapstructure:"forward-query-params"`
+	// at most one of BasicAuth, Authorization, OAuth2 and SigV4 is set, they're only configurable in config file
+	BasicAuth     *BasicAuth     `mapstructure:"basic-auth"`
+	Authorization *Authorization `mapstructure:"authorization"`
+	OAuth2        *OAuth2Config  `mapstructure:"oauth2"`
+	SigV4         *SigV4Config   `mapstructure:"sigv4"`
+}
+
+// OutboundTemplateData is the data of header and query parameter templates of remote writes
+type OutboundTemplateData struct {
+	// Tenant is empty unless tenancy is enabled
+	Tenant string
+	// Identity is the elector identity, or the hostname if it's unknown
+	Identity string
+	// ExternalLabels are labels shared by all series of the write, like prometheus external labels
+	ExternalLabels map[string]string
+}
+
+// ParseOutboundTemplate parses a header or query parameter template, missing external labels are empty
+func ParseOutboundTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Parse(text)
+}
+
+type RetryConfig struct {
+	// MaxRetries is the max retries of a write, 0 means no retry
+	MaxRetries int `mapstructure:"max-retries"`
+	// MinBackoff is doubled on every retry up to MaxBackoff
+	MinBackoff time.Duration `mapstructure:"min-backoff"`
+	MaxBackoff time.Duration `mapstructure:"max-backoff"`
+}
+
+// RemoteWrites returns all remote write destinations, the first one is remote-write
+func (c *Config) RemoteWrites() []RemoteWriteConfig {
+	primary := c.RemoteWriteConfig
+	if primary.Name == "" {
+		primary.Name = DefaultRemoteWriteName
+	}
+	return append([]RemoteWriteConfig{primary}, c.AdditionalRemoteWrites...)
 }
 
 func (r *RemoteWriteConfig) ToOptions() *pflag.FlagSet {
 	fs := pflag.NewFlagSet("remote-write", pflag.ContinueOnError)
+	fs.StringVar(&r.Name, "name", "", "remote write destination name, default: "+DefaultRemoteWriteName)
 	fs.StringVar(&r.Url, "url", "", "remote write url")
 	fs.BoolVar(&r.Insecure, "insecure", false, "insecure config for remote write")
 	fs.DurationVar(&r.Timeout, "timeout", 10*time.Second, "remote write timeout")
+	fs.StringVar(&r.ProtobufMessage, "protobuf-message", prompb.ProtoMsgV1, fmt.Sprintf("remote write version of destination: %s/%s", prompb.ProtoMsgV1, prompb.ProtoMsgV2))
+	fs.BoolVar(&r.ConvertNativeHistograms, "convert-native-histograms", false, "convert native histograms to classic histograms")
+	fs.StringVar(&r.Compression, "compression", compression.Snappy, fmt.Sprintf("compression of remote write payloads: %s", strings.Join(compression.Names, "/")))
+	fs.IntVar(&r.CompressionLevel, "compression-level", 0, "compression level, 1-4 for zstd and 1-9 for gzip, 0 means the default level")
+	fs.StringVar((*string)(&r.Transport), "transport", string(ClientHTTP), "remote write transport, http/grpc")
+	fs.StringSliceVar(&r.GRPC.Addresses, "grpc-addresses", nil, "host:port of deepflow ingesters with grpc transport")
+	fs.BoolVar(&r.GRPC.TLS, "grpc-tls", false, "enable tls of grpc transport")
+	fs.IntVar(&r.GRPC.Streams, "grpc-streams", DefaultGRPCStreams, "concurrent write streams of grpc transport")
+	fs.IntVar(&r.GRPC.MaxInflight, "grpc-max-inflight", DefaultGRPCMaxInflight, "max unacknowledged writes per grpc stream")
+	fs.DurationVar(&r.GRPC.KeepaliveTime, "grpc-keepalive-time", DefaultGRPCKeepaliveTime, "ping interval of idle grpc connections")
+	fs.DurationVar(&r.GRPC.KeepaliveTimeout, "grpc-keepalive-timeout", DefaultGRPCKeepaliveTimeout, "timeout of grpc keepalive pings")
+	for _, name := range []string{"addresses", "tls", "streams", "max-inflight", "keepalive-time", "keepalive-timeout"} {
+		SetFlagKey(fs, "grpc-"+name, "grpc."+name)
+	}
+	fs.IntVar(&r.Retry.MaxRetries, "max-retries", 3, "max retries of writes failed with 5xx, 429 and network errors")
+	fs.DurationVar(&r.Retry.MinBackoff, "min-backoff", 100*time.Millisecond, "initial backoff of retries")
+	fs.DurationVar(&r.Retry.MaxBackoff, "max-backoff", 5*time.Second, "max backoff of retries")
+	for _, name := range []string{"max-retries", "min-backoff", "max-backoff"} {
+		SetFlagKey(fs, name, "retry."+name)
+	}
 	fs.StringVar(&r.TLSConfig.CAFile, "ca-file", "", "remote write https ca")
 	fs.StringVar(&r.TLSConfig.CertFile, "cert-file", "", "remote write https cert file")
 	fs.StringVar(&r.TLSConfig.KeyFile, "key-file", "", "remote write https key file")
 	fs.StringVar(&r.TLSConfig.ServerName, "server-name", "", "remote write https server name")
-	fs.VisitAll(func(f *pflag.Flag) {
-		f.Name = fmt.Sprintf("%s-%s", "remote-write", f.Name)
-	})
+	setTLSFlagKeys(fs)
+	PrefixFlags(fs, "remote-write")
 	return fs
 }
 
+func setTLSFlagKeys(fs *pflag.FlagSet) {
+	for _, name := range []string{"ca-file", "cert-file", "key-file", "server-name"} {
+		SetFlagKey(fs, name, "tls-config."+name)
+	}
+}
+
 type TraceConfig struct {
 	ClientType ClientType `mapstructure:"client-type"`
 
 	Endpoint  string        `mapstructure:"endpoint"`
 	Insecure  bool          `mapstructure:"insecure"`
 	Timeout   time.Duration `mapstructure:"timeout"`
-	TLSConfig TLSConfig
+	TLSConfig TLSConfig     `mapstructure:"tls-config"`
 }
 
 func (t *TraceConfig) ToOptions() *pflag.FlagSet {
@@ -119,9 +271,8 @@ func (t *TraceConfig) ToOptions() *pflag.FlagSet {
 	fs.StringVar(&t.TLSConfig.CertFile, "cert-file", "", "trace https cert file")
 	fs.StringVar(&t.TLSConfig.KeyFile, "key-file", "", "trace https key file")
 	fs.StringVar(&t.TLSConfig.ServerName, "server-name", "", "trace https server name")
-	fs.VisitAll(func(f *pflag.Flag) {
-		f.Name = fmt.Sprintf("%s-%s", "trace", f.Name)
-	})
+	setTLSFlagKeys(fs)
+	PrefixFlags(fs, "trace")
 	return fs
 }
 
@@ -134,8 +285,32 @@ func (p *ProfileConfig) ToOptions() *pflag.FlagSet {
 	fs := pflag.NewFlagSet("profile", pflag.ContinueOnError)
 	fs.IntVar(&p.Rate, "rate", 0, "profile rate")
 	fs.StringSliceVar(&p.Types, "type", []string{}, "profile types")
-	fs.VisitAll(func(f *pflag.Flag) {
-		f.Name = fmt.Sprintf("%s-%s", "profile", f.Name)
-	})
+	SetFlagKey(fs, "type", "types")
+	PrefixFlags(fs, "profile")
+	return fs
+}
+
+type AdminConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Port serves admin api on a dedicated port, 0 means share the port with remote write
+	Port            int    `mapstructure:"port"`
+	Username        string `mapstructure:"username"`
+	Passwd          string `mapstructure:"passwd"`
+	BearerTokenFile string `mapstructure:"bearer-token-file"`
+}
+
+// SharesPort reports whether admin api is served on the remote write port
+func (a *AdminConfig) SharesPort(port int) bool {
+	return a.Port == 0 || a.Port == port
+}
+
+func (a *AdminConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("admin", pflag.ContinueOnError)
+	fs.BoolVar(&a.Enabled, "enabled", false, "enable/disable admin api")
+	fs.IntVar(&a.Port, "port", 0, "admin api listen port, 0 means share the remote write port")
+	fs.StringVar(&a.Username, "username", "", "admin api basic auth username")
+	fs.StringVar(&a.Passwd, "passwd", "", "admin api basic auth password")
+	fs.StringVar(&a.BearerTokenFile, "bearer-token-file", "", "admin api bearer token file")
+	PrefixFlags(fs, "admin")
 	return fs
 }
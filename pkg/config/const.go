@@ -13,9 +13,10 @@ const (
 	Others Elector = "unknown"
 )
 
+// ClientType is the protocol of tracing clients and remote write transports
 type ClientType string
 
 const (
-	http ClientType = "http"
-	grpc ClientType = "grpc"
+	ClientHTTP ClientType = "http"
+	ClientGRPC ClientType = "grpc"
 )
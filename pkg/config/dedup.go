@@ -0,0 +1,46 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// DedupConfig deduplicates samples of prometheus HA replicas, replica labels are stripped and a sample is
+// forwarded only if it's newer than the last one forwarded of the series. Requests are forwarded by every
+// instance instead of the leader only, so replicas should write to the same instance
+type DedupConfig struct {
+	Enabled       bool     `mapstructure:"enabled"`
+	ReplicaLabels []string `mapstructure:"replica-labels"`
+	// MaxSeries bounds tracked series, least recently used ones are evicted
+	MaxSeries int `mapstructure:"max-series"`
+	// Expiry forgets series not received in it
+	Expiry time.Duration `mapstructure:"expiry"`
+}
+
+func (d *DedupConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("dedup", pflag.ContinueOnError)
+	fs.BoolVar(&d.Enabled, "enabled", false, "enable/disable sample deduplication of prometheus HA replicas")
+	fs.StringSliceVar(&d.ReplicaLabels, "replica-labels", []string{"prometheus_replica"}, "labels identifying replicas, they're stripped")
+	fs.IntVar(&d.MaxSeries, "max-series", 1000000, "max tracked series, least recently used ones are evicted")
+	fs.DurationVar(&d.Expiry, "expiry", 10*time.Minute, "forget series not received in expiry")
+	PrefixFlags(fs, "dedup")
+	return fs
+}
+
+func (d *DedupConfig) Validate() []error {
+	var errs []error
+	if len(d.ReplicaLabels) == 0 {
+		errs = append(errs, NewFieldError("replica-labels", "must not be empty"))
+	}
+	for i, l := range d.ReplicaLabels {
+		if l == "" {
+			errs = append(errs, NewFieldError("replica-labels", "label %d must not be empty", i))
+		}
+	}
+	if d.MaxSeries <= 0 {
+		errs = append(errs, NewFieldError("max-series", "must be positive, got %d", d.MaxSeries))
+	}
+	errs = append(errs, ValidatePositiveDuration("expiry", d.Expiry)...)
+	return errs
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+
+	"prometheus-deepflow-adapter/pkg/selector"
+)
+
+// FilterConfig forwards series matching any allow selector and no deny selector, selectors are promql series
+// selectors, e.g. {__name__=~"http_.*", job!="canary"}. Empty allow list allows all series
+type FilterConfig struct {
+	Allow []string `mapstructure:"allow"`
+	Deny  []string `mapstructure:"deny"`
+}
+
+func (f *FilterConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("filter", pflag.ContinueOnError)
+	// selectors contain commas, so they're not split like string slices
+	fs.StringArrayVar(&f.Allow, "allow", []string{}, "series selectors of forwarded series, empty allows all series")
+	fs.StringArrayVar(&f.Deny, "deny", []string{}, "series selectors of dropped series")
+	PrefixFlags(fs, "filter")
+	return fs
+}
+
+func (f *FilterConfig) Validate() []error {
+	var errs []error
+	for i, s := range f.Allow {
+		if _, err := selector.Parse(s); err != nil {
+			errs = append(errs, NewFieldError(fmt.Sprintf("allow[%d]", i), "%s", err))
+		}
+	}
+	for i, s := range f.Deny {
+		if _, err := selector.Parse(s); err != nil {
+			errs = append(errs, NewFieldError(fmt.Sprintf("deny[%d]", i), "%s", err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+)
+
+// flagKeyAnnotation records which config key a flag is mapped to,
+// flag names are flattened(remote-write-ca-file) but config keys are nested(remote-write.tls-config.ca-file)
+const flagKeyAnnotation = "config-key"
+
+// SetFlagKey maps flag to a config key relative to the flag set
+func SetFlagKey(fs *pflag.FlagSet, name, key string) {
+	fs.SetAnnotation(name, flagKeyAnnotation, []string{key})
+}
+
+// FlagKey returns the config key of flag, flag name is used if it's not mapped
+func FlagKey(f *pflag.Flag) string {
+	if key := f.Annotations[flagKeyAnnotation]; len(key) > 0 {
+		return key[0]
+	}
+	return f.Name
+}
+
+// PrefixFlags prefixes flag names with `prefix-` and config keys with `prefix.`
+func PrefixFlags(fs *pflag.FlagSet, prefix string) {
+	fs.VisitAll(func(f *pflag.Flag) {
+		key := fmt.Sprintf("%s.%s", prefix, FlagKey(f))
+		if f.Annotations == nil {
+			f.Annotations = map[string][]string{}
+		}
+		f.Annotations[flagKeyAnnotation] = []string{key}
+		f.Name = fmt.Sprintf("%s-%s", prefix, f.Name)
+	})
+}
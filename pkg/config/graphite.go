@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/spf13/pflag"
+
+	"prometheus-deepflow-adapter/pkg/graphite"
+)
+
+// GraphiteConfig accepts graphite plaintext protocol on TCP and UDP listeners, metrics are translated
+// into prometheus series by templates and flow through the same pipeline as /receive
+type GraphiteConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TCPListenAddress and UDPListenAddress serve graphite plaintext protocol, empty disables the listener
+	TCPListenAddress string `mapstructure:"tcp-listen-address"`
+	UDPListenAddress string `mapstructure:"udp-listen-address"`
+	// Templates map metric paths to names and labels, e.g. "servers.* .host.measurement.field* region=us",
+	// the first matching one applies
+	Templates []string `mapstructure:"templates"`
+}
+
+func (g *GraphiteConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("graphite", pflag.ContinueOnError)
+	fs.BoolVar(&g.Enabled, "enabled", false, "enable/disable graphite plaintext ingest")
+	fs.StringVar(&g.TCPListenAddress, "tcp-listen-address", ":2003", "graphite TCP listen address, empty disables it")
+	fs.StringVar(&g.UDPListenAddress, "udp-listen-address", "", "graphite UDP listen address, empty disables it")
+	fs.StringSliceVar(&g.Templates, "templates", nil, "templates mapping metric paths to names and labels")
+	PrefixFlags(fs, "graphite")
+	return fs
+}
+
+func (g *GraphiteConfig) Validate() []error {
+	var errs []error
+	if g.TCPListenAddress == "" && g.UDPListenAddress == "" {
+		errs = append(errs, NewFieldError("tcp-listen-address", "either tcp-listen-address or udp-listen-address must be set"))
+	}
+	for _, l := range []struct{ key, addr string }{
+		{"tcp-listen-address", g.TCPListenAddress},
+		{"udp-listen-address", g.UDPListenAddress},
+	} {
+		if l.addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(l.addr); err != nil {
+			errs = append(errs, NewFieldError(l.key, "invalid address %q: %s", l.addr, err))
+		}
+	}
+	for i, t := range g.Templates {
+		if _, err := graphite.ParseTemplate(t); err != nil {
+			errs = append(errs, NewFieldError(fmt.Sprintf("templates[%d]", i), "%s", err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"net"
+	"time"
+)
+
+// defaults of grpc transport, they apply to zero values
+const (
+	DefaultGRPCStreams          = 4
+	DefaultGRPCMaxInflight      = 8
+	DefaultGRPCKeepaliveTime    = 30 * time.Second
+	DefaultGRPCKeepaliveTimeout = 10 * time.Second
+)
+
+// GRPCClientConfig streams remote writes to deepflow ingesters over persistent grpc connections,
+// streams are balanced round robin across addresses
+type GRPCClientConfig struct {
+	// Addresses are host:port of ingesters, url of remote write is not used with grpc transport
+	Addresses []string `mapstructure:"addresses"`
+	// TLS enables tls with tls-config and insecure of remote write
+	TLS bool `mapstructure:"tls"`
+	// Streams are concurrent write streams, every stream is on the connection of an address picked round robin.
+	// Zero values of Streams, MaxInflight and keepalives mean the defaults
+	Streams int `mapstructure:"streams"`
+	// MaxInflight is the max unacknowledged writes of a stream, writes wait for a free stream beyond it
+	MaxInflight      int           `mapstructure:"max-inflight"`
+	KeepaliveTime    time.Duration `mapstructure:"keepalive-time"`
+	KeepaliveTimeout time.Duration `mapstructure:"keepalive-timeout"`
+}
+
+func (g *GRPCClientConfig) Validate() []error {
+	var errs []error
+	if len(g.Addresses) == 0 {
+		errs = append(errs, NewFieldError("addresses", "must not be empty"))
+	}
+	for _, addr := range g.Addresses {
+		if host, port, err := net.SplitHostPort(addr); err != nil || host == "" || port == "" {
+			errs = append(errs, NewFieldError("addresses", "must be host:port, got %q", addr))
+		}
+	}
+	if g.Streams < 0 {
+		errs = append(errs, NewFieldError("streams", "must not be negative, got %d", g.Streams))
+	}
+	if g.MaxInflight < 0 {
+		errs = append(errs, NewFieldError("max-inflight", "must not be negative, got %d", g.MaxInflight))
+	}
+	// grpc raises keepalive time under 10s to 10s
+	if g.KeepaliveTime != 0 && g.KeepaliveTime < 10*time.Second {
+		errs = append(errs, NewFieldError("keepalive-time", "must be at least 10s, got %s", g.KeepaliveTime))
+	}
+	if g.KeepaliveTimeout < 0 {
+		errs = append(errs, NewFieldError("keepalive-timeout", "must not be negative, got %s", g.KeepaliveTimeout))
+	}
+	return errs
+}
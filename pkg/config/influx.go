@@ -0,0 +1,16 @@
+package config
+
+import "github.com/spf13/pflag"
+
+// InfluxConfig accepts InfluxDB line protocol on /api/v2/write, points are translated into prometheus series
+// and flow through the same pipeline as /receive
+type InfluxConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+func (i *InfluxConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("influx", pflag.ContinueOnError)
+	fs.BoolVar(&i.Enabled, "enabled", false, "enable/disable InfluxDB line protocol ingest on /api/v2/write")
+	PrefixFlags(fs, "influx")
+	return fs
+}
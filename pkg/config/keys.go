@@ -0,0 +1,110 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// renamedKeys maps keys of old releases to their current names, keys under them are renamed as well
+var renamedKeys = map[string]string{
+	// tls config of trace had no tag, so its key was the lowercased field name
+	"trace.tlsconfig": "trace.tls-config",
+}
+
+// RenamedKey returns the current name of a key of old releases, ok is false if key is not renamed.
+// Keys are matched case-insensitively, as they were decoded
+func RenamedKey(key string) (string, bool) {
+	lower := strings.ToLower(key)
+	for old, name := range renamedKeys {
+		if lower == old {
+			return name, true
+		}
+		if strings.HasPrefix(lower, old+".") {
+			return name + key[len(old):], true
+		}
+	}
+	return "", false
+}
+
+// UnknownKeys returns keys which are not mapped to any config field,
+// keys should be flattened with "." delimiter
+func (c *Config) UnknownKeys(keys []string) []string {
+	known := c.knownKeys()
+	var unknown []string
+	for _, key := range keys {
+		if !isKnownKey(key, known) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// IsKnownKey reports whether key is mapped to a config field
+func (c *Config) IsKnownKey(key string) bool {
+	return isKnownKey(key, c.knownKeys())
+}
+
+func (c *Config) knownKeys() map[string]bool {
+	known := map[string]bool{}
+	collectKeys("", reflect.TypeOf(c), known)
+	for key, extra := range c.ExtraConfigs {
+		known[key] = false
+		collectKeys(key, reflect.TypeOf(extra), known)
+	}
+	return known
+}
+
+// isKnownKey matches key itself, or its parent if the parent is a map
+func isKnownKey(key string, known map[string]bool) bool {
+	if _, ok := known[key]; ok {
+		return true
+	}
+	for i := strings.LastIndex(key, "."); i > 0; i = strings.LastIndex(key, ".") {
+		key = key[:i]
+		if open, ok := known[key]; ok {
+			return open
+		}
+	}
+	return false
+}
+
+// collectKeys walks mapstructure tags, value is true if any sub key is accepted(map fields)
+func collectKeys(prefix string, t reflect.Type, known map[string]bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		ft := field.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		switch ft.Kind() {
+		case reflect.Struct:
+			known[key] = false
+			collectKeys(key, ft, known)
+		case reflect.Map:
+			known[key] = true
+		default:
+			known[key] = false
+		}
+	}
+}
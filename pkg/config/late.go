@@ -0,0 +1,67 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	LatePolicyForward  = "forward"
+	LatePolicyDrop     = "drop"
+	LatePolicyBackfill = "backfill"
+)
+
+var latePolicies = []string{LatePolicyForward, LatePolicyDrop, LatePolicyBackfill}
+
+// LateSamplesConfig handles samples which are out of order or too old, they're tracked unless policy is forward.
+// A sample is out of order if it's not newer than the last forwarded one of the series
+type LateSamplesConfig struct {
+	// Policy is forward, drop, or backfill which sends late samples to BackfillRemoteWrite only
+	Policy string `mapstructure:"policy"`
+	// MaxAge makes samples older than it late, 0 means only out-of-order samples are late
+	MaxAge time.Duration `mapstructure:"max-age"`
+	// BackfillRemoteWrite is name of the additional remote write receiving late samples only
+	BackfillRemoteWrite string `mapstructure:"backfill-remote-write"`
+	// MaxSeries bounds tracked series, least recently used ones are evicted
+	MaxSeries int `mapstructure:"max-series"`
+	// Expiry forgets series not received in it
+	Expiry time.Duration `mapstructure:"expiry"`
+}
+
+func (l *LateSamplesConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("late-samples", pflag.ContinueOnError)
+	fs.StringVar(&l.Policy, "policy", LatePolicyForward, "policy of late samples: forward/drop/backfill")
+	fs.DurationVar(&l.MaxAge, "max-age", 0, "samples older than it are late, 0 means only out-of-order samples are late")
+	fs.StringVar(&l.BackfillRemoteWrite, "backfill-remote-write", "", "name of additional remote write receiving late samples only")
+	fs.IntVar(&l.MaxSeries, "max-series", 1000000, "max tracked series, least recently used ones are evicted")
+	fs.DurationVar(&l.Expiry, "expiry", 10*time.Minute, "forget series not received in expiry")
+	PrefixFlags(fs, "late-samples")
+	return fs
+}
+
+// Tracked reports whether late samples are tracked
+func (l *LateSamplesConfig) Tracked() bool {
+	return l.Policy == LatePolicyDrop || l.Policy == LatePolicyBackfill
+}
+
+func (l *LateSamplesConfig) Validate() []error {
+	var errs []error
+	if !contains(latePolicies, l.Policy) {
+		errs = append(errs, NewFieldError("policy", "must be one of %v, got %q", latePolicies, l.Policy))
+	}
+	if !l.Tracked() {
+		return errs
+	}
+	if l.MaxAge < 0 {
+		errs = append(errs, NewFieldError("max-age", "must not be negative, got %s", l.MaxAge))
+	}
+	if l.Policy == LatePolicyBackfill && l.BackfillRemoteWrite == "" {
+		errs = append(errs, NewFieldError("backfill-remote-write", "must not be empty with %s policy", LatePolicyBackfill))
+	}
+	if l.MaxSeries <= 0 {
+		errs = append(errs, NewFieldError("max-series", "must be positive, got %d", l.MaxSeries))
+	}
+	errs = append(errs, ValidatePositiveDuration("expiry", l.Expiry)...)
+	return errs
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// MetadataConfig caches metric metadata by metric family, cached metadata is attached to requests
+// carrying samples of the family but not its metadata, and served on /api/v1/metadata
+type MetadataConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TTL expires metadata of families which are not updated in it, prometheus sends metadata every minute by default
+	TTL time.Duration `mapstructure:"ttl"`
+	// MaxFamilies bounds cached families, metadata of new families is not cached once it's reached, 0 means no limit
+	MaxFamilies int `mapstructure:"max-families"`
+}
+
+func (m *MetadataConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("metadata", pflag.ContinueOnError)
+	fs.BoolVar(&m.Enabled, "enabled", true, "enable/disable metric metadata cache")
+	fs.DurationVar(&m.TTL, "ttl", time.Hour, "expire metadata of families not updated in ttl")
+	fs.IntVar(&m.MaxFamilies, "max-families", 100000, "max cached metric families, 0 means no limit")
+	PrefixFlags(fs, "metadata")
+	return fs
+}
+
+func (m *MetadataConfig) Validate() []error {
+	var errs []error
+	errs = append(errs, ValidatePositiveDuration("ttl", m.TTL)...)
+	if m.MaxFamilies < 0 {
+		errs = append(errs, NewFieldError("max-families", "must not be negative, got %d", m.MaxFamilies))
+	}
+	return errs
+}
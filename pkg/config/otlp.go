@@ -0,0 +1,45 @@
+package config
+
+import (
+	"net"
+
+	"github.com/spf13/pflag"
+)
+
+// OTLPConfig accepts OTLP metrics on /v1/metrics, and on a dedicated gRPC listener if it's set.
+// Metrics are translated into prometheus series and flow through the same pipeline as /receive
+type OTLPConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// GRPCListenAddress serves OTLP/gRPC, e.g. :4317, empty disables it
+	GRPCListenAddress string `mapstructure:"grpc-listen-address"`
+	// AddMetricSuffixes appends unit and type suffixes to metric names, e.g. _seconds and _total
+	AddMetricSuffixes bool `mapstructure:"add-metric-suffixes"`
+	// PromoteResourceAttributes are resource attributes added as labels to every series of the resource,
+	// service.name, service.namespace and service.instance.id are always translated to job and instance
+	PromoteResourceAttributes []string `mapstructure:"promote-resource-attributes"`
+}
+
+func (o *OTLPConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("otlp", pflag.ContinueOnError)
+	fs.BoolVar(&o.Enabled, "enabled", false, "enable/disable OTLP metrics ingest on /v1/metrics")
+	fs.StringVar(&o.GRPCListenAddress, "grpc-listen-address", "", "OTLP/gRPC listen address, empty disables it")
+	fs.BoolVar(&o.AddMetricSuffixes, "add-metric-suffixes", true, "append unit and type suffixes to metric names")
+	fs.StringSliceVar(&o.PromoteResourceAttributes, "promote-resource-attributes", nil, "resource attributes added as labels to every series")
+	PrefixFlags(fs, "otlp")
+	return fs
+}
+
+func (o *OTLPConfig) Validate() []error {
+	var errs []error
+	if o.GRPCListenAddress != "" {
+		if _, _, err := net.SplitHostPort(o.GRPCListenAddress); err != nil {
+			errs = append(errs, NewFieldError("grpc-listen-address", "invalid address %q: %s", o.GRPCListenAddress, err))
+		}
+	}
+	for i, attr := range o.PromoteResourceAttributes {
+		if attr == "" {
+			errs = append(errs, NewFieldError("promote-resource-attributes", "attribute %d must not be empty", i))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	ClientKeyPrincipal = "principal"
+	ClientKeyIP        = "ip"
+)
+
+// RateLimitConfig throttles ingest requests by decoded samples and payload bytes, requests over
+// the limit are rejected with 429 and Retry-After
+type RateLimitConfig struct {
+	// MaxInflightRequests bounds concurrent ingest requests, 0 means no limit
+	MaxInflightRequests int        `mapstructure:"max-inflight-requests"`
+	Global              RateLimits `mapstructure:"global"`
+	PerClient           RateLimits `mapstructure:"per-client"`
+	// ClientKey identifies clients: principal falls back to source ip for unauthenticated requests
+	ClientKey string `mapstructure:"client-key"`
+	// ClientIdleTimeout removes limiters of clients which send nothing in the timeout
+	ClientIdleTimeout time.Duration `mapstructure:"client-idle-timeout"`
+}
+
+// RateLimits are token buckets, 0 rate means no limit and 0 burst means one second of rate
+type RateLimits struct {
+	SamplesPerSecond float64 `mapstructure:"samples-per-second"`
+	SamplesBurst     int     `mapstructure:"samples-burst"`
+	BytesPerSecond   float64 `mapstructure:"bytes-per-second"`
+	BytesBurst       int     `mapstructure:"bytes-burst"`
+}
+
+func (r *RateLimitConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("rate-limit", pflag.ContinueOnError)
+	fs.IntVar(&r.MaxInflightRequests, "max-inflight-requests", 0, "max concurrent ingest requests, 0 means no limit")
+	r.Global.addFlags(fs, "global", "all clients")
+	r.PerClient.addFlags(fs, "per-client", "every client")
+	fs.StringVar(&r.ClientKey, "client-key", ClientKeyPrincipal, "client identity of per-client limit: principal/ip")
+	fs.DurationVar(&r.ClientIdleTimeout, "client-idle-timeout", 10*time.Minute, "per-client limit is reset after client is idle for the timeout")
+	PrefixFlags(fs, "rate-limit")
+	return fs
+}
+
+func (r *RateLimits) addFlags(fs *pflag.FlagSet, prefix, desc string) {
+	fs.Float64Var(&r.SamplesPerSecond, prefix+"-samples-per-second", 0, "samples per second of "+desc+", 0 means no limit")
+	fs.IntVar(&r.SamplesBurst, prefix+"-samples-burst", 0, "samples burst of "+desc+", 0 means one second of rate")
+	fs.Float64Var(&r.BytesPerSecond, prefix+"-bytes-per-second", 0, "payload bytes per second of "+desc+", 0 means no limit")
+	fs.IntVar(&r.BytesBurst, prefix+"-bytes-burst", 0, "payload bytes burst of "+desc+", 0 means one second of rate")
+	for _, name := range []string{"samples-per-second", "samples-burst", "bytes-per-second", "bytes-burst"} {
+		SetFlagKey(fs, prefix+"-"+name, prefix+"."+name)
+	}
+}
+
+func (r *RateLimitConfig) Validate() []error {
+	var errs []error
+	if r.MaxInflightRequests < 0 {
+		errs = append(errs, NewFieldError("max-inflight-requests", "must not be negative, got %d", r.MaxInflightRequests))
+	}
+	errs = append(errs, PrefixErrors("global", r.Global.Validate())...)
+	errs = append(errs, PrefixErrors("per-client", r.PerClient.Validate())...)
+	if r.ClientKey != ClientKeyPrincipal && r.ClientKey != ClientKeyIP {
+		errs = append(errs, NewFieldError("client-key", "must be one of [%s %s], got %q", ClientKeyPrincipal, ClientKeyIP, r.ClientKey))
+	}
+	errs = append(errs, ValidatePositiveDuration("client-idle-timeout", r.ClientIdleTimeout)...)
+	return errs
+}
+
+func (r *RateLimits) Validate() []error {
+	var errs []error
+	if r.SamplesPerSecond < 0 {
+		errs = append(errs, NewFieldError("samples-per-second", "must not be negative, got %g", r.SamplesPerSecond))
+	}
+	if r.SamplesBurst < 0 {
+		errs = append(errs, NewFieldError("samples-burst", "must not be negative, got %d", r.SamplesBurst))
+	}
+	if r.BytesPerSecond < 0 {
+		errs = append(errs, NewFieldError("bytes-per-second", "must not be negative, got %g", r.BytesPerSecond))
+	}
+	if r.BytesBurst < 0 {
+		errs = append(errs, NewFieldError("bytes-burst", "must not be negative, got %d", r.BytesBurst))
+	}
+	return errs
+}
+
+// Enabled reports whether any limit is set
+func (r *RateLimits) Enabled() bool {
+	return r.SamplesPerSecond > 0 || r.BytesPerSecond > 0
+}
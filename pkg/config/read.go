@@ -0,0 +1,47 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// RemoteReadConfig serves prometheus remote read from deepflow querier, queries are translated
+// to promql and evaluated by prometheus compatible api of the querier
+type RemoteReadConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Url is the base url of querier prometheus api, /api/v1/query is appended to it
+	Url       string        `mapstructure:"url"`
+	Insecure  bool          `mapstructure:"insecure"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+	TLSConfig TLSConfig     `mapstructure:"tls-config"`
+	// MaxSamplesPerQuery fails queries returning more samples, 0 means no limit
+	MaxSamplesPerQuery int `mapstructure:"max-samples-per-query"`
+}
+
+func (r *RemoteReadConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("remote-read", pflag.ContinueOnError)
+	fs.BoolVar(&r.Enabled, "enabled", false, "enable/disable remote read endpoint")
+	fs.StringVar(&r.Url, "url", "http://deepflow-server.deepflow:20416/prom", "base url of querier prometheus api")
+	fs.BoolVar(&r.Insecure, "insecure", false, "insecure config for querier")
+	fs.DurationVar(&r.Timeout, "timeout", time.Minute, "querier timeout")
+	fs.IntVar(&r.MaxSamplesPerQuery, "max-samples-per-query", 50000000, "max samples returned by a query, 0 means no limit")
+	fs.StringVar(&r.TLSConfig.CAFile, "ca-file", "", "querier https ca")
+	fs.StringVar(&r.TLSConfig.CertFile, "cert-file", "", "querier https cert file")
+	fs.StringVar(&r.TLSConfig.KeyFile, "key-file", "", "querier https key file")
+	fs.StringVar(&r.TLSConfig.ServerName, "server-name", "", "querier https server name")
+	setTLSFlagKeys(fs)
+	PrefixFlags(fs, "remote-read")
+	return fs
+}
+
+func (r *RemoteReadConfig) Validate() []error {
+	var errs []error
+	errs = append(errs, validateURL("url", r.Url)...)
+	errs = append(errs, ValidatePositiveDuration("timeout", r.Timeout)...)
+	if r.MaxSamplesPerQuery < 0 {
+		errs = append(errs, NewFieldError("max-samples-per-query", "must not be negative, got %d", r.MaxSamplesPerQuery))
+	}
+	errs = append(errs, PrefixErrors("tls-config", r.TLSConfig.Validate())...)
+	return errs
+}
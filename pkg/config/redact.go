@@ -0,0 +1,55 @@
+package config
+
+import "strings"
+
+const redacted = "<secret>"
+
+// secretKeys are leaf keys whose values should never be exposed, header names like Authorization included
+var secretKeys = []string{"passwd", "password", "secret", "secret-key", "token", "credentials", "api-key", "api_key",
+	"authorization", "cookie"}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range secretKeys {
+		if strings.HasSuffix(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of nested config map, secret values are replaced
+func Redact(raw map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			out[k] = Redact(val)
+		case []interface{}:
+			out[k] = redactList(val)
+		default:
+			if isSecretKey(k) && v != nil && v != "" {
+				out[k] = redacted
+			} else {
+				out[k] = v
+			}
+		}
+	}
+	return out
+}
+
+// redactList redacts maps in list, e.g. additional-remote-writes
+func redactList(list []interface{}) []interface{} {
+	out := make([]interface{}, len(list))
+	for i, v := range list {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			out[i] = Redact(val)
+		case []interface{}:
+			out[i] = redactList(val)
+		default:
+			out[i] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	raw := map[string]interface{}{
+		"remote-write": map[string]interface{}{
+			"url":     "http://localhost:9090/api/v1/write",
+			"passwd":  "secret",
+			"headers": map[string]interface{}{"Authorization": "Bearer abc", "X-Scope-OrgID": "team-a"},
+		},
+		"tenancy": map[string]interface{}{
+			"routes": []interface{}{
+				map[string]interface{}{"headers": map[string]interface{}{"Cookie": "session=abc", "X-Auth-Token": "abc"}},
+			},
+		},
+		"auth": map[string]interface{}{"bearer-token": ""},
+	}
+	want := map[string]interface{}{
+		"remote-write": map[string]interface{}{
+			"url":     "http://localhost:9090/api/v1/write",
+			"passwd":  redacted,
+			"headers": map[string]interface{}{"Authorization": redacted, "X-Scope-OrgID": "team-a"},
+		},
+		"tenancy": map[string]interface{}{
+			"routes": []interface{}{
+				map[string]interface{}{"headers": map[string]interface{}{"Cookie": redacted, "X-Auth-Token": redacted}},
+			},
+		},
+		// empty secrets are shown as they are
+		"auth": map[string]interface{}{"bearer-token": ""},
+	}
+	if got := Redact(raw); !reflect.DeepEqual(got, want) {
+		t.Errorf("Redact() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,31 @@
+package config
+
+import "github.com/spf13/pflag"
+
+// SeriesValidationConfig validates native histograms and exemplars of decoded series,
+// invalid histogram samples and exemplars are dropped while the rest of series is kept
+type SeriesValidationConfig struct {
+	// ExemplarMaxLabelLength limits total length of exemplar label names and values in runes, 0 means no limit
+	ExemplarMaxLabelLength int `mapstructure:"exemplar-max-label-length"`
+	// NativeHistogramMaxBuckets limits buckets of a native histogram sample, 0 means no limit
+	NativeHistogramMaxBuckets int `mapstructure:"native-histogram-max-buckets"`
+}
+
+func (s *SeriesValidationConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("series-validation", pflag.ContinueOnError)
+	fs.IntVar(&s.ExemplarMaxLabelLength, "exemplar-max-label-length", 128, "max total length of exemplar labels, 0 means no limit")
+	fs.IntVar(&s.NativeHistogramMaxBuckets, "native-histogram-max-buckets", 0, "max buckets of a native histogram sample, 0 means no limit")
+	PrefixFlags(fs, "series-validation")
+	return fs
+}
+
+func (s *SeriesValidationConfig) Validate() []error {
+	var errs []error
+	if s.ExemplarMaxLabelLength < 0 {
+		errs = append(errs, NewFieldError("exemplar-max-label-length", "must not be negative, got %d", s.ExemplarMaxLabelLength))
+	}
+	if s.NativeHistogramMaxBuckets < 0 {
+		errs = append(errs, NewFieldError("native-histogram-max-buckets", "must not be negative, got %d", s.NativeHistogramMaxBuckets))
+	}
+	return errs
+}
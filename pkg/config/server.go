@@ -0,0 +1,142 @@
+package config
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+const unixSocketPrefix = "unix://"
+
+const (
+	DefaultMaxRequestBytes = 32 << 20
+	DefaultMaxDecodedBytes = 128 << 20
+)
+
+type ServerConfig struct {
+	// ListenAddress overrides port, `host:port` or `unix:///path/to/socket`
+	ListenAddress string `mapstructure:"listen-address"`
+	// H2C enables http/2 without tls, it's ignored if tls is enabled
+	H2C bool            `mapstructure:"h2c"`
+	TLS ServerTLSConfig `mapstructure:"tls"`
+	// MaxRequestBytes bounds bodies of ingest and remote read requests, larger ones get 413
+	MaxRequestBytes int64 `mapstructure:"max-request-bytes"`
+	// MaxDecodedBytes bounds decompressed bodies, so that small bodies can't decompress into huge ones
+	MaxDecodedBytes int64 `mapstructure:"max-decoded-bytes"`
+}
+
+type ServerTLSConfig struct {
+	CertFile     string `mapstructure:"cert-file"`
+	KeyFile      string `mapstructure:"key-file"`
+	ClientCAFile string `mapstructure:"client-ca-file"`
+	// ClientAuthType is one of RequestClientCert, RequireAnyClientCert, VerifyClientCertIfGiven, RequireAndVerifyClientCert
+	ClientAuthType string   `mapstructure:"client-auth-type"`
+	MinVersion     string   `mapstructure:"min-version"`
+	CipherSuites   []string `mapstructure:"cipher-suites"`
+}
+
+func (s *ServerConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("server", pflag.ContinueOnError)
+	fs.StringVar(&s.ListenAddress, "listen-address", "", "listen address, host:port or unix:///path/to/socket, overrides port")
+	fs.BoolVar(&s.H2C, "h2c", false, "enable http/2 without tls")
+	fs.Int64Var(&s.MaxRequestBytes, "max-request-bytes", DefaultMaxRequestBytes, "max body size of ingest and remote read requests")
+	fs.Int64Var(&s.MaxDecodedBytes, "max-decoded-bytes", DefaultMaxDecodedBytes, "max decompressed body size of ingest and remote read requests")
+	fs.StringVar(&s.TLS.CertFile, "tls-cert-file", "", "server tls cert file, tls is enabled if it's set")
+	fs.StringVar(&s.TLS.KeyFile, "tls-key-file", "", "server tls key file")
+	fs.StringVar(&s.TLS.ClientCAFile, "tls-client-ca-file", "", "ca file to verify client certificates")
+	fs.StringVar(&s.TLS.ClientAuthType, "tls-client-auth-type", "VerifyClientCertIfGiven", "client certificate policy when client ca file is set")
+	fs.StringVar(&s.TLS.MinVersion, "tls-min-version", "TLS12", "minimum tls version: TLS10/TLS11/TLS12/TLS13")
+	fs.StringSliceVar(&s.TLS.CipherSuites, "tls-cipher-suites", nil, "allowed cipher suites, empty means go defaults")
+	for _, name := range []string{"cert-file", "key-file", "client-ca-file", "client-auth-type", "min-version", "cipher-suites"} {
+		SetFlagKey(fs, "tls-"+name, "tls."+name)
+	}
+	PrefixFlags(fs, "server")
+	return fs
+}
+
+// Enabled reports whether server tls is enabled
+func (t *ServerTLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// UnixSocket returns socket path if listen address is a unix domain socket
+func (s *ServerConfig) UnixSocket() (string, bool) {
+	if strings.HasPrefix(s.ListenAddress, unixSocketPrefix) {
+		return strings.TrimPrefix(s.ListenAddress, unixSocketPrefix), true
+	}
+	return "", false
+}
+
+var TLSVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var ClientAuthTypes = map[string]tls.ClientAuthType{
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// CipherSuite returns id of cipher suite name, insecure ones are included
+func CipherSuite(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range suites {
+			if suite.Name == name {
+				return suite.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (s *ServerConfig) Validate() []error {
+	var errs []error
+	if path, ok := s.UnixSocket(); ok {
+		if path == "" {
+			errs = append(errs, NewFieldError("listen-address", "unix socket path must not be empty"))
+		}
+	} else if s.ListenAddress != "" {
+		if _, _, err := net.SplitHostPort(s.ListenAddress); err != nil {
+			errs = append(errs, NewFieldError("listen-address", "%s", err))
+		}
+	}
+	if s.MaxRequestBytes <= 0 {
+		errs = append(errs, NewFieldError("max-request-bytes", "must be positive, got %d", s.MaxRequestBytes))
+	}
+	if s.MaxDecodedBytes <= 0 {
+		errs = append(errs, NewFieldError("max-decoded-bytes", "must be positive, got %d", s.MaxDecodedBytes))
+	}
+	errs = append(errs, PrefixErrors("tls", s.TLS.Validate())...)
+	return errs
+}
+
+func (t *ServerTLSConfig) Validate() []error {
+	var errs []error
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, NewFieldError("cert-file", "cert-file and key-file must be set together"))
+	}
+	errs = append(errs, validateFile("cert-file", t.CertFile)...)
+	errs = append(errs, validateFile("key-file", t.KeyFile)...)
+	errs = append(errs, validateFile("client-ca-file", t.ClientCAFile)...)
+	if t.ClientCAFile != "" && !t.Enabled() {
+		errs = append(errs, NewFieldError("client-ca-file", "requires cert-file and key-file"))
+	}
+	if _, ok := ClientAuthTypes[t.ClientAuthType]; !ok && t.ClientCAFile != "" {
+		errs = append(errs, NewFieldError("client-auth-type", "unknown client auth type %q", t.ClientAuthType))
+	}
+	if _, ok := TLSVersions[t.MinVersion]; !ok {
+		errs = append(errs, NewFieldError("min-version", "unknown tls version %q", t.MinVersion))
+	}
+	for _, name := range t.CipherSuites {
+		if _, ok := CipherSuite(name); !ok {
+			errs = append(errs, NewFieldError("cipher-suites", "unknown cipher suite %q", name))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	TenantSourceHeader    = "header"
+	TenantSourcePrincipal = "principal"
+	TenantSourceLabel     = "label"
+)
+
+var tenantSources = []string{TenantSourceHeader, TenantSourcePrincipal, TenantSourceLabel}
+
+// TenantWildcard routes tenants without their own routes
+const TenantWildcard = "*"
+
+// legacyTenantPlaceholder was replaced with {{.Tenant}} in route headers, it's rejected rather than sent as it is
+const legacyTenantPlaceholder = "${tenant}"
+
+// TenancyConfig derives tenant of ingested series and routes them by tenant
+type TenancyConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Source is where tenant ID comes from: header, authenticated principal, or label of every series
+	Source string `mapstructure:"source"`
+	Header string `mapstructure:"header"`
+	Label  string `mapstructure:"label"`
+	// DefaultTenant is the tenant of series without tenant, they're rejected if it's empty
+	DefaultTenant string `mapstructure:"default-tenant"`
+	// Routes are only configurable in config file, series of tenants without route are rejected
+	Routes []TenantRoute `mapstructure:"routes"`
+}
+
+type TenantRoute struct {
+	// Tenants are tenant IDs of the route, * matches tenants without their own routes
+	Tenants []string `mapstructure:"tenants"`
+	// RemoteWrites are names of destinations receiving series of the tenants, empty means all destinations
+	RemoteWrites []string `mapstructure:"remote-writes"`
+	// Headers are added to remote writes of the tenants, values are templates like remote write headers, e.g.
+	// {{.Tenant}}
+	Headers map[string]string `mapstructure:"headers"`
+	// RateLimit applies to every tenant of the route, bytes are not limited with label source
+	RateLimit RateLimits `mapstructure:"rate-limit"`
+}
+
+func (t *TenancyConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("tenancy", pflag.ContinueOnError)
+	fs.BoolVar(&t.Enabled, "enabled", false, "enable/disable multi-tenancy")
+	fs.StringVar(&t.Source, "source", TenantSourceHeader, "source of tenant ID: header/principal/label")
+	fs.StringVar(&t.Header, "header", "X-Scope-OrgID", "request header of tenant ID with header source")
+	fs.StringVar(&t.Label, "label", "tenant", "series label of tenant ID with label source")
+	fs.StringVar(&t.DefaultTenant, "default-tenant", "", "tenant of series without tenant, empty means they're rejected")
+	PrefixFlags(fs, "tenancy")
+	return fs
+}
+
+func (t *TenancyConfig) Validate(remoteWrites []string) []error {
+	var errs []error
+	if !contains(tenantSources, t.Source) {
+		errs = append(errs, NewFieldError("source", "must be one of %v, got %q", tenantSources, t.Source))
+	}
+	if t.Source == TenantSourceHeader && t.Header == "" {
+		errs = append(errs, NewFieldError("header", "must not be empty with %s source", TenantSourceHeader))
+	}
+	if t.Source == TenantSourceLabel && t.Label == "" {
+		errs = append(errs, NewFieldError("label", "must not be empty with %s source", TenantSourceLabel))
+	}
+	if len(t.Routes) == 0 {
+		errs = append(errs, NewFieldError("routes", "must not be empty"))
+	}
+	routed := map[string]bool{}
+	for i, r := range t.Routes {
+		path := fmt.Sprintf("routes[%d]", i)
+		if len(r.Tenants) == 0 {
+			errs = append(errs, NewFieldError(path+".tenants", "must not be empty"))
+		}
+		for _, tenant := range r.Tenants {
+			if tenant == "" {
+				errs = append(errs, NewFieldError(path+".tenants", "tenant must not be empty"))
+			} else if routed[tenant] {
+				errs = append(errs, NewFieldError(path+".tenants", "duplicated tenant %q", tenant))
+			}
+			routed[tenant] = true
+		}
+		for _, name := range r.RemoteWrites {
+			if !contains(remoteWrites, name) {
+				errs = append(errs, NewFieldError(path+".remote-writes", "unknown remote write %q", name))
+			}
+		}
+		errs = append(errs, validateOutboundTemplates(path+".headers", r.Headers)...)
+		for _, name := range sortedKeys(r.Headers) {
+			if strings.Contains(r.Headers[name], legacyTenantPlaceholder) {
+				errs = append(errs, NewFieldError(path+".headers."+name, "%s is replaced by {{.Tenant}}", legacyTenantPlaceholder))
+			}
+		}
+		errs = append(errs, PrefixErrors(path+".rate-limit", r.RateLimit.Validate())...)
+	}
+	if t.DefaultTenant != "" && !routed[t.DefaultTenant] && !routed[TenantWildcard] {
+		errs = append(errs, NewFieldError("default-tenant", "tenant %q has no route", t.DefaultTenant))
+	}
+	return errs
+}
@@ -0,0 +1,407 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/compression"
+	"prometheus-deepflow-adapter/pkg/prompb"
+	"prometheus-deepflow-adapter/pkg/relabel"
+)
+
+// Validator is optionally implemented by Configuration, errors it returns are
+// FieldError with path relative to the configuration
+type Validator interface {
+	Validate() []error
+}
+
+// FieldError is a validation error of a config key
+type FieldError struct {
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func NewFieldError(path string, format string, args ...any) error {
+	return &FieldError{Path: path, Err: fmt.Errorf(format, args...)}
+}
+
+// PrefixErrors qualifies FieldError path with prefix
+func PrefixErrors(prefix string, errs []error) []error {
+	for i, err := range errs {
+		if fe, ok := err.(*FieldError); ok {
+			errs[i] = &FieldError{Path: prefix + "." + fe.Path, Err: fe.Err}
+		} else {
+			errs[i] = &FieldError{Path: prefix, Err: err}
+		}
+	}
+	return errs
+}
+
+// ValidationErrors collects all errors found in config
+type ValidationErrors []error
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("invalid config: %s", strings.Join(msgs, "; "))
+}
+
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+func (c *Config) Validate() []error {
+	var errs []error
+	if c.Port <= 0 || c.Port > 65535 {
+		errs = append(errs, NewFieldError("port", "must be in range 1-65535, got %d", c.Port))
+	}
+	errs = append(errs, PrefixErrors("server", c.ServerConfig.Validate())...)
+	if !contains(logLevels, c.LogLevel) {
+		errs = append(errs, NewFieldError("log-level", "must be one of %v, got %q", logLevels, c.LogLevel))
+	}
+	if c.PrometheusScrapeInterval < 0 {
+		errs = append(errs, NewFieldError("prometheus-scrape-interval", "must not be negative, got %s", c.PrometheusScrapeInterval))
+	}
+	if c.PrometheusLivenessTimeout <= 0 {
+		errs = append(errs, NewFieldError("prometheus-liveness-timeout", "must be positive, got %s", c.PrometheusLivenessTimeout))
+	}
+
+	if c.ElectionEnabled {
+		if _, ok := extraConfigs[string(c.Elector)]; !ok {
+			errs = append(errs, NewFieldError("elector", "unknown elector %q, available: %v", c.Elector, registeredConfigs()))
+		}
+	}
+
+	errs = append(errs, PrefixErrors("remote-write", c.RemoteWriteConfig.Validate())...)
+	names := map[string]bool{}
+	for i, rw := range c.RemoteWrites() {
+		path := fmt.Sprintf("additional-remote-writes[%d]", i-1)
+		if i == 0 {
+			path = "remote-write"
+		} else {
+			errs = append(errs, PrefixErrors(path, rw.Validate())...)
+			if rw.Name == "" {
+				errs = append(errs, NewFieldError(path+".name", "must not be empty"))
+			}
+		}
+		if rw.Name != "" && names[rw.Name] {
+			errs = append(errs, NewFieldError(path+".name", "duplicated remote write name %q", rw.Name))
+		}
+		names[rw.Name] = true
+	}
+	errs = append(errs, validateRelabelConfigs("relabel-configs", c.RelabelConfigs)...)
+	errs = append(errs, PrefixErrors("filter", c.FilterConfig.Validate())...)
+	errs = append(errs, validateAggregationRules("stream-aggregation", c.StreamAggregation)...)
+	errs = append(errs, PrefixErrors("late-samples", c.LateSamplesConfig.Validate())...)
+	if c.LateSamplesConfig.Policy == LatePolicyBackfill {
+		if name := c.LateSamplesConfig.BackfillRemoteWrite; name != "" && !contains(additionalNames(c), name) {
+			errs = append(errs, NewFieldError("late-samples.backfill-remote-write", "unknown additional remote write %q", name))
+		}
+	}
+	if c.DedupConfig.Enabled {
+		errs = append(errs, PrefixErrors("dedup", c.DedupConfig.Validate())...)
+	}
+	if c.RemoteReadConfig.Enabled {
+		errs = append(errs, PrefixErrors("remote-read", c.RemoteReadConfig.Validate())...)
+	}
+	if c.OTLPConfig.Enabled {
+		errs = append(errs, PrefixErrors("otlp", c.OTLPConfig.Validate())...)
+	}
+	if c.GraphiteConfig.Enabled {
+		errs = append(errs, PrefixErrors("graphite", c.GraphiteConfig.Validate())...)
+	}
+	errs = append(errs, PrefixErrors("series-validation", c.SeriesValidationConfig.Validate())...)
+	if c.MetadataConfig.Enabled {
+		errs = append(errs, PrefixErrors("metadata", c.MetadataConfig.Validate())...)
+	}
+	errs = append(errs, PrefixErrors("auth", c.AuthConfig.Validate())...)
+	if c.TenancyConfig.Enabled {
+		names := make([]string, 0, len(c.AdditionalRemoteWrites)+1)
+		for _, rw := range c.RemoteWrites() {
+			names = append(names, rw.Name)
+		}
+		errs = append(errs, PrefixErrors("tenancy", c.TenancyConfig.Validate(names))...)
+	}
+	errs = append(errs, PrefixErrors("cardinality", c.CardinalityConfig.Validate())...)
+	errs = append(errs, PrefixErrors("rate-limit", c.RateLimitConfig.Validate())...)
+	errs = append(errs, PrefixErrors("circuit-breaker", c.CircuitBreakerConfig.Validate())...)
+	if c.CircuitBreakerConfig.Spool() {
+		errs = append(errs, PrefixErrors("wal", c.WalConfig.Validate())...)
+	}
+	if c.TraceEnabled {
+		errs = append(errs, PrefixErrors("trace", c.TraceConfig.Validate())...)
+	}
+	if c.AdminConfig.Enabled {
+		errs = append(errs, PrefixErrors("admin", c.AdminConfig.Validate())...)
+		// admin api on the ingest port is reachable by every ingest client, it must have its own credentials
+		if c.AdminConfig.SharesPort(c.Port) && c.AdminConfig.Username == "" && c.AdminConfig.BearerTokenFile == "" {
+			errs = append(errs, NewFieldError("admin.username", "username or bearer-token-file must be set when admin api shares the remote write port"))
+		}
+	}
+
+	for _, key := range registeredConfigs() {
+		// elector configs are only validated when the elector is in use, others are never initialized
+		if isElectorConfig(key) && (!c.ElectionEnabled || key != string(c.Elector)) {
+			continue
+		}
+		if v, ok := c.ExtraConfigs[key].(Validator); ok {
+			errs = append(errs, PrefixErrors(key, v.Validate())...)
+		}
+	}
+	return errs
+}
+
+func (r *RemoteWriteConfig) Validate() []error {
+	var errs []error
+	switch r.Transport {
+	case ClientHTTP, "":
+		errs = append(errs, validateURL("url", r.Url)...)
+	case ClientGRPC:
+		errs = append(errs, PrefixErrors("grpc", r.GRPC.Validate())...)
+		if len(r.QueryParams) > 0 || len(r.ForwardQueryParams) > 0 {
+			errs = append(errs, NewFieldError("query-params", "must not be set with %s transport", ClientGRPC))
+		}
+		if r.SigV4 != nil {
+			errs = append(errs, NewFieldError("sigv4", "must not be set with %s transport", ClientGRPC))
+		}
+	default:
+		errs = append(errs, NewFieldError("transport", "must be one of [%s %s], got %q", ClientHTTP, ClientGRPC, r.Transport))
+	}
+	if r.Timeout <= 0 {
+		errs = append(errs, NewFieldError("timeout", "must be positive, got %s", r.Timeout))
+	}
+	errs = append(errs, PrefixErrors("tls-config", r.TLSConfig.Validate())...)
+	errs = append(errs, validateRelabelConfigs("write-relabel-configs", r.WriteRelabelConfigs)...)
+	errs = append(errs, PrefixErrors("filter", r.Filter.Validate())...)
+	if r.ProtobufMessage != "" && r.ProtobufMessage != prompb.ProtoMsgV1 && r.ProtobufMessage != prompb.ProtoMsgV2 {
+		errs = append(errs, NewFieldError("protobuf-message", "must be one of [%s %s], got %q", prompb.ProtoMsgV1, prompb.ProtoMsgV2, r.ProtobufMessage))
+	}
+	errs = append(errs, PrefixErrors("retry", r.Retry.Validate())...)
+	if err := compression.ValidateLevel(r.Compression, r.CompressionLevel); err != nil {
+		errs = append(errs, NewFieldError("compression", "%s", err))
+	}
+	errs = append(errs, validateOutboundTemplates("headers", r.Headers)...)
+	errs = append(errs, validateOutboundTemplates("query-params", r.QueryParams)...)
+	for _, name := range sortedKeys(r.HeaderFiles) {
+		if name == "" || r.HeaderFiles[name] == "" {
+			errs = append(errs, NewFieldError("header-files", "name and file must not be empty, got %q: %q", name, r.HeaderFiles[name]))
+		}
+	}
+	for _, forward := range []struct {
+		field string
+		names []string
+	}{{"forward-headers", r.ForwardHeaders}, {"forward-query-params", r.ForwardQueryParams}} {
+		for i, name := range forward.names {
+			if name == "" {
+				errs = append(errs, NewFieldError(forward.field, "name %d must not be empty", i))
+			}
+		}
+	}
+	errs = append(errs, r.validateAuth()...)
+	return errs
+}
+
+// validateAuth validates auth methods, authorization header is set by auth methods only
+func (r *RemoteWriteConfig) validateAuth() []error {
+	var errs []error
+	var methods []string
+	if r.BasicAuth != nil {
+		methods = append(methods, "basic-auth")
+		errs = append(errs, PrefixErrors("basic-auth", r.BasicAuth.Validate())...)
+	}
+	if r.Authorization != nil {
+		methods = append(methods, "authorization")
+		errs = append(errs, PrefixErrors("authorization", r.Authorization.Validate())...)
+	}
+	if r.OAuth2 != nil {
+		methods = append(methods, "oauth2")
+		errs = append(errs, PrefixErrors("oauth2", r.OAuth2.Validate())...)
+	}
+	if r.SigV4 != nil {
+		methods = append(methods, "sigv4")
+		errs = append(errs, PrefixErrors("sigv4", r.SigV4.Validate())...)
+	}
+	if len(methods) > 1 {
+		errs = append(errs, NewFieldError(methods[1], "must not be set with %s", methods[0]))
+	}
+	if len(methods) == 0 {
+		return errs
+	}
+	for _, headers := range []struct {
+		field string
+		names []string
+	}{{"headers", sortedKeys(r.Headers)}, {"header-files", sortedKeys(r.HeaderFiles)}, {"forward-headers", r.ForwardHeaders}} {
+		for _, name := range headers.names {
+			if strings.EqualFold(name, "Authorization") {
+				errs = append(errs, NewFieldError(headers.field, "must not have Authorization with %s", methods[0]))
+			}
+		}
+	}
+	return errs
+}
+
+func (r *RetryConfig) Validate() []error {
+	if r.MaxRetries == 0 {
+		return nil
+	}
+	var errs []error
+	if r.MaxRetries < 0 {
+		errs = append(errs, NewFieldError("max-retries", "must not be negative, got %d", r.MaxRetries))
+	}
+	errs = append(errs, ValidatePositiveDuration("min-backoff", r.MinBackoff)...)
+	if r.MaxBackoff < r.MinBackoff {
+		errs = append(errs, NewFieldError("max-backoff", "must not be less than min-backoff %s, got %s", r.MinBackoff, r.MaxBackoff))
+	}
+	return errs
+}
+
+func validateOutboundTemplates(path string, templates map[string]string) []error {
+	var errs []error
+	for _, name := range sortedKeys(templates) {
+		if name == "" {
+			errs = append(errs, NewFieldError(path, "name must not be empty"))
+		}
+		if _, err := ParseOutboundTemplate(name, templates[name]); err != nil {
+			errs = append(errs, NewFieldError(path+"."+name, "%s", err))
+		}
+	}
+	return errs
+}
+
+func validateRelabelConfigs(path string, configs []relabel.Config) []error {
+	var errs []error
+	for i := range configs {
+		if err := configs[i].Validate(); err != nil {
+			errs = append(errs, NewFieldError(fmt.Sprintf("%s[%d]", path, i), "%s", err))
+		}
+	}
+	return errs
+}
+
+func (t *TraceConfig) Validate() []error {
+	var errs []error
+	if t.ClientType != ClientHTTP && t.ClientType != ClientGRPC {
+		errs = append(errs, NewFieldError("client-type", "must be one of [%s %s], got %q", ClientHTTP, ClientGRPC, t.ClientType))
+	}
+	if t.Endpoint == "" {
+		errs = append(errs, NewFieldError("endpoint", "must not be empty"))
+	}
+	if t.Timeout <= 0 {
+		errs = append(errs, NewFieldError("timeout", "must be positive, got %s", t.Timeout))
+	}
+	errs = append(errs, PrefixErrors("tls-config", t.TLSConfig.Validate())...)
+	return errs
+}
+
+func (a *AdminConfig) Validate() []error {
+	var errs []error
+	if a.Port < 0 || a.Port > 65535 {
+		errs = append(errs, NewFieldError("port", "must be in range 0-65535, got %d", a.Port))
+	}
+	if a.Username != "" && a.Passwd == "" {
+		errs = append(errs, NewFieldError("passwd", "must not be empty when username is set"))
+	}
+	errs = append(errs, validateFile("bearer-token-file", a.BearerTokenFile)...)
+	return errs
+}
+
+func (t *TLSConfig) Validate() []error {
+	var errs []error
+	errs = append(errs, validateFile("ca-file", t.CAFile)...)
+	errs = append(errs, validateFile("cert-file", t.CertFile)...)
+	errs = append(errs, validateFile("key-file", t.KeyFile)...)
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, NewFieldError("cert-file", "cert-file and key-file must be set together"))
+	}
+	return errs
+}
+
+func validateURL(path, raw string) []error {
+	if raw == "" {
+		return []error{NewFieldError(path, "must not be empty")}
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return []error{NewFieldError(path, "invalid url: %s", err)}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return []error{NewFieldError(path, "scheme must be http or https, got %q", u.Scheme)}
+	}
+	if u.Host == "" {
+		return []error{NewFieldError(path, "host must not be empty")}
+	}
+	return nil
+}
+
+// validateFile checks file is readable if it's set
+func validateFile(path, file string) []error {
+	if file == "" {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return []error{NewFieldError(path, "%s", err)}
+	}
+	f.Close()
+	return nil
+}
+
+// ValidatePositiveDuration is a helper for registered configurations
+func ValidatePositiveDuration(path string, d time.Duration) []error {
+	if d <= 0 {
+		return []error{NewFieldError(path, "must be positive, got %s", d)}
+	}
+	return nil
+}
+
+func isElectorConfig(key string) bool {
+	switch Elector(key) {
+	case K8S, Etcd, Redis, Consul, Zookeeper:
+		return true
+	default:
+		return false
+	}
+}
+
+func registeredConfigs() []string {
+	keys := make([]string, 0, len(extraConfigs))
+	for k := range extraConfigs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func additionalNames(c *Config) []string {
+	names := make([]string, 0, len(c.AdditionalRemoteWrites))
+	for _, rw := range c.AdditionalRemoteWrites {
+		names = append(names, rw.Name)
+	}
+	return names
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestConfig returns the default config forwarding to a url without election, electors are registered
+// by plugins
+func newTestConfig(t *testing.T) *Config {
+	t.Helper()
+	c := NewConfig()
+	if err := c.ToOptions().Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	c.ElectionEnabled = false
+	c.RemoteWriteConfig.Url = "http://localhost:9090/api/v1/write"
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatalf("default config is invalid: %v", errs)
+	}
+	return c
+}
+
+// fieldErrors returns paths of field errors
+func fieldErrors(errs []error) []string {
+	var paths []string
+	for _, err := range errs {
+		if fe, ok := err.(*FieldError); ok {
+			paths = append(paths, fe.Path)
+		} else {
+			paths = append(paths, err.Error())
+		}
+	}
+	return paths
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(c *Config)
+		err       string
+	}{
+		{name: "defaults", configure: func(c *Config) {}},
+		{name: "debug log level", configure: func(c *Config) { c.LogLevel = "debug" }},
+		{name: "warn log level", configure: func(c *Config) { c.LogLevel = "warn" }},
+		{name: "error log level", configure: func(c *Config) { c.LogLevel = "error" }},
+		{name: "unknown log level", configure: func(c *Config) { c.LogLevel = "trace" }, err: "log-level"},
+		{name: "invalid port", configure: func(c *Config) { c.Port = 0 }, err: "port"},
+		{name: "negative scrape interval", configure: func(c *Config) { c.PrometheusScrapeInterval = -1 }, err: "prometheus-scrape-interval"},
+		{name: "unknown elector", configure: func(c *Config) { c.ElectionEnabled, c.Elector = true, "zookeeper" }, err: "elector"},
+		{
+			name: "duplicated remote write name",
+			configure: func(c *Config) {
+				c.AdditionalRemoteWrites = []RemoteWriteConfig{{Name: "a", Url: "http://a/write"}, {Name: "a", Url: "http://b/write"}}
+			},
+			err: "additional-remote-writes[1].name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConfig(t)
+			tt.configure(c)
+			paths := strings.Join(fieldErrors(c.Validate()), ",")
+			if tt.err == "" && paths != "" || tt.err != "" && !strings.Contains(paths, tt.err) {
+				t.Errorf("errors = [%s], want [%s]", paths, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateAdmin(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		configure func(a *AdminConfig)
+		err       string
+	}{
+		{name: "shared port without credentials", configure: func(a *AdminConfig) {}, err: "admin.username"},
+		{name: "same port without credentials", configure: func(a *AdminConfig) { a.Port = 80 }, err: "admin.username"},
+		{name: "shared port with basic auth", configure: func(a *AdminConfig) { a.Username, a.Passwd = "admin", "secret" }},
+		{name: "shared port with bearer token", configure: func(a *AdminConfig) { a.BearerTokenFile = tokenFile }},
+		{name: "dedicated port without credentials", configure: func(a *AdminConfig) { a.Port = 8081 }},
+		{name: "username without passwd", configure: func(a *AdminConfig) { a.Username = "admin" }, err: "admin.passwd"},
+		{name: "invalid port", configure: func(a *AdminConfig) { a.Port = 70000 }, err: "admin.port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConfig(t)
+			c.AdminConfig.Enabled = true
+			tt.configure(&c.AdminConfig)
+			paths := strings.Join(fieldErrors(c.Validate()), ",")
+			if tt.err == "" && paths != "" || tt.err != "" && !strings.Contains(paths, tt.err) {
+				t.Errorf("errors = [%s], want [%s]", paths, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateTenancyHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		err     string
+	}{
+		{name: "template", headers: map[string]string{"X-Scope-OrgID": "{{.Tenant}}"}},
+		{name: "legacy placeholder", headers: map[string]string{"X-Scope-OrgID": "${tenant}"}, err: "tenancy.routes[0].headers.X-Scope-OrgID"},
+		{name: "invalid template", headers: map[string]string{"X-Scope-OrgID": "{{.Tenant"}, err: "tenancy.routes[0].headers.X-Scope-OrgID"},
+		{name: "empty name", headers: map[string]string{"": "a"}, err: "tenancy.routes[0].headers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConfig(t)
+			c.TenancyConfig.Enabled = true
+			c.TenancyConfig.Routes = []TenantRoute{{Tenants: []string{TenantWildcard}, Headers: tt.headers}}
+			paths := strings.Join(fieldErrors(c.Validate()), ",")
+			if tt.err == "" && paths != "" || tt.err != "" && !strings.Contains(paths, tt.err) {
+				t.Errorf("errors = [%s], want [%s]", paths, tt.err)
+			}
+		})
+	}
+}
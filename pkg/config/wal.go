@@ -0,0 +1,42 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// WalConfig is the write ahead log spooling writes of unavailable destinations, every destination
+// has its own directory under Dir
+type WalConfig struct {
+	Dir string `mapstructure:"dir"`
+	// MaxBytes bounds wal size of every destination, writes are rejected when it's full
+	MaxBytes       int64         `mapstructure:"max-bytes"`
+	SegmentBytes   int64         `mapstructure:"segment-bytes"`
+	ReplayInterval time.Duration `mapstructure:"replay-interval"`
+}
+
+func (w *WalConfig) ToOptions() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("wal", pflag.ContinueOnError)
+	fs.StringVar(&w.Dir, "dir", "data/wal", "wal directory")
+	fs.Int64Var(&w.MaxBytes, "max-bytes", 1<<30, "max wal size of every destination")
+	fs.Int64Var(&w.SegmentBytes, "segment-bytes", 64<<20, "wal segment size")
+	fs.DurationVar(&w.ReplayInterval, "replay-interval", 10*time.Second, "interval of replaying wal to recovered destinations")
+	PrefixFlags(fs, "wal")
+	return fs
+}
+
+func (w *WalConfig) Validate() []error {
+	var errs []error
+	if w.Dir == "" {
+		errs = append(errs, NewFieldError("dir", "must not be empty"))
+	}
+	if w.MaxBytes <= 0 {
+		errs = append(errs, NewFieldError("max-bytes", "must be positive, got %d", w.MaxBytes))
+	}
+	if w.SegmentBytes <= 0 || w.SegmentBytes > w.MaxBytes {
+		errs = append(errs, NewFieldError("segment-bytes", "must be in range 1-max-bytes, got %d", w.SegmentBytes))
+	}
+	errs = append(errs, ValidatePositiveDuration("replay-interval", w.ReplayInterval)...)
+	return errs
+}
@@ -0,0 +1,203 @@
+// Package dedup deduplicates samples of prometheus HA replicas. Replica labels are stripped, and a sample
+// is kept only if it's newer than the last one kept of the series, so samples of any replica fill the gap
+// while another one is down. Without replica labels, it tells out-of-order samples of series
+package dedup
+
+import (
+	"container/list"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+type entry struct {
+	key uint64
+	// last is the timestamp of the last kept sample
+	last int64
+	seen time.Time
+}
+
+// Deduplicator tracks the last kept timestamp of series in a LRU list, the most recently seen is in front
+type Deduplicator struct {
+	replicaLabels map[string]bool
+	max           int
+	expiry        time.Duration
+
+	mu     sync.Mutex
+	lru    *list.List
+	series map[uint64]*list.Element
+}
+
+// New returns a Deduplicator tracking at most maxSeries series, series not seen in expiry are forgotten
+func New(replicaLabels []string, maxSeries int, expiry time.Duration) *Deduplicator {
+	d := &Deduplicator{
+		replicaLabels: make(map[string]bool, len(replicaLabels)),
+		max:           maxSeries,
+		expiry:        expiry,
+		lru:           list.New(),
+		series:        map[uint64]*list.Element{},
+	}
+	for _, l := range replicaLabels {
+		d.replicaLabels[l] = true
+	}
+	return d
+}
+
+// Result is the deduplicated request, and samples dropped as duplicates
+type Result struct {
+	Request *prompb.WriteRequest
+	// Rejected are samples and histograms not newer than the last kept ones, exemplars are dropped
+	Rejected   *prompb.WriteRequest
+	Duplicates int
+	// reserved are last timestamps updated by the request, they're restored by Cancel
+	reserved []reservation
+}
+
+type reservation struct {
+	elem     *list.Element
+	previous int64
+	last     int64
+}
+
+// Dedup strips replica labels and drops samples, histograms and exemplars not newer than the last kept ones.
+// Last timestamps are updated immediately, so a concurrent request of another replica doesn't forward the
+// same samples, they should be restored by Cancel if the request is not forwarded
+func (d *Deduplicator) Dedup(req *prompb.WriteRequest, now time.Time) *Result {
+	r := &Result{
+		Request: &prompb.WriteRequest{
+			Timeseries: make([]prompb.TimeSeries, 0, len(req.Timeseries)),
+			Metadata:   req.Metadata,
+		},
+		Rejected: &prompb.WriteRequest{Metadata: req.Metadata},
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(now)
+	for _, ts := range req.Timeseries {
+		ts.Labels = d.strip(ts.Labels)
+		// exemplar-only series have nothing to dedup, they're passed through unchanged and not tracked
+		if len(ts.Samples) == 0 && len(ts.Histograms) == 0 {
+			if len(ts.Exemplars) > 0 {
+				r.Request.Timeseries = append(r.Request.Timeseries, ts)
+			}
+			continue
+		}
+		elem := d.touch(hash(ts.Labels), now)
+		e := elem.Value.(*entry)
+		previous := e.last
+
+		var samples, rejectedSamples []prompb.Sample
+		for _, s := range ts.Samples {
+			if s.Timestamp > e.last {
+				samples = append(samples, s)
+				e.last = s.Timestamp
+			} else {
+				rejectedSamples = append(rejectedSamples, s)
+			}
+		}
+		var histograms, rejectedHistograms []prompb.Histogram
+		for _, h := range ts.Histograms {
+			if h.Timestamp > e.last {
+				histograms = append(histograms, h)
+				e.last = h.Timestamp
+			} else {
+				rejectedHistograms = append(rejectedHistograms, h)
+			}
+		}
+		if n := len(rejectedSamples) + len(rejectedHistograms); n > 0 {
+			r.Duplicates += n
+			rejected := ts
+			rejected.Samples, rejected.Histograms, rejected.Exemplars = rejectedSamples, rejectedHistograms, nil
+			r.Rejected.Timeseries = append(r.Rejected.Timeseries, rejected)
+		}
+		if len(samples) == 0 && len(histograms) == 0 {
+			continue
+		}
+		var exemplars []prompb.Exemplar
+		for _, ex := range ts.Exemplars {
+			if ex.Timestamp > previous {
+				exemplars = append(exemplars, ex)
+			}
+		}
+		ts.Samples, ts.Histograms, ts.Exemplars = samples, histograms, exemplars
+		r.Request.Timeseries = append(r.Request.Timeseries, ts)
+		r.reserved = append(r.reserved, reservation{elem: elem, previous: previous, last: e.last})
+	}
+	return r
+}
+
+// Cancel restores last timestamps updated by the request, unless they're updated by a later request
+func (d *Deduplicator) Cancel(r *Result) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, res := range r.reserved {
+		if e := res.elem.Value.(*entry); e.last == res.last {
+			e.last = res.previous
+		}
+	}
+}
+
+// Len returns the number of tracked series
+func (d *Deduplicator) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lru.Len()
+}
+
+func (d *Deduplicator) strip(labels []prompb.Label) []prompb.Label {
+	for i, l := range labels {
+		if !d.replicaLabels[l.Name] {
+			continue
+		}
+		// labels are copied once as they're shared with the decoded request
+		out := append(make([]prompb.Label, 0, len(labels)-1), labels[:i]...)
+		for _, l := range labels[i+1:] {
+			if !d.replicaLabels[l.Name] {
+				out = append(out, l)
+			}
+		}
+		return out
+	}
+	return labels
+}
+
+// touch returns entry of the series and moves it to front, the least recently seen one is evicted if it's full
+func (d *Deduplicator) touch(key uint64, now time.Time) *list.Element {
+	if elem, ok := d.series[key]; ok {
+		elem.Value.(*entry).seen = now
+		d.lru.MoveToFront(elem)
+		return elem
+	}
+	if d.lru.Len() >= d.max {
+		d.remove(d.lru.Back())
+	}
+	elem := d.lru.PushFront(&entry{key: key, last: math.MinInt64, seen: now})
+	d.series[key] = elem
+	return elem
+}
+
+// expire removes series not seen in expiry from the back
+func (d *Deduplicator) expire(now time.Time) {
+	for elem := d.lru.Back(); elem != nil && now.Sub(elem.Value.(*entry).seen) > d.expiry; elem = d.lru.Back() {
+		d.remove(elem)
+	}
+}
+
+func (d *Deduplicator) remove(elem *list.Element) {
+	d.lru.Remove(elem)
+	delete(d.series, elem.Value.(*entry).key)
+}
+
+func hash(labels []prompb.Label) uint64 {
+	h := fnv.New64a()
+	for _, l := range labels {
+		h.Write([]byte(l.Name))
+		h.Write([]byte{0xff})
+		h.Write([]byte(l.Value))
+		h.Write([]byte{0xff})
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,34 @@
+package dedup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+func TestDedupPassesExemplarOnlySeries(t *testing.T) {
+	d := New([]string{"replica"}, 100, time.Hour)
+	exemplars := []prompb.Exemplar{{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Value: 1, Timestamp: 1000}}
+	series := func(replica string) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels:    []prompb.Label{{Name: prompb.MetricNameLabel, Value: "up"}, {Name: "replica", Value: replica}},
+			Exemplars: exemplars,
+		}
+	}
+	now := time.Now()
+	for _, replica := range []string{"a", "b", "a"} {
+		r := d.Dedup(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{series(replica)}}, now)
+		if r.Duplicates != 0 || len(r.Rejected.Timeseries) != 0 {
+			t.Errorf("replica %s: exemplar-only series is rejected", replica)
+		}
+		want := []prompb.TimeSeries{{Labels: []prompb.Label{{Name: prompb.MetricNameLabel, Value: "up"}}, Exemplars: exemplars}}
+		if !reflect.DeepEqual(r.Request.Timeseries, want) {
+			t.Errorf("replica %s: series = %+v, want %+v", replica, r.Request.Timeseries, want)
+		}
+	}
+	if d.Len() != 0 {
+		t.Errorf("%d series are tracked, want 0", d.Len())
+	}
+}
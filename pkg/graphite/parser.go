@@ -0,0 +1,212 @@
+// Package graphite parses graphite plaintext protocol into prometheus series.
+// Metric paths are mapped to names and labels by templates, tagged metrics(name;tag=value) keep their tags
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// template parts which are not tags
+const (
+	partMeasurement    = "measurement"
+	partMeasurementAll = "measurement*"
+	partField          = "field"
+	partFieldAll       = "field*"
+)
+
+// Template maps nodes of metric path to metric name and labels, it's "[filter] template [tags]", e.g.
+// "servers.* .host.measurement.field* region=us". Nodes of measurement parts are joined as metric name,
+// nodes of field parts are appended to it, nodes of other parts are values of labels named by parts,
+// empty parts are skipped. Tags are default labels of matched metrics
+type Template struct {
+	// filter are globs of path nodes, nil matches all paths
+	filter []string
+	parts  []string
+	tags   map[string]string
+}
+
+// ParseTemplate parses template in the form of "[filter] template [tags]"
+func ParseTemplate(s string) (*Template, error) {
+	fields := strings.Fields(s)
+	t := &Template{}
+	var tags string
+	switch len(fields) {
+	case 1:
+		t.parts = strings.Split(fields[0], ".")
+	case 2:
+		if strings.Contains(fields[1], "=") {
+			t.parts, tags = strings.Split(fields[0], "."), fields[1]
+		} else {
+			t.filter, t.parts = strings.Split(fields[0], "."), strings.Split(fields[1], ".")
+		}
+	case 3:
+		t.filter, t.parts, tags = strings.Split(fields[0], "."), strings.Split(fields[1], "."), fields[2]
+	default:
+		return nil, fmt.Errorf("invalid template %q, must be \"[filter] template [tags]\"", s)
+	}
+
+	for _, glob := range t.filter {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %w", glob, err)
+		}
+	}
+	measurement := false
+	for i, part := range t.parts {
+		switch part {
+		case partMeasurementAll, partFieldAll:
+			if i != len(t.parts)-1 {
+				return nil, fmt.Errorf("invalid template %q, %s must be the last part", s, part)
+			}
+			measurement = measurement || part == partMeasurementAll
+		case partMeasurement:
+			measurement = true
+		}
+	}
+	if !measurement {
+		return nil, fmt.Errorf("invalid template %q, measurement part is required", s)
+	}
+
+	if tags != "" {
+		t.tags = map[string]string{}
+		for _, tag := range strings.Split(tags, ",") {
+			k, v, ok := strings.Cut(tag, "=")
+			if !ok || k == "" || v == "" {
+				return nil, fmt.Errorf("invalid tag %q of template %q", tag, s)
+			}
+			t.tags[prompb.SanitizeLabelName(k)] = v
+		}
+	}
+	return t, nil
+}
+
+func (t *Template) match(nodes []string) bool {
+	if len(nodes) < len(t.filter) {
+		return false
+	}
+	for i, glob := range t.filter {
+		if ok, _ := path.Match(glob, nodes[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns metric name and labels of nodes, labels override tags of template
+func (t *Template) apply(nodes []string, labels map[string]string) string {
+	for k, v := range t.tags {
+		labels[k] = v
+	}
+	var measurement, field []string
+	extracted := map[string][]string{}
+	for i, part := range t.parts {
+		if i >= len(nodes) {
+			break
+		}
+		switch part {
+		case "":
+		case partMeasurement:
+			measurement = append(measurement, nodes[i])
+		case partMeasurementAll:
+			measurement = append(measurement, nodes[i:]...)
+		case partField:
+			field = append(field, nodes[i])
+		case partFieldAll:
+			field = append(field, nodes[i:]...)
+		default:
+			extracted[part] = append(extracted[part], nodes[i])
+		}
+	}
+	for k, v := range extracted {
+		labels[prompb.SanitizeLabelName(k)] = strings.Join(v, ".")
+	}
+	return strings.Join(append(measurement, field...), "_")
+}
+
+// Parser parses graphite lines, the first template matching metric path applies,
+// paths not matching any template are named by replacing dots with _
+type Parser struct {
+	templates []*Template
+}
+
+func NewParser(templates []string) (*Parser, error) {
+	p := &Parser{}
+	for _, s := range templates {
+		t, err := ParseTemplate(s)
+		if err != nil {
+			return nil, err
+		}
+		p.templates = append(p.templates, t)
+	}
+	return p, nil
+}
+
+// Parse parses a line of "path value [timestamp]", timestamp is in seconds, it's now if absent or -1
+func (p *Parser) Parse(line string, now time.Time) (prompb.TimeSeries, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 3 {
+		return prompb.TimeSeries{}, errors.New("must be \"path value [timestamp]\"")
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return prompb.TimeSeries{}, fmt.Errorf("invalid value %q", fields[1])
+	}
+	ts := now.UnixMilli()
+	if len(fields) == 3 && fields[2] != "-1" {
+		t, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || math.IsNaN(t) || math.IsInf(t, 0) {
+			return prompb.TimeSeries{}, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+		ts = int64(t * 1000)
+	}
+
+	labels := map[string]string{}
+	name, tags, tagged := strings.Cut(fields[0], ";")
+	if tagged {
+		keys := map[string]string{}
+		for _, tag := range strings.Split(tags, ";") {
+			k, v, ok := strings.Cut(tag, "=")
+			if !ok || k == "" {
+				return prompb.TimeSeries{}, fmt.Errorf("invalid tag %q", tag)
+			}
+			ln := prompb.SanitizeLabelName(k)
+			if other, ok := keys[ln]; ok {
+				return prompb.TimeSeries{}, fmt.Errorf("tags %q and %q are both label %q", other, k, ln)
+			}
+			keys[ln] = k
+			if v != "" {
+				labels[ln] = v
+			}
+		}
+	} else {
+		nodes := strings.Split(name, ".")
+		for _, t := range p.templates {
+			if t.match(nodes) {
+				name = t.apply(nodes, labels)
+				break
+			}
+		}
+	}
+	if name == "" {
+		return prompb.TimeSeries{}, errors.New("empty metric name")
+	}
+	labels[prompb.MetricNameLabel] = prompb.SanitizeMetricName(name)
+
+	series := prompb.TimeSeries{
+		Labels:  make([]prompb.Label, 0, len(labels)),
+		Samples: []prompb.Sample{{Value: value, Timestamp: ts}},
+	}
+	for k, v := range labels {
+		series.Labels = append(series.Labels, prompb.Label{Name: k, Value: v})
+	}
+	sort.Slice(series.Labels, func(i, j int) bool { return series.Labels[i].Name < series.Labels[j].Name })
+	return series, nil
+}
@@ -0,0 +1,99 @@
+package graphite
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+var now = time.UnixMilli(1700000000000)
+
+// format formats the series as name{labels} value@timestamp
+func format(ts prompb.TimeSeries) string {
+	var labels []string
+	for _, l := range ts.Labels {
+		if l.Name != prompb.MetricNameLabel {
+			labels = append(labels, fmt.Sprintf("%s=%q", l.Name, l.Value))
+		}
+	}
+	return fmt.Sprintf("%s{%s} %v@%d", ts.MetricName(), strings.Join(labels, ","), ts.Samples[0].Value, ts.Samples[0].Timestamp)
+}
+
+func TestParseTemplate(t *testing.T) {
+	for _, s := range []string{
+		"measurement",
+		"host.measurement*",
+		"servers.* .host.measurement.field* region=us",
+		"measurement.field env=prod,dc=a",
+	} {
+		if _, err := ParseTemplate(s); err != nil {
+			t.Errorf("ParseTemplate(%q): %v", s, err)
+		}
+	}
+	for _, s := range []string{
+		"",
+		"host.field",
+		"measurement*.host",
+		"a b c d",
+		"[ measurement",
+		"measurement env",
+		"measurement env=",
+	} {
+		if _, err := ParseTemplate(s); err == nil {
+			t.Errorf("ParseTemplate(%q): no error", s)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	p, err := NewParser([]string{
+		"servers.* .host.measurement.field* region=us",
+		"apps.*.requests .app.measurement.measurement",
+		"measurement*",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		line string
+		want string
+		err  string
+	}{
+		{
+			name: "template with tags",
+			line: "servers.web01.cpu.load.shortterm 0.5 1700000001",
+			want: `cpu_load_shortterm{host="web01",region="us"} 0.5@1700000001000`,
+		},
+		{name: "measurement parts", line: "apps.shop.requests.total 7", want: `requests_total{app="shop"} 7@1700000000000`},
+		{name: "default template", line: "disk.sda-1.used 3 -1", want: `disk_sda_1_used{} 3@1700000000000`},
+		{name: "fractional timestamp", line: "up 1 1700000001.5", want: `up{} 1@1700000001500`},
+		{name: "tagged", line: "disk.used;host=a;mount.point=/;empty= 2", want: `disk_used{host="a",mount_point="/"} 2@1700000000000`},
+		{name: "tags of the same label", line: "disk;mount.point=/;mount_point=/data 1", err: `tags "mount.point" and "mount_point" are both label "mount_point"`},
+		{name: "invalid tag", line: "disk;host 1", err: "invalid tag"},
+		{name: "missing value", line: "disk.used", err: "must be"},
+		{name: "invalid value", line: "disk.used abc", err: "invalid value"},
+		{name: "invalid timestamp", line: "disk.used 1 NaN", err: "invalid timestamp"},
+		{name: "empty name", line: ";host=a 1", err: "empty metric name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, err := p.Parse(tt.line, now)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := format(ts); got != tt.want {
+				t.Errorf("series = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
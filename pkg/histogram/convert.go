@@ -0,0 +1,144 @@
+package histogram
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// ToClassic converts native histograms of series to classic `_bucket`, `_count` and `_sum` series,
+// exemplars are attached to the bucket they fall in. Histograms must be valid.
+// Float samples of the series are kept in a series of its own
+func ToClassic(ts *prompb.TimeSeries) []prompb.TimeSeries {
+	name := ts.MetricName()
+	var out []prompb.TimeSeries
+	if len(ts.Samples) > 0 {
+		out = append(out, prompb.TimeSeries{Labels: ts.Labels, Samples: ts.Samples, Metadata: ts.Metadata, CreatedTimestamp: ts.CreatedTimestamp})
+	}
+
+	buckets := map[float64]*prompb.TimeSeries{}
+	count := series(ts, name+"_count", "")
+	sum := series(ts, name+"_sum", "")
+	for i := range ts.Histograms {
+		h := &ts.Histograms[i]
+		total, _ := counts(h)
+		count.Samples = append(count.Samples, prompb.Sample{Value: total, Timestamp: h.Timestamp})
+		sum.Samples = append(sum.Samples, prompb.Sample{Value: h.Sum, Timestamp: h.Timestamp})
+		for _, b := range cumulativeBuckets(h) {
+			bucket, ok := buckets[b.le]
+			if !ok {
+				bucket = series(ts, name+"_bucket", formatLe(b.le))
+				buckets[b.le] = bucket
+			}
+			bucket.Samples = append(bucket.Samples, prompb.Sample{Value: b.count, Timestamp: h.Timestamp})
+		}
+	}
+
+	les := make([]float64, 0, len(buckets))
+	for le := range buckets {
+		les = append(les, le)
+	}
+	sort.Float64s(les)
+	for _, e := range ts.Exemplars {
+		i := sort.SearchFloat64s(les, e.Value)
+		if i < len(les) {
+			buckets[les[i]].Exemplars = append(buckets[les[i]].Exemplars, e)
+		}
+	}
+	for _, le := range les {
+		out = append(out, *buckets[le])
+	}
+	return append(out, *count, *sum)
+}
+
+// series copies labels of ts with a new name and an optional le label, labels are kept sorted
+func series(ts *prompb.TimeSeries, name, le string) *prompb.TimeSeries {
+	labels := make([]prompb.Label, 0, len(ts.Labels)+1)
+	for _, l := range ts.Labels {
+		if l.Name == prompb.MetricNameLabel {
+			l.Value = name
+		}
+		labels = append(labels, l)
+	}
+	if le != "" {
+		labels = append(labels, prompb.Label{Name: "le", Value: le})
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	}
+	return &prompb.TimeSeries{Labels: labels, Metadata: ts.Metadata}
+}
+
+type bucket struct {
+	le    float64
+	count float64
+}
+
+// cumulativeBuckets returns buckets in ascending order of upper bound with cumulative counts,
+// the last one is +Inf with total count
+func cumulativeBuckets(h *prompb.Histogram) []bucket {
+	negative, _ := bucketCounts(h, h.NegativeSpans, h.NegativeDeltas, h.NegativeCounts, "negative")
+	positive, _ := bucketCounts(h, h.PositiveSpans, h.PositiveDeltas, h.PositiveCounts, "positive")
+	total, zeroCount := counts(h)
+
+	var out []bucket
+	var cumulative float64
+	// negative buckets with higher index are further from zero, upper bound of bucket i is -base^(i-1)
+	negIdx := indexes(h.NegativeSpans)
+	for i := len(negative) - 1; i >= 0; i-- {
+		cumulative += negative[i]
+		// buckets inside zero bucket are empty and their bounds collide with it
+		if le := -upperBound(negIdx[i]-1, h); le < -h.ZeroThreshold {
+			out = append(out, bucket{le: le, count: cumulative})
+		}
+	}
+	if h.Schema != prompb.CustomBucketsSchema {
+		cumulative += zeroCount
+		out = append(out, bucket{le: h.ZeroThreshold, count: cumulative})
+	}
+	posIdx := indexes(h.PositiveSpans)
+	for i, c := range positive {
+		cumulative += c
+		le := upperBound(posIdx[i], h)
+		if math.IsInf(le, 1) || (h.Schema != prompb.CustomBucketsSchema && le <= h.ZeroThreshold) {
+			continue
+		}
+		out = append(out, bucket{le: le, count: cumulative})
+	}
+	return append(out, bucket{le: math.Inf(1), count: total})
+}
+
+func indexes(spans []prompb.BucketSpan) []int {
+	var idx []int
+	i := 0
+	for _, span := range spans {
+		i += int(span.Offset)
+		for j := 0; j < int(span.Length); j++ {
+			idx = append(idx, i)
+			i++
+		}
+	}
+	return idx
+}
+
+// upperBound of bucket index, it's base^idx for exponential schema where base = 2^(2^-schema),
+// and the custom value for custom buckets schema
+func upperBound(idx int, h *prompb.Histogram) float64 {
+	if h.Schema == prompb.CustomBucketsSchema {
+		if idx >= len(h.CustomValues) {
+			return math.Inf(1)
+		}
+		return h.CustomValues[idx]
+	}
+	if h.Schema <= 0 {
+		return math.Ldexp(1, idx<<uint(-h.Schema))
+	}
+	return math.Exp2(float64(idx) / float64(int(1)<<uint(h.Schema)))
+}
+
+func formatLe(le float64) string {
+	if math.IsInf(le, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(le, 'g', -1, 64)
+}
@@ -0,0 +1,156 @@
+// Package histogram validates native histograms of remote write and converts them to classic histograms
+package histogram
+
+import (
+	"fmt"
+	"math"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// schema range of exponential buckets
+const (
+	MinSchema = -4
+	MaxSchema = 8
+)
+
+// validation error reasons, they're used as metric label values
+const (
+	ReasonSchema     = "schema"
+	ReasonSpans      = "spans"
+	ReasonCounts     = "counts"
+	ReasonBuckets    = "too_many_buckets"
+	ReasonCustomVals = "custom_values"
+)
+
+// ValidationError is returned by Validate
+type ValidationError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func invalid(reason, format string, args ...any) error {
+	return &ValidationError{Reason: reason, Err: fmt.Errorf(format, args...)}
+}
+
+// Validate checks schema range, spans and bucket counts consistency, maxBuckets 0 means no limit
+func Validate(h *prompb.Histogram, maxBuckets int) error {
+	custom := h.Schema == prompb.CustomBucketsSchema
+	if !custom && (h.Schema < MinSchema || h.Schema > MaxSchema) {
+		return invalid(ReasonSchema, "schema %d out of range [%d, %d]", h.Schema, MinSchema, MaxSchema)
+	}
+	if custom {
+		if len(h.NegativeSpans) > 0 || len(h.NegativeDeltas) > 0 || len(h.NegativeCounts) > 0 {
+			return invalid(ReasonSpans, "custom buckets histogram must not have negative buckets")
+		}
+		for i := 1; i < len(h.CustomValues); i++ {
+			if h.CustomValues[i] <= h.CustomValues[i-1] {
+				return invalid(ReasonCustomVals, "custom values must be strictly increasing, %g follows %g", h.CustomValues[i], h.CustomValues[i-1])
+			}
+		}
+	} else if len(h.CustomValues) > 0 {
+		return invalid(ReasonCustomVals, "custom values are only allowed for schema %d", prompb.CustomBucketsSchema)
+	}
+
+	negative, err := bucketCounts(h, h.NegativeSpans, h.NegativeDeltas, h.NegativeCounts, "negative")
+	if err != nil {
+		return err
+	}
+	positive, err := bucketCounts(h, h.PositiveSpans, h.PositiveDeltas, h.PositiveCounts, "positive")
+	if err != nil {
+		return err
+	}
+	if maxBuckets > 0 && len(negative)+len(positive) > maxBuckets {
+		return invalid(ReasonBuckets, "%d buckets exceed limit %d", len(negative)+len(positive), maxBuckets)
+	}
+	if custom {
+		if idx := lastIndex(h.PositiveSpans); idx > len(h.CustomValues) {
+			return invalid(ReasonCustomVals, "bucket index %d exceeds %d custom values", idx, len(h.CustomValues))
+		}
+	}
+
+	if math.IsNaN(h.ZeroThreshold) || h.ZeroThreshold < 0 {
+		return invalid(ReasonCounts, "invalid zero threshold %g", h.ZeroThreshold)
+	}
+	var observed float64
+	for _, buckets := range [][]float64{negative, positive} {
+		for _, c := range buckets {
+			if c < 0 {
+				return invalid(ReasonCounts, "negative bucket count %g", c)
+			}
+			observed += c
+		}
+	}
+	count, zeroCount := counts(h)
+	if zeroCount < 0 {
+		return invalid(ReasonCounts, "negative zero count %g", zeroCount)
+	}
+	observed += zeroCount
+	if count < observed && !nearlyEqual(count, observed) {
+		return invalid(ReasonCounts, "count %g is smaller than %g observations in buckets", count, observed)
+	}
+	// NaN observations are counted but not in any bucket, and they make sum NaN
+	if !h.Float && !math.IsNaN(h.Sum) && count != observed {
+		return invalid(ReasonCounts, "count %g doesn't match %g observations in buckets", count, observed)
+	}
+	return nil
+}
+
+func nearlyEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
+
+func counts(h *prompb.Histogram) (count, zeroCount float64) {
+	if h.Float {
+		return h.CountFloat, h.ZeroCountFloat
+	}
+	return float64(h.CountInt), float64(h.ZeroCountInt)
+}
+
+// bucketCounts returns absolute counts of buckets, spans must cover exactly all buckets
+// and only the first span may have negative offset
+func bucketCounts(h *prompb.Histogram, spans []prompb.BucketSpan, deltas []int64, floats []float64, side string) ([]float64, error) {
+	var need int
+	for i, span := range spans {
+		if i > 0 && span.Offset < 0 {
+			return nil, invalid(ReasonSpans, "%s span %d has negative offset %d", side, i, span.Offset)
+		}
+		need += int(span.Length)
+	}
+
+	if h.Float {
+		if len(deltas) > 0 {
+			return nil, invalid(ReasonSpans, "float histogram must not have %s deltas", side)
+		}
+		if need != len(floats) {
+			return nil, invalid(ReasonSpans, "%s spans need %d buckets, have %d", side, need, len(floats))
+		}
+		return floats, nil
+	}
+
+	if len(floats) > 0 {
+		return nil, invalid(ReasonSpans, "integer histogram must not have %s counts", side)
+	}
+	if need != len(deltas) {
+		return nil, invalid(ReasonSpans, "%s spans need %d buckets, have %d", side, need, len(deltas))
+	}
+	out := make([]float64, len(deltas))
+	var c int64
+	for i, d := range deltas {
+		c += d
+		out[i] = float64(c)
+	}
+	return out, nil
+}
+
+func lastIndex(spans []prompb.BucketSpan) int {
+	idx := 0
+	for _, span := range spans {
+		idx += int(span.Offset) + int(span.Length)
+	}
+	return idx - 1
+}
@@ -0,0 +1,218 @@
+// Package influx parses InfluxDB line protocol into prometheus series.
+// Every numeric field of a point is a series named measurement_field, field "value" is named after
+// the measurement, and tags are labels. String fields have no prometheus representation, they're skipped
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// valueField is the field named after measurement only
+const valueField = "value"
+
+// ParsePrecision returns the unit of timestamps, precision is the precision parameter of /api/v2/write,
+// empty means nanoseconds
+func ParsePrecision(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "ns":
+		return time.Nanosecond, nil
+	case "us":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	default:
+		return 0, fmt.Errorf("invalid precision %q, must be one of ns, us, ms and s", precision)
+	}
+}
+
+// Result is the parsed series, invalid lines are skipped and reported by Errors
+type Result struct {
+	Series []prompb.TimeSeries
+	// Lines is the number of points, including invalid ones
+	Lines         int
+	SkippedFields int
+	Errors        []error
+}
+
+// Parse parses line protocol, points without timestamp are at now
+func Parse(data []byte, precision time.Duration, now time.Time) *Result {
+	r := &Result{}
+	for n, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		r.Lines++
+		series, skipped, err := parseLine(string(line), precision, now)
+		if err != nil {
+			r.Errors = append(r.Errors, fmt.Errorf("line %d: %w", n+1, err))
+			continue
+		}
+		r.Series = append(r.Series, series...)
+		r.SkippedFields += skipped
+	}
+	return r
+}
+
+func parseLine(line string, precision time.Duration, now time.Time) ([]prompb.TimeSeries, int, error) {
+	end := indexUnescaped(line, ' ', false)
+	if end < 0 {
+		return nil, 0, errors.New("missing fields")
+	}
+	key, rest := line[:end], strings.TrimLeft(line[end:], " ")
+	keyParts := splitUnescaped(key, ',', false)
+	measurement := unescape(keyParts[0])
+	if measurement == "" {
+		return nil, 0, errors.New("missing measurement")
+	}
+	tags := make(map[string]string, len(keyParts)-1)
+	// keys are tag keys by label name, tags whose keys sanitize to the same label name are rejected
+	keys := make(map[string]string, len(keyParts)-1)
+	for _, tag := range keyParts[1:] {
+		eq := indexUnescaped(tag, '=', false)
+		if eq <= 0 {
+			return nil, 0, fmt.Errorf("invalid tag %q", tag)
+		}
+		key := unescape(tag[:eq])
+		name := prompb.SanitizeLabelName(key)
+		if other, ok := keys[name]; ok {
+			return nil, 0, fmt.Errorf("tags %q and %q are both label %q", other, key, name)
+		}
+		keys[name] = key
+		// empty labels are absent in prometheus
+		if v := unescape(tag[eq+1:]); v != "" {
+			tags[name] = v
+		}
+	}
+	delete(tags, prompb.MetricNameLabel)
+
+	end = indexUnescaped(rest, ' ', true)
+	if end < 0 {
+		end = len(rest)
+	}
+	fields, timestamp := rest[:end], strings.TrimSpace(rest[end:])
+	ts := now.UnixMilli()
+	if timestamp != "" {
+		t, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		ts = toMillis(t, precision)
+	}
+
+	var series []prompb.TimeSeries
+	skipped := 0
+	for _, field := range splitUnescaped(fields, ',', true) {
+		eq := indexUnescaped(field, '=', false)
+		if eq <= 0 || eq == len(field)-1 {
+			return nil, 0, fmt.Errorf("invalid field %q", field)
+		}
+		name, raw := unescape(field[:eq]), field[eq+1:]
+		if raw[0] == '"' {
+			skipped++
+			continue
+		}
+		value, err := parseValue(raw)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid value of field %q: %w", name, err)
+		}
+		metric := measurement + "_" + name
+		if name == valueField {
+			metric = measurement
+		}
+		series = append(series, prompb.TimeSeries{
+			Labels:  labels(prompb.SanitizeMetricName(metric), tags),
+			Samples: []prompb.Sample{{Value: value, Timestamp: ts}},
+		})
+	}
+	return series, skipped, nil
+}
+
+func labels(name string, tags map[string]string) []prompb.Label {
+	labels := make([]prompb.Label, 0, len(tags)+1)
+	labels = append(labels, prompb.Label{Name: prompb.MetricNameLabel, Value: name})
+	for k, v := range tags {
+		labels = append(labels, prompb.Label{Name: k, Value: v})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
+
+// parseValue parses float, integer(i suffix), unsigned integer(u suffix) and boolean fields
+func parseValue(raw string) (float64, error) {
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, nil
+	}
+	switch raw[len(raw)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		return float64(v), err
+	case 'u':
+		v, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		return float64(v), err
+	}
+	return strconv.ParseFloat(raw, 64)
+}
+
+func toMillis(t int64, precision time.Duration) int64 {
+	if precision >= time.Millisecond {
+		return t * int64(precision/time.Millisecond)
+	}
+	return t / int64(time.Millisecond/precision)
+}
+
+// indexUnescaped returns index of the first sep which is not escaped by \ or in a double-quoted string
+func indexUnescaped(s string, sep byte, quoted bool) int {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			return i
+		}
+	}
+	return -1
+}
+
+func splitUnescaped(s string, sep byte, quoted bool) []string {
+	var parts []string
+	for {
+		i := indexUnescaped(s, sep, quoted)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// unescape removes \ before comma, equal sign and space
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == ',' || s[i+1] == '=' || s[i+1] == ' ') {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
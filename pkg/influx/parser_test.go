@@ -0,0 +1,168 @@
+package influx
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+var now = time.UnixMilli(1700000000000)
+
+// format formats series as name{labels} value@timestamp
+func format(series []prompb.TimeSeries) []string {
+	var out []string
+	for _, ts := range series {
+		var labels []string
+		for _, l := range ts.Labels {
+			if l.Name != prompb.MetricNameLabel {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.Name, l.Value))
+			}
+		}
+		out = append(out, fmt.Sprintf("%s{%s} %v@%d", ts.MetricName(), strings.Join(labels, ","), ts.Samples[0].Value, ts.Samples[0].Timestamp))
+	}
+	return out
+}
+
+func TestIndexUnescaped(t *testing.T) {
+	tests := []struct {
+		s      string
+		sep    byte
+		quoted bool
+		want   int
+	}{
+		{s: "a b", sep: ' ', want: 1},
+		{s: `a\ b c`, sep: ' ', want: 4},
+		{s: `a\\ b`, sep: ' ', want: 3},
+		{s: `a=\,b,c`, sep: ',', want: 5},
+		{s: `s="a,b",c`, sep: ',', quoted: true, want: 7},
+		{s: `s="a,b",c`, sep: ',', want: 4},
+		{s: `s="a\",b",c`, sep: ',', quoted: true, want: 9},
+		{s: `abc`, sep: ',', want: -1},
+		{s: `abc\`, sep: ',', want: -1},
+	}
+	for _, tt := range tests {
+		if got := indexUnescaped(tt.s, tt.sep, tt.quoted); got != tt.want {
+			t.Errorf("indexUnescaped(%q, %q, %v) = %d, want %d", tt.s, tt.sep, tt.quoted, got, tt.want)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	for s, want := range map[string]string{
+		"plain":       "plain",
+		`a\ b`:        "a b",
+		`a\,b\=c`:     "a,b=c",
+		`back\\slash`: `back\\slash`,
+		`trailing\`:   `trailing\`,
+		`\n`:          `\n`,
+	} {
+		if got := unescape(s); got != want {
+			t.Errorf("unescape(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	tests := []struct {
+		precision string
+		timestamp string
+		want      int64
+	}{
+		{precision: "", timestamp: "1700000000123456789", want: 1700000000123},
+		{precision: "ns", timestamp: "1700000000123456789", want: 1700000000123},
+		{precision: "us", timestamp: "1700000000123456", want: 1700000000123},
+		{precision: "ms", timestamp: "1700000000123", want: 1700000000123},
+		{precision: "s", timestamp: "1700000000", want: 1700000000000},
+	}
+	for _, tt := range tests {
+		precision, err := ParsePrecision(tt.precision)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := Parse([]byte("cpu value=1 "+tt.timestamp), precision, now)
+		if len(r.Series) != 1 || r.Series[0].Samples[0].Timestamp != tt.want {
+			t.Errorf("precision %q: series = %v, errors = %v, want timestamp %d", tt.precision, r.Series, r.Errors, tt.want)
+		}
+	}
+	if _, err := ParsePrecision("m"); err == nil {
+		t.Error("ParsePrecision(m): no error")
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    []string
+		skipped int
+		err     string
+	}{
+		{
+			name: "fields",
+			line: "cpu,host=a,region=us-west usage=0.5,value=1i 1700000001000000000",
+			want: []string{`cpu_usage{host="a",region="us-west"} 0.5@1700000001000`, `cpu{host="a",region="us-west"} 1@1700000001000`},
+		},
+		{
+			name: "value types",
+			line: "m a=t,b=FALSE,c=3u,d=-2i,e=1e3",
+			want: []string{`m_a{} 1@1700000000000`, `m_b{} 0@1700000000000`, `m_c{} 3@1700000000000`, `m_d{} -2@1700000000000`, `m_e{} 1000@1700000000000`},
+		},
+		{
+			name: "escapes",
+			line: `my\ measurement,tag\,key=val\=ue,sp=a\ b field\=x=1`,
+			want: []string{`my_measurement_field_x{sp="a b",tag_key="val=ue"} 1@1700000000000`},
+		},
+		{
+			name:    "string fields",
+			line:    `m,host=a msg="hello, world = x",quote="a\"b c",v=2 1700000001000000000`,
+			want:    []string{`m_v{host="a"} 2@1700000001000`},
+			skipped: 2,
+		},
+		{
+			name: "empty tags and __name__ tag",
+			line: `m,empty=,__name__=x v=1`,
+			want: []string{`m_v{} 1@1700000000000`},
+		},
+		{name: "missing fields", line: "cpu", err: "missing fields"},
+		{name: "missing measurement", line: ",host=a v=1", err: "missing measurement"},
+		{name: "invalid tag", line: "m,host v=1", err: "invalid tag"},
+		{name: "invalid field", line: "m v=", err: "invalid field"},
+		{name: "invalid value", line: "m v=abc", err: "invalid value"},
+		{name: "invalid timestamp", line: "m v=1 abc", err: "invalid timestamp"},
+		{name: "tags of the same label", line: "m,host.name=a,host_name=b v=1", err: `tags "host.name" and "host_name" are both label "host_name"`},
+		{name: "duplicated tag", line: "m,host=a,host=b v=1", err: `tags "host" and "host" are both label "host"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series, skipped, err := parseLine(tt.line, time.Nanosecond, now)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := format(series); !reflect.DeepEqual(got, tt.want) || skipped != tt.skipped {
+				t.Errorf("series = %q, skipped %d, want %q, skipped %d", got, skipped, tt.want, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	data := "# comment\n\ncpu value=1\nbad\n  mem,host=a used=2  \n"
+	r := Parse([]byte(data), time.Nanosecond, now)
+	if want := []string{`cpu{} 1@1700000000000`, `mem_used{host="a"} 2@1700000000000`}; !reflect.DeepEqual(format(r.Series), want) {
+		t.Errorf("series = %q, want %q", format(r.Series), want)
+	}
+	// valid lines are kept, errors are numbered by lines of data
+	if r.Lines != 3 || len(r.Errors) != 1 || !strings.HasPrefix(r.Errors[0].Error(), "line 4:") {
+		t.Errorf("lines = %d, errors = %v, want 3 lines and an error of line 4", r.Lines, r.Errors)
+	}
+}
@@ -14,21 +14,26 @@ var Logger *log
 
 type log struct {
 	logger *zap.Logger
+	level  zap.AtomicLevel
 	pool   *sync.Pool
 }
 
 func NewLogger(l string) *log {
-	var logger *zap.Logger
+	var zapConfig zap.Config
 	var level = getLevel(l)
 	if level == zap.DebugLevel {
-		logger, _ = zap.NewDevelopment()
+		zapConfig = zap.NewDevelopmentConfig()
 	} else {
-		logger, _ = zap.NewProduction()
+		zapConfig = zap.NewProductionConfig()
 	}
+	// atomic level makes it possible to change log level at runtime
+	zapConfig.Level = zap.NewAtomicLevelAt(level)
+	logger, _ := zapConfig.Build()
 	defer logger.Sync()
 
 	return &log{
 		logger: logger,
+		level:  zapConfig.Level,
 		pool: &sync.Pool{
 			New: func() any {
 				return new(bytes.Buffer)
@@ -43,6 +48,8 @@ func getLevel(level string) zapcore.Level {
 		return zap.InfoLevel
 	case "debug":
 		return zap.DebugLevel
+	case "warn":
+		return zap.WarnLevel
 	case "error":
 		return zap.ErrorLevel
 	default:
@@ -50,6 +57,36 @@ func getLevel(level string) zapcore.Level {
 	}
 }
 
+// ValidLevel reports whether level is a log level known by adapter
+func ValidLevel(level string) bool {
+	switch level {
+	case "info", "debug", "warn", "error":
+		return true
+	default:
+		return false
+	}
+}
+
+// SetLevel changes log level of a running logger
+func (l *log) SetLevel(level string) error {
+	if l == nil {
+		return nil
+	}
+	if !ValidLevel(level) {
+		return fmt.Errorf("unknown log level: %s", level)
+	}
+	l.level.SetLevel(getLevel(level))
+	return nil
+}
+
+// Level returns current log level
+func (l *log) Level() string {
+	if l == nil {
+		return ""
+	}
+	return l.level.String()
+}
+
 func (l *log) getLog(keyvals ...any) string {
 	var log string
 	if len(keyvals)%2 != 0 {
@@ -91,6 +128,13 @@ func (l *log) Debug(keyvals ...any) {
 	l.logger.Debug("", l.getzapfield(keyvals...)...)
 }
 
+func (l *log) Warn(keyvals ...any) {
+	if l == nil {
+		return
+	}
+	l.logger.Warn("", l.getzapfield(keyvals...)...)
+}
+
 func (l *log) Error(keyvals ...any) {
 	if l == nil {
 		return
@@ -0,0 +1,116 @@
+// Package metadata caches metric metadata by metric family
+package metadata
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+type entry struct {
+	md      prompb.MetricMetadata
+	updated time.Time
+}
+
+// Cache merges metadata updates of metric families, families not updated in ttl are expired
+type Cache struct {
+	ttl time.Duration
+	max int
+
+	mu        sync.RWMutex
+	families  map[string]*entry
+	lastPrune time.Time
+}
+
+func NewCache(conf *config.MetadataConfig) *Cache {
+	return &Cache{
+		ttl:       conf.TTL,
+		max:       conf.MaxFamilies,
+		families:  map[string]*entry{},
+		lastPrune: time.Now(),
+	}
+}
+
+// Update merges metadata into cache, known type, help and unit override cached ones.
+// It returns the number of families not cached since the cache is full
+func (c *Cache) Update(mds []prompb.MetricMetadata, now time.Time) int {
+	if len(mds) == 0 {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastPrune) > c.ttl {
+		for name, e := range c.families {
+			if now.Sub(e.updated) > c.ttl {
+				delete(c.families, name)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	dropped := 0
+	for _, md := range mds {
+		if md.MetricFamilyName == "" {
+			continue
+		}
+		e, ok := c.families[md.MetricFamilyName]
+		if !ok {
+			if c.max > 0 && len(c.families) >= c.max {
+				dropped++
+				continue
+			}
+			e = &entry{md: prompb.MetricMetadata{MetricFamilyName: md.MetricFamilyName}}
+			c.families[md.MetricFamilyName] = e
+		}
+		if md.Type != prompb.MetricTypeUnknown {
+			e.md.Type = md.Type
+		}
+		if md.Help != "" {
+			e.md.Help = md.Help
+		}
+		if md.Unit != "" {
+			e.md.Unit = md.Unit
+		}
+		e.updated = now
+	}
+	return dropped
+}
+
+// Lookup returns metadata of the metric family
+func (c *Cache) Lookup(family string, now time.Time) (prompb.MetricMetadata, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.families[family]
+	if !ok || now.Sub(e.updated) > c.ttl {
+		return prompb.MetricMetadata{}, false
+	}
+	return e.md, true
+}
+
+// List returns metadata sorted by family name, metric filters families by name if it's not empty,
+// at most limit families are returned if limit is positive
+func (c *Cache) List(metric string, limit int, now time.Time) []prompb.MetricMetadata {
+	c.mu.RLock()
+	var mds []prompb.MetricMetadata
+	for name, e := range c.families {
+		if (metric == "" || name == metric) && now.Sub(e.updated) <= c.ttl {
+			mds = append(mds, e.md)
+		}
+	}
+	c.mu.RUnlock()
+	sort.Slice(mds, func(i, j int) bool { return mds[i].MetricFamilyName < mds[j].MetricFamilyName })
+	if limit > 0 && len(mds) > limit {
+		mds = mds[:limit]
+	}
+	return mds
+}
+
+// Len returns the number of cached families, including expired ones which are not pruned yet
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.families)
+}
@@ -0,0 +1,435 @@
+package otlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// UnmarshalProto decodes ExportMetricsServiceRequest in protobuf, unknown fields are skipped
+func UnmarshalProto(b []byte) (*MetricsRequest, error) {
+	req := &MetricsRequest{}
+	err := walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var rm ResourceMetrics
+		err := rm.unmarshal(v)
+		req.ResourceMetrics = append(req.ResourceMetrics, rm)
+		return err
+	})
+	return req, err
+}
+
+// UnmarshalJSON decodes ExportMetricsServiceRequest in OTLP JSON encoding
+func UnmarshalJSON(b []byte) (*MetricsRequest, error) {
+	req := &MetricsRequest{}
+	if err := json.Unmarshal(b, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (m *ResourceMetrics) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if num != 1 {
+					return nil
+				}
+				return appendKeyValue(&m.Resource.Attributes, v)
+			})
+		case 2:
+			var sm ScopeMetrics
+			err := sm.unmarshal(v)
+			m.ScopeMetrics = append(m.ScopeMetrics, sm)
+			return err
+		}
+		return nil
+	})
+}
+
+func (m *ScopeMetrics) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch num {
+				case 1:
+					m.Scope.Name = string(v)
+				case 2:
+					m.Scope.Version = string(v)
+				}
+				return nil
+			})
+		case 2:
+			var metric Metric
+			err := metric.unmarshal(v)
+			m.Metrics = append(m.Metrics, metric)
+			return err
+		}
+		return nil
+	})
+}
+
+func (m *Metric) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			m.Name = string(v)
+		case 2:
+			m.Description = string(v)
+		case 3:
+			m.Unit = string(v)
+		case 5:
+			m.Gauge = &Gauge{}
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if num == 1 {
+					return appendNumberDataPoint(&m.Gauge.DataPoints, v)
+				}
+				return nil
+			})
+		case 7:
+			m.Sum = &Sum{}
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch num {
+				case 1:
+					return appendNumberDataPoint(&m.Sum.DataPoints, v)
+				case 2:
+					m.Sum.AggregationTemporality = AggregationTemporality(varint(v))
+				case 3:
+					m.Sum.IsMonotonic = varint(v) != 0
+				}
+				return nil
+			})
+		case 9:
+			m.Histogram = &Histogram{}
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch num {
+				case 1:
+					var p HistogramDataPoint
+					err := p.unmarshal(v)
+					m.Histogram.DataPoints = append(m.Histogram.DataPoints, p)
+					return err
+				case 2:
+					m.Histogram.AggregationTemporality = AggregationTemporality(varint(v))
+				}
+				return nil
+			})
+		case 10:
+			m.ExponentialHistogram = &ExponentialHistogram{}
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch num {
+				case 1:
+					var p ExponentialHistogramDataPoint
+					err := p.unmarshal(v)
+					m.ExponentialHistogram.DataPoints = append(m.ExponentialHistogram.DataPoints, p)
+					return err
+				case 2:
+					m.ExponentialHistogram.AggregationTemporality = AggregationTemporality(varint(v))
+				}
+				return nil
+			})
+		case 11:
+			m.Summary = &Summary{}
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if num != 1 {
+					return nil
+				}
+				var p SummaryDataPoint
+				err := p.unmarshal(v)
+				m.Summary.DataPoints = append(m.Summary.DataPoints, p)
+				return err
+			})
+		}
+		return nil
+	})
+}
+
+func appendNumberDataPoint(points *[]NumberDataPoint, b []byte) error {
+	var p NumberDataPoint
+	err := walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 7:
+			return appendKeyValue(&p.Attributes, v)
+		case 2:
+			p.StartTimeUnixNano = Uint64(fixed64(v))
+		case 3:
+			p.TimeUnixNano = Uint64(fixed64(v))
+		case 4:
+			x := Float64(math.Float64frombits(fixed64(v)))
+			p.AsDouble = &x
+		case 6:
+			x := Int64(fixed64(v))
+			p.AsInt = &x
+		case 5:
+			return appendExemplar(&p.Exemplars, v)
+		case 8:
+			p.Flags = uint32(varint(v))
+		}
+		return nil
+	})
+	*points = append(*points, p)
+	return err
+}
+
+func (p *HistogramDataPoint) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 9:
+			return appendKeyValue(&p.Attributes, v)
+		case 2:
+			p.StartTimeUnixNano = Uint64(fixed64(v))
+		case 3:
+			p.TimeUnixNano = Uint64(fixed64(v))
+		case 4:
+			p.Count = Uint64(fixed64(v))
+		case 5:
+			x := Float64(math.Float64frombits(fixed64(v)))
+			p.Sum = &x
+		case 6:
+			return unpackFixed64(typ, v, func(x uint64) { p.BucketCounts = append(p.BucketCounts, Uint64(x)) })
+		case 7:
+			return unpackFixed64(typ, v, func(x uint64) { p.ExplicitBounds = append(p.ExplicitBounds, Float64(math.Float64frombits(x))) })
+		case 8:
+			return appendExemplar(&p.Exemplars, v)
+		case 10:
+			p.Flags = uint32(varint(v))
+		}
+		return nil
+	})
+}
+
+func (p *ExponentialHistogramDataPoint) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			return appendKeyValue(&p.Attributes, v)
+		case 2:
+			p.StartTimeUnixNano = Uint64(fixed64(v))
+		case 3:
+			p.TimeUnixNano = Uint64(fixed64(v))
+		case 4:
+			p.Count = Uint64(fixed64(v))
+		case 5:
+			x := Float64(math.Float64frombits(fixed64(v)))
+			p.Sum = &x
+		case 6:
+			p.Scale = int32(protowire.DecodeZigZag(varint(v)))
+		case 7:
+			p.ZeroCount = Uint64(fixed64(v))
+		case 8:
+			return p.Positive.unmarshal(v)
+		case 9:
+			return p.Negative.unmarshal(v)
+		case 10:
+			p.Flags = uint32(varint(v))
+		case 11:
+			return appendExemplar(&p.Exemplars, v)
+		case 14:
+			p.ZeroThreshold = Float64(math.Float64frombits(fixed64(v)))
+		}
+		return nil
+	})
+}
+
+func (m *Buckets) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			m.Offset = int32(protowire.DecodeZigZag(varint(v)))
+		case 2:
+			return unpackVarint(typ, v, func(x uint64) { m.BucketCounts = append(m.BucketCounts, Uint64(x)) })
+		}
+		return nil
+	})
+}
+
+func (p *SummaryDataPoint) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 7:
+			return appendKeyValue(&p.Attributes, v)
+		case 2:
+			p.StartTimeUnixNano = Uint64(fixed64(v))
+		case 3:
+			p.TimeUnixNano = Uint64(fixed64(v))
+		case 4:
+			p.Count = Uint64(fixed64(v))
+		case 5:
+			p.Sum = Float64(math.Float64frombits(fixed64(v)))
+		case 6:
+			var q ValueAtQuantile
+			err := walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch num {
+				case 1:
+					q.Quantile = Float64(math.Float64frombits(fixed64(v)))
+				case 2:
+					q.Value = Float64(math.Float64frombits(fixed64(v)))
+				}
+				return nil
+			})
+			p.QuantileValues = append(p.QuantileValues, q)
+			return err
+		case 8:
+			p.Flags = uint32(varint(v))
+		}
+		return nil
+	})
+}
+
+func appendExemplar(exemplars *[]Exemplar, b []byte) error {
+	var e Exemplar
+	err := walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 7:
+			return appendKeyValue(&e.FilteredAttributes, v)
+		case 2:
+			e.TimeUnixNano = Uint64(fixed64(v))
+		case 3:
+			x := Float64(math.Float64frombits(fixed64(v)))
+			e.AsDouble = &x
+		case 6:
+			x := Int64(fixed64(v))
+			e.AsInt = &x
+		case 4:
+			e.SpanID = append(HexBytes(nil), v...)
+		case 5:
+			e.TraceID = append(HexBytes(nil), v...)
+		}
+		return nil
+	})
+	*exemplars = append(*exemplars, e)
+	return err
+}
+
+func appendKeyValue(kvs *[]KeyValue, b []byte) error {
+	var kv KeyValue
+	err := walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			kv.Key = string(v)
+		case 2:
+			return kv.Value.unmarshal(v)
+		}
+		return nil
+	})
+	*kvs = append(*kvs, kv)
+	return err
+}
+
+func (m *AnyValue) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			s := string(v)
+			m.StringValue = &s
+		case 2:
+			x := varint(v) != 0
+			m.BoolValue = &x
+		case 3:
+			x := Int64(varint(v))
+			m.IntValue = &x
+		case 4:
+			x := Float64(math.Float64frombits(fixed64(v)))
+			m.DoubleValue = &x
+		case 5:
+			m.ArrayValue = &ArrayValue{}
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if num != 1 {
+					return nil
+				}
+				var value AnyValue
+				err := value.unmarshal(v)
+				m.ArrayValue.Values = append(m.ArrayValue.Values, value)
+				return err
+			})
+		case 6:
+			m.KvlistValue = &KvlistValue{}
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if num != 1 {
+					return nil
+				}
+				return appendKeyValue(&m.KvlistValue.Values, v)
+			})
+		case 7:
+			m.BytesValue = append([]byte{}, v...)
+		}
+		return nil
+	})
+}
+
+// walk calls fn with every field, v is the raw value: varint and fixed values are re-encoded
+// as they are on the wire, length delimited values are the payload without length prefix
+func walk(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n >= 0 {
+				v = b[:n]
+			}
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func varint(v []byte) uint64 {
+	x, _ := protowire.ConsumeVarint(v)
+	return x
+}
+
+func fixed64(v []byte) uint64 {
+	x, _ := protowire.ConsumeFixed64(v)
+	return x
+}
+
+func unpackFixed64(typ protowire.Type, v []byte, fn func(uint64)) error {
+	if typ != protowire.BytesType {
+		fn(fixed64(v))
+		return nil
+	}
+	for len(v) > 0 {
+		x, n := protowire.ConsumeFixed64(v)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		fn(x)
+		v = v[n:]
+	}
+	return nil
+}
+
+func unpackVarint(typ protowire.Type, v []byte, fn func(uint64)) error {
+	if typ != protowire.BytesType {
+		fn(varint(v))
+		return nil
+	}
+	for len(v) > 0 {
+		x, n := protowire.ConsumeVarint(v)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		fn(x)
+		v = v[n:]
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package otlp
+
+import (
+	"strings"
+	"unicode"
+)
+
+// units are OTel units(UCUM) translated to prometheus unit suffixes
+var units = map[string]string{
+	// time
+	"d":   "days",
+	"h":   "hours",
+	"min": "minutes",
+	"s":   "seconds",
+	"ms":  "milliseconds",
+	"us":  "microseconds",
+	"ns":  "nanoseconds",
+	// bytes
+	"By":   "bytes",
+	"KiBy": "kibibytes",
+	"MiBy": "mebibytes",
+	"GiBy": "gibibytes",
+	"TiBy": "tibibytes",
+	"KBy":  "kilobytes",
+	"MBy":  "megabytes",
+	"GBy":  "gigabytes",
+	"TBy":  "terabytes",
+	// SI
+	"m":   "meters",
+	"V":   "volts",
+	"A":   "amperes",
+	"J":   "joules",
+	"W":   "watts",
+	"g":   "grams",
+	"Cel": "celsius",
+	"Hz":  "hertz",
+	"%":   "percent",
+	"1":   "",
+}
+
+// perUnits are denominators of units like By/s
+var perUnits = map[string]string{
+	"s":  "second",
+	"m":  "minute",
+	"h":  "hour",
+	"d":  "day",
+	"w":  "week",
+	"mo": "month",
+	"y":  "year",
+}
+
+// MetricName returns prometheus metric name of OTel metric, unit and type suffixes are added if suffixes is set:
+// unit is appended unless the name contains it, counters end with _total and gauges of unit 1 with _ratio
+func MetricName(name, unit string, counter, gauge bool, suffixes bool) string {
+	if !suffixes {
+		return sanitizeName(name)
+	}
+
+	tokens := strings.FieldsFunc(name, func(r rune) bool { return !isAlnum(r) })
+	mainUnit, perUnit := unitTokens(unit)
+	if mainUnit != "" && !contains(tokens, mainUnit) {
+		tokens = append(tokens, mainUnit)
+	}
+	if perUnit != "" && !contains(tokens, perUnit) {
+		tokens = append(tokens, "per", perUnit)
+	}
+	if counter {
+		tokens = remove(tokens, "total")
+		tokens = append(tokens, "total")
+	}
+	if gauge && unit == "1" && !contains(tokens, "ratio") {
+		tokens = append(tokens, "ratio")
+	}
+
+	normalized := strings.Join(tokens, "_")
+	if normalized != "" && unicode.IsDigit(rune(normalized[0])) {
+		normalized = "_" + normalized
+	}
+	return normalized
+}
+
+// Unit returns prometheus unit of OTel unit, it's used in metadata
+func Unit(unit string) string {
+	mainUnit, perUnit := unitTokens(unit)
+	if perUnit == "" {
+		return mainUnit
+	}
+	if mainUnit == "" {
+		return "per_" + perUnit
+	}
+	return mainUnit + "_per_" + perUnit
+}
+
+// unitTokens translates unit, annotations in braces like {requests} are dropped
+func unitTokens(unit string) (string, string) {
+	for {
+		start := strings.IndexByte(unit, '{')
+		end := strings.IndexByte(unit, '}')
+		if start < 0 || end < start {
+			break
+		}
+		unit = unit[:start] + unit[end+1:]
+	}
+	mainUnit, perUnit, _ := strings.Cut(strings.TrimSpace(unit), "/")
+	if u, ok := units[mainUnit]; ok {
+		mainUnit = u
+	}
+	if u, ok := perUnits[perUnit]; ok {
+		perUnit = u
+	}
+	return sanitizeUnit(mainUnit), sanitizeUnit(perUnit)
+}
+
+func sanitizeUnit(unit string) string {
+	return strings.Trim(sanitizeName(unit), "_")
+}
+
+// sanitizeName replaces characters not allowed in metric names with _ and collapses consecutive _
+func sanitizeName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if !isAlnum(r) && r != ':' {
+			r = '_'
+		}
+		if r == '_' && strings.HasSuffix(b.String(), "_") {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if s != "" && unicode.IsDigit(rune(s[0])) {
+		s = "_" + s
+	}
+	return s
+}
+
+// LabelName returns prometheus label name of OTel attribute key, invalid characters are replaced with _,
+// a leading digit or single _ is prefixed by key
+func LabelName(key string) string {
+	if key == "" {
+		return key
+	}
+	var b strings.Builder
+	for _, r := range key {
+		if !isAlnum(r) {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	label := b.String()
+	if unicode.IsDigit(rune(label[0])) {
+		label = "key_" + label
+	} else if strings.HasPrefix(label, "_") && !strings.HasPrefix(label, "__") {
+		label = "key" + label
+	}
+	return label
+}
+
+func isAlnum(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+func contains(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(tokens []string, token string) []string {
+	out := tokens[:0]
+	for _, t := range tokens {
+		if t != token {
+			out = append(out, t)
+		}
+	}
+	return out
+}
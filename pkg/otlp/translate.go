@@ -0,0 +1,463 @@
+package otlp
+
+import (
+	"encoding/hex"
+	"math"
+	"sort"
+	"strconv"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// reasons of dropped data points, they're used as metric label values
+const (
+	ReasonDeltaTemporality = "delta_temporality"
+	ReasonScale            = "scale"
+	ReasonEmptyName        = "empty_name"
+)
+
+// target_info holds resource attributes which are not promoted to job and instance
+const targetInfo = "target_info"
+
+const (
+	attrServiceName       = "service.name"
+	attrServiceNamespace  = "service.namespace"
+	attrServiceInstanceID = "service.instance.id"
+
+	defaultZeroThreshold = 1e-128
+	// maxSchema is the largest native histogram schema, finer exponential histograms are downscaled to it
+	maxSchema = 8
+	minSchema = -4
+)
+
+// staleNaN is the prometheus stale marker, data points flagged without recorded value are translated to it
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+// Result is the translated request and data points dropped by reason
+type Result struct {
+	Request    *prompb.WriteRequest
+	DataPoints int
+	Dropped    map[string]int
+}
+
+// Translate translates OTLP metrics into prometheus series and metadata
+func Translate(req *MetricsRequest, conf *config.OTLPConfig) *Result {
+	t := &translator{
+		conf:     conf,
+		result:   &Result{Request: &prompb.WriteRequest{}, Dropped: map[string]int{}},
+		families: map[string]bool{},
+	}
+	for i := range req.ResourceMetrics {
+		t.resourceMetrics(&req.ResourceMetrics[i])
+	}
+	return t.result
+}
+
+type translator struct {
+	conf     *config.OTLPConfig
+	result   *Result
+	families map[string]bool
+
+	// resource labels of the current resource: job, instance and promoted attributes
+	resource []prompb.Label
+	// latest timestamp of the current resource, it's the timestamp of target_info
+	latest int64
+}
+
+func (t *translator) resourceMetrics(rm *ResourceMetrics) {
+	t.resource, t.latest = t.resourceLabels(&rm.Resource), 0
+	for i := range rm.ScopeMetrics {
+		for j := range rm.ScopeMetrics[i].Metrics {
+			t.metric(&rm.ScopeMetrics[i].Metrics[j])
+		}
+	}
+	t.targetInfo(&rm.Resource)
+}
+
+// resourceLabels returns job, instance and promoted resource attributes
+func (t *translator) resourceLabels(r *Resource) []prompb.Label {
+	var labels []prompb.Label
+	attrs := attributeMap(r.Attributes)
+	if name, ok := attrs[attrServiceName]; ok {
+		job := name
+		if ns, ok := attrs[attrServiceNamespace]; ok {
+			job = ns + "/" + name
+		}
+		labels = append(labels, prompb.Label{Name: "job", Value: job})
+	}
+	if id, ok := attrs[attrServiceInstanceID]; ok {
+		labels = append(labels, prompb.Label{Name: "instance", Value: id})
+	}
+	for _, attr := range t.conf.PromoteResourceAttributes {
+		if v, ok := attrs[attr]; ok {
+			labels = append(labels, prompb.Label{Name: LabelName(attr), Value: v})
+		}
+	}
+	return labels
+}
+
+// targetInfo adds target_info of resource attributes which are not translated to job and instance
+func (t *translator) targetInfo(r *Resource) {
+	var attrs []KeyValue
+	for _, kv := range r.Attributes {
+		switch kv.Key {
+		case attrServiceName, attrServiceNamespace, attrServiceInstanceID:
+			continue
+		}
+		attrs = append(attrs, kv)
+	}
+	if len(attrs) == 0 || t.latest == 0 {
+		return
+	}
+	var identifying []prompb.Label
+	for _, l := range t.resource {
+		if l.Name == "job" || l.Name == "instance" {
+			identifying = append(identifying, l)
+		}
+	}
+	labels := t.labels(attrs, targetInfo, identifying)
+	t.addFamily(targetInfo, prompb.MetricTypeGauge, "Target metadata", "")
+	t.result.Request.Timeseries = append(t.result.Request.Timeseries, prompb.TimeSeries{
+		Labels:  labels,
+		Samples: []prompb.Sample{{Value: 1, Timestamp: t.latest}},
+	})
+}
+
+func (t *translator) metric(m *Metric) {
+	suffixes := t.conf.AddMetricSuffixes
+	switch {
+	case m.Gauge != nil:
+		name := MetricName(m.Name, m.Unit, false, true, suffixes)
+		if t.checkName(name, len(m.Gauge.DataPoints)) {
+			t.addFamily(name, prompb.MetricTypeGauge, m.Description, m.Unit)
+			t.numberDataPoints(name, m.Gauge.DataPoints, false)
+		}
+	case m.Sum != nil:
+		if m.Sum.AggregationTemporality != TemporalityCumulative {
+			t.drop(ReasonDeltaTemporality, len(m.Sum.DataPoints))
+			return
+		}
+		// non-monotonic sums are gauges
+		counter := m.Sum.IsMonotonic
+		name := MetricName(m.Name, m.Unit, counter, !counter, suffixes)
+		if !t.checkName(name, len(m.Sum.DataPoints)) {
+			return
+		}
+		if counter {
+			t.addFamily(name, prompb.MetricTypeCounter, m.Description, m.Unit)
+		} else {
+			t.addFamily(name, prompb.MetricTypeGauge, m.Description, m.Unit)
+		}
+		t.numberDataPoints(name, m.Sum.DataPoints, counter)
+	case m.Histogram != nil:
+		if m.Histogram.AggregationTemporality != TemporalityCumulative {
+			t.drop(ReasonDeltaTemporality, len(m.Histogram.DataPoints))
+			return
+		}
+		name := MetricName(m.Name, m.Unit, false, false, suffixes)
+		if t.checkName(name, len(m.Histogram.DataPoints)) {
+			t.addFamily(name, prompb.MetricTypeHistogram, m.Description, m.Unit)
+			for i := range m.Histogram.DataPoints {
+				t.histogram(name, &m.Histogram.DataPoints[i])
+			}
+		}
+	case m.ExponentialHistogram != nil:
+		if m.ExponentialHistogram.AggregationTemporality != TemporalityCumulative {
+			t.drop(ReasonDeltaTemporality, len(m.ExponentialHistogram.DataPoints))
+			return
+		}
+		name := MetricName(m.Name, m.Unit, false, false, suffixes)
+		if t.checkName(name, len(m.ExponentialHistogram.DataPoints)) {
+			t.addFamily(name, prompb.MetricTypeHistogram, m.Description, m.Unit)
+			for i := range m.ExponentialHistogram.DataPoints {
+				t.exponentialHistogram(name, &m.ExponentialHistogram.DataPoints[i])
+			}
+		}
+	case m.Summary != nil:
+		name := MetricName(m.Name, m.Unit, false, false, suffixes)
+		if t.checkName(name, len(m.Summary.DataPoints)) {
+			t.addFamily(name, prompb.MetricTypeSummary, m.Description, m.Unit)
+			for i := range m.Summary.DataPoints {
+				t.summary(name, &m.Summary.DataPoints[i])
+			}
+		}
+	}
+}
+
+func (t *translator) checkName(name string, points int) bool {
+	t.result.DataPoints += points
+	if name == "" {
+		t.drop(ReasonEmptyName, points)
+		return false
+	}
+	return true
+}
+
+func (t *translator) drop(reason string, points int) {
+	if points > 0 {
+		t.result.Dropped[reason] += points
+	}
+}
+
+func (t *translator) addFamily(name string, typ prompb.MetricType, help, unit string) {
+	if t.families[name] {
+		return
+	}
+	t.families[name] = true
+	t.result.Request.Metadata = append(t.result.Request.Metadata, prompb.MetricMetadata{
+		Type:             typ,
+		MetricFamilyName: name,
+		Help:             help,
+		Unit:             Unit(unit),
+	})
+}
+
+func (t *translator) numberDataPoints(name string, points []NumberDataPoint, counter bool) {
+	for i := range points {
+		p := &points[i]
+		ts := t.timestamp(p.TimeUnixNano)
+		value := p.Value()
+		if p.Flags&FlagNoRecordedValue != 0 {
+			value = staleNaN
+		}
+		series := prompb.TimeSeries{
+			Labels:    t.labels(p.Attributes, name, nil),
+			Samples:   []prompb.Sample{{Value: value, Timestamp: ts}},
+			Exemplars: exemplars(p.Exemplars),
+		}
+		if counter {
+			series.CreatedTimestamp = nanosToMillis(p.StartTimeUnixNano)
+		}
+		t.result.Request.Timeseries = append(t.result.Request.Timeseries, series)
+	}
+}
+
+// histogram translates explicit bucket histogram into classic _bucket, _count and _sum series
+func (t *translator) histogram(name string, p *HistogramDataPoint) {
+	ts := t.timestamp(p.TimeUnixNano)
+	created := nanosToMillis(p.StartTimeUnixNano)
+	stale := p.Flags&FlagNoRecordedValue != 0
+	value := func(v float64) float64 {
+		if stale {
+			return staleNaN
+		}
+		return v
+	}
+	add := func(suffix string, v float64, extra []prompb.Label, exemplars []prompb.Exemplar) {
+		t.result.Request.Timeseries = append(t.result.Request.Timeseries, prompb.TimeSeries{
+			Labels:           t.labels(p.Attributes, name+suffix, extra),
+			Samples:          []prompb.Sample{{Value: value(v), Timestamp: ts}},
+			Exemplars:        exemplars,
+			CreatedTimestamp: created,
+		})
+	}
+
+	if p.Sum != nil {
+		add("_sum", float64(*p.Sum), nil, nil)
+	}
+	add("_count", float64(p.Count), nil, nil)
+
+	all := exemplars(p.Exemplars)
+	// bucket counts are ignored if they don't match bounds
+	if len(p.BucketCounts) == len(p.ExplicitBounds)+1 {
+		var cumulative uint64
+		for i, bound := range p.ExplicitBounds {
+			cumulative += uint64(p.BucketCounts[i])
+			var bucketExemplars []prompb.Exemplar
+			bucketExemplars, all = takeExemplars(all, float64(bound))
+			add("_bucket", float64(cumulative), []prompb.Label{{Name: "le", Value: formatFloat(float64(bound))}}, bucketExemplars)
+		}
+	}
+	add("_bucket", float64(p.Count), []prompb.Label{{Name: "le", Value: "+Inf"}}, all)
+}
+
+// takeExemplars splits exemplars not greater than the bound
+func takeExemplars(exemplars []prompb.Exemplar, bound float64) (taken, rest []prompb.Exemplar) {
+	for _, e := range exemplars {
+		if e.Value <= bound {
+			taken = append(taken, e)
+		} else {
+			rest = append(rest, e)
+		}
+	}
+	return taken, rest
+}
+
+// exponentialHistogram translates exponential histogram into native histogram, finer scales than
+// the max schema are downscaled by merging buckets
+func (t *translator) exponentialHistogram(name string, p *ExponentialHistogramDataPoint) {
+	scale := p.Scale
+	if scale < minSchema {
+		t.drop(ReasonScale, 1)
+		return
+	}
+	var shift int32
+	if scale > maxSchema {
+		shift, scale = scale-maxSchema, maxSchema
+	}
+
+	h := prompb.Histogram{
+		CountInt:      uint64(p.Count),
+		Schema:        scale,
+		ZeroThreshold: float64(p.ZeroThreshold),
+		ZeroCountInt:  uint64(p.ZeroCount),
+		Timestamp:     t.timestamp(p.TimeUnixNano),
+	}
+	if h.ZeroThreshold == 0 {
+		h.ZeroThreshold = defaultZeroThreshold
+	}
+	if p.Sum != nil {
+		h.Sum = float64(*p.Sum)
+	}
+	if p.Flags&FlagNoRecordedValue != 0 {
+		h = prompb.Histogram{Sum: staleNaN, Timestamp: h.Timestamp, Schema: h.Schema, ZeroThreshold: h.ZeroThreshold}
+	} else {
+		h.PositiveSpans, h.PositiveDeltas = nativeBuckets(&p.Positive, shift)
+		h.NegativeSpans, h.NegativeDeltas = nativeBuckets(&p.Negative, shift)
+	}
+
+	t.result.Request.Timeseries = append(t.result.Request.Timeseries, prompb.TimeSeries{
+		Labels:           t.labels(p.Attributes, name, nil),
+		Histograms:       []prompb.Histogram{h},
+		Exemplars:        exemplars(p.Exemplars),
+		CreatedTimestamp: nanosToMillis(p.StartTimeUnixNano),
+	})
+}
+
+// nativeBuckets converts OTel buckets into a span and delta encoded counts. OTel bucket i is
+// (base^i, base^(i+1)] while native histogram bucket i is (base^(i-1), base^i], so indexes are shifted by one
+func nativeBuckets(b *Buckets, shift int32) ([]prompb.BucketSpan, []int64) {
+	if len(b.BucketCounts) == 0 {
+		return nil, nil
+	}
+	// merge 2^shift buckets into one, the index of merged bucket is floor(i / 2^shift)
+	first := b.Offset >> shift
+	var counts []uint64
+	for i, c := range b.BucketCounts {
+		idx := int(((b.Offset + int32(i)) >> shift) - first)
+		for len(counts) <= idx {
+			counts = append(counts, 0)
+		}
+		counts[idx] += uint64(c)
+	}
+
+	deltas := make([]int64, len(counts))
+	var prev int64
+	for i, c := range counts {
+		deltas[i] = int64(c) - prev
+		prev = int64(c)
+	}
+	return []prompb.BucketSpan{{Offset: first + 1, Length: uint32(len(counts))}}, deltas
+}
+
+// summary translates summary into quantile, _count and _sum series
+func (t *translator) summary(name string, p *SummaryDataPoint) {
+	ts := t.timestamp(p.TimeUnixNano)
+	created := nanosToMillis(p.StartTimeUnixNano)
+	stale := p.Flags&FlagNoRecordedValue != 0
+	add := func(suffix string, v float64, extra []prompb.Label) {
+		if stale {
+			v = staleNaN
+		}
+		t.result.Request.Timeseries = append(t.result.Request.Timeseries, prompb.TimeSeries{
+			Labels:           t.labels(p.Attributes, name+suffix, extra),
+			Samples:          []prompb.Sample{{Value: v, Timestamp: ts}},
+			CreatedTimestamp: created,
+		})
+	}
+	add("_sum", float64(p.Sum), nil)
+	add("_count", float64(p.Count), nil)
+	for _, q := range p.QuantileValues {
+		add("", float64(q.Value), []prompb.Label{{Name: "quantile", Value: formatFloat(float64(q.Quantile))}})
+	}
+}
+
+func (t *translator) timestamp(nanos Uint64) int64 {
+	ts := nanosToMillis(nanos)
+	if ts > t.latest {
+		t.latest = ts
+	}
+	return ts
+}
+
+// labels returns sorted labels of attributes, resource labels, name and extra labels, latter ones override
+// former ones, attributes normalized to the same name are joined with ;
+func (t *translator) labels(attrs []KeyValue, name string, extra []prompb.Label) []prompb.Label {
+	sorted := make([]KeyValue, len(attrs))
+	copy(sorted, attrs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	m := make(map[string]string, len(attrs)+len(t.resource)+len(extra)+1)
+	for i := range sorted {
+		v := sorted[i].Value.String()
+		if v == "" {
+			continue
+		}
+		ln := LabelName(sorted[i].Key)
+		if existing, ok := m[ln]; ok {
+			v = existing + ";" + v
+		}
+		m[ln] = v
+	}
+	for _, l := range t.resource {
+		// promoted attributes don't override data point attributes, job and instance do
+		if _, ok := m[l.Name]; ok && l.Name != "job" && l.Name != "instance" {
+			continue
+		}
+		m[l.Name] = l.Value
+	}
+	for _, l := range extra {
+		m[l.Name] = l.Value
+	}
+	m[prompb.MetricNameLabel] = name
+
+	labels := make([]prompb.Label, 0, len(m))
+	for k, v := range m {
+		labels = append(labels, prompb.Label{Name: k, Value: v})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
+
+func exemplars(es []Exemplar) []prompb.Exemplar {
+	if len(es) == 0 {
+		return nil
+	}
+	out := make([]prompb.Exemplar, 0, len(es))
+	for i := range es {
+		e := &es[i]
+		var labels []prompb.Label
+		for _, kv := range e.FilteredAttributes {
+			labels = append(labels, prompb.Label{Name: LabelName(kv.Key), Value: kv.Value.String()})
+		}
+		if len(e.TraceID) > 0 {
+			labels = append(labels, prompb.Label{Name: "trace_id", Value: hex.EncodeToString(e.TraceID)})
+		}
+		if len(e.SpanID) > 0 {
+			labels = append(labels, prompb.Label{Name: "span_id", Value: hex.EncodeToString(e.SpanID)})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+		out = append(out, prompb.Exemplar{Labels: labels, Value: e.Value(), Timestamp: nanosToMillis(e.TimeUnixNano)})
+	}
+	return out
+}
+
+func attributeMap(attrs []KeyValue) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for i := range attrs {
+		m[attrs[i].Key] = attrs[i].Value.String()
+	}
+	return m
+}
+
+func nanosToMillis(nanos Uint64) int64 {
+	return int64(nanos / 1e6)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,285 @@
+package otlp
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+func TestMetricName(t *testing.T) {
+	tests := []struct {
+		name, unit     string
+		counter, gauge bool
+		suffixes       bool
+		want           string
+	}{
+		{name: "http.server.duration", unit: "s", suffixes: true, want: "http_server_duration_seconds"},
+		{name: "http.server.duration", unit: "ms", suffixes: true, want: "http_server_duration_milliseconds"},
+		{name: "http.server.duration", unit: "s", want: "http_server_duration"},
+		{name: "duration_seconds", unit: "s", suffixes: true, want: "duration_seconds"},
+		{name: "request.size", unit: "By", suffixes: true, want: "request_size_bytes"},
+		{name: "throughput", unit: "By/s", suffixes: true, want: "throughput_bytes_per_second"},
+		{name: "request.rate", unit: "{requests}/s", gauge: true, suffixes: true, want: "request_rate_per_second"},
+		{name: "temperature", unit: "Cel", gauge: true, suffixes: true, want: "temperature_celsius"},
+		{name: "items", unit: "widgets", gauge: true, suffixes: true, want: "items_widgets"},
+		{name: "requests", unit: "{requests}", counter: true, suffixes: true, want: "requests_total"},
+		{name: "requests.total.count", unit: "1", counter: true, suffixes: true, want: "requests_count_total"},
+		{name: "requests", counter: true, want: "requests"},
+		{name: "memory.utilization", unit: "1", gauge: true, suffixes: true, want: "memory_utilization_ratio"},
+		{name: "cpu.ratio", unit: "1", gauge: true, suffixes: true, want: "cpu_ratio"},
+		{name: "queue.length", unit: "1", suffixes: true, want: "queue_length"},
+		{name: "2xx.responses", counter: true, suffixes: true, want: "_2xx_responses_total"},
+		{name: "2xx.responses", want: "_2xx_responses"},
+		{name: "a..b:c", want: "a_b:c"},
+		{name: "...", suffixes: true, want: ""},
+	}
+	for _, tt := range tests {
+		got := MetricName(tt.name, tt.unit, tt.counter, tt.gauge, tt.suffixes)
+		if got != tt.want {
+			t.Errorf("MetricName(%q, %q, counter %v, gauge %v, suffixes %v) = %q, want %q",
+				tt.name, tt.unit, tt.counter, tt.gauge, tt.suffixes, got, tt.want)
+		}
+	}
+}
+
+func TestUnit(t *testing.T) {
+	for unit, want := range map[string]string{
+		"s": "seconds", "By/s": "bytes_per_second", "{requests}/s": "per_second", "1": "", "{requests}": "", "widgets": "widgets",
+	} {
+		if got := Unit(unit); got != want {
+			t.Errorf("Unit(%q) = %q, want %q", unit, got, want)
+		}
+	}
+}
+
+func TestLabelName(t *testing.T) {
+	for key, want := range map[string]string{
+		"http.method": "http_method", "2xx": "key_2xx", "_private": "key_private", "__reserved": "__reserved", "": "",
+	} {
+		if got := LabelName(key); got != want {
+			t.Errorf("LabelName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func f64(v float64) *Float64 {
+	f := Float64(v)
+	return &f
+}
+
+func i64(v int64) *Int64 {
+	i := Int64(v)
+	return &i
+}
+
+func attr(key, value string) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{StringValue: &value}}
+}
+
+// format formats series as name{labels} value@timestamp, histograms as count/sum/schema and buckets
+func format(ts prompb.TimeSeries) string {
+	var labels []string
+	for _, l := range ts.Labels {
+		if l.Name != prompb.MetricNameLabel {
+			labels = append(labels, fmt.Sprintf("%s=%q", l.Name, l.Value))
+		}
+	}
+	s := fmt.Sprintf("%s{%s}", ts.MetricName(), strings.Join(labels, ","))
+	for _, sample := range ts.Samples {
+		s += fmt.Sprintf(" %v@%d", sample.Value, sample.Timestamp)
+	}
+	for _, h := range ts.Histograms {
+		s += fmt.Sprintf(" count=%d sum=%v schema=%d zero=%d spans=%v deltas=%v@%d",
+			h.CountInt, h.Sum, h.Schema, h.ZeroCountInt, h.PositiveSpans, h.PositiveDeltas, h.Timestamp)
+	}
+	for _, e := range ts.Exemplars {
+		s += fmt.Sprintf(" # %v %v", e.Labels, e.Value)
+	}
+	if ts.CreatedTimestamp != 0 {
+		s += fmt.Sprintf(" ct=%d", ts.CreatedTimestamp)
+	}
+	return s
+}
+
+func TestTranslate(t *testing.T) {
+	point := func(v float64) NumberDataPoint {
+		return NumberDataPoint{
+			Attributes:        []KeyValue{attr("http.method", "GET")},
+			StartTimeUnixNano: 1e9,
+			TimeUnixNano:      2e9,
+			AsDouble:          f64(v),
+		}
+	}
+	tests := []struct {
+		name     string
+		metric   Metric
+		series   []string
+		metadata []prompb.MetricMetadata
+		dropped  map[string]int
+	}{
+		{
+			name:     "gauge",
+			metric:   Metric{Name: "memory.usage", Unit: "By", Description: "Memory usage.", Gauge: &Gauge{DataPoints: []NumberDataPoint{point(10)}}},
+			series:   []string{`memory_usage_bytes{http_method="GET"} 10@2000`},
+			metadata: []prompb.MetricMetadata{{Type: prompb.MetricTypeGauge, MetricFamilyName: "memory_usage_bytes", Help: "Memory usage.", Unit: "bytes"}},
+		},
+		{
+			name: "cumulative monotonic sum",
+			metric: Metric{Name: "http.requests", Unit: "{requests}", Sum: &Sum{
+				AggregationTemporality: TemporalityCumulative, IsMonotonic: true,
+				DataPoints: []NumberDataPoint{{
+					TimeUnixNano: 2e9, StartTimeUnixNano: 1e9, AsInt: i64(7),
+					Exemplars: []Exemplar{{AsDouble: f64(1), TimeUnixNano: 15e8, TraceID: HexBytes{0xab}, SpanID: HexBytes{0xcd}}},
+				}},
+			}},
+			series:   []string{`http_requests_total{} 7@2000 # [{span_id cd} {trace_id ab}] 1 ct=1000`},
+			metadata: []prompb.MetricMetadata{{Type: prompb.MetricTypeCounter, MetricFamilyName: "http_requests_total"}},
+		},
+		{
+			name: "cumulative non-monotonic sum",
+			metric: Metric{Name: "queue.size", Sum: &Sum{
+				AggregationTemporality: TemporalityCumulative, DataPoints: []NumberDataPoint{point(3)},
+			}},
+			series:   []string{`queue_size{http_method="GET"} 3@2000`},
+			metadata: []prompb.MetricMetadata{{Type: prompb.MetricTypeGauge, MetricFamilyName: "queue_size"}},
+		},
+		{
+			name: "delta sum",
+			metric: Metric{Name: "http.requests", Sum: &Sum{
+				AggregationTemporality: TemporalityDelta, IsMonotonic: true, DataPoints: []NumberDataPoint{point(1), point(2)},
+			}},
+			dropped: map[string]int{ReasonDeltaTemporality: 2},
+		},
+		{
+			name: "delta histogram",
+			metric: Metric{Name: "latency", Histogram: &Histogram{
+				AggregationTemporality: TemporalityDelta, DataPoints: []HistogramDataPoint{{Count: 1}},
+			}},
+			dropped: map[string]int{ReasonDeltaTemporality: 1},
+		},
+		{
+			name:    "empty name",
+			metric:  Metric{Name: "...", Gauge: &Gauge{DataPoints: []NumberDataPoint{point(1)}}},
+			dropped: map[string]int{ReasonEmptyName: 1},
+		},
+		{
+			name: "histogram",
+			metric: Metric{Name: "http.server.duration", Unit: "s", Histogram: &Histogram{
+				AggregationTemporality: TemporalityCumulative,
+				DataPoints: []HistogramDataPoint{{
+					StartTimeUnixNano: 1e9, TimeUnixNano: 2e9, Count: 6, Sum: f64(4.5),
+					BucketCounts: []Uint64{1, 2, 3}, ExplicitBounds: []Float64{0.1, 1},
+					Exemplars: []Exemplar{{AsDouble: f64(0.5), TimeUnixNano: 15e8}, {AsDouble: f64(5), TimeUnixNano: 15e8}},
+				}},
+			}},
+			series: []string{
+				`http_server_duration_seconds_sum{} 4.5@2000 ct=1000`,
+				`http_server_duration_seconds_count{} 6@2000 ct=1000`,
+				`http_server_duration_seconds_bucket{le="0.1"} 1@2000 ct=1000`,
+				`http_server_duration_seconds_bucket{le="1"} 3@2000 # [] 0.5 ct=1000`,
+				`http_server_duration_seconds_bucket{le="+Inf"} 6@2000 # [] 5 ct=1000`,
+			},
+			metadata: []prompb.MetricMetadata{{Type: prompb.MetricTypeHistogram, MetricFamilyName: "http_server_duration_seconds", Unit: "seconds"}},
+		},
+		{
+			name: "histogram with mismatched buckets",
+			metric: Metric{Name: "latency", Histogram: &Histogram{
+				AggregationTemporality: TemporalityCumulative,
+				DataPoints:             []HistogramDataPoint{{TimeUnixNano: 2e9, Count: 3, BucketCounts: []Uint64{1, 2}, ExplicitBounds: []Float64{0.1, 1}}},
+			}},
+			series:   []string{`latency_count{} 3@2000`, `latency_bucket{le="+Inf"} 3@2000`},
+			metadata: []prompb.MetricMetadata{{Type: prompb.MetricTypeHistogram, MetricFamilyName: "latency"}},
+		},
+		{
+			name: "exponential histogram",
+			metric: Metric{Name: "latency", ExponentialHistogram: &ExponentialHistogram{
+				AggregationTemporality: TemporalityCumulative,
+				DataPoints: []ExponentialHistogramDataPoint{
+					// scale 10 is downscaled to 8 by merging every 4 buckets
+					{TimeUnixNano: 2e9, Count: 6, Sum: f64(3), Scale: 10, ZeroCount: 1,
+						Positive: Buckets{Offset: -1, BucketCounts: []Uint64{1, 1, 1, 1, 1}}},
+					{TimeUnixNano: 2e9, Count: 1, Scale: -5},
+				},
+			}},
+			series:   []string{`latency{} count=6 sum=3 schema=8 zero=1 spans=[{0 2}] deltas=[1 3]@2000`},
+			metadata: []prompb.MetricMetadata{{Type: prompb.MetricTypeHistogram, MetricFamilyName: "latency"}},
+			dropped:  map[string]int{ReasonScale: 1},
+		},
+		{
+			name: "summary",
+			metric: Metric{Name: "rpc.duration", Unit: "ms", Summary: &Summary{DataPoints: []SummaryDataPoint{{
+				StartTimeUnixNano: 1e9, TimeUnixNano: 2e9, Count: 4, Sum: 10,
+				QuantileValues: []ValueAtQuantile{{Quantile: 0.5, Value: 2}, {Quantile: 0.99, Value: 5}},
+			}}}},
+			series: []string{
+				`rpc_duration_milliseconds_sum{} 10@2000 ct=1000`,
+				`rpc_duration_milliseconds_count{} 4@2000 ct=1000`,
+				`rpc_duration_milliseconds{quantile="0.5"} 2@2000 ct=1000`,
+				`rpc_duration_milliseconds{quantile="0.99"} 5@2000 ct=1000`,
+			},
+			metadata: []prompb.MetricMetadata{{Type: prompb.MetricTypeSummary, MetricFamilyName: "rpc_duration_milliseconds", Unit: "milliseconds"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &MetricsRequest{ResourceMetrics: []ResourceMetrics{{ScopeMetrics: []ScopeMetrics{{Metrics: []Metric{tt.metric}}}}}}
+			result := Translate(req, &config.OTLPConfig{AddMetricSuffixes: true})
+			var series []string
+			for _, ts := range result.Request.Timeseries {
+				series = append(series, format(ts))
+			}
+			if !reflect.DeepEqual(series, tt.series) {
+				t.Errorf("series = %q, want %q", series, tt.series)
+			}
+			if !reflect.DeepEqual(result.Request.Metadata, tt.metadata) {
+				t.Errorf("metadata = %+v, want %+v", result.Request.Metadata, tt.metadata)
+			}
+			if tt.dropped == nil {
+				tt.dropped = map[string]int{}
+			}
+			if !reflect.DeepEqual(result.Dropped, tt.dropped) {
+				t.Errorf("dropped = %v, want %v", result.Dropped, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestTranslateStale(t *testing.T) {
+	req := &MetricsRequest{ResourceMetrics: []ResourceMetrics{{ScopeMetrics: []ScopeMetrics{{Metrics: []Metric{
+		{Name: "up", Gauge: &Gauge{DataPoints: []NumberDataPoint{{TimeUnixNano: 2e9, Flags: FlagNoRecordedValue}}}},
+	}}}}}}
+	ts := Translate(req, &config.OTLPConfig{}).Request.Timeseries
+	if len(ts) != 1 || math.Float64bits(ts[0].Samples[0].Value) != math.Float64bits(staleNaN) {
+		t.Errorf("series = %v, want a stale marker", ts)
+	}
+}
+
+func TestTranslateResource(t *testing.T) {
+	req := &MetricsRequest{ResourceMetrics: []ResourceMetrics{{
+		Resource: Resource{Attributes: []KeyValue{
+			attr("service.name", "checkout"), attr("service.namespace", "shop"), attr("service.instance.id", "pod-1"),
+			attr("k8s.cluster.name", "c1"), attr("host.arch", "amd64"),
+		}},
+		ScopeMetrics: []ScopeMetrics{{Metrics: []Metric{{Name: "up", Gauge: &Gauge{DataPoints: []NumberDataPoint{
+			{Attributes: []KeyValue{attr("job", "ignored"), attr("k8s.cluster.name", "c2")}, TimeUnixNano: 3e9, AsInt: i64(1)},
+		}}}}}},
+	}}}
+	result := Translate(req, &config.OTLPConfig{PromoteResourceAttributes: []string{"k8s.cluster.name"}})
+	var series []string
+	for _, ts := range result.Request.Timeseries {
+		series = append(series, format(ts))
+	}
+	// job and instance override data point attributes, promoted attributes don't
+	want := []string{
+		`up{instance="pod-1",job="shop/checkout",k8s_cluster_name="c2"} 1@3000`,
+		`target_info{host_arch="amd64",instance="pod-1",job="shop/checkout",k8s_cluster_name="c1"} 1@3000`,
+	}
+	if !reflect.DeepEqual(series, want) {
+		t.Errorf("series = %q, want %q", series, want)
+	}
+}
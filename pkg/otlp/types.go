@@ -0,0 +1,309 @@
+// Package otlp decodes OTLP metrics export requests in protobuf and JSON, and translates them
+// into prometheus remote write series following the OpenTelemetry to Prometheus compatibility spec.
+// Only fields used by translation are decoded
+package otlp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// MetricsRequest is opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest
+type MetricsRequest struct {
+	ResourceMetrics []ResourceMetrics `json:"resourceMetrics"`
+}
+
+type ResourceMetrics struct {
+	Resource     Resource       `json:"resource"`
+	ScopeMetrics []ScopeMetrics `json:"scopeMetrics"`
+}
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeMetrics struct {
+	Scope   Scope    `json:"scope"`
+	Metrics []Metric `json:"metrics"`
+}
+
+type Scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Metric struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Unit        string `json:"unit"`
+	// one of data fields is set
+	Gauge                *Gauge                `json:"gauge"`
+	Sum                  *Sum                  `json:"sum"`
+	Histogram            *Histogram            `json:"histogram"`
+	ExponentialHistogram *ExponentialHistogram `json:"exponentialHistogram"`
+	Summary              *Summary              `json:"summary"`
+}
+
+type AggregationTemporality int32
+
+const (
+	TemporalityUnspecified AggregationTemporality = iota
+	TemporalityDelta
+	TemporalityCumulative
+)
+
+type Gauge struct {
+	DataPoints []NumberDataPoint `json:"dataPoints"`
+}
+
+type Sum struct {
+	DataPoints             []NumberDataPoint      `json:"dataPoints"`
+	AggregationTemporality AggregationTemporality `json:"aggregationTemporality"`
+	IsMonotonic            bool                   `json:"isMonotonic"`
+}
+
+type Histogram struct {
+	DataPoints             []HistogramDataPoint   `json:"dataPoints"`
+	AggregationTemporality AggregationTemporality `json:"aggregationTemporality"`
+}
+
+type ExponentialHistogram struct {
+	DataPoints             []ExponentialHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality AggregationTemporality          `json:"aggregationTemporality"`
+}
+
+type Summary struct {
+	DataPoints []SummaryDataPoint `json:"dataPoints"`
+}
+
+// FlagNoRecordedValue marks a data point without value, it's translated to a stale marker
+const FlagNoRecordedValue = 1
+
+type NumberDataPoint struct {
+	Attributes        []KeyValue `json:"attributes"`
+	StartTimeUnixNano Uint64     `json:"startTimeUnixNano"`
+	TimeUnixNano      Uint64     `json:"timeUnixNano"`
+	// AsDouble is used unless AsInt is set
+	AsDouble  *Float64   `json:"asDouble"`
+	AsInt     *Int64     `json:"asInt"`
+	Exemplars []Exemplar `json:"exemplars"`
+	Flags     uint32     `json:"flags"`
+}
+
+// Value returns the value of the data point
+func (p *NumberDataPoint) Value() float64 {
+	if p.AsInt != nil {
+		return float64(*p.AsInt)
+	}
+	if p.AsDouble != nil {
+		return float64(*p.AsDouble)
+	}
+	return 0
+}
+
+type HistogramDataPoint struct {
+	Attributes        []KeyValue `json:"attributes"`
+	StartTimeUnixNano Uint64     `json:"startTimeUnixNano"`
+	TimeUnixNano      Uint64     `json:"timeUnixNano"`
+	Count             Uint64     `json:"count"`
+	Sum               *Float64   `json:"sum"`
+	// BucketCounts has one more element than ExplicitBounds, the last one is (bounds[len-1], +Inf]
+	BucketCounts   []Uint64   `json:"bucketCounts"`
+	ExplicitBounds []Float64  `json:"explicitBounds"`
+	Exemplars      []Exemplar `json:"exemplars"`
+	Flags          uint32     `json:"flags"`
+}
+
+type ExponentialHistogramDataPoint struct {
+	Attributes        []KeyValue `json:"attributes"`
+	StartTimeUnixNano Uint64     `json:"startTimeUnixNano"`
+	TimeUnixNano      Uint64     `json:"timeUnixNano"`
+	Count             Uint64     `json:"count"`
+	Sum               *Float64   `json:"sum"`
+	Scale             int32      `json:"scale"`
+	ZeroCount         Uint64     `json:"zeroCount"`
+	ZeroThreshold     Float64    `json:"zeroThreshold"`
+	Positive          Buckets    `json:"positive"`
+	Negative          Buckets    `json:"negative"`
+	Exemplars         []Exemplar `json:"exemplars"`
+	Flags             uint32     `json:"flags"`
+}
+
+// Buckets of index i covers (base^i, base^(i+1)], where base is 2^2^-scale
+type Buckets struct {
+	Offset       int32    `json:"offset"`
+	BucketCounts []Uint64 `json:"bucketCounts"`
+}
+
+type SummaryDataPoint struct {
+	Attributes        []KeyValue        `json:"attributes"`
+	StartTimeUnixNano Uint64            `json:"startTimeUnixNano"`
+	TimeUnixNano      Uint64            `json:"timeUnixNano"`
+	Count             Uint64            `json:"count"`
+	Sum               Float64           `json:"sum"`
+	QuantileValues    []ValueAtQuantile `json:"quantileValues"`
+	Flags             uint32            `json:"flags"`
+}
+
+type ValueAtQuantile struct {
+	Quantile Float64 `json:"quantile"`
+	Value    Float64 `json:"value"`
+}
+
+type Exemplar struct {
+	FilteredAttributes []KeyValue `json:"filteredAttributes"`
+	TimeUnixNano       Uint64     `json:"timeUnixNano"`
+	AsDouble           *Float64   `json:"asDouble"`
+	AsInt              *Int64     `json:"asInt"`
+	SpanID             HexBytes   `json:"spanId"`
+	TraceID            HexBytes   `json:"traceId"`
+}
+
+// Value returns the value of the exemplar
+func (e *Exemplar) Value() float64 {
+	if e.AsInt != nil {
+		return float64(*e.AsInt)
+	}
+	if e.AsDouble != nil {
+		return float64(*e.AsDouble)
+	}
+	return 0
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue is the attribute value, at most one field is set
+type AnyValue struct {
+	StringValue *string      `json:"stringValue"`
+	BoolValue   *bool        `json:"boolValue"`
+	IntValue    *Int64       `json:"intValue"`
+	DoubleValue *Float64     `json:"doubleValue"`
+	ArrayValue  *ArrayValue  `json:"arrayValue"`
+	KvlistValue *KvlistValue `json:"kvlistValue"`
+	BytesValue  []byte       `json:"bytesValue"`
+}
+
+type ArrayValue struct {
+	Values []AnyValue `json:"values"`
+}
+
+type KvlistValue struct {
+	Values []KeyValue `json:"values"`
+}
+
+// String formats the value as a label value, arrays and maps are in JSON
+func (v *AnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(float64(*v.DoubleValue), 'f', -1, 64)
+	case v.BytesValue != nil:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case v.ArrayValue != nil, v.KvlistValue != nil:
+		b, _ := json.Marshal(v.jsonValue())
+		return string(b)
+	}
+	return ""
+}
+
+func (v *AnyValue) jsonValue() any {
+	switch {
+	case v.ArrayValue != nil:
+		values := make([]any, 0, len(v.ArrayValue.Values))
+		for i := range v.ArrayValue.Values {
+			values = append(values, v.ArrayValue.Values[i].jsonValue())
+		}
+		return values
+	case v.KvlistValue != nil:
+		values := make(map[string]any, len(v.KvlistValue.Values))
+		for i := range v.KvlistValue.Values {
+			values[v.KvlistValue.Values[i].Key] = v.KvlistValue.Values[i].Value.jsonValue()
+		}
+		return values
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return int64(*v.IntValue)
+	case v.DoubleValue != nil:
+		return float64(*v.DoubleValue)
+	case v.BytesValue != nil:
+		return v.BytesValue
+	}
+	return nil
+}
+
+// Uint64 is encoded in JSON as a decimal string, a number is accepted as well
+type Uint64 uint64
+
+func (u *Uint64) UnmarshalJSON(b []byte) error {
+	x, err := strconv.ParseUint(string(bytes.Trim(b, `"`)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid uint64 %s: %w", b, err)
+	}
+	*u = Uint64(x)
+	return nil
+}
+
+// Int64 is encoded in JSON as a decimal string, a number is accepted as well
+type Int64 int64
+
+func (i *Int64) UnmarshalJSON(b []byte) error {
+	x, err := strconv.ParseInt(string(bytes.Trim(b, `"`)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid int64 %s: %w", b, err)
+	}
+	*i = Int64(x)
+	return nil
+}
+
+// Float64 is encoded in JSON as a number, or "NaN", "Infinity" and "-Infinity"
+type Float64 float64
+
+func (f *Float64) UnmarshalJSON(b []byte) error {
+	switch s := string(bytes.Trim(b, `"`)); s {
+	case "NaN":
+		*f = Float64(math.NaN())
+	case "Infinity":
+		*f = Float64(math.Inf(1))
+	case "-Infinity":
+		*f = Float64(math.Inf(-1))
+	default:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid double %s: %w", b, err)
+		}
+		*f = Float64(x)
+	}
+	return nil
+}
+
+// HexBytes are trace and span ids, they're encoded in JSON as hex strings instead of base64
+type HexBytes []byte
+
+func (h *HexBytes) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	x, err := hex.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("invalid hex id %q: %w", s, err)
+	}
+	*h = x
+	return nil
+}
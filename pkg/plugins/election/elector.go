@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"prometheus-deepflow-adapter/pkg/config"
-	"prometheus-deepflow-adapter/pkg/log"
 	"time"
 )
 
@@ -40,6 +39,14 @@ type Election interface {
 	HeartBeat() time.Duration
 }
 
+// Inspector is optionally implemented by electors, it exposes lock details for runtime inspection
+type Inspector interface {
+	// Identity returns the identity current server holds the lock with
+	Identity() string
+	// LockHolder returns the identity of current lock holder, empty if lock is free
+	LockHolder(context.Context) (string, error)
+}
+
 type electorConstructor func(config.Configuration) (Election, error)
 
 var electorComponents = map[config.Elector]electorConstructor{}
@@ -48,25 +55,15 @@ func RegisterElector(name config.Elector, f electorConstructor) {
 	electorComponents[name] = f
 }
 
-func StartElection(conf *config.Config) Election {
+// NewElector builds the elector of conf once, callers keep trying to lead with StartLeading of it
+func NewElector(conf *config.Config) (Election, error) {
 	electorFunc := electorComponents[conf.Elector]
 	if electorFunc == nil {
-		log.Logger.Error("msg", "get elector failed", "elector", conf.Elector)
-		return nil
+		return nil, fmt.Errorf("unknown elector %q", conf.Elector)
 	}
-
 	elector, err := electorFunc(conf.ExtraConfigs[string(conf.Elector)])
 	if err != nil {
-		log.Logger.Error("msg", "call elector constructor function failed", "elector", conf.Elector, "err", err)
-		return nil
-	}
-
-	log.Logger.Info("msg", fmt.Sprintf("%selector start election now", conf.Elector))
-
-	err = elector.StartLeading(context.Background())
-	if err != nil {
-		log.Logger.Error("msg", "try get leader lock failed, server is not leader", "elector", conf.Elector, "err", err)
-		return nil
+		return nil, fmt.Errorf("build %s elector failed: %w", conf.Elector, err)
 	}
-	return elector
+	return elector, nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"prometheus-deepflow-adapter/pkg/config"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -17,8 +18,9 @@ type etcdElector struct {
 	client   *clientv3.Client
 	isLeader *atomic.Bool
 
-	mutex   *concurrency.Mutex
-	session *concurrency.Session
+	mutex    *concurrency.Mutex
+	session  *concurrency.Session
+	identity atomic.Value
 }
 
 // implement etcd distribution locker
@@ -45,6 +47,8 @@ func (e *etcdElector) StartLeading(ctx context.Context) error {
 		return err
 	}
 	// defer session.Close()
+	// etcd mutex holds the lock with key `prefix/lease-id`, so lease id is the identity
+	e.identity.Store(fmt.Sprintf("%x", e.session.Lease()))
 
 	e.mutex = concurrency.NewMutex(e.session, e.config.Key)
 	err = e.mutex.TryLock(ctx)
@@ -83,6 +87,23 @@ func (e *etcdElector) HeartBeat() time.Duration {
 	return e.config.HeartBeat
 }
 
+func (e *etcdElector) Identity() string {
+	identity, _ := e.identity.Load().(string)
+	return identity
+}
+
+func (e *etcdElector) LockHolder(ctx context.Context) (string, error) {
+	prefix := e.config.Key + "/"
+	resp, err := e.client.Get(ctx, prefix, clientv3.WithFirstCreate()...)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", nil
+	}
+	return strings.TrimPrefix(string(resp.Kvs[0].Key), prefix), nil
+}
+
 func (e *etcdElector) KeepAlive(ctx context.Context) {
 	// nothing, etcd concurrency will keep alive
 }
@@ -104,12 +125,23 @@ func (e *EtcdConfig) ToOptions() *pflag.FlagSet {
 	fs.StringVar(&e.Key, "key", "/p8s-df-adapter-lock", "etcd election keys")
 	fs.DurationVar(&e.HeartBeat, "heartbeat", 15*time.Second, "lock heartbeat interval")
 	fs.DurationVar(&e.RetryPeriod, "retry-period", 10*time.Second, "lock retry interval")
-	fs.VisitAll(func(f *pflag.Flag) {
-		f.Name = fmt.Sprintf("%s-%s", "etcd", f.Name)
-	})
+	config.PrefixFlags(fs, "etcd")
 	return fs
 }
 
+func (e *EtcdConfig) Validate() []error {
+	var errs []error
+	if len(e.Endpoints) == 0 {
+		errs = append(errs, config.NewFieldError("endpoints", "must not be empty"))
+	}
+	if e.Key == "" {
+		errs = append(errs, config.NewFieldError("key", "must not be empty"))
+	}
+	errs = append(errs, config.ValidatePositiveDuration("heartbeat", e.HeartBeat)...)
+	errs = append(errs, config.ValidatePositiveDuration("retry-period", e.RetryPeriod)...)
+	return errs
+}
+
 func init() {
 	config.RegisterConfig(string(config.Etcd), NewEtcdConfig)
 	RegisterElector(config.Etcd, NewEtcdElector)
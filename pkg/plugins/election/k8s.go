@@ -2,7 +2,6 @@ package election
 
 import (
 	"context"
-	"fmt"
 	"sync/atomic"
 	"time"
 
@@ -128,6 +127,18 @@ func (k *k8sElector) HeartBeat() time.Duration {
 	return k.config.HeartBeat
 }
 
+func (k *k8sElector) Identity() string {
+	return k.uuid
+}
+
+func (k *k8sElector) LockHolder(ctx context.Context) (string, error) {
+	record, _, err := k.lock.Get(ctx)
+	if err != nil {
+		return "", err
+	}
+	return record.HolderIdentity, nil
+}
+
 func (k *k8sElector) KeepAlive(ctx context.Context) {
 	// nothing, k8s lease will keep alive
 }
@@ -151,12 +162,23 @@ func (k *K8SConfig) ToOptions() *pflag.FlagSet {
 	fs.DurationVar(&k.RetryPeriod, "retry-period", 10*time.Second, "lock retry interval")
 	fs.StringVar(&k.LeaseLockName, "lease-lock-name", "p8s-df-adapter-lock", "kubernetes lease lock name")
 	fs.StringVar(&k.LeaseLockNamespace, "lease-lock-namespace", "default", "kubernetes lease lock namespace")
-	fs.VisitAll(func(f *pflag.Flag) {
-		f.Name = fmt.Sprintf("%s-%s", "k8s", f.Name)
-	})
+	config.PrefixFlags(fs, "k8s")
 	return fs
 }
 
+func (k *K8SConfig) Validate() []error {
+	var errs []error
+	errs = append(errs, config.ValidatePositiveDuration("heartbeat", k.HeartBeat)...)
+	errs = append(errs, config.ValidatePositiveDuration("retry-period", k.RetryPeriod)...)
+	if k.LeaseLockName == "" {
+		errs = append(errs, config.NewFieldError("lease-lock-name", "must not be empty"))
+	}
+	if k.LeaseLockNamespace == "" {
+		errs = append(errs, config.NewFieldError("lease-lock-namespace", "must not be empty"))
+	}
+	return errs
+}
+
 func init() {
 	config.RegisterConfig(string(config.K8S), NewK8SConfig)
 	RegisterElector(config.K8S, Newk8sElector)
@@ -2,7 +2,6 @@ package election
 
 import (
 	"context"
-	"fmt"
 	"prometheus-deepflow-adapter/pkg/config"
 	"sync/atomic"
 	"time"
@@ -77,6 +76,18 @@ func (r *redisElector) HeartBeat() time.Duration {
 	return r.config.HeartBeat
 }
 
+func (r *redisElector) Identity() string {
+	return r.uuid
+}
+
+func (r *redisElector) LockHolder(ctx context.Context) (string, error) {
+	holder, err := r.client.Get(ctx, r.config.Key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return holder, err
+}
+
 func (r *redisElector) KeepAlive(ctx context.Context) {
 	r.client.Expire(ctx, r.config.Key, r.config.HeartBeat)
 	r.isLeader.Store(true)
@@ -101,12 +112,23 @@ func (r *RedisConfig) ToOptions() *pflag.FlagSet {
 	fs.StringVar(&r.Key, "key", "p8s-df-adapter-lock", "redis lock leader key")
 	fs.DurationVar(&r.HeartBeat, "heartbeat", 15*time.Second, "lock heartbeat interval")
 	fs.DurationVar(&r.RetryPeriod, "retry-period", 10*time.Second, "lock retry interval")
-	fs.VisitAll(func(f *pflag.Flag) {
-		f.Name = fmt.Sprintf("%s-%s", "redis", f.Name)
-	})
+	config.PrefixFlags(fs, "redis")
 	return fs
 }
 
+func (r *RedisConfig) Validate() []error {
+	var errs []error
+	if r.Addr == "" {
+		errs = append(errs, config.NewFieldError("addr", "must not be empty"))
+	}
+	if r.Key == "" {
+		errs = append(errs, config.NewFieldError("key", "must not be empty"))
+	}
+	errs = append(errs, config.ValidatePositiveDuration("heartbeat", r.HeartBeat)...)
+	errs = append(errs, config.ValidatePositiveDuration("retry-period", r.RetryPeriod)...)
+	return errs
+}
+
 func init() {
 	config.RegisterConfig(string(config.Redis), NewRedisConfig)
 	RegisterElector(config.Redis, NewRedisElector)
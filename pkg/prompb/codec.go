@@ -0,0 +1,405 @@
+package prompb
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Marshal encodes the request into protobuf wire format
+func (m *WriteRequest) Marshal() []byte {
+	var b []byte
+	for i := range m.Timeseries {
+		b = appendMessage(b, 1, m.Timeseries[i].marshal(nil))
+	}
+	for i := range m.Metadata {
+		b = appendMessage(b, 3, m.Metadata[i].marshal(nil))
+	}
+	return b
+}
+
+// Unmarshal decodes protobuf wire format, unknown fields are skipped
+func (m *WriteRequest) Unmarshal(b []byte) error {
+	*m = WriteRequest{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			var ts TimeSeries
+			if err := ts.unmarshal(v); err != nil {
+				return err
+			}
+			m.Timeseries = append(m.Timeseries, ts)
+		case 3:
+			var md MetricMetadata
+			if err := md.unmarshal(v); err != nil {
+				return err
+			}
+			m.Metadata = append(m.Metadata, md)
+		}
+		return nil
+	})
+}
+
+func (m *MetricMetadata) marshal(b []byte) []byte {
+	b = appendVarint(b, 1, uint64(m.Type))
+	b = appendString(b, 2, m.MetricFamilyName)
+	b = appendString(b, 4, m.Help)
+	b = appendString(b, 5, m.Unit)
+	return b
+}
+
+func (m *MetricMetadata) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			m.Type = MetricType(varint(v))
+		case 2:
+			m.MetricFamilyName = string(v)
+		case 4:
+			m.Help = string(v)
+		case 5:
+			m.Unit = string(v)
+		}
+		return nil
+	})
+}
+
+func (m *TimeSeries) marshal(b []byte) []byte {
+	for i := range m.Labels {
+		b = appendMessage(b, 1, m.Labels[i].marshal(nil))
+	}
+	for i := range m.Samples {
+		b = appendMessage(b, 2, m.Samples[i].marshal(nil))
+	}
+	for i := range m.Exemplars {
+		b = appendMessage(b, 3, m.Exemplars[i].marshal(nil))
+	}
+	for i := range m.Histograms {
+		b = appendMessage(b, 4, m.Histograms[i].marshal(nil))
+	}
+	return b
+}
+
+func (m *TimeSeries) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			var l Label
+			if err := l.unmarshal(v); err != nil {
+				return err
+			}
+			m.Labels = append(m.Labels, l)
+		case 2:
+			var s Sample
+			if err := s.unmarshal(v); err != nil {
+				return err
+			}
+			m.Samples = append(m.Samples, s)
+		case 3:
+			var e Exemplar
+			if err := e.unmarshal(v); err != nil {
+				return err
+			}
+			m.Exemplars = append(m.Exemplars, e)
+		case 4:
+			var h Histogram
+			if err := h.unmarshal(v); err != nil {
+				return err
+			}
+			m.Histograms = append(m.Histograms, h)
+		}
+		return nil
+	})
+}
+
+func (m *Label) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.Name)
+	b = appendString(b, 2, m.Value)
+	return b
+}
+
+func (m *Label) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			m.Name = string(v)
+		case 2:
+			m.Value = string(v)
+		}
+		return nil
+	})
+}
+
+func (m *Sample) marshal(b []byte) []byte {
+	b = appendDouble(b, 1, m.Value)
+	b = appendVarint(b, 2, uint64(m.Timestamp))
+	return b
+}
+
+func (m *Sample) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			m.Value = double(v)
+		case 2:
+			m.Timestamp = int64(varint(v))
+		}
+		return nil
+	})
+}
+
+func (m *Exemplar) marshal(b []byte) []byte {
+	for i := range m.Labels {
+		b = appendMessage(b, 1, m.Labels[i].marshal(nil))
+	}
+	b = appendDouble(b, 2, m.Value)
+	b = appendVarint(b, 3, uint64(m.Timestamp))
+	return b
+}
+
+func (m *Exemplar) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			var l Label
+			if err := l.unmarshal(v); err != nil {
+				return err
+			}
+			m.Labels = append(m.Labels, l)
+		case 2:
+			m.Value = double(v)
+		case 3:
+			m.Timestamp = int64(varint(v))
+		}
+		return nil
+	})
+}
+
+func (m *Histogram) marshal(b []byte) []byte {
+	// count and zero count are oneof fields, they are always written to keep the kind of histogram
+	if m.Float {
+		b = protowire.AppendTag(b, 2, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(m.CountFloat))
+	} else {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.CountInt)
+	}
+	b = appendDouble(b, 3, m.Sum)
+	b = appendSint(b, 4, int64(m.Schema))
+	b = appendDouble(b, 5, m.ZeroThreshold)
+	if m.Float {
+		b = protowire.AppendTag(b, 7, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(m.ZeroCountFloat))
+	} else {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.ZeroCountInt)
+	}
+	for i := range m.NegativeSpans {
+		b = appendMessage(b, 8, m.NegativeSpans[i].marshal(nil))
+	}
+	b = appendPackedSint(b, 9, m.NegativeDeltas)
+	b = appendPackedDouble(b, 10, m.NegativeCounts)
+	for i := range m.PositiveSpans {
+		b = appendMessage(b, 11, m.PositiveSpans[i].marshal(nil))
+	}
+	b = appendPackedSint(b, 12, m.PositiveDeltas)
+	b = appendPackedDouble(b, 13, m.PositiveCounts)
+	b = appendVarint(b, 14, uint64(m.ResetHint))
+	b = appendVarint(b, 15, uint64(m.Timestamp))
+	b = appendPackedDouble(b, 16, m.CustomValues)
+	return b
+}
+
+func (m *Histogram) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		var err error
+		switch num {
+		case 1:
+			m.CountInt = varint(v)
+		case 2:
+			m.Float = true
+			m.CountFloat = double(v)
+		case 3:
+			m.Sum = double(v)
+		case 4:
+			m.Schema = int32(protowire.DecodeZigZag(varint(v)))
+		case 5:
+			m.ZeroThreshold = double(v)
+		case 6:
+			m.ZeroCountInt = varint(v)
+		case 7:
+			m.Float = true
+			m.ZeroCountFloat = double(v)
+		case 8:
+			var s BucketSpan
+			err = s.unmarshal(v)
+			m.NegativeSpans = append(m.NegativeSpans, s)
+		case 9:
+			m.NegativeDeltas, err = unpackSint(m.NegativeDeltas, typ, v)
+		case 10:
+			m.NegativeCounts, err = unpackDouble(m.NegativeCounts, typ, v)
+		case 11:
+			var s BucketSpan
+			err = s.unmarshal(v)
+			m.PositiveSpans = append(m.PositiveSpans, s)
+		case 12:
+			m.PositiveDeltas, err = unpackSint(m.PositiveDeltas, typ, v)
+		case 13:
+			m.PositiveCounts, err = unpackDouble(m.PositiveCounts, typ, v)
+		case 14:
+			m.ResetHint = ResetHint(varint(v))
+		case 15:
+			m.Timestamp = int64(varint(v))
+		case 16:
+			m.CustomValues, err = unpackDouble(m.CustomValues, typ, v)
+		}
+		return err
+	})
+}
+
+func (m *BucketSpan) marshal(b []byte) []byte {
+	b = appendSint(b, 1, int64(m.Offset))
+	b = appendVarint(b, 2, uint64(m.Length))
+	return b
+}
+
+func (m *BucketSpan) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			m.Offset = int32(protowire.DecodeZigZag(varint(v)))
+		case 2:
+			m.Length = uint32(varint(v))
+		}
+		return nil
+	})
+}
+
+// walk calls fn with every field, v is the raw value: varint and fixed values are re-encoded
+// as they are on the wire, length delimited values are the payload without length prefix
+func walk(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n >= 0 {
+				v = b[:n]
+			}
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func varint(v []byte) uint64 {
+	x, _ := protowire.ConsumeVarint(v)
+	return x
+}
+
+func double(v []byte) float64 {
+	x, _ := protowire.ConsumeFixed64(v)
+	return math.Float64frombits(x)
+}
+
+func unpackSint(dst []int64, typ protowire.Type, v []byte) ([]int64, error) {
+	if typ != protowire.BytesType {
+		return append(dst, protowire.DecodeZigZag(varint(v))), nil
+	}
+	for len(v) > 0 {
+		x, n := protowire.ConsumeVarint(v)
+		if n < 0 {
+			return dst, protowire.ParseError(n)
+		}
+		dst = append(dst, protowire.DecodeZigZag(x))
+		v = v[n:]
+	}
+	return dst, nil
+}
+
+func unpackDouble(dst []float64, typ protowire.Type, v []byte) ([]float64, error) {
+	if typ != protowire.BytesType {
+		return append(dst, double(v)), nil
+	}
+	for len(v) > 0 {
+		x, n := protowire.ConsumeFixed64(v)
+		if n < 0 {
+			return dst, protowire.ParseError(n)
+		}
+		dst = append(dst, math.Float64frombits(x))
+		v = v[n:]
+	}
+	return dst, nil
+}
+
+// append helpers skip zero values as proto3 does
+
+func appendMessage(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendSint(b []byte, num protowire.Number, v int64) []byte {
+	return appendVarint(b, num, protowire.EncodeZigZag(v))
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	bits := math.Float64bits(v)
+	if bits == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, bits)
+}
+
+func appendPackedSint(b []byte, num protowire.Number, v []int64) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, x := range v {
+		packed = protowire.AppendVarint(packed, protowire.EncodeZigZag(x))
+	}
+	return appendMessage(b, num, packed)
+}
+
+func appendPackedDouble(b []byte, num protowire.Number, v []float64) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	packed := make([]byte, 0, 8*len(v))
+	for _, x := range v {
+		packed = protowire.AppendFixed64(packed, math.Float64bits(x))
+	}
+	return appendMessage(b, num, packed)
+}
@@ -0,0 +1,230 @@
+package prompb
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func testHistograms() []Histogram {
+	return []Histogram{
+		{
+			CountInt:       12,
+			Sum:            18.4,
+			Schema:         1,
+			ZeroThreshold:  0.001,
+			ZeroCountInt:   2,
+			NegativeSpans:  []BucketSpan{{Offset: 0, Length: 1}},
+			NegativeDeltas: []int64{1},
+			PositiveSpans:  []BucketSpan{{Offset: -2, Length: 2}, {Offset: 1, Length: 1}},
+			PositiveDeltas: []int64{2, -1, 3},
+			ResetHint:      ResetHintNo,
+			Timestamp:      1700000000000,
+		},
+		{
+			Float:          true,
+			CountFloat:     3.5,
+			Sum:            -1.25,
+			Schema:         CustomBucketsSchema,
+			ZeroCountFloat: 0.5,
+			PositiveSpans:  []BucketSpan{{Offset: 0, Length: 3}},
+			PositiveCounts: []float64{1, 0.5, 1.5},
+			ResetHint:      ResetHintGauge,
+			Timestamp:      1700000015000,
+			CustomValues:   []float64{0.1, 1, 10},
+		},
+	}
+}
+
+func testRequest() *WriteRequest {
+	return &WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels:  []Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "job", Value: "api"}},
+				Samples: []Sample{{Value: 1, Timestamp: 1700000000000}, {Value: 3.5, Timestamp: 1700000015000}},
+				Exemplars: []Exemplar{
+					{Labels: []Label{{Name: "trace_id", Value: "abc"}}, Value: 1, Timestamp: 1700000001000},
+				},
+			},
+			{
+				Labels:     []Label{{Name: "__name__", Value: "http_request_duration_seconds"}, {Name: "job", Value: "api"}},
+				Histograms: testHistograms(),
+			},
+			// negative timestamps are before 1970
+			{
+				Labels:  []Label{{Name: "__name__", Value: "up"}},
+				Samples: []Sample{{Value: -2, Timestamp: -1000}},
+			},
+		},
+		Metadata: []MetricMetadata{
+			{Type: MetricTypeCounter, MetricFamilyName: "http_requests", Help: "Total requests.", Unit: "requests"},
+			{Type: MetricTypeHistogram, MetricFamilyName: "http_request_duration_seconds", Help: "Request latency."},
+		},
+	}
+}
+
+func TestRoundTripV1(t *testing.T) {
+	req := testRequest()
+	got := &WriteRequest{}
+	if err := got.Unmarshal(req.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, req) {
+		t.Errorf("round trip = %+v, want %+v", got, req)
+	}
+}
+
+func TestRoundTripV2(t *testing.T) {
+	req := testRequest()
+	req.Timeseries[2].Metadata = MetricMetadata{Type: MetricTypeGauge, Help: "Up."}
+	req.Timeseries[2].CreatedTimestamp = 1699999999000
+	got := &WriteRequest{}
+	if err := got.UnmarshalV2(req.MarshalV2()); err != nil {
+		t.Fatal(err)
+	}
+
+	// series without metadata get metadata of their families, including families of counter and histogram series
+	want := testRequest()
+	want.Timeseries[0].Metadata = MetricMetadata{Type: MetricTypeCounter, Help: "Total requests.", Unit: "requests"}
+	want.Timeseries[1].Metadata = MetricMetadata{Type: MetricTypeHistogram, Help: "Request latency."}
+	want.Timeseries[2].Metadata = MetricMetadata{Type: MetricTypeGauge, Help: "Up."}
+	want.Timeseries[2].CreatedTimestamp = 1699999999000
+	// request metadata is derived from series metadata
+	want.Metadata = []MetricMetadata{
+		{Type: MetricTypeCounter, MetricFamilyName: "http_requests_total", Help: "Total requests.", Unit: "requests"},
+		{Type: MetricTypeHistogram, MetricFamilyName: "http_request_duration_seconds", Help: "Request latency."},
+		{Type: MetricTypeGauge, MetricFamilyName: "up", Help: "Up."},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
+
+func TestV2ToV1(t *testing.T) {
+	v2 := &WriteRequest{Timeseries: []TimeSeries{
+		{
+			Labels:           []Label{{Name: "__name__", Value: "latency_seconds_bucket"}, {Name: "le", Value: "1"}},
+			Samples:          []Sample{{Value: 4, Timestamp: 1000}},
+			Exemplars:        []Exemplar{{Labels: []Label{{Name: "trace_id", Value: "abc"}}, Value: 0.4, Timestamp: 900}},
+			Metadata:         MetricMetadata{Type: MetricTypeHistogram, Help: "Latency.", Unit: "seconds"},
+			CreatedTimestamp: 500,
+		},
+		{
+			Labels:   []Label{{Name: "__name__", Value: "latency_seconds_count"}},
+			Samples:  []Sample{{Value: 4, Timestamp: 1000}},
+			Metadata: MetricMetadata{Type: MetricTypeHistogram, Help: "Latency.", Unit: "seconds"},
+		},
+		{
+			Labels:     []Label{{Name: "__name__", Value: "native_seconds"}},
+			Histograms: testHistograms(),
+		},
+	}}
+	decoded := &WriteRequest{}
+	if err := decoded.UnmarshalV2(v2.MarshalV2()); err != nil {
+		t.Fatal(err)
+	}
+	v1 := &WriteRequest{}
+	if err := v1.Unmarshal(decoded.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	// series metadata and created timestamps are lost, metadata of classic histogram series is per family
+	want := &WriteRequest{
+		Timeseries: []TimeSeries{
+			{Labels: v2.Timeseries[0].Labels, Samples: v2.Timeseries[0].Samples, Exemplars: v2.Timeseries[0].Exemplars},
+			{Labels: v2.Timeseries[1].Labels, Samples: v2.Timeseries[1].Samples},
+			{Labels: v2.Timeseries[2].Labels, Histograms: testHistograms()},
+		},
+		Metadata: []MetricMetadata{{Type: MetricTypeHistogram, MetricFamilyName: "latency_seconds", Help: "Latency.", Unit: "seconds"}},
+	}
+	if !reflect.DeepEqual(v1, want) {
+		t.Errorf("v1 = %+v, want %+v", v1, want)
+	}
+}
+
+func TestSymbolTable(t *testing.T) {
+	st := newSymbolTable()
+	refs := st.refs([]Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "up"}, {Name: "instance", Value: ""}})
+	if want := []uint32{1, 2, 3, 2, 4, 0}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("refs = %v, want %v", refs, want)
+	}
+	if want := []string{"", "__name__", "up", "job", "instance"}; !reflect.DeepEqual(st.symbols, want) {
+		t.Errorf("symbols = %q, want %q", st.symbols, want)
+	}
+
+	// symbols of an encoded request start with the empty string and are unique
+	var symbols []string
+	err := walk(testRequest().MarshalV2(), func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num == 4 {
+			symbols = append(symbols, string(v))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, s := range symbols {
+		if seen[s] {
+			t.Errorf("duplicated symbol %q", s)
+		}
+		seen[s] = true
+	}
+	if len(symbols) == 0 || symbols[0] != "" {
+		t.Errorf("symbols = %q, want the empty string first", symbols)
+	}
+}
+
+// v2Request encodes symbols and a series message
+func v2Request(symbols []string, series []byte) []byte {
+	var b []byte
+	for _, s := range symbols {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	return appendMessage(b, 5, series)
+}
+
+func TestUnmarshalV2Errors(t *testing.T) {
+	symbols := []string{"", "__name__", "up"}
+	exemplar := appendPackedUint32(nil, 1, []uint32{1, 7})
+	metadata := appendVarint(appendVarint(nil, 1, uint64(MetricTypeGauge)), 3, 9)
+	valid := v2Request(symbols, appendPackedUint32(nil, 1, []uint32{1, 2}))
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{name: "label ref out of range", b: v2Request(symbols, appendPackedUint32(nil, 1, []uint32{1, 3}))},
+		{name: "odd label refs", b: v2Request(symbols, appendPackedUint32(nil, 1, []uint32{1, 2, 1}))},
+		{name: "exemplar label ref out of range", b: v2Request(symbols, appendMessage(nil, 4, exemplar))},
+		{name: "help ref out of range", b: v2Request(symbols, appendMessage(nil, 5, metadata))},
+		{name: "truncated", b: valid[:len(valid)-1]},
+		{name: "truncated symbol", b: v2Request([]string{"", "__name__"}, nil)[:5]},
+		{name: "invalid tag", b: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (&WriteRequest{}).UnmarshalV2(tt.b); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+	if err := (&WriteRequest{}).UnmarshalV2(valid); err != nil {
+		t.Errorf("valid request: %v", err)
+	}
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	b := testRequest().Marshal()
+	// cutting any length delimited field short fails, the last field is the last metadata
+	for _, n := range []int{1, 2, 10} {
+		if err := (&WriteRequest{}).Unmarshal(b[:len(b)-n]); err == nil {
+			t.Errorf("truncated by %d bytes: no error", n)
+		}
+	}
+	// the first series is cut inside its labels
+	if err := (&WriteRequest{}).Unmarshal(b[:6]); err == nil {
+		t.Error("truncated series: no error")
+	}
+}
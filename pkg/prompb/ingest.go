@@ -0,0 +1,115 @@
+package prompb
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// IngestWriteMethod is the bidirectional streaming method of deepflow ingesters receiving remote writes:
+//
+//	service Ingest {
+//	  rpc Write(stream IngestRequest) returns (stream IngestResponse);
+//	}
+//
+// Every request is acknowledged by a response with the same id, responses may arrive out of order
+const IngestWriteMethod = "/deepflow.adapter.v1.Ingest/Write"
+
+// IngestRequest is a compressed remote write payload:
+//
+//	message IngestRequest {
+//	  uint64 id = 1;
+//	  string protobuf_message = 2;
+//	  string content_encoding = 3;
+//	  bytes payload = 4;
+//	  map<string, string> headers = 5;
+//	}
+type IngestRequest struct {
+	Id              uint64
+	ProtobufMessage string
+	ContentEncoding string
+	Payload         []byte
+	// Headers are outbound headers of the write, multiple values are joined with comma
+	Headers map[string]string
+}
+
+// IngestResponse acknowledges an IngestRequest, Status is a http status code:
+//
+//	message IngestResponse {
+//	  uint64 id = 1;
+//	  int32 status = 2;
+//	  string message = 3;
+//	}
+type IngestResponse struct {
+	Id      uint64
+	Status  int32
+	Message string
+}
+
+// Marshal encodes the request into protobuf wire format
+func (m *IngestRequest) Marshal() []byte {
+	b := appendVarint(nil, 1, m.Id)
+	b = appendString(b, 2, m.ProtobufMessage)
+	b = appendString(b, 3, m.ContentEncoding)
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	b = protowire.AppendBytes(b, m.Payload)
+	// map entries are sorted, so that equal requests are encoded the same
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b = appendMessage(b, 5, (&Label{Name: name, Value: m.Headers[name]}).marshal(nil))
+	}
+	return b
+}
+
+// Unmarshal decodes protobuf wire format, unknown fields are skipped
+func (m *IngestRequest) Unmarshal(b []byte) error {
+	*m = IngestRequest{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			m.Id = varint(v)
+		case 2:
+			m.ProtobufMessage = string(v)
+		case 3:
+			m.ContentEncoding = string(v)
+		case 4:
+			m.Payload = append([]byte(nil), v...)
+		case 5:
+			// map entry has the same encoding as a label
+			var entry Label
+			if err := entry.unmarshal(v); err != nil {
+				return err
+			}
+			if m.Headers == nil {
+				m.Headers = map[string]string{}
+			}
+			m.Headers[entry.Name] = entry.Value
+		}
+		return nil
+	})
+}
+
+func (m *IngestResponse) Marshal() []byte {
+	b := appendVarint(nil, 1, m.Id)
+	b = appendVarint(b, 2, uint64(m.Status))
+	return appendString(b, 3, m.Message)
+}
+
+func (m *IngestResponse) Unmarshal(b []byte) error {
+	*m = IngestResponse{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			m.Id = varint(v)
+		case 2:
+			m.Status = int32(varint(v))
+		case 3:
+			m.Message = string(v)
+		}
+		return nil
+	})
+}
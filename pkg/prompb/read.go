@@ -0,0 +1,290 @@
+package prompb
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ProtoMsgChunkedReadResponse is the proto parameter of streamed remote read responses
+const ProtoMsgChunkedReadResponse = "prometheus.ChunkedReadResponse"
+
+// ResponseType is the remote read response type client accepts
+type ResponseType int32
+
+const (
+	// ResponseTypeSamples is a snappy compressed ReadResponse with raw samples
+	ResponseTypeSamples ResponseType = iota
+	// ResponseTypeStreamedXORChunks is a stream of ChunkedReadResponse frames with XOR encoded chunks
+	ResponseTypeStreamedXORChunks
+)
+
+type ReadRequest struct {
+	Queries []Query
+	// AcceptedResponseTypes are in order of preference, empty means samples only
+	AcceptedResponseTypes []ResponseType
+}
+
+// Query selects series matching all Matchers in [StartTimestampMs, EndTimestampMs], hints are ignored
+type Query struct {
+	StartTimestampMs int64
+	EndTimestampMs   int64
+	Matchers         []LabelMatcher
+}
+
+type MatchType int32
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+// String returns the promql operator of the match type
+func (t MatchType) String() string {
+	switch t {
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	default:
+		return "="
+	}
+}
+
+type LabelMatcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+}
+
+type ReadResponse struct {
+	// Results are in the same order as queries of request
+	Results []QueryResult
+}
+
+type QueryResult struct {
+	Timeseries []TimeSeries
+}
+
+// ChunkedReadResponse is a frame of streamed response, series of a query may span several frames
+type ChunkedReadResponse struct {
+	ChunkedSeries []ChunkedSeries
+	QueryIndex    int64
+}
+
+type ChunkedSeries struct {
+	// Labels are sorted by name
+	Labels []Label
+	// Chunks are sorted by time and don't overlap
+	Chunks []Chunk
+}
+
+type ChunkEncoding int32
+
+const (
+	ChunkEncodingUnknown ChunkEncoding = iota
+	ChunkEncodingXOR
+)
+
+type Chunk struct {
+	MinTimeMs int64
+	MaxTimeMs int64
+	Type      ChunkEncoding
+	Data      []byte
+}
+
+// Unmarshal decodes protobuf wire format, unknown fields are skipped
+func (m *ReadRequest) Unmarshal(b []byte) error {
+	*m = ReadRequest{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			var q Query
+			if err := q.unmarshal(v); err != nil {
+				return err
+			}
+			m.Queries = append(m.Queries, q)
+		case 2:
+			types, err := unpackUint32(nil, typ, v)
+			if err != nil {
+				return err
+			}
+			for _, t := range types {
+				m.AcceptedResponseTypes = append(m.AcceptedResponseTypes, ResponseType(t))
+			}
+		}
+		return nil
+	})
+}
+
+// Marshal encodes the request into protobuf wire format
+func (m *ReadRequest) Marshal() []byte {
+	var b []byte
+	for i := range m.Queries {
+		b = appendMessage(b, 1, m.Queries[i].marshal(nil))
+	}
+	types := make([]uint32, 0, len(m.AcceptedResponseTypes))
+	for _, t := range m.AcceptedResponseTypes {
+		types = append(types, uint32(t))
+	}
+	return appendPackedUint32(b, 2, types)
+}
+
+func (m *Query) marshal(b []byte) []byte {
+	b = appendVarint(b, 1, uint64(m.StartTimestampMs))
+	b = appendVarint(b, 2, uint64(m.EndTimestampMs))
+	for i := range m.Matchers {
+		var mb []byte
+		mb = appendVarint(mb, 1, uint64(m.Matchers[i].Type))
+		mb = appendString(mb, 2, m.Matchers[i].Name)
+		mb = appendString(mb, 3, m.Matchers[i].Value)
+		b = appendMessage(b, 3, mb)
+	}
+	return b
+}
+
+func (m *Query) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			m.StartTimestampMs = int64(varint(v))
+		case 2:
+			m.EndTimestampMs = int64(varint(v))
+		case 3:
+			var lm LabelMatcher
+			if err := lm.unmarshal(v); err != nil {
+				return err
+			}
+			m.Matchers = append(m.Matchers, lm)
+		}
+		return nil
+	})
+}
+
+func (m *LabelMatcher) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			m.Type = MatchType(varint(v))
+		case 2:
+			m.Name = string(v)
+		case 3:
+			m.Value = string(v)
+		}
+		return nil
+	})
+}
+
+// Marshal encodes the response into protobuf wire format
+func (m *ReadResponse) Marshal() []byte {
+	var b []byte
+	for i := range m.Results {
+		var rb []byte
+		for j := range m.Results[i].Timeseries {
+			rb = appendMessage(rb, 1, m.Results[i].Timeseries[j].marshal(nil))
+		}
+		b = appendMessage(b, 1, rb)
+	}
+	return b
+}
+
+// Unmarshal decodes protobuf wire format, unknown fields are skipped
+func (m *ReadResponse) Unmarshal(b []byte) error {
+	*m = ReadResponse{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var r QueryResult
+		err := walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			if num != 1 {
+				return nil
+			}
+			var ts TimeSeries
+			if err := ts.unmarshal(v); err != nil {
+				return err
+			}
+			r.Timeseries = append(r.Timeseries, ts)
+			return nil
+		})
+		m.Results = append(m.Results, r)
+		return err
+	})
+}
+
+// Marshal encodes the frame into protobuf wire format
+func (m *ChunkedReadResponse) Marshal() []byte {
+	var b []byte
+	for i := range m.ChunkedSeries {
+		s := &m.ChunkedSeries[i]
+		var sb []byte
+		for j := range s.Labels {
+			sb = appendMessage(sb, 1, s.Labels[j].marshal(nil))
+		}
+		for j := range s.Chunks {
+			c := &s.Chunks[j]
+			var cb []byte
+			cb = appendVarint(cb, 1, uint64(c.MinTimeMs))
+			cb = appendVarint(cb, 2, uint64(c.MaxTimeMs))
+			cb = appendVarint(cb, 3, uint64(c.Type))
+			cb = protowire.AppendTag(cb, 4, protowire.BytesType)
+			cb = protowire.AppendBytes(cb, c.Data)
+			sb = appendMessage(sb, 2, cb)
+		}
+		b = appendMessage(b, 1, sb)
+	}
+	return appendVarint(b, 2, uint64(m.QueryIndex))
+}
+
+// Unmarshal decodes protobuf wire format, unknown fields are skipped
+func (m *ChunkedReadResponse) Unmarshal(b []byte) error {
+	*m = ChunkedReadResponse{}
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			var s ChunkedSeries
+			if err := s.unmarshal(v); err != nil {
+				return err
+			}
+			m.ChunkedSeries = append(m.ChunkedSeries, s)
+		case 2:
+			m.QueryIndex = int64(varint(v))
+		}
+		return nil
+	})
+}
+
+func (m *ChunkedSeries) unmarshal(b []byte) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			var l Label
+			if err := l.unmarshal(v); err != nil {
+				return err
+			}
+			m.Labels = append(m.Labels, l)
+		case 2:
+			var c Chunk
+			err := walk(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch num {
+				case 1:
+					c.MinTimeMs = int64(varint(v))
+				case 2:
+					c.MaxTimeMs = int64(varint(v))
+				case 3:
+					c.Type = ChunkEncoding(varint(v))
+				case 4:
+					c.Data = append([]byte(nil), v...)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			m.Chunks = append(m.Chunks, c)
+		}
+		return nil
+	})
+}
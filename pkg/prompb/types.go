@@ -0,0 +1,133 @@
+// Package prompb holds prometheus remote write messages, they're wire compatible with
+// prometheus/prompb(remote write 1.0) and io.prometheus.write.v2(remote write 2.0), and only
+// implement what the adapter needs to inspect and rewrite payloads.
+// Both versions are decoded into the same WriteRequest, fields only in 2.0 are lost when it's encoded in 1.0
+package prompb
+
+// protobuf message names of remote write versions, they're the proto parameter of content type
+const (
+	ProtoMsgV1 = "prometheus.WriteRequest"
+	ProtoMsgV2 = "io.prometheus.write.v2.Request"
+)
+
+type WriteRequest struct {
+	Timeseries []TimeSeries
+	Metadata   []MetricMetadata
+}
+
+type MetricType int32
+
+const (
+	MetricTypeUnknown MetricType = iota
+	MetricTypeCounter
+	MetricTypeGauge
+	MetricTypeHistogram
+	MetricTypeGaugeHistogram
+	MetricTypeSummary
+	MetricTypeInfo
+	MetricTypeStateset
+)
+
+var metricTypeNames = []string{"unknown", "counter", "gauge", "histogram", "gaugehistogram", "summary", "info", "stateset"}
+
+func (t MetricType) String() string {
+	if t < 0 || int(t) >= len(metricTypeNames) {
+		return metricTypeNames[0]
+	}
+	return metricTypeNames[t]
+}
+
+type MetricMetadata struct {
+	Type             MetricType
+	MetricFamilyName string
+	Help             string
+	Unit             string
+}
+
+type TimeSeries struct {
+	// Labels are sorted by name
+	Labels     []Label
+	Samples    []Sample
+	Exemplars  []Exemplar
+	Histograms []Histogram
+
+	// Metadata of the series, MetricFamilyName is not used, remote write 2.0 only
+	Metadata MetricMetadata
+	// CreatedTimestamp is in milliseconds, 0 means unknown, remote write 2.0 only
+	CreatedTimestamp int64
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+type Exemplar struct {
+	Labels    []Label
+	Value     float64
+	Timestamp int64
+}
+
+type ResetHint int32
+
+const (
+	ResetHintUnknown ResetHint = iota
+	ResetHintYes
+	ResetHintNo
+	ResetHintGauge
+)
+
+// Histogram is a native histogram, counts are integers unless Float is set, in which case
+// CountFloat, ZeroCountFloat and PositiveCounts/NegativeCounts are used instead
+type Histogram struct {
+	Float          bool
+	CountInt       uint64
+	CountFloat     float64
+	Sum            float64
+	Schema         int32
+	ZeroThreshold  float64
+	ZeroCountInt   uint64
+	ZeroCountFloat float64
+
+	NegativeSpans  []BucketSpan
+	NegativeDeltas []int64
+	NegativeCounts []float64
+	PositiveSpans  []BucketSpan
+	PositiveDeltas []int64
+	PositiveCounts []float64
+
+	ResetHint ResetHint
+	Timestamp int64
+	// CustomValues are bucket bounds of custom buckets schema
+	CustomValues []float64
+}
+
+type BucketSpan struct {
+	Offset int32
+	Length uint32
+}
+
+// MetricName returns value of __name__
+func (ts *TimeSeries) MetricName() string {
+	return LabelValue(ts.Labels, MetricNameLabel)
+}
+
+const MetricNameLabel = "__name__"
+
+// CustomBucketsSchema is the schema of histograms with custom bucket bounds
+const CustomBucketsSchema = -53
+
+// LabelValue returns value of the named label, empty if it's absent
+func LabelValue(labels []Label, name string) string {
+	for _, l := range labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,269 @@
+package prompb
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MarshalV2 encodes the request as io.prometheus.write.v2.Request, metadata of series without
+// its own metadata is looked up from request metadata by metric family name
+func (m *WriteRequest) MarshalV2() []byte {
+	st := newSymbolTable()
+	families := make(map[string]*MetricMetadata, len(m.Metadata))
+	for i := range m.Metadata {
+		families[m.Metadata[i].MetricFamilyName] = &m.Metadata[i]
+	}
+
+	var series []byte
+	for i := range m.Timeseries {
+		ts := &m.Timeseries[i]
+		md := ts.Metadata
+		if md.isEmpty() {
+			name := ts.MetricName()
+			if family, ok := families[name]; ok {
+				md = *family
+			} else if family, ok := families[TrimTypeSuffix(name)]; ok {
+				md = *family
+			}
+		}
+		series = appendMessage(series, 5, ts.marshalV2(nil, st, &md))
+	}
+
+	var b []byte
+	for _, s := range st.symbols {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	return append(b, series...)
+}
+
+// UnmarshalV2 decodes io.prometheus.write.v2.Request, request metadata is derived from series
+// metadata so that it can be encoded in remote write 1.0
+func (m *WriteRequest) UnmarshalV2(b []byte) error {
+	*m = WriteRequest{}
+	// symbols are not necessarily in front of series
+	var symbols []string
+	var series [][]byte
+	err := walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 4:
+			symbols = append(symbols, string(v))
+		case 5:
+			series = append(series, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	families := map[string]bool{}
+	for _, v := range series {
+		var ts TimeSeries
+		if err := ts.unmarshalV2(v, symbols); err != nil {
+			return err
+		}
+		m.Timeseries = append(m.Timeseries, ts)
+
+		if ts.Metadata.isEmpty() {
+			continue
+		}
+		family := ts.MetricName()
+		if ts.Metadata.Type == MetricTypeHistogram || ts.Metadata.Type == MetricTypeSummary || ts.Metadata.Type == MetricTypeGaugeHistogram {
+			family = TrimTypeSuffix(family)
+		}
+		if families[family] {
+			continue
+		}
+		families[family] = true
+		md := ts.Metadata
+		md.MetricFamilyName = family
+		m.Metadata = append(m.Metadata, md)
+	}
+	return nil
+}
+
+func (m *MetricMetadata) isEmpty() bool {
+	return m.Type == MetricTypeUnknown && m.Help == "" && m.Unit == ""
+}
+
+// TrimTypeSuffix returns metric family name of series, it trims suffixes of classic histogram, summary and counter series
+func TrimTypeSuffix(name string) string {
+	for _, suffix := range []string{"_bucket", "_count", "_sum", "_total", "_created"} {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return name
+}
+
+func (m *TimeSeries) marshalV2(b []byte, st *symbolTable, md *MetricMetadata) []byte {
+	b = appendPackedUint32(b, 1, st.refs(m.Labels))
+	for i := range m.Samples {
+		b = appendMessage(b, 2, m.Samples[i].marshal(nil))
+	}
+	for i := range m.Histograms {
+		b = appendMessage(b, 3, m.Histograms[i].marshal(nil))
+	}
+	for i := range m.Exemplars {
+		e := &m.Exemplars[i]
+		var eb []byte
+		eb = appendPackedUint32(eb, 1, st.refs(e.Labels))
+		eb = appendDouble(eb, 2, e.Value)
+		eb = appendVarint(eb, 3, uint64(e.Timestamp))
+		b = appendMessage(b, 4, eb)
+	}
+	if !md.isEmpty() {
+		var mb []byte
+		mb = appendVarint(mb, 1, uint64(md.Type))
+		mb = appendVarint(mb, 3, uint64(st.ref(md.Help)))
+		mb = appendVarint(mb, 4, uint64(st.ref(md.Unit)))
+		b = appendMessage(b, 5, mb)
+	}
+	b = appendVarint(b, 6, uint64(m.CreatedTimestamp))
+	return b
+}
+
+func (m *TimeSeries) unmarshalV2(b []byte, symbols []string) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		var err error
+		switch num {
+		case 1:
+			var refs []uint32
+			if refs, err = unpackUint32(nil, typ, v); err == nil {
+				m.Labels, err = resolveLabels(m.Labels, refs, symbols)
+			}
+		case 2:
+			var s Sample
+			err = s.unmarshal(v)
+			m.Samples = append(m.Samples, s)
+		case 3:
+			var h Histogram
+			err = h.unmarshal(v)
+			m.Histograms = append(m.Histograms, h)
+		case 4:
+			var e Exemplar
+			err = e.unmarshalV2(v, symbols)
+			m.Exemplars = append(m.Exemplars, e)
+		case 5:
+			err = m.Metadata.unmarshalV2(v, symbols)
+		case 6:
+			m.CreatedTimestamp = int64(varint(v))
+		}
+		return err
+	})
+}
+
+func (m *Exemplar) unmarshalV2(b []byte, symbols []string) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		var err error
+		switch num {
+		case 1:
+			var refs []uint32
+			if refs, err = unpackUint32(nil, typ, v); err == nil {
+				m.Labels, err = resolveLabels(m.Labels, refs, symbols)
+			}
+		case 2:
+			m.Value = double(v)
+		case 3:
+			m.Timestamp = int64(varint(v))
+		}
+		return err
+	})
+}
+
+func (m *MetricMetadata) unmarshalV2(b []byte, symbols []string) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		var err error
+		switch num {
+		case 1:
+			m.Type = MetricType(varint(v))
+		case 3:
+			m.Help, err = symbol(symbols, uint32(varint(v)))
+		case 4:
+			m.Unit, err = symbol(symbols, uint32(varint(v)))
+		}
+		return err
+	})
+}
+
+func resolveLabels(dst []Label, refs []uint32, symbols []string) ([]Label, error) {
+	if len(refs)%2 != 0 {
+		return nil, fmt.Errorf("odd number of label refs: %d", len(refs))
+	}
+	for i := 0; i < len(refs); i += 2 {
+		name, err := symbol(symbols, refs[i])
+		if err != nil {
+			return nil, err
+		}
+		value, err := symbol(symbols, refs[i+1])
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, Label{Name: name, Value: value})
+	}
+	return dst, nil
+}
+
+func symbol(symbols []string, ref uint32) (string, error) {
+	if int(ref) >= len(symbols) {
+		return "", fmt.Errorf("symbol ref %d out of range %d", ref, len(symbols))
+	}
+	return symbols[ref], nil
+}
+
+// symbolTable interns strings, the first symbol is always empty string as the spec requires
+type symbolTable struct {
+	symbols []string
+	index   map[string]uint32
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{symbols: []string{""}, index: map[string]uint32{"": 0}}
+}
+
+func (t *symbolTable) ref(s string) uint32 {
+	if ref, ok := t.index[s]; ok {
+		return ref
+	}
+	ref := uint32(len(t.symbols))
+	t.symbols = append(t.symbols, s)
+	t.index[s] = ref
+	return ref
+}
+
+func (t *symbolTable) refs(labels []Label) []uint32 {
+	refs := make([]uint32, 0, 2*len(labels))
+	for _, l := range labels {
+		refs = append(refs, t.ref(l.Name), t.ref(l.Value))
+	}
+	return refs
+}
+
+func unpackUint32(dst []uint32, typ protowire.Type, v []byte) ([]uint32, error) {
+	if typ != protowire.BytesType {
+		return append(dst, uint32(varint(v))), nil
+	}
+	for len(v) > 0 {
+		x, n := protowire.ConsumeVarint(v)
+		if n < 0 {
+			return dst, protowire.ParseError(n)
+		}
+		dst = append(dst, uint32(x))
+		v = v[n:]
+	}
+	return dst, nil
+}
+
+func appendPackedUint32(b []byte, num protowire.Number, v []uint32) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, x := range v {
+		packed = protowire.AppendVarint(packed, uint64(x))
+	}
+	return appendMessage(b, num, packed)
+}
@@ -0,0 +1,207 @@
+// Package querier queries raw samples from prometheus compatible api of deepflow querier
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+	"prometheus-deepflow-adapter/pkg/utils"
+)
+
+// ErrTooManySamples is returned when a query returns more samples than the limit
+var ErrTooManySamples = errors.New("too many samples")
+
+// Error is a failed response of querier
+type Error struct {
+	StatusCode int
+	ErrorType  string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("querier responded %d %s: %s", e.StatusCode, e.ErrorType, e.Message)
+}
+
+type Client struct {
+	url        string
+	client     *http.Client
+	maxSamples int
+}
+
+func New(conf *config.RemoteReadConfig) (*Client, error) {
+	tlsConfig, err := utils.NewTLSConfig(&conf.TLSConfig, conf.Insecure)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &Client{
+		url: strings.TrimSuffix(conf.Url, "/") + "/api/v1/query",
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   conf.Timeout,
+		},
+		maxSamples: conf.MaxSamplesPerQuery,
+	}, nil
+}
+
+// Select returns raw samples of series matching the query, series are sorted by labels.
+// The query is evaluated as a range vector selector at its end timestamp
+func (c *Client) Select(ctx context.Context, q *prompb.Query) ([]prompb.TimeSeries, error) {
+	if q.EndTimestampMs < q.StartTimestampMs {
+		return nil, nil
+	}
+	// range selector is (end - range, end], it covers start with one more millisecond
+	promql := fmt.Sprintf("%s[%dms]", Selector(q.Matchers), q.EndTimestampMs-q.StartTimestampMs+1)
+	form := url.Values{
+		"query": {promql},
+		"time":  {strconv.FormatFloat(float64(q.EndTimestampMs)/1000, 'f', 3, 64)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result apiResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		if resp.StatusCode/100 != 2 {
+			return nil, &Error{StatusCode: resp.StatusCode, Message: string(body)}
+		}
+		return nil, fmt.Errorf("decode querier response failed: %w", err)
+	}
+	if result.Status != "success" {
+		return nil, &Error{StatusCode: resp.StatusCode, ErrorType: result.ErrorType, Message: result.Error}
+	}
+	if result.Data.ResultType != "matrix" {
+		return nil, fmt.Errorf("unexpected querier result type %q", result.Data.ResultType)
+	}
+	return c.toTimeSeries(result.Data.Result, q)
+}
+
+type apiResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string         `json:"resultType"`
+		Result     []sampleStream `json:"result"`
+	} `json:"data"`
+}
+
+type sampleStream struct {
+	Metric map[string]string `json:"metric"`
+	// Values are [unix seconds, "value"] pairs
+	Values [][2]json.RawMessage `json:"values"`
+}
+
+func (c *Client) toTimeSeries(streams []sampleStream, q *prompb.Query) ([]prompb.TimeSeries, error) {
+	series := make([]prompb.TimeSeries, 0, len(streams))
+	total := 0
+	for _, stream := range streams {
+		ts := prompb.TimeSeries{Labels: make([]prompb.Label, 0, len(stream.Metric))}
+		for name, value := range stream.Metric {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: name, Value: value})
+		}
+		sort.Slice(ts.Labels, func(i, j int) bool { return ts.Labels[i].Name < ts.Labels[j].Name })
+
+		for _, pair := range stream.Values {
+			s, err := parseSample(pair)
+			if err != nil {
+				return nil, err
+			}
+			if s.Timestamp < q.StartTimestampMs || s.Timestamp > q.EndTimestampMs {
+				continue
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+		if len(ts.Samples) == 0 {
+			continue
+		}
+		total += len(ts.Samples)
+		if c.maxSamples > 0 && total > c.maxSamples {
+			return nil, fmt.Errorf("%w: query returned more than %d samples", ErrTooManySamples, c.maxSamples)
+		}
+		series = append(series, ts)
+	}
+	sort.Slice(series, func(i, j int) bool { return lessLabels(series[i].Labels, series[j].Labels) })
+	return series, nil
+}
+
+func parseSample(pair [2]json.RawMessage) (prompb.Sample, error) {
+	var seconds json.Number
+	if err := json.Unmarshal(pair[0], &seconds); err != nil {
+		return prompb.Sample{}, fmt.Errorf("invalid sample timestamp %s: %w", pair[0], err)
+	}
+	var value string
+	if err := json.Unmarshal(pair[1], &value); err != nil {
+		return prompb.Sample{}, fmt.Errorf("invalid sample value %s: %w", pair[1], err)
+	}
+	ts, err := parseMillis(seconds.String())
+	if err != nil {
+		return prompb.Sample{}, err
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return prompb.Sample{}, fmt.Errorf("invalid sample value %q: %w", value, err)
+	}
+	return prompb.Sample{Timestamp: ts, Value: v}, nil
+}
+
+// parseMillis parses decimal unix seconds into milliseconds without float rounding errors
+func parseMillis(s string) (int64, error) {
+	sec, frac, _ := strings.Cut(s, ".")
+	ms, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sample timestamp %q: %w", s, err)
+	}
+	frac = (frac + "000")[:3]
+	f, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sample timestamp %q: %w", s, err)
+	}
+	if strings.HasPrefix(sec, "-") {
+		f = -f
+	}
+	return ms*1000 + f, nil
+}
+
+// Selector returns promql vector selector of the matchers
+func Selector(matchers []prompb.LabelMatcher) string {
+	parts := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		parts = append(parts, m.Name+m.Type.String()+strconv.Quote(m.Value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func lessLabels(a, b []prompb.Label) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].Name != b[i].Name {
+			return a[i].Name < b[i].Name
+		}
+		if a[i].Value != b[i].Value {
+			return a[i].Value < b[i].Value
+		}
+	}
+	return len(a) < len(b)
+}
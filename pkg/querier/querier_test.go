@@ -0,0 +1,207 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+func TestSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		matchers []prompb.LabelMatcher
+		want     string
+	}{
+		{name: "no matchers", want: "{}"},
+		{
+			name:     "equal",
+			matchers: []prompb.LabelMatcher{{Type: prompb.MatchEqual, Name: "__name__", Value: "up"}},
+			want:     `{__name__="up"}`,
+		},
+		{
+			name:     "not equal",
+			matchers: []prompb.LabelMatcher{{Type: prompb.MatchNotEqual, Name: "job", Value: ""}},
+			want:     `{job!=""}`,
+		},
+		{
+			name:     "regexp",
+			matchers: []prompb.LabelMatcher{{Type: prompb.MatchRegexp, Name: "instance", Value: "10\\.0\\..*:9100"}},
+			want:     `{instance=~"10\\.0\\..*:9100"}`,
+		},
+		{
+			name:     "not regexp",
+			matchers: []prompb.LabelMatcher{{Type: prompb.MatchNotRegexp, Name: "mode", Value: "idle|iowait"}},
+			want:     `{mode!~"idle|iowait"}`,
+		},
+		{
+			name: "multiple matchers in order",
+			matchers: []prompb.LabelMatcher{
+				{Type: prompb.MatchEqual, Name: "__name__", Value: "node_cpu_seconds_total"},
+				{Type: prompb.MatchNotRegexp, Name: "mode", Value: "idle"},
+				{Type: prompb.MatchNotEqual, Name: "cpu", Value: "0"},
+			},
+			want: `{__name__="node_cpu_seconds_total",mode!~"idle",cpu!="0"}`,
+		},
+		{
+			name:     "values are escaped",
+			matchers: []prompb.LabelMatcher{{Type: prompb.MatchEqual, Name: "path", Value: "C:\\dir \"a\"\n"}},
+			want:     `{path="C:\\dir \"a\"\n"}`,
+		},
+		{
+			name:     "unknown type is equal",
+			matchers: []prompb.LabelMatcher{{Type: prompb.MatchType(9), Name: "job", Value: "node"}},
+			want:     `{job="node"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Selector(tt.matchers); got != tt.want {
+				t.Errorf("Selector() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMillis(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{in: "1700000000", want: 1700000000000},
+		{in: "1700000000.1", want: 1700000000100},
+		{in: "1700000000.123", want: 1700000000123},
+		{in: "1700000000.1239", want: 1700000000123},
+		{in: "-1.5", want: -1500},
+		{in: "abc", err: true},
+		{in: "1.x", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMillis(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseMillis(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func newTestClient(t *testing.T, maxSamples int, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := New(&config.RemoteReadConfig{Url: srv.URL + "/prom/", MaxSamplesPerQuery: maxSamples})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSelect(t *testing.T) {
+	var form map[string][]string
+	c := newTestClient(t, 0, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/prom/api/v1/query" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"job":"node","__name__":"up","instance":"b"},"values":[[999.999,"1"],[1000,"1"],[1000.5,"0"],[1002,"1"]]},
+			{"metric":{"__name__":"up","job":"node","instance":"a"},"values":[[1001,"NaN"]]},
+			{"metric":{"__name__":"up","job":"node","instance":"c"},"values":[[1003,"1"]]}
+		]}}`))
+	})
+
+	q := &prompb.Query{
+		StartTimestampMs: 1000000,
+		EndTimestampMs:   1002000,
+		Matchers:         []prompb.LabelMatcher{{Type: prompb.MatchEqual, Name: "__name__", Value: "up"}},
+	}
+	series, err := c.Select(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := form["query"]; !reflect.DeepEqual(got, []string{`{__name__="up"}[2001ms]`}) {
+		t.Errorf("query = %v", got)
+	}
+	if got := form["time"]; !reflect.DeepEqual(got, []string{"1002.000"}) {
+		t.Errorf("time = %v", got)
+	}
+
+	// samples out of the query range and series without samples are dropped, series are sorted by labels
+	if len(series) != 2 {
+		t.Fatalf("got %d series, want 2: %v", len(series), series)
+	}
+	wantLabels := []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "a"}, {Name: "job", Value: "node"}}
+	if !reflect.DeepEqual(series[0].Labels, wantLabels) {
+		t.Errorf("labels = %v, want %v", series[0].Labels, wantLabels)
+	}
+	if !math.IsNaN(series[0].Samples[0].Value) {
+		t.Errorf("value = %v, want NaN", series[0].Samples[0].Value)
+	}
+	wantSamples := []prompb.Sample{{Timestamp: 1000000, Value: 1}, {Timestamp: 1000500, Value: 0}, {Timestamp: 1002000, Value: 1}}
+	if !reflect.DeepEqual(series[1].Samples, wantSamples) {
+		t.Errorf("samples = %v, want %v", series[1].Samples, wantSamples)
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	matrix := `{"status":"success","data":{"resultType":"matrix","result":[
+		{"metric":{"__name__":"up"},"values":[[1,"1"],[2,"1"],[3,"1"]]}]}}`
+	tests := []struct {
+		name       string
+		maxSamples int
+		status     int
+		body       string
+		check      func(err error) bool
+	}{
+		{
+			name:       "too many samples",
+			maxSamples: 2,
+			body:       matrix,
+			check:      func(err error) bool { return errors.Is(err, ErrTooManySamples) },
+		},
+		{
+			name:   "querier error",
+			status: http.StatusUnprocessableEntity,
+			body:   `{"status":"error","errorType":"execution","error":"query timed out"}`,
+			check: func(err error) bool {
+				var qe *Error
+				return errors.As(err, &qe) && qe.StatusCode == http.StatusUnprocessableEntity && qe.ErrorType == "execution"
+			},
+		},
+		{
+			name:   "non json error",
+			status: http.StatusBadGateway,
+			body:   "bad gateway",
+			check: func(err error) bool {
+				var qe *Error
+				return errors.As(err, &qe) && qe.StatusCode == http.StatusBadGateway && qe.Message == "bad gateway"
+			},
+		},
+		{
+			name:  "unexpected result type",
+			body:  `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			check: func(err error) bool { return err != nil },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, tt.maxSamples, func(w http.ResponseWriter, r *http.Request) {
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				w.Write([]byte(tt.body))
+			})
+			_, err := c.Select(context.Background(), &prompb.Query{StartTimestampMs: 0, EndTimestampMs: 5000})
+			if !tt.check(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
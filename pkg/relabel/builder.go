@@ -0,0 +1,70 @@
+package relabel
+
+import (
+	"sort"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// builder is a copy-on-write label set, label sets are small so a slice is used instead of a map
+type builder struct {
+	base []prompb.Label
+	ls   []prompb.Label
+	// copied is set once ls doesn't share memory with base
+	copied bool
+}
+
+func newBuilder(base []prompb.Label) *builder {
+	return &builder{base: base, ls: base}
+}
+
+func (b *builder) get(name string) string {
+	return prompb.LabelValue(b.ls, name)
+}
+
+// current returns a snapshot which is safe to iterate while modifying the builder
+func (b *builder) current() []prompb.Label {
+	b.copy()
+	return append([]prompb.Label(nil), b.ls...)
+}
+
+func (b *builder) set(name, value string) {
+	if value == "" {
+		b.del(name)
+		return
+	}
+	b.copy()
+	for i := range b.ls {
+		if b.ls[i].Name == name {
+			b.ls[i].Value = value
+			return
+		}
+	}
+	b.ls = append(b.ls, prompb.Label{Name: name, Value: value})
+}
+
+func (b *builder) del(name string) {
+	for i := range b.ls {
+		if b.ls[i].Name == name {
+			b.copy()
+			b.ls = append(b.ls[:i], b.ls[i+1:]...)
+			return
+		}
+	}
+}
+
+func (b *builder) copy() {
+	if b.copied {
+		return
+	}
+	b.ls = append(make([]prompb.Label, 0, len(b.base)+2), b.base...)
+	b.copied = true
+}
+
+func (b *builder) labels() []prompb.Label {
+	if !b.copied {
+		return b.base
+	}
+	sort.Slice(b.ls, func(i, j int) bool { return b.ls[i].Name < b.ls[j].Name })
+	return b.ls
+}
@@ -0,0 +1,281 @@
+// Package relabel implements prometheus relabel_config semantics on remote write series
+package relabel
+
+import (
+	"crypto/md5"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+type Action string
+
+const (
+	Replace   Action = "replace"
+	Keep      Action = "keep"
+	Drop      Action = "drop"
+	KeepEqual Action = "keepequal"
+	DropEqual Action = "dropequal"
+	HashMod   Action = "hashmod"
+	LabelMap  Action = "labelmap"
+	LabelDrop Action = "labeldrop"
+	LabelKeep Action = "labelkeep"
+	Lowercase Action = "lowercase"
+	Uppercase Action = "uppercase"
+)
+
+var actions = []Action{Replace, Keep, Drop, KeepEqual, DropEqual, HashMod, LabelMap, LabelDrop, LabelKeep, Lowercase, Uppercase}
+
+const (
+	defaultSeparator   = ";"
+	defaultRegex       = "(.*)"
+	defaultReplacement = "$1"
+)
+
+var (
+	labelNameRE      = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	relabelTargetRE  = regexp.MustCompile(`^(?:(?:[a-zA-Z_]|\$(?:\{\w+\}|\w+))+\w*)+$`)
+	defaultRegexComp = mustNewRegexp(defaultRegex)
+)
+
+// Config is the prometheus relabel_config with kebab-case keys, Separator, Regex and Replacement are pointers
+// since empty string is a valid value for them, e.g. an empty regex matches empty values only
+type Config struct {
+	SourceLabels []string `mapstructure:"source-labels" json:"source-labels,omitempty"`
+	Separator    *string  `mapstructure:"separator" json:"separator,omitempty"`
+	Regex        *string  `mapstructure:"regex" json:"regex,omitempty"`
+	Modulus      uint64   `mapstructure:"modulus" json:"modulus,omitempty"`
+	TargetLabel  string   `mapstructure:"target-label" json:"target-label,omitempty"`
+	Replacement  *string  `mapstructure:"replacement" json:"replacement,omitempty"`
+	Action       Action   `mapstructure:"action" json:"action,omitempty"`
+}
+
+func (c *Config) action() Action {
+	if c.Action == "" {
+		return Replace
+	}
+	return Action(strings.ToLower(string(c.Action)))
+}
+
+// Validate follows the checks of prometheus relabel_config unmarshalling
+func (c *Config) Validate() error {
+	action := c.action()
+	if !containsAction(action) {
+		return fmt.Errorf("unknown relabel action %q", c.Action)
+	}
+	if _, err := newRegexp(c.regex()); err != nil {
+		return fmt.Errorf("invalid regex %q: %w", c.regex(), err)
+	}
+	if action == HashMod && c.Modulus == 0 {
+		return fmt.Errorf("relabel configuration for hashmod requires non-zero modulus")
+	}
+	switch action {
+	case Replace, HashMod, Lowercase, Uppercase, KeepEqual, DropEqual:
+		if c.TargetLabel == "" {
+			return fmt.Errorf("relabel configuration for %s action requires 'target-label' value", action)
+		}
+	}
+	if action == Replace && !relabelTargetRE.MatchString(c.TargetLabel) {
+		return fmt.Errorf("%q is invalid 'target-label' for %s action", c.TargetLabel, action)
+	}
+	switch action {
+	case HashMod, Lowercase, Uppercase, KeepEqual, DropEqual:
+		if !labelNameRE.MatchString(c.TargetLabel) {
+			return fmt.Errorf("%q is invalid 'target-label' for %s action", c.TargetLabel, action)
+		}
+	}
+	if (action == KeepEqual || action == DropEqual) &&
+		(c.Regex != nil || c.Modulus != 0 || c.Separator != nil || c.Replacement != nil) {
+		return fmt.Errorf("%s action requires only 'source-labels' and 'target-label', and no other fields", action)
+	}
+	if action == LabelDrop || action == LabelKeep {
+		if c.SourceLabels != nil || c.TargetLabel != "" || c.Modulus != 0 ||
+			c.separator() != defaultSeparator || c.replacement() != defaultReplacement {
+			return fmt.Errorf("%s action requires only 'regex', and no other fields", action)
+		}
+	}
+	for _, name := range c.SourceLabels {
+		if !labelNameRE.MatchString(name) {
+			return fmt.Errorf("%q is invalid 'source-labels'", name)
+		}
+	}
+	return nil
+}
+
+func (c *Config) regex() string {
+	if c.Regex == nil {
+		return defaultRegex
+	}
+	return *c.Regex
+}
+
+func (c *Config) separator() string {
+	if c.Separator == nil {
+		return defaultSeparator
+	}
+	return *c.Separator
+}
+
+func (c *Config) replacement() string {
+	if c.Replacement == nil {
+		return defaultReplacement
+	}
+	return *c.Replacement
+}
+
+// Rule is a compiled Config
+type Rule struct {
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	modulus      uint64
+	targetLabel  string
+	replacement  string
+	action       Action
+}
+
+// Compile validates and compiles configs, rules are applied in order
+func Compile(configs []Config) ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(configs))
+	for i := range configs {
+		c := &configs[i]
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("relabel config %d: %w", i, err)
+		}
+		re := defaultRegexComp
+		if c.Regex != nil {
+			re = mustNewRegexp(*c.Regex)
+		}
+		rules = append(rules, &Rule{
+			sourceLabels: c.SourceLabels,
+			separator:    c.separator(),
+			regex:        re,
+			modulus:      c.Modulus,
+			targetLabel:  c.TargetLabel,
+			replacement:  c.replacement(),
+			action:       c.action(),
+		})
+	}
+	return rules, nil
+}
+
+// Process applies rules to labels, the input is never modified. It returns the relabeled labels
+// sorted by name and false if the series should be dropped, series without labels are dropped too
+func Process(labels []prompb.Label, rules []*Rule) ([]prompb.Label, bool) {
+	if len(rules) == 0 {
+		return labels, len(labels) > 0
+	}
+	b := newBuilder(labels)
+	for _, rule := range rules {
+		if !rule.apply(b) {
+			return nil, false
+		}
+	}
+	out := b.labels()
+	return out, len(out) > 0
+}
+
+func (r *Rule) apply(b *builder) bool {
+	values := make([]string, 0, len(r.sourceLabels))
+	for _, name := range r.sourceLabels {
+		values = append(values, b.get(name))
+	}
+	val := strings.Join(values, r.separator)
+
+	switch r.action {
+	case Drop:
+		if r.regex.MatchString(val) {
+			return false
+		}
+	case Keep:
+		if !r.regex.MatchString(val) {
+			return false
+		}
+	case DropEqual:
+		if b.get(r.targetLabel) == val {
+			return false
+		}
+	case KeepEqual:
+		if b.get(r.targetLabel) != val {
+			return false
+		}
+	case Replace:
+		indexes := r.regex.FindStringSubmatchIndex(val)
+		// no match, no replacement
+		if indexes == nil {
+			break
+		}
+		target := string(r.regex.ExpandString([]byte{}, r.targetLabel, val, indexes))
+		if !labelNameRE.MatchString(target) {
+			break
+		}
+		res := r.regex.ExpandString([]byte{}, r.replacement, val, indexes)
+		if len(res) == 0 {
+			b.del(target)
+			break
+		}
+		b.set(target, string(res))
+	case Lowercase:
+		b.set(r.targetLabel, strings.ToLower(val))
+	case Uppercase:
+		b.set(r.targetLabel, strings.ToUpper(val))
+	case HashMod:
+		hash := md5.Sum([]byte(val))
+		// use only the last 8 bytes of the hash to give the same result as earlier versions of prometheus
+		mod := sum64(hash) % r.modulus
+		b.set(r.targetLabel, fmt.Sprintf("%d", mod))
+	case LabelMap:
+		for _, l := range b.current() {
+			if r.regex.MatchString(l.Name) {
+				res := r.regex.ReplaceAllString(l.Name, r.replacement)
+				b.set(res, l.Value)
+			}
+		}
+	case LabelDrop:
+		for _, l := range b.current() {
+			if r.regex.MatchString(l.Name) {
+				b.del(l.Name)
+			}
+		}
+	case LabelKeep:
+		for _, l := range b.current() {
+			if !r.regex.MatchString(l.Name) {
+				b.del(l.Name)
+			}
+		}
+	}
+	return true
+}
+
+func sum64(hash [md5.Size]byte) uint64 {
+	var s uint64
+	for i, b := range hash {
+		shift := uint64((md5.Size - i - 1) * 8)
+		s |= uint64(b) << shift
+	}
+	return s
+}
+
+// newRegexp anchors the expression as prometheus does
+func newRegexp(s string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + s + ")$")
+}
+
+func mustNewRegexp(s string) *regexp.Regexp {
+	re, err := newRegexp(s)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+func containsAction(a Action) bool {
+	for _, v := range actions {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
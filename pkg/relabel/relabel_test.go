@@ -0,0 +1,268 @@
+package relabel
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func lbls(kv ...string) []prompb.Label {
+	var out []prompb.Label
+	for i := 0; i < len(kv); i += 2 {
+		out = append(out, prompb.Label{Name: kv[i], Value: kv[i+1]})
+	}
+	return out
+}
+
+// cases mirror TestRelabel of prometheus model/relabel, inputs are sorted like labels of decoded series
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []prompb.Label
+		configs []Config
+		output  []prompb.Label
+		drop    bool
+	}{
+		{
+			name:  "replace with regex groups",
+			input: lbls("a", "foo", "b", "bar", "c", "baz"),
+			configs: []Config{{
+				SourceLabels: []string{"a"},
+				Regex:        strPtr("f(.*)"),
+				TargetLabel:  "d",
+				Replacement:  strPtr("ch${1}-ch${1}"),
+			}},
+			output: lbls("a", "foo", "b", "bar", "c", "baz", "d", "choo-choo"),
+		},
+		{
+			name:  "replace chained with custom separator",
+			input: lbls("a", "foo", "b", "bar", "c", "baz"),
+			configs: []Config{
+				{
+					SourceLabels: []string{"a", "b"},
+					Regex:        strPtr("f(.*);(.*)r"),
+					TargetLabel:  "a",
+					Replacement:  strPtr("b${1}${2}m"),
+				},
+				{
+					SourceLabels: []string{"c", "a"},
+					Regex:        strPtr("(b).*b(.*)ba(.*)"),
+					TargetLabel:  "d",
+					Replacement:  strPtr("$1$2$2$3"),
+				},
+			},
+			output: lbls("a", "boobam", "b", "bar", "c", "baz", "d", "boooom"),
+		},
+		{
+			name:  "replace without match",
+			input: lbls("a", "foo"),
+			configs: []Config{{
+				SourceLabels: []string{"a"},
+				Regex:        strPtr("f"),
+				TargetLabel:  "b",
+				Replacement:  strPtr("bar"),
+			}},
+			output: lbls("a", "foo"),
+		},
+		{
+			name:  "replace with empty replacement deletes the label",
+			input: lbls("a", "foo", "b", "bar"),
+			configs: []Config{{
+				SourceLabels: []string{"a"},
+				Regex:        strPtr("foo"),
+				TargetLabel:  "b",
+				Replacement:  strPtr(""),
+			}},
+			output: lbls("a", "foo"),
+		},
+		{
+			name:  "replace with templated target label",
+			input: lbls("a", "some-name-value"),
+			configs: []Config{{
+				SourceLabels: []string{"a"},
+				Regex:        strPtr("some-([^-]+)-([^,]+)"),
+				TargetLabel:  "${1}",
+				Replacement:  strPtr("${2}"),
+			}},
+			output: lbls("a", "some-name-value", "name", "value"),
+		},
+		{
+			name:  "replace with invalid target label is skipped",
+			input: lbls("a", "some-0-value"),
+			configs: []Config{{
+				SourceLabels: []string{"a"},
+				Regex:        strPtr("some-([^-]+)-([^,]+)"),
+				TargetLabel:  "${1}",
+				Replacement:  strPtr("${2}"),
+			}},
+			output: lbls("a", "some-0-value"),
+		},
+		{
+			name:    "drop",
+			input:   lbls("a", "foo"),
+			configs: []Config{{SourceLabels: []string{"a"}, Regex: strPtr(".*o.*"), Action: Drop}},
+			drop:    true,
+		},
+		{
+			name:    "drop without match",
+			input:   lbls("a", "foo", "b", "bar"),
+			configs: []Config{{SourceLabels: []string{"a"}, Regex: strPtr("f|o"), Action: Drop}},
+			output:  lbls("a", "foo", "b", "bar"),
+		},
+		{
+			name:    "keep without match",
+			input:   lbls("a", "foo"),
+			configs: []Config{{SourceLabels: []string{"a"}, Regex: strPtr("no-match"), Action: Keep}},
+			drop:    true,
+		},
+		{
+			name:    "keep with default regex",
+			input:   lbls("a", "foo"),
+			configs: []Config{{SourceLabels: []string{"a"}, Action: Keep}},
+			output:  lbls("a", "foo"),
+		},
+		{
+			name:    "keep with empty regex matches empty values only",
+			input:   lbls("a", "foo"),
+			configs: []Config{{SourceLabels: []string{"a"}, Regex: strPtr(""), Action: Keep}},
+			drop:    true,
+		},
+		{
+			name:    "keep with empty regex and missing label",
+			input:   lbls("a", "foo"),
+			configs: []Config{{SourceLabels: []string{"b"}, Regex: strPtr(""), Action: Keep}},
+			output:  lbls("a", "foo"),
+		},
+		{
+			name:    "drop with empty regex drops series without the label",
+			input:   lbls("a", "foo"),
+			configs: []Config{{SourceLabels: []string{"b"}, Regex: strPtr(""), Action: Drop}},
+			drop:    true,
+		},
+		{
+			name:  "hashmod",
+			input: lbls("a", "foo", "b", "bar", "c", "baz"),
+			configs: []Config{{
+				SourceLabels: []string{"c"},
+				TargetLabel:  "d",
+				Separator:    strPtr(";"),
+				Action:       HashMod,
+				Modulus:      1000,
+			}},
+			output: lbls("a", "foo", "b", "bar", "c", "baz", "d", "976"),
+		},
+		{
+			name:    "labelmap",
+			input:   lbls("a", "foo", "b1", "bar", "b2", "baz"),
+			configs: []Config{{Regex: strPtr("(b.*)"), Replacement: strPtr("bar_${1}"), Action: LabelMap}},
+			output:  lbls("a", "foo", "b1", "bar", "b2", "baz", "bar_b1", "bar", "bar_b2", "baz"),
+		},
+		{
+			name:    "labeldrop",
+			input:   lbls("a", "foo", "b1", "bar", "b2", "baz"),
+			configs: []Config{{Regex: strPtr("(b.*)"), Action: LabelDrop}},
+			output:  lbls("a", "foo"),
+		},
+		{
+			name:    "labelkeep",
+			input:   lbls("a", "foo", "b1", "bar", "b2", "baz"),
+			configs: []Config{{Regex: strPtr("(b.*)"), Action: LabelKeep}},
+			output:  lbls("b1", "bar", "b2", "baz"),
+		},
+		{
+			name:    "lowercase",
+			input:   lbls("foo", "bAr123Foo"),
+			configs: []Config{{SourceLabels: []string{"foo"}, TargetLabel: "foo_lowercase", Action: Lowercase}},
+			output:  lbls("foo", "bAr123Foo", "foo_lowercase", "bar123foo"),
+		},
+		{
+			name:    "uppercase",
+			input:   lbls("foo", "bAr123Foo"),
+			configs: []Config{{SourceLabels: []string{"foo"}, TargetLabel: "foo_uppercase", Action: Uppercase}},
+			output:  lbls("foo", "bAr123Foo", "foo_uppercase", "BAR123FOO"),
+		},
+		{
+			name:    "keepequal",
+			input:   lbls("__port1", "1234", "__port2", "5678", "__tmp_port", "1234"),
+			configs: []Config{{SourceLabels: []string{"__port1"}, TargetLabel: "__tmp_port", Action: KeepEqual}},
+			output:  lbls("__port1", "1234", "__port2", "5678", "__tmp_port", "1234"),
+		},
+		{
+			name:    "keepequal without match",
+			input:   lbls("__port1", "1234", "__port2", "5678", "__tmp_port", "1234"),
+			configs: []Config{{SourceLabels: []string{"__port2"}, TargetLabel: "__tmp_port", Action: KeepEqual}},
+			drop:    true,
+		},
+		{
+			name:    "dropequal",
+			input:   lbls("__port1", "1234", "__port2", "5678", "__tmp_port", "1234"),
+			configs: []Config{{SourceLabels: []string{"__port1"}, TargetLabel: "__tmp_port", Action: DropEqual}},
+			drop:    true,
+		},
+		{
+			name:    "series without labels are dropped",
+			input:   lbls("a", "foo"),
+			configs: []Config{{Regex: strPtr("a"), Action: LabelDrop}},
+			drop:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := Compile(tt.configs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			input := append([]prompb.Label(nil), tt.input...)
+			out, keep := Process(tt.input, rules)
+			if keep == tt.drop {
+				t.Fatalf("keep = %v, want %v", keep, !tt.drop)
+			}
+			if !tt.drop && !reflect.DeepEqual(out, sorted(tt.output)) {
+				t.Errorf("labels = %v, want %v", out, sorted(tt.output))
+			}
+			if !reflect.DeepEqual(tt.input, input) {
+				t.Errorf("input is modified: %v", tt.input)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		valid  bool
+	}{
+		{name: "unknown action", config: Config{Action: "foo"}},
+		{name: "invalid regex", config: Config{Regex: strPtr("("), TargetLabel: "a"}},
+		{name: "replace without target", config: Config{SourceLabels: []string{"a"}}},
+		{name: "replace with invalid target", config: Config{SourceLabels: []string{"a"}, TargetLabel: "0a"}},
+		{name: "hashmod without modulus", config: Config{SourceLabels: []string{"a"}, TargetLabel: "b", Action: HashMod}},
+		{name: "keepequal with regex", config: Config{SourceLabels: []string{"a"}, TargetLabel: "b", Regex: strPtr(""), Action: KeepEqual}},
+		{name: "labeldrop with source labels", config: Config{SourceLabels: []string{"a"}, Regex: strPtr("a"), Action: LabelDrop}},
+		{name: "invalid source label", config: Config{SourceLabels: []string{"a-b"}, TargetLabel: "b"}},
+		{name: "action is case insensitive", config: Config{SourceLabels: []string{"a"}, Action: "KEEP"}, valid: true},
+		{name: "empty regex", config: Config{SourceLabels: []string{"a"}, Regex: strPtr(""), Action: Drop}, valid: true},
+		{name: "keepequal", config: Config{SourceLabels: []string{"a"}, TargetLabel: "b", Action: KeepEqual}, valid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func sorted(labels []prompb.Label) []prompb.Label {
+	out := append([]prompb.Label(nil), labels...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,56 @@
+package selector
+
+import (
+	"fmt"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// Filter keeps series matching any allow selector and no deny selector, empty allow list allows all series
+type Filter struct {
+	Allow []*Selector
+	Deny  []*Selector
+}
+
+// NewFilter parses allow and deny selectors
+func NewFilter(allow, deny []string) (*Filter, error) {
+	f := &Filter{}
+	for i, s := range allow {
+		sel, err := Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("allow[%d]: %w", i, err)
+		}
+		f.Allow = append(f.Allow, sel)
+	}
+	for i, s := range deny {
+		sel, err := Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("deny[%d]: %w", i, err)
+		}
+		f.Deny = append(f.Deny, sel)
+	}
+	return f, nil
+}
+
+// Empty reports whether the filter keeps all series
+func (f *Filter) Empty() bool {
+	return len(f.Allow) == 0 && len(f.Deny) == 0
+}
+
+// Match returns the first allow and deny selectors matching labels, nil if there's none,
+// and whether the series is kept
+func (f *Filter) Match(labels []prompb.Label) (allow, deny *Selector, keep bool) {
+	for _, s := range f.Allow {
+		if s.Matches(labels) {
+			allow = s
+			break
+		}
+	}
+	for _, s := range f.Deny {
+		if s.Matches(labels) {
+			deny = s
+			break
+		}
+	}
+	return allow, deny, deny == nil && (allow != nil || len(f.Allow) == 0)
+}
@@ -0,0 +1,243 @@
+// Package selector parses promql series selectors, e.g. http_requests_total{job!="canary"}, and matches series
+// against them
+package selector
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+type matcher struct {
+	prompb.LabelMatcher
+	// re is the anchored regexp of regexp matchers
+	re *regexp.Regexp
+}
+
+func (m *matcher) matches(value string) bool {
+	switch m.Type {
+	case prompb.MatchNotEqual:
+		return value != m.Value
+	case prompb.MatchRegexp:
+		return m.re.MatchString(value)
+	case prompb.MatchNotRegexp:
+		return !m.re.MatchString(value)
+	default:
+		return value == m.Value
+	}
+}
+
+// Selector is a parsed series selector, series match it if they match all of its matchers
+type Selector struct {
+	text     string
+	matchers []*matcher
+}
+
+// Parse parses a series selector, like promql, it must have at least one matcher not matching empty value
+func Parse(s string) (*Selector, error) {
+	p := &parser{input: s}
+	matchers, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("parse selector %q: %w", s, err)
+	}
+	sel := &Selector{text: strings.TrimSpace(s), matchers: matchers}
+	for _, m := range sel.matchers {
+		if !m.matches("") {
+			return sel, nil
+		}
+	}
+	return nil, fmt.Errorf("selector %q must contain at least one matcher not matching empty value", s)
+}
+
+// String returns the selector as it's written
+func (s *Selector) String() string {
+	return s.text
+}
+
+// Matches reports whether labels match the selector, absent labels have empty value
+func (s *Selector) Matches(labels []prompb.Label) bool {
+	for _, m := range s.matchers {
+		if !m.matches(prompb.LabelValue(labels, m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) parse() ([]*matcher, error) {
+	var matchers []*matcher
+	p.skipSpaces()
+	if name := p.name(true); name != "" {
+		matchers = append(matchers, &matcher{LabelMatcher: prompb.LabelMatcher{Name: prompb.MetricNameLabel, Value: name}})
+		p.skipSpaces()
+	}
+	if p.eof() {
+		if len(matchers) == 0 {
+			return nil, fmt.Errorf("empty selector")
+		}
+		return matchers, nil
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpaces()
+		if p.consume("}") {
+			break
+		}
+		m, err := p.matcher()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+		p.skipSpaces()
+		if p.consume("}") {
+			break
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+	p.skipSpaces()
+	if !p.eof() {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos:], p.pos)
+	}
+	return matchers, nil
+}
+
+func (p *parser) matcher() (*matcher, error) {
+	name := p.name(false)
+	if name == "" {
+		return nil, fmt.Errorf("expected label name at position %d", p.pos)
+	}
+	p.skipSpaces()
+	var m matcher
+	m.Name = name
+	switch {
+	case p.consume("=~"):
+		m.Type = prompb.MatchRegexp
+	case p.consume("!~"):
+		m.Type = prompb.MatchNotRegexp
+	case p.consume("!="):
+		m.Type = prompb.MatchNotEqual
+	case p.consume("="):
+		m.Type = prompb.MatchEqual
+	default:
+		return nil, fmt.Errorf("expected match operator after %q at position %d", name, p.pos)
+	}
+	p.skipSpaces()
+	value, err := p.str()
+	if err != nil {
+		return nil, err
+	}
+	m.Value = value
+	if m.Type == prompb.MatchRegexp || m.Type == prompb.MatchNotRegexp {
+		if m.re, err = regexp.Compile("^(?:" + value + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid regexp of %q: %w", name, err)
+		}
+	}
+	return &m, nil
+}
+
+// name consumes a label name, colons are allowed in metric names only
+func (p *parser) name(metric bool) string {
+	start := p.pos
+	for ; !p.eof(); p.pos++ {
+		c := p.input[p.pos]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || metric && c == ':' ||
+			p.pos > start && c >= '0' && c <= '9' {
+			continue
+		}
+		break
+	}
+	return p.input[start:p.pos]
+}
+
+// str consumes a promql string literal in double quotes, single quotes or backticks
+func (p *parser) str() (string, error) {
+	if p.eof() {
+		return "", fmt.Errorf("expected string at end of selector")
+	}
+	quote := p.input[p.pos]
+	if quote != '"' && quote != '\'' && quote != '`' {
+		return "", fmt.Errorf("expected string at position %d", p.pos)
+	}
+	start := p.pos
+	for p.pos++; !p.eof(); p.pos++ {
+		switch p.input[p.pos] {
+		case '\\':
+			if quote != '`' {
+				p.pos++
+			}
+		case quote:
+			p.pos++
+			return unquote(p.input[start:p.pos])
+		}
+	}
+	return "", fmt.Errorf("unterminated string at position %d", start)
+}
+
+func unquote(s string) (string, error) {
+	if s[0] == '\'' {
+		// strconv unquotes single quoted characters only, so it's rewritten in double quotes
+		var b strings.Builder
+		b.WriteByte('"')
+		for i := 1; i < len(s)-1; i++ {
+			switch c := s[i]; {
+			case c == '\\' && s[i+1] == '\'':
+				b.WriteByte('\'')
+				i++
+			case c == '\\':
+				b.WriteString(s[i : i+2])
+				i++
+			case c == '"':
+				b.WriteString(`\"`)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte('"')
+		s = b.String()
+	}
+	v, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s: %w", s, err)
+	}
+	return v, nil
+}
+
+func (p *parser) skipSpaces() {
+	for !p.eof() && strings.IndexByte(" \t\r\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *parser) consume(token string) bool {
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(token string) error {
+	if !p.consume(token) {
+		if p.eof() {
+			return fmt.Errorf("expected %q at end of selector", token)
+		}
+		return fmt.Errorf("expected %q at position %d", token, p.pos)
+	}
+	return nil
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.input)
+}
@@ -0,0 +1,148 @@
+package selector
+
+import (
+	"reflect"
+	"testing"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+func TestParse(t *testing.T) {
+	name := func(v string) prompb.LabelMatcher {
+		return prompb.LabelMatcher{Type: prompb.MatchEqual, Name: prompb.MetricNameLabel, Value: v}
+	}
+	tests := []struct {
+		in   string
+		want []prompb.LabelMatcher
+		err  bool
+	}{
+		{in: "up", want: []prompb.LabelMatcher{name("up")}},
+		{in: "  job:up:sum  ", want: []prompb.LabelMatcher{name("job:up:sum")}},
+		{in: "up{}", want: []prompb.LabelMatcher{name("up")}},
+		{in: `{__name__="up"}`, want: []prompb.LabelMatcher{name("up")}},
+		{
+			in: `http_requests_total{ job = "api", code!="200",method=~"GET|POST", path !~ "/debug/.*" , }`,
+			want: []prompb.LabelMatcher{
+				name("http_requests_total"),
+				{Type: prompb.MatchEqual, Name: "job", Value: "api"},
+				{Type: prompb.MatchNotEqual, Name: "code", Value: "200"},
+				{Type: prompb.MatchRegexp, Name: "method", Value: "GET|POST"},
+				{Type: prompb.MatchNotRegexp, Name: "path", Value: "/debug/.*"},
+			},
+		},
+		{in: `{job="a\"b\\c\n"}`, want: []prompb.LabelMatcher{{Name: "job", Value: "a\"b\\c\n"}}},
+		{in: `{job='it\'s "a"'}`, want: []prompb.LabelMatcher{{Name: "job", Value: `it's "a"`}}},
+		{in: "{path=~`C:\\dir\\.*`}", want: []prompb.LabelMatcher{{Type: prompb.MatchRegexp, Name: "path", Value: `C:\dir\.*`}}},
+		{in: `{job="\u00e9"}`, want: []prompb.LabelMatcher{{Name: "job", Value: "é"}}},
+
+		{in: "", err: true},
+		{in: "{}", err: true},
+		{in: `{job=""}`, err: true},
+		{in: `{job=~".*"}`, err: true},
+		{in: `{job!="a"}`, err: true},
+		{in: "up{", err: true},
+		{in: `up{job="a"`, err: true},
+		{in: `up{job="a" code="b"}`, err: true},
+		{in: `up{job}`, err: true},
+		{in: `up{job=="a"}`, err: true},
+		{in: `up{job=a}`, err: true},
+		{in: `up{job="a}`, err: true},
+		{in: `up{job="\q"}`, err: true},
+		{in: `up{1job="a"}`, err: true},
+		{in: `up{job:x="a"}`, err: true},
+		{in: `up{job=~"("}`, err: true},
+		{in: `up{job="a"} x`, err: true},
+		{in: `1up`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			sel, err := Parse(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Errorf("Parse(%q) = %v, want error", tt.in, sel.matchers)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []prompb.LabelMatcher
+			for _, m := range sel.matchers {
+				got = append(got, m.LabelMatcher)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	labels := []prompb.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "code", Value: "500"}, {Name: "job", Value: "api"}}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{selector: "http_requests_total", want: true},
+		{selector: "up", want: false},
+		{selector: `{job="api"}`, want: true},
+		{selector: `http_requests_total{job!="api"}`, want: false},
+		{selector: `http_requests_total{job!="web"}`, want: true},
+		{selector: `{code=~"5.."}`, want: true},
+		// regexps are anchored
+		{selector: `{code=~"5"}`, want: false},
+		{selector: `http_requests_total{code!~"5.."}`, want: false},
+		{selector: `{job="api",code!~"2.."}`, want: true},
+		// absent labels have empty value
+		{selector: `http_requests_total{env=""}`, want: true},
+		{selector: `http_requests_total{env!=""}`, want: false},
+		{selector: `http_requests_total{env=~"prod|"}`, want: true},
+	}
+	for _, tt := range tests {
+		sel, err := Parse(tt.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sel.Matches(labels); got != tt.want {
+			t.Errorf("%s: Matches() = %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	series := func(name string) []prompb.Label { return []prompb.Label{{Name: "__name__", Value: name}} }
+	f, err := NewFilter([]string{`{__name__=~"go_.*"}`, "up"}, []string{"go_gc_duration_seconds"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		allow, deny string
+		keep        bool
+	}{
+		{name: "go_goroutines", allow: `{__name__=~"go_.*"}`, keep: true},
+		{name: "up", allow: "up", keep: true},
+		{name: "go_gc_duration_seconds", allow: `{__name__=~"go_.*"}`, deny: "go_gc_duration_seconds"},
+		{name: "process_cpu_seconds_total"},
+	}
+	for _, tt := range tests {
+		allow, deny, keep := f.Match(series(tt.name))
+		var gotAllow, gotDeny string
+		if allow != nil {
+			gotAllow = allow.String()
+		}
+		if deny != nil {
+			gotDeny = deny.String()
+		}
+		if gotAllow != tt.allow || gotDeny != tt.deny || keep != tt.keep {
+			t.Errorf("%s: Match() = %q, %q, %v, want %q, %q, %v", tt.name, gotAllow, gotDeny, keep, tt.allow, tt.deny, tt.keep)
+		}
+	}
+
+	if _, err := NewFilter(nil, []string{"up{"}); err == nil {
+		t.Error("invalid deny selector: no error")
+	}
+	if _, _, keep := (&Filter{}).Match(series("up")); !keep {
+		t.Error("empty filter drops series")
+	}
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/plugins/election"
+)
+
+const (
+	electorDisabled = "disabled"
+	electorLeader   = "leader"
+	electorFollower = "follower"
+)
+
+type electorInfo struct {
+	Type       config.Elector `json:"type"`
+	State      string         `json:"state"`
+	Identity   string         `json:"identity,omitempty"`
+	LockHolder string         `json:"lockHolder,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+func (s *Service) injectAdminRouters() error {
+	conf := s.config()
+	if !conf.AdminConfig.Enabled {
+		return nil
+	}
+
+	engine := s.engine
+	if !conf.AdminConfig.SharesPort(conf.Port) {
+		engine = gin.New()
+		engine.Use(gin.Recovery())
+		engine.Use(loggerMiddlewares()...)
+		// admin server shares tls config with remote write server
+		var err error
+		s.adminServer, err = newHTTPServer(fmt.Sprintf(":%d", conf.AdminConfig.Port), engine, &conf.ServerConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	router := engine.Group("/admin", adminAuth(conf.AdminConfig))
+	router.GET("/config", s.adminConfig())
+	router.GET("/elector", s.adminElector())
+	router.POST("/elector/step-down", s.adminStepDown())
+	router.GET("/log-level", adminGetLogLevel())
+	router.PUT("/log-level", adminSetLogLevel())
+	router.GET("/forwarding", s.adminForwarding())
+	router.POST("/forwarding/pause", s.adminPauseForwarding(true))
+	router.POST("/forwarding/resume", s.adminPauseForwarding(false))
+	router.POST("/flush", s.adminFlush())
+	router.GET("/cardinality", s.adminCardinality())
+	return nil
+}
+
+// RegisterFlusher registers a queue which can be flushed by admin api
+func (s *Service) RegisterFlusher(name string, f func(context.Context) error) {
+	s.flushers[name] = f
+}
+
+// adminAuth authenticates admin api with its own credentials, admin api without credentials is only allowed
+// on a dedicated port
+func adminAuth(conf config.AdminConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if conf.Username == "" && conf.BearerTokenFile == "" {
+			return
+		}
+
+		if conf.Username != "" {
+			user, passwd, ok := c.Request.BasicAuth()
+			if ok && secureCompare(user, conf.Username) && secureCompare(passwd, conf.Passwd) {
+				return
+			}
+		}
+
+		if conf.BearerTokenFile != "" {
+			// read token every time, so rotated token takes effect without restart
+			token, err := os.ReadFile(conf.BearerTokenFile)
+			if err != nil {
+				log.Logger.Error("msg", "read admin bearer token failed", "err", err)
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			auth := c.GetHeader("Authorization")
+			if strings.HasPrefix(auth, "Bearer ") &&
+				secureCompare(strings.TrimPrefix(auth, "Bearer "), strings.TrimSpace(string(token))) {
+				return
+			}
+		}
+
+		c.Header("WWW-Authenticate", `Basic realm="deepflow-adapter admin"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func (s *Service) adminConfig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, config.Redact(s.config().Raw))
+	}
+}
+
+func (s *Service) electorInfo(ctx context.Context) electorInfo {
+	conf := s.config()
+	info := electorInfo{Type: conf.Elector}
+	elector := s.getElector()
+	switch {
+	case !conf.ElectionEnabled || elector == nil:
+		info.State = electorDisabled
+		return info
+	case elector.IsLeader():
+		info.State = electorLeader
+	default:
+		info.State = electorFollower
+	}
+
+	if inspector, ok := elector.(election.Inspector); ok {
+		info.Identity = inspector.Identity()
+		holder, err := inspector.LockHolder(ctx)
+		if err != nil {
+			info.Error = err.Error()
+		}
+		info.LockHolder = holder
+	}
+	return info
+}
+
+func (s *Service) adminElector() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.electorInfo(c.Request.Context()))
+	}
+}
+
+func (s *Service) adminStepDown() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		elector := s.getElector()
+		if !s.config().ElectionEnabled || elector == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "election is not running"})
+			return
+		}
+
+		// hold off at least two retry periods, so other servers get chance to take the lock
+		holdOff := 2 * elector.RetryPeriod()
+		if v := c.Query("hold-off"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			holdOff = d
+		}
+
+		wasLeader := elector.IsLeader()
+		s.stepDownUntil.Store(time.Now().Add(holdOff).UnixNano())
+		if err := elector.Release(c.Request.Context()); err != nil {
+			log.Logger.Error("msg", "step down failed", "err", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		log.Logger.Info("msg", "server stepped down by admin api", "leader", wasLeader, "holdOff", holdOff)
+		c.JSON(http.StatusOK, gin.H{"wasLeader": wasLeader, "holdOff": holdOff.String()})
+	}
+}
+
+func adminGetLogLevel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"level": log.Logger.Level()})
+	}
+}
+
+func adminSetLogLevel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Level string `json:"level" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := log.Logger.SetLevel(body.Level); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Logger.Info("msg", "log level changed by admin api", "level", body.Level)
+		c.JSON(http.StatusOK, gin.H{"level": log.Logger.Level()})
+	}
+}
+
+func (s *Service) adminForwarding() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"paused": s.paused.Load()})
+	}
+}
+
+func (s *Service) adminPauseForwarding(pause bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.paused.Store(pause)
+		log.Logger.Info("msg", "forwarding state changed by admin api", "paused", pause)
+		c.JSON(http.StatusOK, gin.H{"paused": pause})
+	}
+}
+
+func (s *Service) adminFlush() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result := make(map[string]string, len(s.flushers))
+		status := http.StatusOK
+		for name, flush := range s.flushers {
+			if err := flush(c.Request.Context()); err != nil {
+				log.Logger.Error("msg", "flush queue failed", "queue", name, "err", err)
+				result[name] = err.Error()
+				status = http.StatusInternalServerError
+				continue
+			}
+			result[name] = "flushed"
+		}
+		c.JSON(status, gin.H{"queues": result})
+	}
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/snappy"
+
+	"prometheus-deepflow-adapter/pkg/aggregate"
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// aggregatePath is the route of aggregates on the internal engine, it's not served on http server
+const aggregatePath = "/aggregate"
+
+// aggregateFlushInterval is how often windows are checked, it's the precision of window boundaries
+const aggregateFlushInterval = time.Second
+
+// streamAggregator is replaced only when stream aggregation rules change, current windows of the replaced one
+// are flushed
+type streamAggregator struct {
+	conf       []config.AggregationRule
+	aggregator atomic.Pointer[aggregate.Aggregator]
+	// only the leader aggregates and emits aggregates, followers accepting writes forward input as it is
+	isLeader func() bool
+	emit     func(*prompb.WriteRequest)
+
+	mu     sync.Mutex
+	stop   chan struct{}
+	closed sync.WaitGroup
+}
+
+func newStreamAggregator(rules []config.AggregationRule, isLeader func() bool, emit func(*prompb.WriteRequest)) (*streamAggregator, error) {
+	a := &streamAggregator{isLeader: isLeader, emit: emit, stop: make(chan struct{})}
+	if err := a.ApplyConfig(rules); err != nil {
+		return nil, err
+	}
+	a.closed.Add(1)
+	go a.flushLoop()
+	return a, nil
+}
+
+func (a *streamAggregator) ApplyConfig(rules []config.AggregationRule) error {
+	commit, err := a.prepare(rules)
+	if err != nil {
+		return err
+	}
+	commit()
+	return nil
+}
+
+// prepare builds the aggregator of rules, the returned func swaps it in and flushes the old one.
+// It's a no-op if rules are unchanged
+func (a *streamAggregator) prepare(rules []config.AggregationRule) (func(), error) {
+	a.mu.Lock()
+	unchanged := a.aggregator.Load() != nil && reflect.DeepEqual(a.conf, rules)
+	a.mu.Unlock()
+	if unchanged {
+		return func() {}, nil
+	}
+	aggregator, err := aggregate.New(rules, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.conf = rules
+		if old := a.aggregator.Swap(aggregator); old != nil {
+			a.flush(old, time.Now(), true, a.isLeader())
+		}
+	}, nil
+}
+
+func (a *streamAggregator) flushLoop() {
+	defer a.closed.Done()
+	ticker := time.NewTicker(aggregateFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case now := <-ticker.C:
+			a.mu.Lock()
+			a.flush(a.aggregator.Load(), now, false, a.isLeader())
+			a.mu.Unlock()
+		}
+	}
+}
+
+func (a *streamAggregator) flush(aggregator *aggregate.Aggregator, now time.Time, force, emit bool) {
+	req := aggregator.Flush(now, force)
+	if len(req.Timeseries) == 0 || !emit {
+		return
+	}
+	aggregateOutputSeries.Add(float64(len(req.Timeseries)))
+	a.emit(req)
+}
+
+// Close stops flushing windows, current windows are flushed and emitted if leader is set. Leadership may be
+// released during shutdown, so it's decided by the caller
+func (a *streamAggregator) Close(leader bool) {
+	close(a.stop)
+	a.closed.Wait()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.flush(a.aggregator.Load(), time.Now(), true, leader)
+}
+
+// aggregateSeries pushes series to stream aggregation, matching series are dropped unless rules keep input.
+// Series are pushed once the request is forwarded, so that requests retried by clients aren't aggregated twice.
+// Aggregates are emitted by the leader only, so followers accepting writes(OTLP, or /receive with dedup) don't
+// aggregate, and forward input as it is instead of dropping it
+func aggregateSeries(a *streamAggregator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.isLeader() {
+			return
+		}
+		aggregator := a.aggregator.Load()
+		req := writeRequest(c)
+		out := &prompb.WriteRequest{
+			Timeseries: make([]prompb.TimeSeries, 0, len(req.Timeseries)),
+			Metadata:   req.Metadata,
+		}
+		var matched []prompb.TimeSeries
+		for i := range req.Timeseries {
+			ts := req.Timeseries[i]
+			ok, keep := aggregator.Match(&ts)
+			if ok {
+				matched = append(matched, ts)
+			}
+			if !keep {
+				if len(ts.Histograms) == 0 {
+					aggregateDroppedExemplars.Add(float64(len(ts.Exemplars)))
+					continue
+				}
+				// native histograms aren't aggregated, they're still forwarded
+				ts.Samples = nil
+			}
+			out.Timeseries = append(out.Timeseries, ts)
+		}
+		setWriteRequest(c, out)
+
+		if len(matched) == 0 {
+			return
+		}
+		c.Next()
+		if c.IsAborted() || c.Writer.Status()/100 != 2 {
+			return
+		}
+		for i := range matched {
+			aggregator.Push(&matched[i])
+			aggregateInputSamples.Add(float64(len(matched[i].Samples)))
+		}
+	}
+}
+
+// emitAggregates forwards aggregates through the internal engine
+func (s *Service) emitAggregates(req *prompb.WriteRequest) {
+	httpReq, err := http.NewRequest(http.MethodPost, aggregatePath, bytes.NewReader(snappy.Encode(nil, req.Marshal())))
+	if err != nil {
+		log.Logger.Error("msg", "build aggregate request failed", "err", err)
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	w := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+	s.aggregateEngine.ServeHTTP(w, httpReq)
+	// windows are not kept, failed aggregates are lost
+	if w.status/100 != 2 {
+		log.Logger.Error("msg", "emit aggregates failed", "status", w.status, "series", len(req.Timeseries))
+	}
+}
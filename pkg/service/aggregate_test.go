@@ -0,0 +1,81 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+func TestAggregateSeriesOnFollower(t *testing.T) {
+	tests := []struct {
+		name   string
+		leader bool
+		// forwarded is the number of input series forwarded right away
+		forwarded int
+	}{
+		{name: "leader consumes input", leader: true, forwarded: 0},
+		{name: "follower forwards input", leader: false, forwarded: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newTestUpstream(t)
+			c := newTestConfig(t, upstream.URL)
+			c.DedupConfig.Enabled = true
+			c.StreamAggregation = []config.AggregationRule{{Match: `{__name__="requests_total"}`, Interval: time.Hour, Outputs: []string{config.AggregateSum}}}
+			s := newElectedService(t, c, tt.leader)
+
+			req := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: prompb.MetricNameLabel, Value: "requests_total"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: time.Now().UnixMilli()}},
+			}}}
+			if w := receive(s, req); w.Code/100 != 2 {
+				t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+			}
+			if n := len(upstream.series()); n != tt.forwarded {
+				t.Errorf("got %d series forwarded, want %d", n, tt.forwarded)
+			}
+		})
+	}
+}
+
+func TestAggregateSeriesRetriedRequest(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	c := newTestConfig(t, upstream.URL)
+	c.RemoteWriteConfig.Retry.MaxRetries = 0
+	c.StreamAggregation = []config.AggregationRule{{
+		Match:     `{__name__="requests_total"}`,
+		Interval:  time.Hour,
+		Outputs:   []string{config.AggregateCount},
+		KeepInput: true,
+	}}
+	s := newTestService(t, c)
+
+	req := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: prompb.MetricNameLabel, Value: "requests_total"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: time.Now().UnixMilli()}},
+	}}}
+	// the failed request is retried by the client, and it's aggregated once
+	if w := receive(s, req); w.Code/100 == 2 {
+		t.Fatalf("status = %d, want failure", w.Code)
+	}
+	fail.Store(false)
+	if w := receive(s, req); w.Code/100 != 2 {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	out := s.aggregator.aggregator.Load().Flush(time.Now(), true)
+	if len(out.Timeseries) != 1 || out.Timeseries[0].Samples[0].Value != 1 {
+		t.Errorf("aggregates = %+v, want count 1", out.Timeseries)
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/auth"
+	"prometheus-deepflow-adapter/pkg/log"
+)
+
+const principalKey = "principal"
+
+// publicPaths are never authenticated, admin api has its own authentication
+var publicPaths = []string{"/healthz", "/readyz", "/metrics", "/admin/"}
+
+func authenticate(chain *atomic.Pointer[auth.Chain]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, path := range publicPaths {
+			if c.Request.URL.Path == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(c.Request.URL.Path, path)) {
+				return
+			}
+		}
+
+		p, err := chain.Load().Authenticate(c.Request)
+		if err != nil {
+			authFailures.Inc()
+			log.Logger.Debug("msg", "authentication failed", "client", c.ClientIP(), "err", err)
+			c.Header("WWW-Authenticate", `Basic realm="deepflow-adapter"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if p != nil {
+			c.Set(principalKey, p)
+		}
+	}
+}
+
+// principal returns the authenticated principal of request, nil if authentication is disabled
+func principal(c *gin.Context) *auth.Principal {
+	if p, ok := c.Get(principalKey); ok {
+		return p.(*auth.Principal)
+	}
+	return nil
+}
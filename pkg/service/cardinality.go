@@ -0,0 +1,92 @@
+package service
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/cardinality"
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// cardinalityLimiter is replaced only when cardinality config changes, since tracked series are lost
+type cardinalityLimiter struct {
+	conf    config.CardinalityConfig
+	limiter atomic.Pointer[cardinality.Limiter]
+}
+
+func newCardinalityLimiter(conf *config.CardinalityConfig) *cardinalityLimiter {
+	l := &cardinalityLimiter{}
+	l.ApplyConfig(conf)
+	return l
+}
+
+func (l *cardinalityLimiter) ApplyConfig(conf *config.CardinalityConfig) {
+	if l.limiter.Load() != nil && reflect.DeepEqual(l.conf, *conf) {
+		return
+	}
+	l.conf = *conf
+	cardinalityActiveSeries.Reset()
+	if !conf.Enabled {
+		l.limiter.Store(nil)
+		return
+	}
+	l.limiter.Store(cardinality.NewLimiter(conf))
+}
+
+// limitCardinality drops new series over the cardinality limits
+func limitCardinality(l *cardinalityLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limiter := l.limiter.Load()
+		if limiter == nil {
+			return
+		}
+		req := writeRequest(c)
+		out := &prompb.WriteRequest{
+			Timeseries: make([]prompb.TimeSeries, 0, len(req.Timeseries)),
+			Metadata:   req.Metadata,
+		}
+		for _, ts := range req.Timeseries {
+			result := limiter.Admit(ts.Labels)
+			if result.Limit != "" {
+				cardinalityLimitHits.WithLabelValues(result.Limit).Inc()
+			}
+			if !result.Admitted {
+				cardinalityRejectedSeries.WithLabelValues(result.Limit).Inc()
+				continue
+			}
+			out.Timeseries = append(out.Timeseries, ts)
+		}
+		setWriteRequest(c, out)
+
+		for _, s := range limiter.Stats(0) {
+			cardinalityActiveSeries.WithLabelValues(s.Limit).Set(float64(s.Active))
+		}
+	}
+}
+
+func (s *Service) adminCardinality() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limiter := s.cardinality.limiter.Load()
+		if limiter == nil {
+			c.JSON(http.StatusOK, gin.H{"enabled": false})
+			return
+		}
+		top, err := strconv.Atoi(c.DefaultQuery("top", "10"))
+		if err != nil || top < 0 {
+			c.String(http.StatusBadRequest, "invalid top: %s", c.Query("top"))
+			return
+		}
+		conf := s.config().CardinalityConfig
+		c.JSON(http.StatusOK, gin.H{
+			"enabled": true,
+			"window":  conf.Window.String(),
+			"policy":  conf.Policy,
+			"limits":  limiter.Stats(top),
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+// secretRefreshInterval is how long secrets read from files are cached, so rotated secrets take effect without reload
+var secretRefreshInterval = time.Minute
+
+// secret returns an inline secret or the content of a secret file
+type secret func() (string, error)
+
+func newSecret(value, file string) secret {
+	if file == "" {
+		return func() (string, error) { return value, nil }
+	}
+	f := &fileSecret{file: file}
+	return f.get
+}
+
+type fileSecret struct {
+	file string
+
+	mu     sync.Mutex
+	value  string
+	readAt time.Time
+}
+
+// get returns the cached secret, the file is re-read once the cache is older than secretRefreshInterval
+func (f *fileSecret) get() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.readAt.IsZero() && time.Since(f.readAt) < secretRefreshInterval {
+		return f.value, nil
+	}
+	b, err := os.ReadFile(f.file)
+	if err != nil {
+		return "", fmt.Errorf("read secret file failed: %w", err)
+	}
+	f.value, f.readAt = strings.TrimSpace(string(b)), time.Now()
+	return f.value, nil
+}
+
+// authRoundTripper authenticates requests of a destination before they're sent by base
+type authRoundTripper struct {
+	base         http.RoundTripper
+	authenticate func(req *http.Request) error
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// round trippers must not modify the request
+	req = req.Clone(req.Context())
+	if err := rt.authenticate(req); err != nil {
+		return nil, fmt.Errorf("authenticate remote write failed: %w", err)
+	}
+	return rt.base.RoundTrip(req)
+}
+
+// newAuthRoundTripper wraps base with the auth method of the destination, base is returned if there's none.
+// Token requests of oauth2 are sent by base as well
+func newAuthRoundTripper(conf *config.RemoteWriteConfig, base http.RoundTripper) (http.RoundTripper, error) {
+	authenticate, err := newAuthenticator(conf, base)
+	if err != nil || authenticate == nil {
+		return base, err
+	}
+	return &authRoundTripper{base: base, authenticate: authenticate}, nil
+}
+
+// newAuthenticator returns the func setting auth headers of the destination, it's nil if there's no auth method
+func newAuthenticator(conf *config.RemoteWriteConfig, base http.RoundTripper) (func(req *http.Request) error, error) {
+	var authenticate func(req *http.Request) error
+	switch {
+	case conf.BasicAuth != nil:
+		username, password := conf.BasicAuth.Username, newSecret(conf.BasicAuth.Password, conf.BasicAuth.PasswordFile)
+		authenticate = func(req *http.Request) error {
+			p, err := password()
+			if err != nil {
+				return err
+			}
+			req.SetBasicAuth(username, p)
+			return nil
+		}
+	case conf.Authorization != nil:
+		typ := conf.Authorization.Type
+		if typ == "" {
+			typ = config.DefaultAuthorizationType
+		}
+		credentials := newSecret(conf.Authorization.Credentials, conf.Authorization.CredentialsFile)
+		authenticate = func(req *http.Request) error {
+			c, err := credentials()
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", typ+" "+c)
+			return nil
+		}
+	case conf.OAuth2 != nil:
+		o := &oauth2Tokens{
+			conf:   *conf.OAuth2,
+			secret: newSecret(conf.OAuth2.ClientSecret, conf.OAuth2.ClientSecretFile),
+			client: &http.Client{Transport: base, Timeout: conf.Timeout},
+		}
+		authenticate = func(req *http.Request) error {
+			token, err := o.token()
+			if err != nil {
+				return err
+			}
+			token.SetAuthHeader(req)
+			return nil
+		}
+	case conf.SigV4 != nil:
+		s, err := newSigV4Signer(conf.SigV4)
+		if err != nil {
+			return nil, err
+		}
+		authenticate = s.sign
+	}
+	return authenticate, nil
+}
+
+// oauth2Tokens fetches tokens by client credentials flow, tokens are cached until they're about to expire,
+// and the token source is rebuilt once the client secret changes
+type oauth2Tokens struct {
+	conf   config.OAuth2Config
+	secret secret
+	client *http.Client
+
+	mu           sync.Mutex
+	clientSecret string
+	source       oauth2.TokenSource
+}
+
+func (o *oauth2Tokens) token() (*oauth2.Token, error) {
+	clientSecret, err := o.secret()
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	if o.source == nil || clientSecret != o.clientSecret {
+		params := url.Values{}
+		for name, value := range o.conf.EndpointParams {
+			params.Set(name, value)
+		}
+		cc := &clientcredentials.Config{
+			ClientID:       o.conf.ClientID,
+			ClientSecret:   clientSecret,
+			TokenURL:       o.conf.TokenURL,
+			Scopes:         o.conf.Scopes,
+			EndpointParams: params,
+		}
+		o.source = cc.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, o.client))
+		o.clientSecret = clientSecret
+	}
+	source := o.source
+	o.mu.Unlock()
+	// the token source is safe for concurrent use, tokens are fetched outside the lock
+	return source.Token()
+}
+
+// sigV4Signer signs requests with aws signature version 4, credentials are resolved like prometheus does: static
+// keys of config, or the default credential chain of aws sdk with the profile, optionally assuming the role.
+// Credentials of the chain are refreshed by aws sdk once they expire
+type sigV4Signer struct {
+	region, service string
+	signer          *v4.Signer
+	now             func() time.Time
+}
+
+func newSigV4Signer(conf *config.SigV4Config) (*sigV4Signer, error) {
+	var creds *credentials.Credentials
+	if conf.AccessKey != "" {
+		creds = credentials.NewStaticCredentials(conf.AccessKey, conf.SecretKey, "")
+	}
+	awsConf := aws.Config{Credentials: creds}
+	if conf.Region != "" {
+		awsConf.Region = aws.String(conf.Region)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConf,
+		Profile:           conf.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create aws session failed: %w", err)
+	}
+	if _, err := sess.Config.Credentials.Get(); err != nil {
+		return nil, fmt.Errorf("get aws credentials failed: %w", err)
+	}
+	region := aws.StringValue(sess.Config.Region)
+	if region == "" {
+		return nil, fmt.Errorf("sigv4 region must be set in config, env or aws profile")
+	}
+	if conf.RoleARN != "" {
+		creds = stscreds.NewCredentials(sess, conf.RoleARN)
+	} else {
+		creds = sess.Config.Credentials
+	}
+	service := conf.Service
+	if service == "" {
+		service = config.DefaultSigV4Service
+	}
+	return &sigV4Signer{region: region, service: service, signer: v4.NewSigner(creds), now: time.Now}, nil
+}
+
+// sign adds date, payload hash, session token and authorization headers
+func (s *sigV4Signer) sign(req *http.Request) error {
+	payload, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+	_, err = s.signer.Sign(req, bytes.NewReader(payload), s.service, s.region, s.now())
+	return err
+}
+
+// requestBody returns the body of the request without consuming it
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(payload))
+	return payload, nil
+}
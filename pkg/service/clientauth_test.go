@@ -0,0 +1,213 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+// authorizations returns a server recording Authorization headers of requests
+func authorizations(t *testing.T) (*httptest.Server, *[]string) {
+	var headers []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Get("Authorization"))
+	}))
+	t.Cleanup(s.Close)
+	return s, &headers
+}
+
+func writeAuthFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sendAuthenticated(t *testing.T, conf *config.RemoteWriteConfig, url string) {
+	t.Helper()
+	rt, err := newAuthRoundTripper(conf, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: rt}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func setSecretRefreshInterval(t *testing.T, d time.Duration) {
+	old := secretRefreshInterval
+	secretRefreshInterval = d
+	t.Cleanup(func() { secretRefreshInterval = old })
+}
+
+func TestAuthorizationCredentialsFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		refresh time.Duration
+		want    []string
+	}{
+		{name: "cached", refresh: time.Hour, want: []string{"Bearer first", "Bearer first"}},
+		{name: "re-read", refresh: 0, want: []string{"Bearer first", "Bearer second"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setSecretRefreshInterval(t, tt.refresh)
+			server, headers := authorizations(t)
+			file := filepath.Join(t.TempDir(), "token")
+			writeAuthFile(t, file, "first\n")
+			conf := &config.RemoteWriteConfig{Authorization: &config.Authorization{CredentialsFile: file}}
+
+			rt, err := newAuthRoundTripper(conf, http.DefaultTransport)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: rt}
+			for i := range tt.want {
+				if i > 0 {
+					writeAuthFile(t, file, "second\n")
+				}
+				resp, err := client.Post(server.URL, "text/plain", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+			if fmt.Sprint(*headers) != fmt.Sprint(tt.want) {
+				t.Errorf("authorization headers = %q, want %q", *headers, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuth2(t *testing.T) {
+	setSecretRefreshInterval(t, 0)
+	var tokenRequests atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, secret, _ := r.BasicAuth()
+		if r.Form.Get("grant_type") != "client_credentials" || id != "adapter" || r.Form.Get("audience") != "deepflow" {
+			http.Error(w, "unexpected token request", http.StatusBadRequest)
+			return
+		}
+		n := tokenRequests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "%s-%d", "token_type": "Bearer", "expires_in": 3600}`, secret, n)
+	}))
+	defer tokenServer.Close()
+	server, headers := authorizations(t)
+	secretFile := filepath.Join(t.TempDir(), "client-secret")
+	writeAuthFile(t, secretFile, "s1")
+	conf := &config.RemoteWriteConfig{
+		Timeout: 5 * time.Second,
+		OAuth2: &config.OAuth2Config{
+			ClientID:         "adapter",
+			ClientSecretFile: secretFile,
+			TokenURL:         tokenServer.URL,
+			EndpointParams:   map[string]string{"audience": "deepflow"},
+		},
+	}
+
+	rt, err := newAuthRoundTripper(conf, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: rt}
+	for i := 0; i < 3; i++ {
+		// the token is cached until the client secret rotates
+		if i == 2 {
+			writeAuthFile(t, secretFile, "s2")
+		}
+		resp, err := client.Post(server.URL, "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	want := []string{"Bearer s1-1", "Bearer s1-1", "Bearer s2-2"}
+	if fmt.Sprint(*headers) != fmt.Sprint(want) {
+		t.Errorf("authorization headers = %q, want %q", *headers, want)
+	}
+	if n := tokenRequests.Load(); n != 2 {
+		t.Errorf("%d token requests, want 2", n)
+	}
+}
+
+func TestOAuth2TokenServerError(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer tokenServer.Close()
+	conf := &config.RemoteWriteConfig{OAuth2: &config.OAuth2Config{ClientID: "adapter", ClientSecret: "s", TokenURL: tokenServer.URL}}
+	rt, err := newAuthRoundTripper(conf, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&http.Client{Transport: rt}).Post("http://127.0.0.1:1", "text/plain", nil); err == nil {
+		t.Error("write succeeded without token")
+	}
+}
+
+func TestSigV4Credentials(t *testing.T) {
+	dir := t.TempDir()
+	credentialsFile, configFile := filepath.Join(dir, "credentials"), filepath.Join(dir, "config")
+	writeAuthFile(t, credentialsFile, "[prod]\naws_access_key_id = PROFILEKEY\naws_secret_access_key = secret\n")
+	writeAuthFile(t, configFile, "[profile prod]\nregion = eu-west-1\n")
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	tests := []struct {
+		name  string
+		conf  config.SigV4Config
+		env   map[string]string
+		scope string
+	}{
+		{name: "static", conf: config.SigV4Config{Region: "us-east-1", AccessKey: "AKID", SecretKey: "secret"}, scope: "AKID/*/us-east-1/aps/aws4_request"},
+		{name: "env", conf: config.SigV4Config{Service: "execute-api"}, scope: "ENVKEY/*/ap-east-1/execute-api/aws4_request",
+			env: map[string]string{"AWS_ACCESS_KEY_ID": "ENVKEY", "AWS_SECRET_ACCESS_KEY": "secret", "AWS_REGION": "ap-east-1"}},
+		{name: "profile", conf: config.SigV4Config{Profile: "prod"}, scope: "PROFILEKEY/*/eu-west-1/aps/aws4_request"},
+		{name: "profile env", conf: config.SigV4Config{Region: "us-west-2"}, scope: "PROFILEKEY/*/us-west-2/aps/aws4_request",
+			env: map[string]string{"AWS_PROFILE": "prod"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			server, headers := authorizations(t)
+			sendAuthenticated(t, &config.RemoteWriteConfig{SigV4: &tt.conf}, server.URL)
+			prefix, suffix, _ := strings.Cut(tt.scope, "*")
+			_, credential, _ := strings.Cut((*headers)[0], "Credential=")
+			credential, _, _ = strings.Cut(credential, ",")
+			if !strings.HasPrefix((*headers)[0], "AWS4-HMAC-SHA256 ") || !strings.HasPrefix(credential, prefix) || !strings.HasSuffix(credential, suffix) {
+				t.Errorf("authorization = %q, want credential %s", (*headers)[0], tt.scope)
+			}
+		})
+	}
+
+	t.Run("no region", func(t *testing.T) {
+		if _, err := newSigV4Signer(&config.SigV4Config{AccessKey: "AKID", SecretKey: "secret"}); err == nil {
+			t.Error("signer is created without region")
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/compression"
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+const (
+	writeRequestKey = "deepflow-adapter/write-request"
+	payloadSizeKey  = "deepflow-adapter/payload-size"
+	protoMsgKey     = "deepflow-adapter/proto-msg"
+)
+
+const (
+	remoteWriteVersionHeader = "X-Prometheus-Remote-Write-Version"
+	remoteWriteVersion1      = "0.1.0"
+	remoteWriteVersion2      = "2.0.0"
+
+	samplesWrittenHeader    = "X-Prometheus-Remote-Write-Samples-Written"
+	histogramsWrittenHeader = "X-Prometheus-Remote-Write-Histograms-Written"
+	exemplarsWrittenHeader  = "X-Prometheus-Remote-Write-Exemplars-Written"
+)
+
+// decodeWriteRequest decodes remote write payload, following handlers get it by writeRequest.
+// Version is negotiated by proto parameter of content type, remote write 1.0 is assumed without it
+func decodeWriteRequest(conf func() *config.ServerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		protoMsg, err := negotiateProtoMsg(c.GetHeader("Content-Type"))
+		if err != nil {
+			c.AbortWithError(http.StatusUnsupportedMediaType, err)
+			return
+		}
+		// remote write compresses payload with snappy, other codecs are accepted for chained adapters
+		codec, err := compression.ForContentEncoding(c.GetHeader("Content-Encoding"))
+		if err != nil {
+			c.AbortWithError(http.StatusUnsupportedMediaType, err)
+			return
+		}
+		sc := conf()
+		compressed, ok := readBody(c, sc)
+		if !ok {
+			return
+		}
+		raw, ok := decodeBody(c, codec, compressed, sc)
+		if !ok {
+			return
+		}
+		req := &prompb.WriteRequest{}
+		if protoMsg == prompb.ProtoMsgV2 {
+			err = req.UnmarshalV2(raw)
+		} else {
+			err = req.Unmarshal(raw)
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("decode write request failed: %w", err))
+			return
+		}
+		c.Set(writeRequestKey, req)
+		c.Set(payloadSizeKey, len(compressed))
+		c.Set(protoMsgKey, protoMsg)
+	}
+}
+
+// readBody reads body of the request, requests over max-request-bytes are aborted with 413
+func readBody(c *gin.Context, conf *config.ServerConfig) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, conf.MaxRequestBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithError(http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", tooLarge.Limit))
+			return nil, false
+		}
+		log.Logger.Error("msg", "read request body error", "path", c.Request.URL.Path, "err", err)
+		c.AbortWithError(http.StatusBadRequest, err)
+		return nil, false
+	}
+	return body, true
+}
+
+// decodeBody decompresses body of the request, bodies decompressed over max-decoded-bytes are aborted with 413
+func decodeBody(c *gin.Context, codec compression.Codec, body []byte, conf *config.ServerConfig) ([]byte, bool) {
+	raw, err := codec.DecodeMax(body, int(conf.MaxDecodedBytes))
+	if errors.Is(err, compression.ErrTooLarge) {
+		c.AbortWithError(http.StatusRequestEntityTooLarge, fmt.Errorf("decompressed %s body exceeds %d bytes", codec.Name(), conf.MaxDecodedBytes))
+		return nil, false
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("decompress %s failed: %w", codec.Name(), err))
+		return nil, false
+	}
+	return raw, true
+}
+
+func negotiateProtoMsg(contentType string) (string, error) {
+	if contentType == "" {
+		return prompb.ProtoMsgV1, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+	if mediaType != "application/x-protobuf" {
+		return "", fmt.Errorf("unsupported content type %q", contentType)
+	}
+	switch proto := params["proto"]; proto {
+	case "", prompb.ProtoMsgV1:
+		return prompb.ProtoMsgV1, nil
+	case prompb.ProtoMsgV2:
+		return prompb.ProtoMsgV2, nil
+	default:
+		return "", fmt.Errorf("unsupported protobuf message %q, supported: [%s %s]", proto, prompb.ProtoMsgV1, prompb.ProtoMsgV2)
+	}
+}
+
+// setWrittenHeaders sets written headers of remote write 2.0, they're what is sent to destinations
+func setWrittenHeaders(c *gin.Context, req *prompb.WriteRequest) {
+	if c.GetString(protoMsgKey) != prompb.ProtoMsgV2 {
+		return
+	}
+	var samples, histograms, exemplars int
+	for i := range req.Timeseries {
+		samples += len(req.Timeseries[i].Samples)
+		histograms += len(req.Timeseries[i].Histograms)
+		exemplars += len(req.Timeseries[i].Exemplars)
+	}
+	c.Header(samplesWrittenHeader, strconv.Itoa(samples))
+	c.Header(histogramsWrittenHeader, strconv.Itoa(histograms))
+	c.Header(exemplarsWrittenHeader, strconv.Itoa(exemplars))
+}
+
+// contentType returns content type of the protobuf message
+func contentType(protoMsg string) string {
+	if protoMsg == prompb.ProtoMsgV1 {
+		return "application/x-protobuf"
+	}
+	return "application/x-protobuf;proto=" + protoMsg
+}
+
+func writeRequest(c *gin.Context) *prompb.WriteRequest {
+	return c.MustGet(writeRequestKey).(*prompb.WriteRequest)
+}
+
+// payloadSize is the size of payload on the wire
+func payloadSize(c *gin.Context) int {
+	return c.GetInt(payloadSizeKey)
+}
+
+func setWriteRequest(c *gin.Context, req *prompb.WriteRequest) {
+	c.Set(writeRequestKey, req)
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"testing"
+
+	"github.com/golang/snappy"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+func TestReceiveSizeLimits(t *testing.T) {
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	w.Write(make([]byte, 64<<10))
+	w.Close()
+
+	req := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}}
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+		code            int
+	}{
+		{name: "within limits", contentEncoding: "snappy", body: snappy.Encode(nil, req.Marshal()), code: http.StatusOK},
+		{name: "body over max-request-bytes", contentEncoding: "identity", body: make([]byte, 8192), code: http.StatusRequestEntityTooLarge},
+		{name: "snappy over max-decoded-bytes", contentEncoding: "snappy", body: snappy.Encode(nil, make([]byte, 64<<10)), code: http.StatusRequestEntityTooLarge},
+		{name: "gzip over max-decoded-bytes", contentEncoding: "gzip", body: gzipped.Bytes(), code: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newTestUpstream(t)
+			c := newTestConfig(t, upstream.URL)
+			c.ServerConfig.MaxRequestBytes = 4096
+			c.ServerConfig.MaxDecodedBytes = 8192
+			s := newTestService(t, c)
+
+			if len(tt.body) > 4096 && tt.contentEncoding != "identity" {
+				t.Fatalf("compressed body of %d bytes exceeds max-request-bytes", len(tt.body))
+			}
+			if w := post(s, "/receive", tt.contentEncoding, tt.body); w.Code != tt.code {
+				t.Errorf("code = %d, want %d: %s", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/dedup"
+)
+
+// deduplicator is replaced only when dedup config changes, since tracked series are lost
+type deduplicator struct {
+	conf  config.DedupConfig
+	dedup atomic.Pointer[dedup.Deduplicator]
+}
+
+func newDeduplicator(conf *config.DedupConfig) *deduplicator {
+	d := &deduplicator{}
+	d.ApplyConfig(conf)
+	return d
+}
+
+func (d *deduplicator) ApplyConfig(conf *config.DedupConfig) {
+	if d.dedup.Load() != nil && reflect.DeepEqual(d.conf, *conf) {
+		return
+	}
+	d.conf = *conf
+	dedupTrackedSeries.Set(0)
+	if !conf.Enabled {
+		d.dedup.Store(nil)
+		return
+	}
+	d.dedup.Store(dedup.New(conf.ReplicaLabels, conf.MaxSeries, conf.Expiry))
+}
+
+// dedupSeries drops samples of HA replicas which are already forwarded, last timestamps are restored
+// if the request is not forwarded, so that samples retried by prometheus are not dropped
+func dedupSeries(d *deduplicator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dd := d.dedup.Load()
+		if dd == nil {
+			return
+		}
+		result := dd.Dedup(writeRequest(c), time.Now())
+		dedupDuplicateSamples.Add(float64(result.Duplicates))
+		dedupTrackedSeries.Set(float64(dd.Len()))
+		setWriteRequest(c, result.Request)
+
+		c.Next()
+		if c.IsAborted() || c.Writer.Status()/100 != 2 {
+			dd.Cancel(result)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+	"prometheus-deepflow-adapter/pkg/selector"
+)
+
+const (
+	filterListAllow = "allow"
+	filterListDeny  = "deny"
+)
+
+// filterSeries applies the global filter to the decoded write request
+func filterSeries(filter *atomic.Pointer[selector.Filter]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setWriteRequest(c, filterRequest(writeRequest(c), filter.Load(), globalRelabelScope))
+	}
+}
+
+// filterRequest returns a copy of req with series kept by the filter, req itself is returned if the filter is empty
+func filterRequest(req *prompb.WriteRequest, f *selector.Filter, scope string) *prompb.WriteRequest {
+	if f.Empty() {
+		return req
+	}
+	out := &prompb.WriteRequest{
+		Timeseries: make([]prompb.TimeSeries, 0, len(req.Timeseries)),
+		Metadata:   req.Metadata,
+	}
+	for _, ts := range req.Timeseries {
+		allow, deny, keep := f.Match(ts.Labels)
+		if allow != nil {
+			filterMatchedSeries.WithLabelValues(scope, filterListAllow, allow.String()).Inc()
+		}
+		if deny != nil {
+			filterMatchedSeries.WithLabelValues(scope, filterListDeny, deny.String()).Inc()
+		}
+		if !keep {
+			filterDroppedSeries.WithLabelValues(scope).Inc()
+			continue
+		}
+		out.Timeseries = append(out.Timeseries, ts)
+	}
+	return out
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/graphite"
+	"prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// graphitePath is the route of graphite batches on the internal engine, it's not served on http server
+const graphitePath = "/graphite"
+
+// graphiteMaxBatchLines bounds lines of a batch read from a TCP connection
+const graphiteMaxBatchLines = 1000
+
+// graphiteMaxPacketSize is the max UDP payload
+const graphiteMaxPacketSize = 65536
+
+// decodeGraphite parses graphite lines into a write request, invalid lines are dropped
+func decodeGraphite(parser *atomic.Pointer[graphite.Parser]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		p, now := parser.Load(), time.Now()
+		req := &prompb.WriteRequest{}
+		lines, invalid := 0, 0
+		for _, line := range strings.Split(string(body), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			lines++
+			series, err := p.Parse(line, now)
+			if err != nil {
+				invalid++
+				log.Logger.Debug("msg", "drop invalid graphite line", "client", c.ClientIP(), "line", line, "err", err)
+				continue
+			}
+			req.Timeseries = append(req.Timeseries, series)
+		}
+		ingestLines.WithLabelValues(protocolGraphite).Add(float64(lines))
+		ingestDropped.WithLabelValues(protocolGraphite, reasonInvalidLine).Add(float64(invalid))
+		c.Set(writeRequestKey, req)
+		c.Set(payloadSizeKey, len(body))
+	}
+}
+
+// graphiteServer reads graphite plaintext from TCP connections and UDP packets, batches are handed to the
+// internal engine, so they go through the same limits and forwarding as /receive
+type graphiteServer struct {
+	handler http.Handler
+	tcpAddr string
+	udpAddr string
+
+	mu     sync.Mutex
+	closed bool
+	tcp    net.Listener
+	udp    net.PacketConn
+	conns  map[net.Conn]struct{}
+}
+
+func newGraphiteServer(handler http.Handler, conf *config.GraphiteConfig) *graphiteServer {
+	return &graphiteServer{
+		handler: handler,
+		tcpAddr: conf.TCPListenAddress,
+		udpAddr: conf.UDPListenAddress,
+		conns:   map[net.Conn]struct{}{},
+	}
+}
+
+func (g *graphiteServer) ListenAndServe() {
+	if g.tcpAddr != "" {
+		ln, err := net.Listen("tcp", g.tcpAddr)
+		if err != nil {
+			log.Logger.Error("msg", "graphite TCP listen error", "addr", g.tcpAddr, "err", err)
+		} else {
+			g.mu.Lock()
+			g.tcp = ln
+			g.mu.Unlock()
+			log.Logger.Info("msg", "graphite TCP server start up", "addr", g.tcpAddr)
+			go g.serveTCP(ln)
+		}
+	}
+	if g.udpAddr != "" {
+		conn, err := net.ListenPacket("udp", g.udpAddr)
+		if err != nil {
+			log.Logger.Error("msg", "graphite UDP listen error", "addr", g.udpAddr, "err", err)
+		} else {
+			g.mu.Lock()
+			g.udp = conn
+			g.mu.Unlock()
+			log.Logger.Info("msg", "graphite UDP server start up", "addr", g.udpAddr)
+			go g.serveUDP(conn)
+		}
+	}
+}
+
+func (g *graphiteServer) serveTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Logger.Error("msg", "graphite TCP server error", "err", err)
+			}
+			return
+		}
+		g.mu.Lock()
+		if g.closed {
+			g.mu.Unlock()
+			conn.Close()
+			return
+		}
+		g.conns[conn] = struct{}{}
+		g.mu.Unlock()
+		go g.serveConn(conn)
+	}
+}
+
+// serveConn posts lines in batches, a batch ends when nothing more is buffered or it's full
+func (g *graphiteServer) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		g.mu.Lock()
+		delete(g.conns, conn)
+		g.mu.Unlock()
+	}()
+	r := bufio.NewReader(conn)
+	var batch bytes.Buffer
+	lines := 0
+	for {
+		line, err := r.ReadBytes('\n')
+		batch.Write(line)
+		lines++
+		if err != nil {
+			if batch.Len() > 0 {
+				g.post(conn.RemoteAddr().String(), batch.Bytes())
+			}
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Logger.Debug("msg", "graphite connection error", "client", conn.RemoteAddr(), "err", err)
+			}
+			return
+		}
+		if lines >= graphiteMaxBatchLines || r.Buffered() == 0 {
+			g.post(conn.RemoteAddr().String(), batch.Bytes())
+			batch.Reset()
+			lines = 0
+		}
+	}
+}
+
+// serveUDP posts every packet as a batch
+func (g *graphiteServer) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, graphiteMaxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Logger.Error("msg", "graphite UDP server error", "err", err)
+			}
+			return
+		}
+		g.post(addr.String(), buf[:n])
+	}
+}
+
+func (g *graphiteServer) post(remoteAddr string, body []byte) {
+	req, err := http.NewRequest(http.MethodPost, graphitePath, bytes.NewReader(body))
+	if err != nil {
+		log.Logger.Error("msg", "build graphite request failed", "err", err)
+		return
+	}
+	req.RemoteAddr = remoteAddr
+	w := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+	g.handler.ServeHTTP(w, req)
+	// graphite has no response, failed batches are lost
+	if w.status/100 != 2 {
+		log.Logger.Error("msg", "graphite write failed", "client", remoteAddr, "status", w.status)
+	}
+}
+
+// Close stops listeners and closes connections
+func (g *graphiteServer) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	if g.tcp != nil {
+		g.tcp.Close()
+	}
+	if g.udp != nil {
+		g.udp.Close()
+	}
+	for conn := range g.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/compression"
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/influx"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+const (
+	influxWritePath = "/api/v2/write"
+	influxErrorKey  = "deepflow-adapter/influx-error"
+
+	protocolInflux   = "influx"
+	protocolGraphite = "graphite"
+
+	reasonInvalidLine = "invalid_line"
+	reasonStringField = "string_field"
+)
+
+// decodeInflux parses line protocol into a write request, so that following handlers process it as a
+// remote write request. Like InfluxDB, valid points are written even if some lines are invalid
+func decodeInflux(conf func() *config.InfluxConfig, serverConf func() *config.ServerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !conf().Enabled {
+			c.AbortWithError(http.StatusNotFound, errors.New("InfluxDB line protocol ingest is disabled"))
+			return
+		}
+		precision, err := influx.ParsePrecision(c.Query("precision"))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		enc := c.GetHeader("Content-Encoding")
+		if enc != "" && enc != "identity" && enc != "gzip" {
+			c.AbortWithError(http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content encoding %q", enc))
+			return
+		}
+		sc := serverConf()
+		body, ok := readBody(c, sc)
+		if !ok {
+			return
+		}
+		payload := body
+		if enc == "gzip" {
+			if payload, ok = decodeBody(c, compression.Gunzip, body, sc); !ok {
+				return
+			}
+		}
+
+		result := influx.Parse(payload, precision, time.Now())
+		ingestLines.WithLabelValues(protocolInflux).Add(float64(result.Lines))
+		ingestDropped.WithLabelValues(protocolInflux, reasonStringField).Add(float64(result.SkippedFields))
+		if len(result.Errors) > 0 {
+			ingestDropped.WithLabelValues(protocolInflux, reasonInvalidLine).Add(float64(len(result.Errors)))
+			err := fmt.Errorf("%d of %d lines are invalid, first: %w", len(result.Errors), result.Lines, result.Errors[0])
+			if len(result.Series) == 0 {
+				c.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+			c.Set(influxErrorKey, err)
+		}
+		c.Set(writeRequestKey, &prompb.WriteRequest{Timeseries: result.Series})
+		c.Set(payloadSizeKey, len(body))
+	}
+}
+
+// influxResponse responds 204 as InfluxDB does, or 400 if some lines are invalid, other points are written anyway
+func influxResponse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err, ok := c.Get(influxErrorKey); ok {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("partial write: %w", err.(error)))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
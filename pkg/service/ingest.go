@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/prompb"
+	"prometheus-deepflow-adapter/pkg/utils"
+)
+
+const (
+	ingestResolverScheme = "deepflow-ingest"
+	// ingestClientCloseDelay is how long a replaced ingest client is kept for writes of old destinations
+	ingestClientCloseDelay = time.Minute
+)
+
+var ingestStreamDesc = &grpc.StreamDesc{StreamName: "Write", ServerStreams: true, ClientStreams: true}
+
+// ingestClientConf is the config an ingest client is built from, the client is kept on reload if it's unchanged
+type ingestClientConf struct {
+	grpc      config.GRPCClientConfig
+	tlsConfig config.TLSConfig
+	insecure  bool
+}
+
+func newIngestClientConf(conf *config.RemoteWriteConfig) ingestClientConf {
+	return ingestClientConf{grpc: conf.GRPC, tlsConfig: conf.TLSConfig, insecure: conf.Insecure}
+}
+
+// ingestClient streams writes to deepflow ingesters. All addresses share a connection whose round robin
+// balancer spreads streams across ready addresses, streams of a failed address are reopened on the others
+type ingestClient struct {
+	name    string
+	conn    *grpc.ClientConn
+	streams []*ingestStream
+	next    atomic.Uint64
+}
+
+// newIngestClient dials ingesters of conf, opts are appended to the dial options
+func newIngestClient(name string, conf ingestClientConf, opts ...grpc.DialOption) (*ingestClient, error) {
+	g := conf.grpc
+	streams, maxInflight := g.Streams, g.MaxInflight
+	if streams == 0 {
+		streams = config.DefaultGRPCStreams
+	}
+	if maxInflight == 0 {
+		maxInflight = config.DefaultGRPCMaxInflight
+	}
+	kp := keepalive.ClientParameters{Time: g.KeepaliveTime, Timeout: g.KeepaliveTimeout, PermitWithoutStream: true}
+	if kp.Time == 0 {
+		kp.Time = config.DefaultGRPCKeepaliveTime
+	}
+	if kp.Timeout == 0 {
+		kp.Timeout = config.DefaultGRPCKeepaliveTimeout
+	}
+
+	creds := insecure.NewCredentials()
+	if g.TLS {
+		tlsConfig, err := utils.NewTLSConfig(&conf.tlsConfig, conf.insecure)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	r := manual.NewBuilderWithScheme(ingestResolverScheme)
+	state := resolver.State{}
+	for _, addr := range g.Addresses {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+	}
+	r.InitialState(state)
+	opts = append([]grpc.DialOption{
+		grpc.WithResolvers(r),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"round_robin": {}}]}`),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithKeepaliveParams(kp),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})),
+	}, opts...)
+	conn, err := grpc.Dial(r.Scheme()+":///"+name, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("dial ingesters failed: %w", err)
+	}
+
+	c := &ingestClient{name: name, conn: conn}
+	for i := 0; i < streams; i++ {
+		c.streams = append(c.streams, &ingestStream{client: c, slots: make(chan struct{}, maxInflight)})
+	}
+	return c, nil
+}
+
+// write sends the request on a stream with free inflight slots and waits for its acknowledgement.
+// Streams are tried round robin, and the write waits for the next stream if all of them are full
+func (c *ingestClient) write(ctx context.Context, req *prompb.IngestRequest) (*prompb.IngestResponse, error) {
+	start := c.next.Add(1)
+	var s *ingestStream
+	for i := range c.streams {
+		candidate := c.streams[(start+uint64(i))%uint64(len(c.streams))]
+		select {
+		case candidate.slots <- struct{}{}:
+			s = candidate
+		default:
+			continue
+		}
+		break
+	}
+	if s == nil {
+		s = c.streams[start%uint64(len(c.streams))]
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for inflight writes of ingesters: %w", ctx.Err())
+		}
+	}
+	return s.write(ctx, req)
+}
+
+// close closes all streams and the connection, unacknowledged writes fail
+func (c *ingestClient) close() {
+	for _, s := range c.streams {
+		s.mu.Lock()
+		stream := s.stream
+		s.mu.Unlock()
+		if stream != nil {
+			s.reset(stream, errors.New("ingest client is closed"))
+		}
+	}
+	if err := c.conn.Close(); err != nil {
+		log.Logger.Error("msg", "close ingest connection failed", "destination", c.name, "err", err)
+	}
+}
+
+// ingestStream is a write stream opened on demand, it's reset on any stream error and reopened by the next write
+type ingestStream struct {
+	client *ingestClient
+	// slots limits unacknowledged writes, a slot is taken before sending and freed on acknowledgement or reset
+	slots chan struct{}
+
+	mu      sync.Mutex
+	stream  grpc.ClientStream
+	cancel  context.CancelFunc
+	pending map[uint64]chan ingestResult
+	nextID  uint64
+
+	// sendMu serializes sending, SendMsg blocks while the stream is out of flow control window
+	sendMu sync.Mutex
+}
+
+type ingestResult struct {
+	resp *prompb.IngestResponse
+	err  error
+}
+
+// write sends the request with a taken slot. A send blocked by flow control beyond deadline of the write resets
+// the stream, since ingesters not reading it would block all writes of the stream otherwise
+func (s *ingestStream) write(ctx context.Context, req *prompb.IngestRequest) (*prompb.IngestResponse, error) {
+	s.mu.Lock()
+	if s.stream == nil {
+		if err := s.open(); err != nil {
+			s.mu.Unlock()
+			<-s.slots
+			return nil, err
+		}
+	}
+	stream := s.stream
+	s.nextID++
+	req.Id = s.nextID
+	result := make(chan ingestResult, 1)
+	s.pending[req.Id] = result
+	s.mu.Unlock()
+	grpcInflightWrites.WithLabelValues(s.client.name).Inc()
+
+	msg := req.Marshal()
+	s.sendMu.Lock()
+	var timer *time.Timer
+	if deadline, ok := ctx.Deadline(); ok {
+		timer = time.AfterFunc(time.Until(deadline), func() {
+			s.reset(stream, errors.New("send is blocked by flow control"))
+		})
+	}
+	err := stream.SendMsg(&msg)
+	if timer != nil {
+		timer.Stop()
+	}
+	s.sendMu.Unlock()
+	if err != nil {
+		// the stream is broken, and the real error is returned by receiving
+		s.reset(stream, fmt.Errorf("send write failed: %w", err))
+	}
+
+	select {
+	case r := <-result:
+		return r.resp, r.err
+	case <-ctx.Done():
+		// the slot is kept until the write is acknowledged or the stream is reset
+		return nil, fmt.Errorf("wait for acknowledgement of ingesters: %w", ctx.Err())
+	}
+}
+
+// open opens the stream with mu held, the stream lives until it's reset
+func (s *ingestStream) open() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := s.client.conn.NewStream(ctx, ingestStreamDesc, prompb.IngestWriteMethod)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("open write stream failed: %w", err)
+	}
+	s.stream, s.cancel, s.pending = stream, cancel, map[uint64]chan ingestResult{}
+	go s.recv(stream)
+	return nil
+}
+
+// recv dispatches acknowledgements of the stream until it fails
+func (s *ingestStream) recv(stream grpc.ClientStream) {
+	for {
+		var msg []byte
+		if err := stream.RecvMsg(&msg); err != nil {
+			if err == io.EOF {
+				err = errors.New("write stream is closed by ingester")
+			}
+			s.reset(stream, err)
+			return
+		}
+		resp := &prompb.IngestResponse{}
+		if err := resp.Unmarshal(msg); err != nil {
+			s.reset(stream, fmt.Errorf("decode acknowledgement failed: %w", err))
+			return
+		}
+		s.mu.Lock()
+		result, ok := s.pending[resp.Id]
+		delete(s.pending, resp.Id)
+		s.mu.Unlock()
+		if !ok {
+			continue
+		}
+		<-s.slots
+		grpcInflightWrites.WithLabelValues(s.client.name).Dec()
+		result <- ingestResult{resp: resp}
+	}
+}
+
+// reset cancels the stream and fails its unacknowledged writes, it's a no-op if the stream is already replaced
+func (s *ingestStream) reset(stream grpc.ClientStream, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream != stream {
+		return
+	}
+	log.Logger.Error("msg", "reset grpc write stream", "destination", s.client.name, "unacknowledged", len(s.pending), "err", err)
+	grpcStreamResets.WithLabelValues(s.client.name).Inc()
+	s.cancel()
+	for _, result := range s.pending {
+		<-s.slots
+		grpcInflightWrites.WithLabelValues(s.client.name).Dec()
+		result <- ingestResult{err: err}
+	}
+	s.stream, s.cancel, s.pending = nil, nil, nil
+}
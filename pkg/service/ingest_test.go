@@ -0,0 +1,214 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"prometheus-deepflow-adapter/pkg/compression"
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// testIngester is an in-process deepflow ingester, handle returns the acknowledgement of a write,
+// nil fails the stream
+type testIngester struct {
+	lis    *bufconn.Listener
+	handle func(req *prompb.IngestRequest) *prompb.IngestResponse
+
+	mu       sync.Mutex
+	streams  int
+	requests []*prompb.IngestRequest
+}
+
+func newTestIngester(t *testing.T, handle func(req *prompb.IngestRequest) *prompb.IngestResponse) *testIngester {
+	t.Helper()
+	ing := &testIngester{lis: bufconn.Listen(1 << 20), handle: handle}
+	srv := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}))
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "deepflow.adapter.v1.Ingest",
+		HandlerType: (*any)(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "Write",
+			ServerStreams: true,
+			ClientStreams: true,
+			Handler:       func(_ any, stream grpc.ServerStream) error { return ing.serve(stream) },
+		}},
+	}, nil)
+	go srv.Serve(ing.lis)
+	t.Cleanup(srv.Stop)
+	return ing
+}
+
+func (ing *testIngester) serve(stream grpc.ServerStream) error {
+	ing.mu.Lock()
+	ing.streams++
+	ing.mu.Unlock()
+	for {
+		var msg []byte
+		if err := stream.RecvMsg(&msg); err != nil {
+			return err
+		}
+		req := &prompb.IngestRequest{}
+		if err := req.Unmarshal(msg); err != nil {
+			return err
+		}
+		ing.mu.Lock()
+		ing.requests = append(ing.requests, req)
+		ing.mu.Unlock()
+		resp := ing.handle(req)
+		if resp == nil {
+			return status.Error(codes.Unavailable, "ingester is unavailable")
+		}
+		resp.Id = req.Id
+		b := resp.Marshal()
+		if err := stream.SendMsg(&b); err != nil {
+			return err
+		}
+	}
+}
+
+// client returns an ingest client of a single stream dialing the ingester
+func (ing *testIngester) client(t *testing.T, maxInflight int) *ingestClient {
+	t.Helper()
+	conf := ingestClientConf{grpc: config.GRPCClientConfig{Addresses: []string{"bufconn:0"}, Streams: 1, MaxInflight: maxInflight}}
+	c, err := newIngestClient("test", conf, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return ing.lis.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.close)
+	return c
+}
+
+func ack(req *prompb.IngestRequest) *prompb.IngestResponse {
+	if string(req.Payload) == "bad" {
+		return &prompb.IngestResponse{Status: http.StatusBadRequest, Message: "bad write"}
+	}
+	return &prompb.IngestResponse{Status: http.StatusOK}
+}
+
+func TestIngestClientAcks(t *testing.T) {
+	ing := newTestIngester(t, ack)
+	c := ing.client(t, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := fmt.Sprintf("write-%d", i)
+			resp, err := c.write(ctx, &prompb.IngestRequest{Payload: []byte(payload)})
+			if err != nil {
+				errs <- fmt.Errorf("%s: %w", payload, err)
+			} else if resp.Status != http.StatusOK {
+				errs <- fmt.Errorf("%s: status = %d", payload, resp.Status)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	resp, err := c.write(ctx, &prompb.IngestRequest{Payload: []byte("bad")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusBadRequest || resp.Message != "bad write" {
+		t.Errorf("response = %+v, want status 400 of the bad write", resp)
+	}
+	if len(ing.requests) != 9 || ing.streams != 1 {
+		t.Errorf("ingester got %d writes on %d streams, want 9 writes on 1 stream", len(ing.requests), ing.streams)
+	}
+	if n := len(c.streams[0].slots); n != 0 {
+		t.Errorf("%d inflight slots are not freed", n)
+	}
+}
+
+func TestIngestClientStreamReset(t *testing.T) {
+	var mu sync.Mutex
+	failed := false
+	ing := newTestIngester(t, func(req *prompb.IngestRequest) *prompb.IngestResponse {
+		mu.Lock()
+		defer mu.Unlock()
+		// the first write fails the stream
+		if !failed {
+			failed = true
+			return nil
+		}
+		return ack(req)
+	})
+	c := ing.client(t, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := c.write(ctx, &prompb.IngestRequest{Payload: []byte("write-0")}); err == nil {
+		t.Fatal("write on the failed stream succeeded")
+	}
+	if n := len(c.streams[0].slots); n != 0 {
+		t.Errorf("%d inflight slots are not freed by reset", n)
+	}
+	resp, err := c.write(ctx, &prompb.IngestRequest{Payload: []byte("write-1")})
+	if err != nil {
+		t.Fatalf("write after reset: %v", err)
+	}
+	if resp.Status != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.Status)
+	}
+	if ing.streams != 2 {
+		t.Errorf("ingester got %d streams, want the stream reopened once", ing.streams)
+	}
+}
+
+func TestGRPCTransportSnappyFallback(t *testing.T) {
+	ing := newTestIngester(t, func(req *prompb.IngestRequest) *prompb.IngestResponse {
+		if req.ContentEncoding != compression.Snappy {
+			return &prompb.IngestResponse{Status: http.StatusUnsupportedMediaType, Message: "unsupported content encoding"}
+		}
+		return ack(req)
+	})
+	conf := &config.RemoteWriteConfig{Name: "test", Transport: config.ClientGRPC, Compression: compression.Zstd}
+	d, err := newDestination(conf, &destinationState{ingest: ing.client(t, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := []byte("remote write payload")
+	for i := 0; i < 2; i++ {
+		if err := d.postWithRetry(context.Background(), raw, &outboundParams{}); err != nil {
+			t.Fatalf("write %d: %v", i, err.err)
+		}
+	}
+	if !d.snappyFallback.Load() {
+		t.Error("destination doesn't fall back to snappy")
+	}
+	var encodings []string
+	for _, req := range ing.requests {
+		encodings = append(encodings, req.ContentEncoding)
+	}
+	// only the first write is sent with zstd
+	if fmt.Sprint(encodings) != "[zstd snappy snappy]" {
+		t.Errorf("content encodings = %v, want [zstd snappy snappy]", encodings)
+	}
+	decoded, err := snappy.Decode(nil, ing.requests[2].Payload)
+	if err != nil || !bytes.Equal(decoded, raw) {
+		t.Errorf("payload = %q, %v, want %q", decoded, err, raw)
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/dedup"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// lateRequestKey is the write request of late samples sent to backfill destination
+const lateRequestKey = "deepflow-adapter/late-request"
+
+const (
+	reasonOutOfOrder = "out_of_order"
+	reasonTooOld     = "too_old"
+)
+
+// lateSamples is replaced only when late samples config changes, since tracked series are lost
+type lateSamples struct {
+	conf config.LateSamplesConfig
+	// tracker is nil unless late samples are tracked
+	tracker atomic.Pointer[lateTracker]
+}
+
+type lateTracker struct {
+	policy string
+	maxAge time.Duration
+	series *dedup.Deduplicator
+}
+
+func newLateSamples(conf *config.LateSamplesConfig) *lateSamples {
+	l := &lateSamples{}
+	l.ApplyConfig(conf)
+	return l
+}
+
+func (l *lateSamples) ApplyConfig(conf *config.LateSamplesConfig) {
+	if reflect.DeepEqual(l.conf, *conf) && (l.tracker.Load() != nil || !conf.Tracked()) {
+		return
+	}
+	l.conf = *conf
+	if !conf.Tracked() {
+		l.tracker.Store(nil)
+		return
+	}
+	l.tracker.Store(&lateTracker{
+		policy: conf.Policy,
+		maxAge: conf.MaxAge,
+		series: dedup.New(nil, conf.MaxSeries, conf.Expiry),
+	})
+}
+
+// splitLateSamples takes out-of-order and too old samples out of the request, they're dropped or sent to backfill
+// destination by policy. It's placed right before sending, so that series are tracked by their final labels.
+// Tracked timestamps are restored if the request is not forwarded
+func splitLateSamples(l *lateSamples) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := l.tracker.Load()
+		if t == nil {
+			return
+		}
+		now := time.Now()
+		req, tooOld := writeRequest(c), &prompb.WriteRequest{}
+		if t.maxAge > 0 {
+			req, tooOld = splitByAge(req, now.Add(-t.maxAge).UnixMilli())
+		}
+		result := t.series.Dedup(req, now)
+		outOfOrder := result.Rejected
+		lateSamplesTotal.WithLabelValues(reasonTooOld).Add(float64(countSamples(tooOld)))
+		lateSamplesTotal.WithLabelValues(reasonOutOfOrder).Add(float64(result.Duplicates))
+
+		setWriteRequest(c, result.Request)
+		if t.policy == config.LatePolicyBackfill && len(tooOld.Timeseries)+len(outOfOrder.Timeseries) > 0 {
+			c.Set(lateRequestKey, &prompb.WriteRequest{
+				Timeseries: append(tooOld.Timeseries, outOfOrder.Timeseries...),
+				Metadata:   result.Request.Metadata,
+			})
+		}
+
+		c.Next()
+		if c.IsAborted() || c.Writer.Status()/100 != 2 {
+			t.series.Cancel(result)
+		}
+	}
+}
+
+// splitByAge splits samples and histograms before minTimestamp out of the request
+func splitByAge(req *prompb.WriteRequest, minTimestamp int64) (*prompb.WriteRequest, *prompb.WriteRequest) {
+	recent := &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, len(req.Timeseries)), Metadata: req.Metadata}
+	old := &prompb.WriteRequest{Metadata: req.Metadata}
+	for _, ts := range req.Timeseries {
+		var samples, oldSamples []prompb.Sample
+		for _, s := range ts.Samples {
+			if s.Timestamp < minTimestamp {
+				oldSamples = append(oldSamples, s)
+			} else {
+				samples = append(samples, s)
+			}
+		}
+		var histograms, oldHistograms []prompb.Histogram
+		for _, h := range ts.Histograms {
+			if h.Timestamp < minTimestamp {
+				oldHistograms = append(oldHistograms, h)
+			} else {
+				histograms = append(histograms, h)
+			}
+		}
+		if len(oldSamples) > 0 || len(oldHistograms) > 0 {
+			o := ts
+			o.Samples, o.Histograms, o.Exemplars = oldSamples, oldHistograms, nil
+			old.Timeseries = append(old.Timeseries, o)
+		}
+		// exemplar-only series are kept as they are
+		if len(samples) > 0 || len(histograms) > 0 || len(ts.Samples) == 0 && len(ts.Histograms) == 0 {
+			ts.Samples, ts.Histograms = samples, histograms
+			recent.Timeseries = append(recent.Timeseries, ts)
+		}
+	}
+	return recent, old
+}
+
+// lateRequest returns the write request of late samples, nil if there's none
+func lateRequest(c *gin.Context) *prompb.WriteRequest {
+	if req, ok := c.Get(lateRequestKey); ok {
+		return req.(*prompb.WriteRequest)
+	}
+	return nil
+}
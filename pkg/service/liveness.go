@@ -1,11 +1,13 @@
 package service
 
 import (
+	"net/http"
 	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"prometheus-deepflow-adapter/pkg/breaker"
 	"prometheus-deepflow-adapter/pkg/log"
 )
 
@@ -15,6 +17,25 @@ func healthz() gin.HandlerFunc {
 	}
 }
 
+// readyz is not ready if writes to any destination are rejected by an open circuit breaker
+func readyz(w *remoteWriter, spool func() bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := http.StatusOK
+		destinations := map[string]string{}
+		for name, state := range w.breakerStates() {
+			destinations[name] = state.String()
+			if state == breaker.Open && !spool() {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		ready := "READY"
+		if status != http.StatusOK {
+			ready = "NOT READY"
+		}
+		c.JSON(status, gin.H{"status": ready, "destinations": destinations})
+	}
+}
+
 func prometheusLiveness(lastReceiveTime *int64, abortExecute func() bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		atomic.StoreInt64(lastReceiveTime, time.Now().UnixNano())
@@ -26,3 +47,23 @@ func prometheusLiveness(lastReceiveTime *int64, abortExecute func() bool) gin.Ha
 		}
 	}
 }
+
+func forwardingGate(paused *atomic.Bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if paused.Load() {
+			// prometheus retries on 5xx, so nothing is lost while forwarding is paused
+			log.Logger.Debug("msg", "forwarding is paused, reject remote write")
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// leaderGate accepts and drops writes on followers, like prometheusLiveness but it doesn't count as prometheus liveness
+func leaderGate(abortExecute func() bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if abortExecute() {
+			log.Logger.Debug("msg", "server is not leader, abort write", "path", c.Request.URL.Path)
+			c.AbortWithStatus(http.StatusNoContent)
+		}
+	}
+}
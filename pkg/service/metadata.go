@@ -0,0 +1,128 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/metadata"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+const metadataPath = "/api/v1/metadata"
+
+// metadataCache is replaced only when metadata config changes, since cached metadata is lost
+type metadataCache struct {
+	conf  config.MetadataConfig
+	cache atomic.Pointer[metadata.Cache]
+}
+
+func newMetadataCache(conf *config.MetadataConfig) *metadataCache {
+	m := &metadataCache{}
+	m.ApplyConfig(conf)
+	return m
+}
+
+func (m *metadataCache) ApplyConfig(conf *config.MetadataConfig) {
+	if m.cache.Load() != nil && reflect.DeepEqual(m.conf, *conf) {
+		return
+	}
+	m.conf = *conf
+	metadataFamilies.Set(0)
+	if !conf.Enabled {
+		m.cache.Store(nil)
+		return
+	}
+	m.cache.Store(metadata.NewCache(conf))
+}
+
+// cacheMetadata merges request metadata into cache, and attaches cached metadata of metric families
+// whose samples are in the request without their metadata, so that every destination gets it along with samples
+func cacheMetadata(m *metadataCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cache := m.cache.Load()
+		if cache == nil {
+			return
+		}
+		req, now := writeRequest(c), time.Now()
+		if dropped := cache.Update(req.Metadata, now); dropped > 0 {
+			metadataDroppedFamilies.Add(float64(dropped))
+		}
+		metadataFamilies.Set(float64(cache.Len()))
+
+		present := make(map[string]bool, len(req.Metadata))
+		for i := range req.Metadata {
+			present[req.Metadata[i].MetricFamilyName] = true
+		}
+		var attached []prompb.MetricMetadata
+		for i := range req.Timeseries {
+			name := req.Timeseries[i].MetricName()
+			family := prompb.TrimTypeSuffix(name)
+			if present[name] || present[family] {
+				continue
+			}
+			for _, f := range []string{name, family} {
+				if md, ok := cache.Lookup(f, now); ok {
+					attached = append(attached, md)
+					break
+				}
+			}
+			// families are looked up once per request
+			present[name], present[family] = true, true
+		}
+		if len(attached) == 0 {
+			return
+		}
+		out := *req
+		out.Metadata = append(append(make([]prompb.MetricMetadata, 0, len(req.Metadata)+len(attached)), req.Metadata...), attached...)
+		setWriteRequest(c, &out)
+	}
+}
+
+type metadataItem struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// listMetadata serves cached metadata in the format of prometheus /api/v1/metadata,
+// there's at most one metadata per metric since updates are merged
+func listMetadata(m *metadataCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cache := m.cache.Load()
+		if cache == nil {
+			apiError(c, http.StatusNotFound, "not_found", "metadata cache is disabled")
+			return
+		}
+		limit := -1
+		if s := c.Query("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil {
+				apiError(c, http.StatusBadRequest, "bad_data", fmt.Sprintf("invalid limit %q", s))
+				return
+			}
+		}
+		if s := c.Query("limit_per_metric"); s != "" {
+			if _, err := strconv.Atoi(s); err != nil {
+				apiError(c, http.StatusBadRequest, "bad_data", fmt.Sprintf("invalid limit_per_metric %q", s))
+				return
+			}
+		}
+		data := map[string][]metadataItem{}
+		for _, md := range cache.List(c.Query("metric"), limit, time.Now()) {
+			data[md.MetricFamilyName] = []metadataItem{{Type: md.Type.String(), Help: md.Help, Unit: md.Unit}}
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": data})
+	}
+}
+
+// apiError responds error in the format of prometheus http api
+func apiError(c *gin.Context, status int, errorType, msg string) {
+	c.AbortWithStatusJSON(status, gin.H{"status": "error", "errorType": errorType, "error": msg})
+}
@@ -0,0 +1,188 @@
+package service
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	authFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "deepflow_adapter_auth_failures_total",
+		Help: "Total number of ingest requests rejected by authentication.",
+	})
+	relabelDroppedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_relabel_dropped_series_total",
+		Help: "Total number of series dropped by relabeling, scope is global or the remote write destination name.",
+	}, []string{"scope"})
+	filterMatchedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_filter_matched_series_total",
+		Help: "Total number of series matching filter selectors, list is allow or deny, only the first matching selector of a list counts.",
+	}, []string{"scope", "list", "selector"})
+	filterDroppedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_filter_dropped_series_total",
+		Help: "Total number of series dropped by filter, scope is global or the remote write destination name.",
+	}, []string{"scope"})
+	cardinalityLimitHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_cardinality_limit_hits_total",
+		Help: "Total number of new series over the cardinality limit, limit is __name__ or the limited labels.",
+	}, []string{"limit"})
+	cardinalityRejectedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_cardinality_rejected_series_total",
+		Help: "Total number of series dropped by the cardinality limit.",
+	}, []string{"limit"})
+	cardinalityActiveSeries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deepflow_adapter_cardinality_active_series",
+		Help: "Number of active series tracked by the cardinality limit, summed over all groups of the limit.",
+	}, []string{"limit"})
+	rateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_rate_limited_requests_total",
+		Help: "Total number of ingest requests rejected with 429, scope is global, client or tenant, reason is inflight, samples or bytes.",
+	}, []string{"scope", "reason"})
+	inflightRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "deepflow_adapter_inflight_requests",
+		Help: "Number of ingest requests in flight, only tracked when max inflight requests is limited.",
+	})
+	receivedHistograms = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "deepflow_adapter_received_native_histograms_total",
+		Help: "Total number of received native histogram samples.",
+	})
+	receivedExemplars = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "deepflow_adapter_received_exemplars_total",
+		Help: "Total number of received exemplars.",
+	})
+	invalidHistograms = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_invalid_native_histograms_total",
+		Help: "Total number of native histogram samples dropped by validation.",
+	}, []string{"reason"})
+	invalidExemplars = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_invalid_exemplars_total",
+		Help: "Total number of exemplars dropped by validation.",
+	}, []string{"reason"})
+	convertedHistograms = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_converted_native_histograms_total",
+		Help: "Total number of native histogram samples converted to classic histograms.",
+	}, []string{"destination"})
+	remoteReadQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_remote_read_queries_total",
+		Help: "Total number of remote read queries, response_type is samples or streamed_xor_chunks, result is success or failure.",
+	}, []string{"response_type", "result"})
+	otlpDataPoints = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "deepflow_adapter_otlp_received_data_points_total",
+		Help: "Total number of received OTLP metric data points.",
+	})
+	otlpDroppedDataPoints = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_otlp_dropped_data_points_total",
+		Help: "Total number of OTLP metric data points which can't be translated, e.g. of delta temporality.",
+	}, []string{"reason"})
+	ingestLines = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_ingest_lines_total",
+		Help: "Total number of received lines of text protocols, protocol is influx or graphite.",
+	}, []string{"protocol"})
+	ingestDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_ingest_dropped_total",
+		Help: "Total number of dropped lines and fields of text protocols, reason is invalid_line or string_field.",
+	}, []string{"protocol", "reason"})
+	metadataFamilies = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "deepflow_adapter_metadata_cache_families",
+		Help: "Number of metric families in metadata cache.",
+	})
+	metadataDroppedFamilies = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "deepflow_adapter_metadata_cache_dropped_families_total",
+		Help: "Total number of metadata updates of new families not cached since the cache is full.",
+	})
+	dedupDuplicateSamples = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "deepflow_adapter_dedup_duplicate_samples_total",
+		Help: "Total number of samples and histogram samples dropped as they're not newer than the forwarded ones.",
+	})
+	dedupTrackedSeries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "deepflow_adapter_dedup_tracked_series",
+		Help: "Number of series whose last forwarded timestamps are tracked by deduplication.",
+	})
+	aggregateInputSamples = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "deepflow_adapter_stream_aggregation_input_samples_total",
+		Help: "Total number of samples of series matching stream aggregation rules.",
+	})
+	aggregateOutputSeries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "deepflow_adapter_stream_aggregation_output_series_total",
+		Help: "Total number of aggregate series emitted by stream aggregation, followers don't aggregate.",
+	})
+	aggregateDroppedExemplars = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "deepflow_adapter_stream_aggregation_dropped_exemplars_total",
+		Help: "Total number of exemplars dropped with series consumed by stream aggregation.",
+	})
+	tenantReceivedSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_tenant_received_samples_total",
+		Help: "Total number of received samples and histogram samples by tenant, tenants of the wildcard route are labeled *.",
+	}, []string{"tenant"})
+	tenantRejectedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_tenant_rejected_requests_total",
+		Help: "Total number of ingest requests rejected by tenant, reason is no_tenant, unknown_tenant, samples or bytes. Tenants without route are labeled unknown, tenants of the wildcard route are labeled *.",
+	}, []string{"tenant", "reason"})
+	tenantDroppedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_tenant_dropped_series_total",
+		Help: "Total number of series dropped as they have no routed tenant, reason is no_tenant or unknown_tenant.",
+	}, []string{"reason"})
+	lateSamplesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_late_samples_total",
+		Help: "Total number of late samples and histogram samples, reason is out_of_order or too_old.",
+	}, []string{"reason"})
+	remoteWriteUncompressedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_remote_write_uncompressed_bytes_total",
+		Help: "Total bytes of remote write payloads before compression.",
+	}, []string{"destination"})
+	remoteWriteCompressedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_remote_write_compressed_bytes_total",
+		Help: "Total bytes of remote write payloads after compression, retries are not counted.",
+	}, []string{"destination", "compression"})
+	remoteWriteCompressionFallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_remote_write_compression_fallbacks_total",
+		Help: "Total number of falling back to snappy since destination rejects the configured compression.",
+	}, []string{"destination", "compression"})
+	remoteWriteRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_remote_write_retries_total",
+		Help: "Total number of retried writes failed with 5xx, 429 and network errors.",
+	}, []string{"destination"})
+	remoteWriteRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_remote_write_rejected_total",
+		Help: "Total number of writes rejected by destination with 4xx other than 429, they're dropped.",
+	}, []string{"destination"})
+	grpcInflightWrites = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deepflow_adapter_grpc_inflight_writes",
+		Help: "Number of writes sent to deepflow ingesters by grpc transport and not acknowledged yet.",
+	}, []string{"destination"})
+	grpcStreamResets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_grpc_stream_resets_total",
+		Help: "Total number of grpc write streams reset by stream errors or blocked sends, unacknowledged writes of them fail.",
+	}, []string{"destination"})
+	breakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deepflow_adapter_circuit_breaker_state",
+		Help: "Circuit breaker state of remote write destination, 0: closed, 1: open, 2: half-open.",
+	}, []string{"destination"})
+	breakerTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_circuit_breaker_transitions_total",
+		Help: "Total number of circuit breaker state transitions by the new state.",
+	}, []string{"destination", "state"})
+	walSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deepflow_adapter_wal_size_bytes",
+		Help: "Size of spooled writes of remote write destination.",
+	}, []string{"destination"})
+	walSpooledRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_wal_spooled_records_total",
+		Help: "Total number of writes spooled while circuit breaker is open.",
+	}, []string{"destination"})
+	walReplayedRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deepflow_adapter_wal_replayed_records_total",
+		Help: "Total number of spooled writes replayed to remote write destination.",
+	}, []string{"destination"})
+)
+
+func init() {
+	prometheus.MustRegister(authFailures, relabelDroppedSeries, filterMatchedSeries, filterDroppedSeries,
+		cardinalityLimitHits, cardinalityRejectedSeries, cardinalityActiveSeries,
+		rateLimitedRequests, inflightRequests,
+		breakerState, breakerTransitions, walSize, walSpooledRecords, walReplayedRecords,
+		receivedHistograms, receivedExemplars, invalidHistograms, invalidExemplars, convertedHistograms, remoteReadQueries,
+		otlpDataPoints, otlpDroppedDataPoints, ingestLines, ingestDropped,
+		metadataFamilies, metadataDroppedFamilies, dedupDuplicateSamples, dedupTrackedSeries,
+		lateSamplesTotal, remoteWriteRetries, remoteWriteRejected, aggregateInputSamples, aggregateOutputSeries,
+		aggregateDroppedExemplars, remoteWriteUncompressedBytes, remoteWriteCompressedBytes, remoteWriteCompressionFallbacks,
+		grpcInflightWrites, grpcStreamResets,
+		tenantReceivedSamples, tenantRejectedRequests, tenantDroppedSeries)
+}
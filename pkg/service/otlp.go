@@ -0,0 +1,222 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // OTLP exporters compress with gzip by default
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"prometheus-deepflow-adapter/pkg/compression"
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/otlp"
+)
+
+const (
+	otlpMetricsPath = "/v1/metrics"
+	otlpMediaKey    = "deepflow-adapter/otlp-media-type"
+
+	mediaTypeProtobuf = "application/x-protobuf"
+	mediaTypeJSON     = "application/json"
+)
+
+// decodeOTLP decodes OTLP metrics and translates them into a write request, so that following
+// handlers process it as a remote write request
+func decodeOTLP(conf func() *config.OTLPConfig, serverConf func() *config.ServerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		oc := conf()
+		if !oc.Enabled {
+			c.AbortWithError(http.StatusNotFound, errors.New("OTLP ingest is disabled"))
+			return
+		}
+		mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+		if err != nil || (mediaType != mediaTypeProtobuf && mediaType != mediaTypeJSON) {
+			c.AbortWithError(http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", c.GetHeader("Content-Type")))
+			return
+		}
+		enc := c.GetHeader("Content-Encoding")
+		if enc != "" && enc != "identity" && enc != "gzip" {
+			c.AbortWithError(http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content encoding %q", enc))
+			return
+		}
+		sc := serverConf()
+		body, ok := readBody(c, sc)
+		if !ok {
+			return
+		}
+		payload := body
+		if enc == "gzip" {
+			if payload, ok = decodeBody(c, compression.Gunzip, body, sc); !ok {
+				return
+			}
+		}
+
+		var req *otlp.MetricsRequest
+		if mediaType == mediaTypeJSON {
+			req, err = otlp.UnmarshalJSON(payload)
+		} else {
+			req, err = otlp.UnmarshalProto(payload)
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("decode OTLP metrics failed: %w", err))
+			return
+		}
+		result := otlp.Translate(req, oc)
+		otlpDataPoints.Add(float64(result.DataPoints))
+		for reason, n := range result.Dropped {
+			otlpDroppedDataPoints.WithLabelValues(reason).Add(float64(n))
+		}
+		c.Set(writeRequestKey, result.Request)
+		c.Set(payloadSizeKey, len(body))
+		c.Set(otlpMediaKey, mediaType)
+	}
+}
+
+// otlpResponse responds an empty ExportMetricsServiceResponse in the encoding of request
+func otlpResponse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(otlpMediaKey) == mediaTypeJSON {
+			c.Data(http.StatusOK, mediaTypeJSON, []byte("{}"))
+			return
+		}
+		c.Data(http.StatusOK, mediaTypeProtobuf, nil)
+	}
+}
+
+// newOTLPGRPCServer serves OTLP/gRPC metrics service, requests are handed to the http handler of /v1/metrics,
+// so they go through the same authentication, limits and forwarding
+func newOTLPGRPCServer(handler http.Handler, conf *config.ServerConfig) (*grpc.Server, error) {
+	opts := []grpc.ServerOption{grpc.ForceServerCodec(rawCodec{})}
+	// grpc server shares tls config with http server
+	if conf.TLS.Enabled() {
+		reloader, err := newTLSReloader(&conf.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("load OTLP gRPC server tls config failed: %w", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(reloader.TLSConfig())))
+	}
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "opentelemetry.proto.collector.metrics.v1.MetricsService",
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Export",
+			Handler: func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				var body []byte
+				if err := dec(&body); err != nil {
+					return nil, err
+				}
+				return exportOTLP(ctx, handler, body)
+			},
+		}},
+		Metadata: "opentelemetry/proto/collector/metrics/v1/metrics_service.proto",
+	}, nil)
+	return srv, nil
+}
+
+func exportOTLP(ctx context.Context, handler http.Handler, body []byte) (any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, otlpMetricsPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// metadata(e.g. authorization) is passed as headers
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, vs := range md {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Content-Type", mediaTypeProtobuf)
+	req.Header.Del("Content-Encoding")
+	if p, ok := peer.FromContext(ctx); ok {
+		req.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.TLS = &info.State
+		}
+	}
+
+	w := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+	handler.ServeHTTP(w, req)
+	if w.status/100 == 2 {
+		return &[]byte{}, nil
+	}
+	return nil, status.Error(grpcCode(w.status), fmt.Sprintf("%d %s", w.status, bytes.TrimSpace(w.body.Bytes())))
+}
+
+// grpcCode maps http status to grpc code as OTLP specifies, retryable ones are Unavailable and ResourceExhausted
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// rawCodec passes messages as bytes, they're decoded by the http handler or the ingest client
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	return *(v.(*[]byte)), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	*(v.(*[]byte)) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+}
+
+func serveGRPC(srv *grpc.Server, addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Logger.Error("msg", "OTLP gRPC server listen error", "addr", addr, "err", err)
+		return
+	}
+	log.Logger.Info("msg", "OTLP gRPC server start up", "addr", addr)
+	if err := srv.Serve(ln); err != nil {
+		log.Logger.Error("msg", "OTLP gRPC server error", "err", err)
+	}
+}
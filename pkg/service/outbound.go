@@ -0,0 +1,143 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// outbound renders extra headers and query parameters of writes to a destination
+type outbound struct {
+	headers        map[string]*template.Template
+	headerFiles    map[string]string
+	forwardHeaders []string
+	query          map[string]*template.Template
+	forwardQuery   []string
+}
+
+// outboundParams are extra headers and query parameters of a write
+type outboundParams struct {
+	header http.Header
+	query  url.Values
+	// identity is the identity params are rendered with
+	identity string
+}
+
+func newOutbound(conf *config.RemoteWriteConfig) (*outbound, error) {
+	o := &outbound{
+		headers:        map[string]*template.Template{},
+		headerFiles:    conf.HeaderFiles,
+		forwardHeaders: conf.ForwardHeaders,
+		query:          map[string]*template.Template{},
+		forwardQuery:   conf.ForwardQueryParams,
+	}
+	for name, text := range conf.Headers {
+		t, err := config.ParseOutboundTemplate(name, text)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		o.headers[name] = t
+	}
+	for name, text := range conf.QueryParams {
+		t, err := config.ParseOutboundTemplate(name, text)
+		if err != nil {
+			return nil, fmt.Errorf("query param %s: %w", name, err)
+		}
+		o.query[name] = t
+	}
+	return o, nil
+}
+
+// templated reports whether any value is a template, external labels are only collected for templates
+func (o *outbound) templated() bool {
+	return len(o.headers) > 0 || len(o.query) > 0
+}
+
+// render returns extra headers and query parameters of a write, inbound is nil if the write has no inbound
+// request, e.g. replayed writes. Forwarded values are overridden by configured ones
+func (o *outbound) render(inbound *http.Request, data *config.OutboundTemplateData) (*outboundParams, error) {
+	p := &outboundParams{header: http.Header{}, query: url.Values{}, identity: data.Identity}
+	if inbound != nil {
+		for _, name := range o.forwardHeaders {
+			if values := inbound.Header.Values(name); len(values) > 0 {
+				p.header[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		inboundQuery := inbound.URL.Query()
+		for _, name := range o.forwardQuery {
+			if values, ok := inboundQuery[name]; ok {
+				p.query[name] = values
+			}
+		}
+	}
+	if err := renderHeaders(p.header, o.headers, data); err != nil {
+		return nil, err
+	}
+	// files are read on every write, so rotated secrets take effect without reload
+	for name, file := range o.headerFiles {
+		value, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read header %s: %w", name, err)
+		}
+		p.header.Set(name, strings.TrimSpace(string(value)))
+	}
+	var b strings.Builder
+	for name, t := range o.query {
+		b.Reset()
+		if err := t.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("render query param %s: %w", name, err)
+		}
+		p.query.Set(name, b.String())
+	}
+	return p, nil
+}
+
+// renderHeaders sets headers rendered from templates
+func renderHeaders(h http.Header, templates map[string]*template.Template, data *config.OutboundTemplateData) error {
+	var b strings.Builder
+	for name, t := range templates {
+		b.Reset()
+		if err := t.Execute(&b, data); err != nil {
+			return fmt.Errorf("render header %s: %w", name, err)
+		}
+		h.Set(name, b.String())
+	}
+	return nil
+}
+
+// externalLabels returns labels shared by all series of the request except metric name,
+// prometheus external labels are attached to every series
+func externalLabels(req *prompb.WriteRequest) map[string]string {
+	if len(req.Timeseries) == 0 {
+		return map[string]string{}
+	}
+	labels := map[string]string{}
+	for _, l := range req.Timeseries[0].Labels {
+		if l.Name != prompb.MetricNameLabel {
+			labels[l.Name] = l.Value
+		}
+	}
+	for _, ts := range req.Timeseries[1:] {
+		for name, value := range labels {
+			if prompb.LabelValue(ts.Labels, name) != value {
+				delete(labels, name)
+			}
+		}
+		if len(labels) == 0 {
+			break
+		}
+	}
+	return labels
+}
+
+// hostname is the identity of outbound templates if the elector doesn't expose one
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
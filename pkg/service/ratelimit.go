@@ -0,0 +1,236 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+const (
+	rateLimitGlobal = "global"
+	rateLimitClient = "client"
+
+	reasonInflight = "inflight"
+	reasonSamples  = "samples"
+	reasonBytes    = "bytes"
+)
+
+// rateLimiter is replaced when rate limit config changes
+type rateLimiter struct {
+	// inflight is nil if max inflight requests is not limited
+	inflight  chan struct{}
+	global    *buckets
+	perClient config.RateLimits
+	clientKey string
+	idle      time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*clientBuckets
+	lastPrune time.Time
+}
+
+// buckets are token buckets of samples and bytes, nil bucket means no limit
+type buckets struct {
+	samples *rate.Limiter
+	bytes   *rate.Limiter
+}
+
+type clientBuckets struct {
+	*buckets
+	lastSeen time.Time
+}
+
+func newRateLimiter(conf *config.RateLimitConfig) *rateLimiter {
+	l := &rateLimiter{
+		perClient: conf.PerClient,
+		clientKey: conf.ClientKey,
+		idle:      conf.ClientIdleTimeout,
+		clients:   map[string]*clientBuckets{},
+		lastPrune: time.Now(),
+	}
+	if conf.MaxInflightRequests > 0 {
+		l.inflight = make(chan struct{}, conf.MaxInflightRequests)
+	}
+	if conf.Global.Enabled() {
+		l.global = newBuckets(&conf.Global)
+	}
+	return l
+}
+
+func newBuckets(conf *config.RateLimits) *buckets {
+	return &buckets{
+		samples: newBucket(conf.SamplesPerSecond, conf.SamplesBurst),
+		bytes:   newBucket(conf.BytesPerSecond, conf.BytesBurst),
+	}
+}
+
+func newBucket(r float64, burst int) *rate.Limiter {
+	if r <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(r))
+	}
+	return rate.NewLimiter(rate.Limit(r), burst)
+}
+
+// reserve takes tokens from both buckets, nothing is taken if any of them is exhausted,
+// the returned delay is how long the client should wait before retrying, taken tokens are
+// returned by cancel
+func (b *buckets) reserve(now time.Time, samples, bytes int) (cancel func(), delay time.Duration, reason string) {
+	rs, delay := reserve(b.samples, now, samples)
+	if delay > 0 {
+		return nil, delay, reasonSamples
+	}
+	rb, delay := reserve(b.bytes, now, bytes)
+	if delay > 0 {
+		cancelAt(rs, now)
+		return nil, delay, reasonBytes
+	}
+	return func() {
+		cancelAt(rs, now)
+		cancelAt(rb, now)
+	}, 0, ""
+}
+
+func reserve(l *rate.Limiter, now time.Time, n int) (*rate.Reservation, time.Duration) {
+	if l == nil {
+		return nil, 0
+	}
+	// a request larger than burst would never pass, it takes the whole bucket instead
+	if n > l.Burst() {
+		n = l.Burst()
+	}
+	r := l.ReserveN(now, n)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return nil, delay
+	}
+	return r, 0
+}
+
+func cancelAt(r *rate.Reservation, now time.Time) {
+	if r != nil {
+		r.CancelAt(now)
+	}
+}
+
+func (l *rateLimiter) client(key string, now time.Time) *buckets {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPrune) > l.idle {
+		for k, cb := range l.clients {
+			if now.Sub(cb.lastSeen) > l.idle {
+				delete(l.clients, k)
+			}
+		}
+		l.lastPrune = now
+	}
+	cb, ok := l.clients[key]
+	if !ok {
+		cb = &clientBuckets{buckets: newBuckets(&l.perClient)}
+		l.clients[key] = cb
+	}
+	cb.lastSeen = now
+	return cb.buckets
+}
+
+func (l *rateLimiter) clientID(c *gin.Context) string {
+	if l.clientKey == config.ClientKeyPrincipal {
+		if p := principal(c); p != nil {
+			return p.Name
+		}
+	}
+	return remoteIP(c)
+}
+
+// remoteIP is the ip of the peer, forwarded headers are ignored since they're set by clients as well
+func remoteIP(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
+// limitInflight bounds concurrent ingest requests, it's placed before decoding to bound memory as well
+func limitInflight(p *atomic.Pointer[rateLimiter]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		inflight := p.Load().inflight
+		if inflight == nil {
+			return
+		}
+		select {
+		case inflight <- struct{}{}:
+		default:
+			throttle(c, rateLimitGlobal, reasonInflight, time.Second)
+			return
+		}
+		inflightRequests.Inc()
+		defer func() {
+			<-inflight
+			inflightRequests.Dec()
+		}()
+		c.Next()
+	}
+}
+
+// limitRate throttles requests by decoded samples and payload bytes, global limit is checked
+// after client limit, so a throttled client doesn't consume global tokens
+func limitRate(p *atomic.Pointer[rateLimiter]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l := p.Load()
+		if l.global == nil && !l.perClient.Enabled() {
+			return
+		}
+		now := time.Now()
+		samples, bytes := countSamples(writeRequest(c)), payloadSize(c)
+
+		cancelClient := func() {}
+		if l.perClient.Enabled() {
+			cancel, delay, reason := l.client(l.clientID(c), now).reserve(now, samples, bytes)
+			if delay > 0 {
+				throttle(c, rateLimitClient, reason, delay)
+				return
+			}
+			cancelClient = cancel
+		}
+		if l.global != nil {
+			if _, delay, reason := l.global.reserve(now, samples, bytes); delay > 0 {
+				// tokens of the client are returned as the request is not accepted
+				cancelClient()
+				throttle(c, rateLimitGlobal, reason, delay)
+				return
+			}
+		}
+	}
+}
+
+func throttle(c *gin.Context, scope, reason string, delay time.Duration) {
+	rateLimitedRequests.WithLabelValues(scope, reason).Inc()
+	log.Logger.Debug("msg", "ingest request is throttled", "client", remoteIP(c), "scope", scope, "reason", reason, "retry-after", delay)
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	c.AbortWithError(http.StatusTooManyRequests, fmt.Errorf("%s %s rate limit exceeded", scope, reason))
+}
+
+// countSamples counts samples and histogram samples, exemplars are not counted
+func countSamples(req *prompb.WriteRequest) int {
+	n := 0
+	for i := range req.Timeseries {
+		n += len(req.Timeseries[i].Samples) + len(req.Timeseries[i].Histograms)
+	}
+	return n
+}
@@ -0,0 +1,22 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+func TestRateLimitClientIgnoresForwardedFor(t *testing.T) {
+	l := &rateLimiter{clientKey: config.ClientKeyIP}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/receive", nil)
+	c.Request.RemoteAddr = "10.0.0.1:34567"
+	c.Request.Header.Set("X-Forwarded-For", "192.168.0.1")
+	c.Request.Header.Set("X-Real-IP", "192.168.0.2")
+	if got := l.clientID(c); got != "10.0.0.1" {
+		t.Errorf("client = %q, want 10.0.0.1", got)
+	}
+}
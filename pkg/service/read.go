@@ -0,0 +1,217 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/snappy"
+
+	"prometheus-deepflow-adapter/pkg/chunkenc"
+	"prometheus-deepflow-adapter/pkg/compression"
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/prompb"
+	"prometheus-deepflow-adapter/pkg/querier"
+)
+
+const (
+	responseTypeSamples  = "samples"
+	responseTypeStreamed = "streamed_xor_chunks"
+)
+
+// maxBytesInFrame is the frame size chunked series are split by, as prometheus does
+const maxBytesInFrame = 1 << 20
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// newQuerier returns nil if remote read is disabled
+func newQuerier(conf *config.RemoteReadConfig) (*querier.Client, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	return querier.New(conf)
+}
+
+// remoteRead serves prometheus remote read from querier, streamed xor chunks are responded if client accepts them
+func remoteRead(q *atomic.Pointer[querier.Client], conf func() *config.ServerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := q.Load()
+		if client == nil {
+			c.AbortWithError(http.StatusNotFound, errors.New("remote read is disabled"))
+			return
+		}
+		sc := conf()
+		compressed, ok := readBody(c, sc)
+		if !ok {
+			return
+		}
+		raw, ok := decodeBody(c, compression.Default, compressed, sc)
+		if !ok {
+			return
+		}
+		req := &prompb.ReadRequest{}
+		if err := req.Unmarshal(raw); err != nil {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("decode read request failed: %w", err))
+			return
+		}
+
+		if negotiateResponseType(req.AcceptedResponseTypes) == prompb.ResponseTypeStreamedXORChunks {
+			streamChunks(c, client, req)
+			return
+		}
+		readSamples(c, client, req)
+	}
+}
+
+// negotiateResponseType returns the first accepted response type adapter supports
+func negotiateResponseType(accepted []prompb.ResponseType) prompb.ResponseType {
+	for _, t := range accepted {
+		if t == prompb.ResponseTypeSamples || t == prompb.ResponseTypeStreamedXORChunks {
+			return t
+		}
+	}
+	return prompb.ResponseTypeSamples
+}
+
+// readSamples runs queries concurrently and responds all results in a snappy compressed ReadResponse
+func readSamples(c *gin.Context, client *querier.Client, req *prompb.ReadRequest) {
+	resp := &prompb.ReadResponse{Results: make([]prompb.QueryResult, len(req.Queries))}
+	errs := make([]error, len(req.Queries))
+	var wg sync.WaitGroup
+	for i := range req.Queries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp.Results[i].Timeseries, errs[i] = client.Select(c.Request.Context(), &req.Queries[i])
+		}(i)
+	}
+	wg.Wait()
+	var failed error
+	for _, err := range errs {
+		if err != nil {
+			remoteReadQueries.WithLabelValues(responseTypeSamples, "failure").Inc()
+			failed = err
+		} else {
+			remoteReadQueries.WithLabelValues(responseTypeSamples, "success").Inc()
+		}
+	}
+	if failed != nil {
+		c.AbortWithError(readErrorStatus(failed), failed)
+		return
+	}
+
+	c.Header("Content-Encoding", "snappy")
+	c.Data(http.StatusOK, "application/x-protobuf", snappy.Encode(nil, resp.Marshal()))
+}
+
+// streamChunks responds queries in order, series are encoded in xor chunks and written in frames,
+// errors after the first frame can only be logged since the status is written
+func streamChunks(c *gin.Context, client *querier.Client, req *prompb.ReadRequest) {
+	written := false
+	for i := range req.Queries {
+		series, err := client.Select(c.Request.Context(), &req.Queries[i])
+		if err != nil {
+			remoteReadQueries.WithLabelValues(responseTypeStreamed, "failure").Inc()
+			if !written {
+				c.AbortWithError(readErrorStatus(err), err)
+				return
+			}
+			log.Logger.Error("msg", "remote read query failed after response started", "query", i, "err", err)
+			c.Abort()
+			return
+		}
+		remoteReadQueries.WithLabelValues(responseTypeStreamed, "success").Inc()
+
+		if !written {
+			writeStreamedHeader(c)
+			written = true
+		}
+		if err := writeChunkedSeries(c.Writer, int64(i), series); err != nil {
+			log.Logger.Error("msg", "write remote read response failed", "err", err)
+			c.Abort()
+			return
+		}
+	}
+	if !written {
+		writeStreamedHeader(c)
+	}
+}
+
+func writeStreamedHeader(c *gin.Context) {
+	c.Header("Content-Type", "application/x-streamed-protobuf; proto="+prompb.ProtoMsgChunkedReadResponse)
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+}
+
+// writeChunkedSeries writes a frame per series, or more frames if its chunks exceed maxBytesInFrame
+func writeChunkedSeries(w gin.ResponseWriter, queryIndex int64, series []prompb.TimeSeries) error {
+	for i := range series {
+		frame := prompb.ChunkedReadResponse{
+			ChunkedSeries: []prompb.ChunkedSeries{{Labels: series[i].Labels}},
+			QueryIndex:    queryIndex,
+		}
+		cs := &frame.ChunkedSeries[0]
+		size := 0
+		samples := series[i].Samples
+		for len(samples) > 0 {
+			n := len(samples)
+			if n > chunkenc.MaxSamplesPerChunk {
+				n = chunkenc.MaxSamplesPerChunk
+			}
+			chunk := chunkenc.NewXORChunk()
+			for _, s := range samples[:n] {
+				chunk.Append(s.Timestamp, s.Value)
+			}
+			cs.Chunks = append(cs.Chunks, prompb.Chunk{
+				MinTimeMs: samples[0].Timestamp,
+				MaxTimeMs: samples[n-1].Timestamp,
+				Type:      prompb.ChunkEncodingXOR,
+				Data:      chunk.Bytes(),
+			})
+			size += len(chunk.Bytes())
+			samples = samples[n:]
+
+			if size >= maxBytesInFrame && len(samples) > 0 {
+				if err := writeFrame(w, frame.Marshal()); err != nil {
+					return err
+				}
+				cs.Chunks, size = nil, 0
+			}
+		}
+		if err := writeFrame(w, frame.Marshal()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFrame writes uvarint size, big endian crc32 castagnoli checksum and the message
+func writeFrame(w gin.ResponseWriter, msg []byte) error {
+	buf := make([]byte, binary.MaxVarintLen64+4, binary.MaxVarintLen64+4+len(msg))
+	n := binary.PutUvarint(buf, uint64(len(msg)))
+	binary.BigEndian.PutUint32(buf[n:], crc32.Checksum(msg, castagnoli))
+	buf = append(buf[:n+4], msg...)
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// readErrorStatus passes through client errors of querier, other failures are 500
+func readErrorStatus(err error) int {
+	if errors.Is(err, querier.ErrTooManySamples) {
+		return http.StatusBadRequest
+	}
+	var qe *querier.Error
+	if errors.As(err, &qe) && qe.StatusCode/100 == 4 {
+		return qe.StatusCode
+	}
+	return http.StatusInternalServerError
+}
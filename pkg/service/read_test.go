@@ -0,0 +1,270 @@
+package service
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/snappy"
+
+	"prometheus-deepflow-adapter/pkg/chunkenc"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// testQuerier responds every query with series, samples are 15s apart from 0 to the end of the query
+type testQuerier struct {
+	*httptest.Server
+	series  map[string]int
+	status  int
+	mu      sync.Mutex
+	queries []string
+}
+
+func newTestQuerier(t *testing.T, series map[string]int) *testQuerier {
+	t.Helper()
+	q := &testQuerier{series: series}
+	q.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		q.mu.Lock()
+		q.queries = append(q.queries, r.PostForm.Get("query"))
+		q.mu.Unlock()
+		if q.status != 0 {
+			w.WriteHeader(q.status)
+			fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"invalid query"}`)
+			return
+		}
+		var result []string
+		for instance, n := range q.series {
+			var values []string
+			for i := 0; i < n; i++ {
+				values = append(values, fmt.Sprintf(`[%d,"%d"]`, i*15, i))
+			}
+			result = append(result, fmt.Sprintf(`{"metric":{"__name__":"up","instance":%q},"values":[%s]}`, instance, strings.Join(values, ",")))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[%s]}}`, strings.Join(result, ","))
+	}))
+	t.Cleanup(q.Close)
+	return q
+}
+
+func newReadService(t *testing.T, q *testQuerier) *Service {
+	t.Helper()
+	upstream := newTestUpstream(t)
+	c := newTestConfig(t, upstream.URL)
+	c.RemoteReadConfig.Enabled = true
+	c.RemoteReadConfig.Url = q.URL
+	return newTestService(t, c)
+}
+
+func read(s *Service, req *prompb.ReadRequest) *httptest.ResponseRecorder {
+	return post(s, "/read", "snappy", snappy.Encode(nil, req.Marshal()))
+}
+
+func TestRemoteReadMatchers(t *testing.T) {
+	tests := []struct {
+		name     string
+		matchers []prompb.LabelMatcher
+		want     string
+	}{
+		{
+			name:     "equal",
+			matchers: []prompb.LabelMatcher{{Type: prompb.MatchEqual, Name: "__name__", Value: "up"}},
+			want:     `{__name__="up"}[60001ms]`,
+		},
+		{
+			name: "all match types",
+			matchers: []prompb.LabelMatcher{
+				{Type: prompb.MatchEqual, Name: "__name__", Value: "up"},
+				{Type: prompb.MatchNotEqual, Name: "job", Value: ""},
+				{Type: prompb.MatchRegexp, Name: "instance", Value: "a|b"},
+				{Type: prompb.MatchNotRegexp, Name: "env", Value: "dev.*"},
+			},
+			want: `{__name__="up",job!="",instance=~"a|b",env!~"dev.*"}[60001ms]`,
+		},
+		{
+			name:     "escaped value",
+			matchers: []prompb.LabelMatcher{{Type: prompb.MatchRegexp, Name: "path", Value: `"/var/.*\.log"`}},
+			want:     `{path=~"\"/var/.*\\.log\""}[60001ms]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQuerier(t, nil)
+			s := newReadService(t, q)
+			req := &prompb.ReadRequest{Queries: []prompb.Query{{StartTimestampMs: 0, EndTimestampMs: 60000, Matchers: tt.matchers}}}
+			if w := read(s, req); w.Code != http.StatusOK {
+				t.Fatalf("code = %d: %s", w.Code, w.Body.String())
+			}
+			if !reflect.DeepEqual(q.queries, []string{tt.want}) {
+				t.Errorf("queries = %v, want [%s]", q.queries, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteReadSamples(t *testing.T) {
+	q := newTestQuerier(t, map[string]int{"b": 3, "a": 2})
+	s := newReadService(t, q)
+	req := &prompb.ReadRequest{
+		Queries: []prompb.Query{
+			{EndTimestampMs: 60000, Matchers: []prompb.LabelMatcher{{Name: "__name__", Value: "up"}}},
+			{EndTimestampMs: 15000, Matchers: []prompb.LabelMatcher{{Name: "__name__", Value: "up"}}},
+		},
+		AcceptedResponseTypes: []prompb.ResponseType{prompb.ResponseTypeSamples},
+	}
+	w := read(s, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "snappy" {
+		t.Fatalf("code = %d, encoding = %q: %s", w.Code, w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+	raw, err := snappy.Decode(nil, w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &prompb.ReadResponse{}
+	if err := resp.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(resp.Results))
+	}
+	// results are in order of queries, samples after the end of a query are dropped
+	for i, want := range [][]int{{2, 3}, {2, 2}} {
+		series := resp.Results[i].Timeseries
+		if len(series) != 2 || series[0].Labels[1].Value != "a" || series[1].Labels[1].Value != "b" {
+			t.Fatalf("result %d: series = %v", i, series)
+		}
+		for j, n := range want {
+			if len(series[j].Samples) != n {
+				t.Errorf("result %d series %d: got %d samples, want %d", i, j, len(series[j].Samples), n)
+			}
+		}
+	}
+}
+
+func TestRemoteReadStreamedChunks(t *testing.T) {
+	q := newTestQuerier(t, map[string]int{"a": 2*chunkenc.MaxSamplesPerChunk + 10, "b": 1})
+	s := newReadService(t, q)
+	matchers := []prompb.LabelMatcher{{Name: "__name__", Value: "up"}}
+	req := &prompb.ReadRequest{
+		Queries: []prompb.Query{
+			{EndTimestampMs: 1 << 40, Matchers: matchers},
+			{StartTimestampMs: 15000, EndTimestampMs: 30000, Matchers: matchers},
+		},
+		AcceptedResponseTypes: []prompb.ResponseType{prompb.ResponseTypeStreamedXORChunks, prompb.ResponseTypeSamples},
+	}
+	w := read(s, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse" {
+		t.Errorf("content type = %q", ct)
+	}
+
+	var frames []prompb.ChunkedReadResponse
+	r := bufio.NewReader(w.Body)
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var checksum [4]byte
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(r, checksum[:]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		if binary.BigEndian.Uint32(checksum[:]) != crc32.Checksum(msg, castagnoli) {
+			t.Fatalf("frame %d: checksum mismatch", len(frames))
+		}
+		var frame prompb.ChunkedReadResponse
+		if err := frame.Unmarshal(msg); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+
+	// a frame per series, in order of queries and labels
+	want := []struct {
+		queryIndex int64
+		instance   string
+		// chunks are [min time, max time, samples]
+		chunks [][3]int64
+	}{
+		{0, "a", [][3]int64{{0, 1785000, 120}, {1800000, 3585000, 120}, {3600000, 3735000, 10}}},
+		{0, "b", [][3]int64{{0, 0, 1}}},
+		{1, "a", [][3]int64{{15000, 30000, 2}}},
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(want))
+	}
+	for i, w := range want {
+		f := frames[i]
+		if f.QueryIndex != w.queryIndex || len(f.ChunkedSeries) != 1 {
+			t.Fatalf("frame %d: query index = %d with %d series", i, f.QueryIndex, len(f.ChunkedSeries))
+		}
+		cs := f.ChunkedSeries[0]
+		if !reflect.DeepEqual(cs.Labels, []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: w.instance}}) {
+			t.Errorf("frame %d: labels = %v", i, cs.Labels)
+		}
+		if len(cs.Chunks) != len(w.chunks) {
+			t.Fatalf("frame %d: got %d chunks, want %d", i, len(cs.Chunks), len(w.chunks))
+		}
+		for j, c := range cs.Chunks {
+			got := [3]int64{c.MinTimeMs, c.MaxTimeMs, int64(binary.BigEndian.Uint16(c.Data))}
+			if c.Type != prompb.ChunkEncodingXOR || got != w.chunks[j] {
+				t.Errorf("frame %d chunk %d: type %d %v, want xor %v", i, j, c.Type, got, w.chunks[j])
+			}
+		}
+	}
+}
+
+func TestRemoteReadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		accepted []prompb.ResponseType
+		body     []byte
+		status   int
+		code     int
+	}{
+		{name: "querier client error of samples", status: http.StatusBadRequest, code: http.StatusBadRequest},
+		{
+			name:     "querier client error of streamed chunks",
+			accepted: []prompb.ResponseType{prompb.ResponseTypeStreamedXORChunks},
+			status:   http.StatusUnprocessableEntity,
+			code:     http.StatusUnprocessableEntity,
+		},
+		{name: "querier server error", status: http.StatusServiceUnavailable, code: http.StatusInternalServerError},
+		{name: "invalid snappy", body: []byte("not snappy"), code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQuerier(t, nil)
+			q.status = tt.status
+			s := newReadService(t, q)
+			body := tt.body
+			if body == nil {
+				req := &prompb.ReadRequest{
+					Queries:               []prompb.Query{{EndTimestampMs: 60000}},
+					AcceptedResponseTypes: tt.accepted,
+				}
+				body = snappy.Encode(nil, req.Marshal())
+			}
+			if w := post(s, "/read", "snappy", body); w.Code != tt.code {
+				t.Errorf("code = %d, want %d: %s", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/prompb"
+	"prometheus-deepflow-adapter/pkg/relabel"
+)
+
+const globalRelabelScope = "global"
+
+// relabelSeries applies the global relabel rules to the decoded write request
+func relabelSeries(rules *atomic.Pointer[[]*relabel.Rule]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setWriteRequest(c, relabelRequest(writeRequest(c), *rules.Load(), globalRelabelScope))
+	}
+}
+
+// relabelRequest returns a relabeled copy of req, req itself is returned if there's no rule
+func relabelRequest(req *prompb.WriteRequest, rules []*relabel.Rule, scope string) *prompb.WriteRequest {
+	if len(rules) == 0 {
+		return req
+	}
+	out := &prompb.WriteRequest{
+		Timeseries: make([]prompb.TimeSeries, 0, len(req.Timeseries)),
+		Metadata:   req.Metadata,
+	}
+	for _, ts := range req.Timeseries {
+		labels, keep := relabel.Process(ts.Labels, rules)
+		if !keep {
+			relabelDroppedSeries.WithLabelValues(scope).Inc()
+			continue
+		}
+		ts.Labels = labels
+		out.Timeseries = append(out.Timeseries, ts)
+	}
+	return out
+}
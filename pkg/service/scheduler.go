@@ -3,25 +3,26 @@ package service
 import (
 	"context"
 	"prometheus-deepflow-adapter/pkg/log"
+	"sync/atomic"
 	"time"
 )
 
-// temporary add magic timeout for prometheus remote_write
-// TODO: make it configurable
-const magicTimeout = 10 * time.Second
-
 func (svc *Service) prometheusLivenessCheck(ctx context.Context) {
-	ticker := time.NewTicker(magicTimeout)
-	for range ticker.C {
-		elapsed := time.Since(time.Unix(0, svc.lastReceiveTime))
-		if elapsed > svc.conf.PrometheusScrapeInterval+magicTimeout {
+	for range svc.livenessCheck.C {
+		conf := svc.config()
+		elector := svc.getElector()
+		if conf.PrometheusScrapeInterval <= 0 || !conf.ElectionEnabled || elector == nil {
+			continue
+		}
+		elapsed := time.Since(time.Unix(0, atomic.LoadInt64(&svc.lastReceiveTime)))
+		if elapsed > conf.PrometheusScrapeInterval+conf.PrometheusLivenessTimeout {
 			svc.keepAlive.Stop()
 			svc.retryLock.Stop()
 			svc.stopLivenessCheck.Store(true)
 
-			if svc.elector.IsLeader() {
+			if elector.IsLeader() {
 				// prometheus liveness check failed
-				err := svc.elector.Release(ctx)
+				err := elector.Release(ctx)
 				if err != nil {
 					log.Logger.Error("msg", "release elector locker failed", "err", err)
 				}
@@ -32,7 +33,7 @@ func (svc *Service) prometheusLivenessCheck(ctx context.Context) {
 				// if locker is stop, resume it
 				log.Logger.Debug("msg", "prometheus liveness check pass, resume locker")
 				svc.stopLivenessCheck.Store(false)
-				svc.retryLock.Reset(svc.elector.RetryPeriod())
+				svc.retryLock.Reset(elector.RetryPeriod())
 			}
 		}
 	}
@@ -42,12 +43,20 @@ func (svc *Service) lockerRetry(ctx context.Context) {
 	for {
 		select {
 		case <-svc.retryLock.C:
-			if !svc.elector.IsLeader() {
-				err := svc.elector.StartLeading(ctx)
+			if time.Now().UnixNano() < svc.stepDownUntil.Load() {
+				log.Logger.Debug("msg", "server stepped down, skip trying get leader")
+				continue
+			}
+			elector := svc.getElector()
+			if elector == nil {
+				continue
+			}
+			if !elector.IsLeader() {
+				err := elector.StartLeading(ctx)
 				if err != nil {
 					log.Logger.Debug("msg", "server keep trying get leader failed")
 				} else {
-					svc.keepAlive.Reset(svc.elector.HeartBeat())
+					svc.keepAlive.Reset(elector.HeartBeat())
 				}
 			}
 		}
@@ -58,8 +67,8 @@ func (svc *Service) lockerKeepAlive(ctx context.Context) {
 	for {
 		select {
 		case <-svc.keepAlive.C:
-			if svc.elector.IsLeader() {
-				svc.elector.KeepAlive(ctx)
+			if elector := svc.getElector(); elector != nil && elector.IsLeader() {
+				elector.KeepAlive(ctx)
 				log.Logger.Debug("msg", "server locker keep alive")
 			}
 		}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/plugins/election"
+)
+
+const testElectorName config.Elector = "test"
+
+// testElector takes the lock once lockFree is set
+type testElector struct {
+	lockFree atomic.Bool
+	leader   atomic.Bool
+	attempts atomic.Int32
+}
+
+var (
+	testElectorInstance *testElector
+	testElectorBuilds   atomic.Int32
+)
+
+func init() {
+	election.RegisterElector(testElectorName, func(config.Configuration) (election.Election, error) {
+		testElectorBuilds.Add(1)
+		return testElectorInstance, nil
+	})
+}
+
+func (e *testElector) StartLeading(context.Context) error {
+	e.attempts.Add(1)
+	if !e.lockFree.Load() {
+		return errors.New("lock is held by another server")
+	}
+	e.leader.Store(true)
+	return nil
+}
+
+func (e *testElector) Release(context.Context) error {
+	e.leader.Store(false)
+	return nil
+}
+
+func (e *testElector) IsLeader() bool                             { return e.leader.Load() }
+func (e *testElector) KeepAlive(context.Context)                  {}
+func (e *testElector) RetryPeriod() time.Duration                 { return 10 * time.Millisecond }
+func (e *testElector) HeartBeat() time.Duration                   { return time.Hour }
+func (e *testElector) Identity() string                           { return "server-a" }
+func (e *testElector) LockHolder(context.Context) (string, error) { return "server-b", nil }
+
+// newElectedService starts the service with the test elector, it's the leader if the lock is free
+func newElectedService(t *testing.T, c *config.Config, lockFree bool) *Service {
+	t.Helper()
+	testElectorInstance = &testElector{}
+	testElectorInstance.lockFree.Store(lockFree)
+	testElectorBuilds.Store(0)
+	c.ElectionEnabled = true
+	c.Elector = testElectorName
+	s := newTestService(t, c)
+	t.Cleanup(func() {
+		s.retryLock.Stop()
+		s.keepAlive.Stop()
+	})
+	return s
+}
+
+func TestFollowerKeepsElector(t *testing.T) {
+	upstream := newTestUpstream(t)
+	s := newElectedService(t, newTestConfig(t, upstream.URL), false)
+
+	info := s.electorInfo(context.Background())
+	if info.State != electorFollower || info.Identity != "server-a" || info.LockHolder != "server-b" {
+		t.Errorf("elector info of follower = %+v", info)
+	}
+	// followers retry with the same elector
+	deadline := time.Now().Add(5 * time.Second)
+	for testElectorInstance.attempts.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("follower doesn't retry leading")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	testElectorInstance.lockFree.Store(true)
+	for !s.getElector().IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("follower doesn't take the released lock")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := testElectorBuilds.Load(); n != 1 {
+		t.Errorf("elector is built %d times, want once", n)
+	}
+}
@@ -1,51 +1,683 @@
 package service
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"net/http"
-	"prometheus-deepflow-adapter/pkg/log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/gin-gonic/gin"
-)
+	"github.com/golang/snappy"
 
-var (
-	httpclient = http.DefaultClient
+	"prometheus-deepflow-adapter/pkg/breaker"
+	"prometheus-deepflow-adapter/pkg/compression"
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/prompb"
+	"prometheus-deepflow-adapter/pkg/relabel"
+	"prometheus-deepflow-adapter/pkg/selector"
+	"prometheus-deepflow-adapter/pkg/utils"
+	"prometheus-deepflow-adapter/pkg/wal"
 )
 
-func sendSamples(remoteUrl string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		req, err := http.NewRequest("POST", remoteUrl, c.Request.Body)
+// remoteWriter holds the outbound destinations, they're replaced on config reload
+type remoteWriter struct {
+	destinations atomic.Pointer[[]*destination]
+
+	// states of destinations are kept by name across config reloads
+	mu      sync.Mutex
+	states  map[string]*destinationState
+	walConf config.WalConfig
+	stop    chan struct{}
+	// identity is the identity of outbound templates, it's set by service once elector starts
+	identity func() string
+}
+
+type destination struct {
+	*destinationState
+	name      string
+	transport transport
+	rules     []*relabel.Rule
+	filter    *selector.Filter
+	// protoMsg is the remote write version destination accepts, requests are translated to it
+	protoMsg          string
+	convertHistograms bool
+	// spool is set if writes are spooled to wal while breaker is open
+	spool bool
+	// unreplayable are the configs rendering params which may not be replayed, writes with such params are
+	// rejected instead of spooled
+	unreplayable []string
+	// backfill destination receives late samples only
+	backfill bool
+	retry    config.RetryConfig
+	outbound *outbound
+	codec    compression.Codec
+	// snappyFallback is set once destination rejects codec with 415, writes are compressed with snappy since then
+	snappyFallback atomic.Bool
+}
+
+type destinationState struct {
+	breakerConf config.CircuitBreakerConfig
+	// breaker is nil if circuit breaker is disabled
+	breaker *breaker.Breaker
+	// wal is opened once spooling is enabled, it's kept until all spooled writes are replayed
+	wal *wal.WAL
+	// ingest is the client of grpc transport, it's kept while its config is unchanged
+	ingest     *ingestClient
+	ingestConf ingestClientConf
+}
+
+func newRemoteWriter(conf *config.Config) (*remoteWriter, error) {
+	w := &remoteWriter{
+		states:   map[string]*destinationState{},
+		walConf:  conf.WalConfig,
+		stop:     make(chan struct{}),
+		identity: hostname,
+	}
+	if err := w.ApplyConfig(conf); err != nil {
+		return nil, err
+	}
+	go w.replayLoop()
+	return w, nil
+}
+
+func (w *remoteWriter) ApplyConfig(conf *config.Config) error {
+	u, err := w.prepare(conf)
+	if err != nil {
+		return err
+	}
+	u.commit()
+	return nil
+}
+
+// remoteWriteUpdate holds destinations built from a new config, they're swapped in by commit, or resources
+// opened for them are released by abort
+type remoteWriteUpdate struct {
+	w            *remoteWriter
+	destinations []*destination
+	// states and old states of destinations by name, old state is nil for new destinations
+	states    map[string]*destinationState
+	oldStates map[string]*destinationState
+}
+
+// prepare builds destinations of conf without touching running ones
+func (w *remoteWriter) prepare(conf *config.Config) (*remoteWriteUpdate, error) {
+	w.mu.Lock()
+	oldStates := make(map[string]*destinationState, len(w.states))
+	for name, st := range w.states {
+		oldStates[name] = st
+	}
+	w.mu.Unlock()
+
+	u := &remoteWriteUpdate{w: w, states: map[string]*destinationState{}, oldStates: oldStates}
+	for _, rw := range conf.RemoteWrites() {
+		st, err := w.newState(&rw, conf, oldStates[rw.Name])
 		if err != nil {
-			log.Logger.Error("msg", "build http request error", "err", err)
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
+			u.abort()
+			return nil, fmt.Errorf("remote write %s: %w", rw.Name, err)
+		}
+		u.states[rw.Name] = st
+		d, err := newDestination(&rw, st)
+		if err != nil {
+			u.abort()
+			return nil, fmt.Errorf("remote write %s: %w", rw.Name, err)
+		}
+		d.spool = conf.CircuitBreakerConfig.Spool()
+		if d.spool {
+			d.unreplayable = unreplayableConfigs(&rw, conf)
+		}
+		d.backfill = conf.LateSamplesConfig.Policy == config.LatePolicyBackfill && rw.Name == conf.LateSamplesConfig.BackfillRemoteWrite
+		u.destinations = append(u.destinations, d)
+	}
+	return u, nil
+}
+
+// commit swaps in destinations, ingest clients replaced or of removed destinations are closed
+func (u *remoteWriteUpdate) commit() {
+	w := u.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for name, st := range u.states {
+		old := u.oldStates[name]
+		if old != nil && old.ingest != nil && old.ingest != st.ingest {
+			closeIngestClient(old.ingest)
+		}
+		if st.breaker != nil && (old == nil || old.breaker != st.breaker) {
+			breakerState.WithLabelValues(name).Set(float64(breaker.Closed))
+		}
+		w.states[name] = st
+	}
+	for name, st := range w.states {
+		if _, ok := u.states[name]; !ok && st.ingest != nil {
+			closeIngestClient(st.ingest)
+			st.ingest = nil
 		}
+	}
+	w.destinations.Store(&u.destinations)
+	for _, d := range u.destinations {
+		if len(d.unreplayable) > 0 {
+			log.Logger.Info("msg", "writes with params which can't be replayed are rejected instead of spooled while breaker is open",
+				"destination", d.name, "configs", strings.Join(d.unreplayable, ","))
+		}
+	}
+}
 
-		req.Header.Set("Content-Type", "application/x-protobuf")
-		req.Header.Set("Content-Encoding", "snappy")
-		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+// perRequestTemplate reports whether any template refers to tenant or external labels of writes
+func perRequestTemplate(templates map[string]string) bool {
+	for _, text := range templates {
+		if strings.Contains(text, ".Tenant") || strings.Contains(text, ".ExternalLabels") {
+			return true
+		}
+	}
+	return false
+}
 
-		resp, err := httpclient.Do(req.WithContext(c.Request.Context()))
+// unreplayableConfigs returns configs of the destination whose params depend on inbound requests,
+// they may differ from params of replays, which have no inbound request, tenant or external labels
+func unreplayableConfigs(rw *config.RemoteWriteConfig, conf *config.Config) []string {
+	var configs []string
+	if len(rw.ForwardHeaders) > 0 {
+		configs = append(configs, "forward-headers")
+	}
+	if len(rw.ForwardQueryParams) > 0 {
+		configs = append(configs, "forward-query-params")
+	}
+	if perRequestTemplate(rw.Headers) {
+		configs = append(configs, "headers")
+	}
+	if perRequestTemplate(rw.QueryParams) {
+		configs = append(configs, "query-params")
+	}
+	if conf.TenancyConfig.Enabled {
+		for _, route := range conf.TenancyConfig.Routes {
+			if len(route.Headers) > 0 {
+				configs = append(configs, "tenancy.routes.headers")
+				break
+			}
+		}
+	}
+	return configs
+}
+
+// abort closes ingest clients and wal opened for the update, resources reused from old states are kept
+func (u *remoteWriteUpdate) abort() {
+	for name, st := range u.states {
+		old := u.oldStates[name]
+		if st.ingest != nil && (old == nil || old.ingest != st.ingest) {
+			st.ingest.close()
+		}
+		if st.wal != nil && (old == nil || old.wal != st.wal) {
+			if err := st.wal.Close(); err != nil {
+				log.Logger.Error("msg", "close wal failed", "destination", name, "err", err)
+			}
+		}
+	}
+}
+
+// newState returns state of the destination, breaker is reset only if its config changes, wal is opened once
+// spooling is enabled, and ingest client of grpc transport is rebuilt only if its config changes
+func (w *remoteWriter) newState(rw *config.RemoteWriteConfig, conf *config.Config, old *destinationState) (*destinationState, error) {
+	name := rw.Name
+	st := &destinationState{breakerConf: conf.CircuitBreakerConfig}
+	if old != nil {
+		st.wal = old.wal
+		if reflect.DeepEqual(old.breakerConf, st.breakerConf) {
+			st.breaker = old.breaker
+		}
+	}
+	if rw.Transport == config.ClientGRPC {
+		st.ingestConf = newIngestClientConf(rw)
+		if old != nil && old.ingest != nil && reflect.DeepEqual(old.ingestConf, st.ingestConf) {
+			st.ingest = old.ingest
+		} else {
+			var err error
+			if st.ingest, err = newIngestClient(name, st.ingestConf); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if st.breaker == nil && st.breakerConf.Enabled {
+		st.breaker = breaker.New(&st.breakerConf, func(from, to breaker.State) {
+			log.Logger.Info("msg", "circuit breaker state changed", "destination", name, "from", from.String(), "to", to.String())
+			breakerTransitions.WithLabelValues(name, to.String()).Inc()
+			breakerState.WithLabelValues(name).Set(float64(to))
+		})
+	}
+	if st.wal == nil && st.breakerConf.Spool() {
+		var err error
+		st.wal, err = wal.Open(filepath.Join(w.walConf.Dir, name), w.walConf.MaxBytes, w.walConf.SegmentBytes)
 		if err != nil {
-			log.Logger.Error("msg", "remote write error", "err", err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			if st.ingest != nil && (old == nil || old.ingest != st.ingest) {
+				st.ingest.close()
+			}
+			return nil, fmt.Errorf("open wal failed: %w", err)
+		}
+		walSize.WithLabelValues(name).Set(float64(st.wal.Size()))
+	}
+	return st, nil
+}
+
+// closeIngestClient closes a replaced ingest client once writes of old destinations are done
+func closeIngestClient(c *ingestClient) {
+	time.AfterFunc(ingestClientCloseDelay, c.close)
+}
+
+func newDestination(conf *config.RemoteWriteConfig, st *destinationState) (*destination, error) {
+	tlsConfig, err := utils.NewTLSConfig(&conf.TLSConfig, conf.Insecure)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := relabel.Compile(conf.WriteRelabelConfigs)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := selector.NewFilter(conf.Filter.Allow, conf.Filter.Deny)
+	if err != nil {
+		return nil, err
+	}
+	outbound, err := newOutbound(conf)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := compression.New(conf.Compression, conf.CompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	protoMsg := conf.ProtobufMessage
+	if protoMsg == "" {
+		protoMsg = prompb.ProtoMsgV1
+	}
+	// token requests of oauth2 are sent over http with tls config of destination whatever the transport is
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSClientConfig = tlsConfig
+	var t transport
+	if conf.Transport == config.ClientGRPC {
+		authenticate, err := newAuthenticator(conf, base)
+		if err != nil {
+			return nil, err
+		}
+		t = &grpcTransport{name: conf.Name, client: st.ingest, protoMsg: protoMsg, timeout: conf.Timeout, authenticate: authenticate}
+	} else {
+		roundTripper, err := newAuthRoundTripper(conf, base)
+		if err != nil {
+			return nil, err
+		}
+		t = &httpTransport{
+			name: conf.Name,
+			url:  conf.Url,
+			client: &http.Client{
+				Transport: roundTripper,
+				Timeout:   conf.Timeout,
+			},
+			protoMsg: protoMsg,
+		}
+	}
+
+	return &destination{
+		destinationState:  st,
+		name:              conf.Name,
+		transport:         t,
+		rules:             rules,
+		filter:            filter,
+		protoMsg:          protoMsg,
+		convertHistograms: conf.ConvertNativeHistograms,
+		retry:             conf.Retry,
+		outbound:          outbound,
+		codec:             codec,
+	}, nil
+}
+
+// sendSamples forwards the decoded write request to all destinations concurrently, and late samples to
+// backfill destination. The request fails if any destination fails after retries so that prometheus retries it,
+// writes rejected as bad data are dropped since retrying doesn't help
+func sendSamples(w *remoteWriter, tenants *atomic.Pointer[tenantRouter]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := writeRequest(c)
+		writes := routeWrites(c, *w.destinations.Load(), tenants.Load())
+
+		errs := make([]*sendError, len(writes))
+		var wg sync.WaitGroup
+		for i := range writes {
+			wg.Add(1)
+			go func(i int, wr *routedWrite) {
+				defer wg.Done()
+				d := wr.destination
+				r := relabelRequest(filterRequest(wr.req, d.filter, d.name), d.rules, d.name)
+				params, err := d.params(c.Request, wr, r, w.identity())
+				if err != nil {
+					log.Logger.Error("msg", "render outbound params error", "destination", d.name, "err", err)
+					errs[i] = &sendError{status: http.StatusInternalServerError, err: err}
+					return
+				}
+				errs[i] = d.send(c, r, params)
+			}(i, &writes[i])
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if err == nil {
+				continue
+			}
+			name := writes[i].destination.name
+			if !err.destinationFailure() {
+				remoteWriteRejected.WithLabelValues(name).Inc()
+				log.Logger.Error("msg", "drop write rejected by destination", "destination", name, "status", err.status, "err", err.err)
+				continue
+			}
+			c.AbortWithError(err.status, err.err)
 			return
 		}
-		defer resp.Body.Close()
+		setWrittenHeaders(c, req)
+	}
+}
 
-		if resp.StatusCode != http.StatusOK {
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Logger.Error("msg", "read remote write response error", "err", err)
-				c.AbortWithError(resp.StatusCode, err)
-				return
+// routedWrite is a write request sent to a destination
+type routedWrite struct {
+	destination *destination
+	req         *prompb.WriteRequest
+	// tenant is empty unless tenancy is enabled
+	tenant string
+	// headers are templates of extra headers of the tenant
+	headers map[string]*template.Template
+}
+
+// routeWrites routes the request to destinations, and late samples to backfill destination only.
+// With tenancy, series are split by tenant and series of every tenant are routed to its destinations
+func routeWrites(c *gin.Context, destinations []*destination, router *tenantRouter) []routedWrite {
+	req, late := writeRequest(c), lateRequest(c)
+	var tenants, lateTenants map[string]*prompb.WriteRequest
+	if router != nil {
+		tenants = router.split(c, req)
+		if late != nil {
+			lateTenants = router.split(c, late)
+		}
+	}
+
+	writes := make([]routedWrite, 0, len(destinations))
+	for _, d := range destinations {
+		r, byTenant := req, tenants
+		if d.backfill {
+			if late == nil {
+				continue
+			}
+			r, byTenant = late, lateTenants
+		}
+		if router == nil {
+			writes = append(writes, routedWrite{destination: d, req: r})
+			continue
+		}
+		for tenant, tr := range byTenant {
+			route := router.route(tenant)
+			if route.destinations != nil && !route.destinations[d.name] {
+				continue
+			}
+			writes = append(writes, routedWrite{destination: d, req: tr, tenant: tenant, headers: route.headers})
+		}
+	}
+	return writes
+}
+
+// params renders extra headers and query parameters of the write, inbound is nil for replayed writes.
+// Headers of the tenant override headers of the destination
+func (d *destination) params(inbound *http.Request, wr *routedWrite, req *prompb.WriteRequest, identity string) (*outboundParams, error) {
+	data := &config.OutboundTemplateData{Identity: identity}
+	if wr != nil {
+		data.Tenant = wr.tenant
+	}
+	if req != nil && (d.outbound.templated() || (wr != nil && len(wr.headers) > 0)) {
+		data.ExternalLabels = externalLabels(req)
+	}
+	p, err := d.outbound.render(inbound, data)
+	if err != nil {
+		return nil, err
+	}
+	if wr != nil {
+		if err := renderHeaders(p.header, wr.headers, data); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+type sendError struct {
+	status int
+	err    error
+}
+
+func (d *destination) send(c *gin.Context, req *prompb.WriteRequest, params *outboundParams) *sendError {
+	if len(req.Timeseries) == 0 && len(req.Metadata) == 0 {
+		return nil
+	}
+	if d.convertHistograms {
+		req = convertNativeHistograms(req, d.name)
+	}
+	var raw []byte
+	if d.protoMsg == prompb.ProtoMsgV2 {
+		raw = req.MarshalV2()
+	} else {
+		raw = req.Marshal()
+	}
+	return d.write(c.Request.Context(), raw, params)
+}
+
+// write compresses and sends raw payload with extra params through circuit breaker, it's spooled or rejected
+// while breaker is open. Params are not spooled, so writes are only spooled if replaying renders the same params.
+// Spooled writes are always snappy compressed, they're compressed with the current codec on replay
+func (d *destination) write(ctx context.Context, raw []byte, params *outboundParams) *sendError {
+	if d.breaker == nil {
+		return d.postWithRetry(ctx, raw, params)
+	}
+	if !d.breaker.Allow() {
+		if d.spool && d.replayable(params) {
+			err := d.wal.Append(snappy.Encode(nil, raw))
+			if err == nil {
+				walSpooledRecords.WithLabelValues(d.name).Inc()
+				walSize.WithLabelValues(d.name).Set(float64(d.wal.Size()))
+				return nil
 			}
+			log.Logger.Error("msg", "spool remote write failed", "destination", d.name, "err", err)
+		} else if d.spool {
+			log.Logger.Debug("msg", "reject remote write whose params can't be replayed", "destination", d.name)
+		}
+		return &sendError{status: http.StatusServiceUnavailable, err: fmt.Errorf("circuit breaker of %s is open", d.name)}
+	}
+	err := d.postWithRetry(ctx, raw, params)
+	d.breaker.Record(!err.destinationFailure())
+	return err
+}
 
-			c.AbortWithError(resp.StatusCode, fmt.Errorf("body=%s", body))
+// replayable reports whether params of a write are the same as params of its replay, which has no inbound
+// request, tenant or external labels
+func (d *destination) replayable(params *outboundParams) bool {
+	replay, err := d.params(nil, nil, nil, params.identity)
+	return err == nil && reflect.DeepEqual(params, replay)
+}
+
+// destinationFailure reports whether the error is caused by destination, 4xx except 429 means the data is bad
+func (e *sendError) destinationFailure() bool {
+	return e != nil && (e.status/100 != 4 || e.status == http.StatusTooManyRequests)
+}
+
+// currentCodec returns codec of writes, it's snappy once destination rejects the configured codec
+func (d *destination) currentCodec() compression.Codec {
+	if d.snappyFallback.Load() {
+		return compression.Default
+	}
+	return d.codec
+}
+
+// compress compresses raw payload with the current codec
+func (d *destination) compress(raw []byte) (compression.Codec, []byte, *sendError) {
+	codec := d.currentCodec()
+	body, err := codec.Encode(raw)
+	if err != nil {
+		log.Logger.Error("msg", "compress remote write error", "destination", d.name, "compression", codec.Name(), "err", err)
+		return nil, nil, &sendError{status: http.StatusInternalServerError, err: err}
+	}
+	remoteWriteUncompressedBytes.WithLabelValues(d.name).Add(float64(len(raw)))
+	remoteWriteCompressedBytes.WithLabelValues(d.name, codec.Name()).Add(float64(len(body)))
+	return codec, body, nil
+}
+
+// negotiate falls back to snappy if destination rejects codec as unsupported media type, it reports whether
+// the write should be sent again with snappy
+func (d *destination) negotiate(codec compression.Codec, err *sendError) bool {
+	if err == nil || err.status != http.StatusUnsupportedMediaType || codec.Name() == compression.Snappy {
+		return false
+	}
+	if !d.snappyFallback.Swap(true) {
+		log.Logger.Error("msg", "destination rejects compression, fall back to snappy", "destination", d.name, "compression", codec.Name(), "err", err.err)
+		remoteWriteCompressionFallbacks.WithLabelValues(d.name, codec.Name()).Inc()
+	}
+	return true
+}
+
+// postWithRetry retries failures caused by destination with exponential backoff, the last error is returned
+func (d *destination) postWithRetry(ctx context.Context, raw []byte, params *outboundParams) *sendError {
+	codec, body, err := d.compress(raw)
+	if err != nil {
+		return err
+	}
+	backoff := d.retry.MinBackoff
+	for i := 0; ; i++ {
+		err := d.transport.send(ctx, body, codec.ContentEncoding(), params)
+		if d.negotiate(codec, err) {
+			// sending again with snappy is not a retry
+			if codec, body, err = d.compress(raw); err != nil {
+				return err
+			}
+			i--
+			continue
+		}
+		if !err.destinationFailure() || i >= d.retry.MaxRetries {
+			return err
+		}
+		remoteWriteRetries.WithLabelValues(d.name).Inc()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > d.retry.MaxBackoff {
+			backoff = d.retry.MaxBackoff
+		}
+	}
+}
+
+// replayLoop replays spooled writes to destinations whose breaker is not open, replays are probes in half-open state
+func (w *remoteWriter) replayLoop() {
+	ticker := time.NewTicker(w.walConf.ReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
 			return
+		case <-ticker.C:
+			for _, d := range *w.destinations.Load() {
+				if d.breaker != nil && d.breaker.State() == breaker.Open {
+					continue
+				}
+				if err := d.replay(context.Background(), w.identity()); err != nil {
+					log.Logger.Error("msg", "replay wal failed", "destination", d.name, "err", err)
+				}
+			}
 		}
+	}
+}
 
-		log.Logger.Debug("msg", fmt.Sprintf("remote write to %s success", remoteUrl))
+// replay sends spooled writes in order, it stops at the first failure
+func (d *destination) replay(ctx context.Context, identity string) error {
+	if d.wal == nil || d.wal.Size() == 0 {
+		return nil
+	}
+	params, err := d.params(nil, nil, nil, identity)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		walSize.WithLabelValues(d.name).Set(float64(d.wal.Size()))
+	}()
+	return d.wal.Replay(func(record []byte) error {
+		// replay goes through breaker as well, a failed replay opens it again
+		if d.breaker != nil && !d.breaker.Allow() {
+			return fmt.Errorf("circuit breaker of %s is open", d.name)
+		}
+		codec, body := compression.Default, record
+		if d.currentCodec().Name() != compression.Snappy {
+			raw, err := snappy.Decode(nil, record)
+			if err != nil {
+				log.Logger.Error("msg", "drop corrupted spooled write", "destination", d.name, "err", err)
+				return nil
+			}
+			var sendErr *sendError
+			if codec, body, sendErr = d.compress(raw); sendErr != nil {
+				return sendErr.err
+			}
+		}
+		err := d.transport.send(ctx, body, codec.ContentEncoding(), params)
+		if d.breaker != nil {
+			d.breaker.Record(!err.destinationFailure())
+		}
+		if d.negotiate(codec, err) {
+			// the record is replayed with snappy next time
+			return err.err
+		}
+		if err != nil {
+			// bad data is dropped, otherwise it blocks the wal forever
+			if !err.destinationFailure() {
+				log.Logger.Error("msg", "drop spooled write rejected by destination", "destination", d.name, "err", err.err)
+				return nil
+			}
+			return err.err
+		}
+		walReplayedRecords.WithLabelValues(d.name).Inc()
+		return nil
+	})
+}
+
+// Flush replays spooled writes of all destinations, replay fails if breaker is open
+func (w *remoteWriter) Flush(ctx context.Context) error {
+	for _, d := range *w.destinations.Load() {
+		if err := d.replay(ctx, w.identity()); err != nil {
+			return fmt.Errorf("destination %s: %w", d.name, err)
+		}
+	}
+	return nil
+}
+
+// Close stops replaying, closes ingest clients and wal
+func (w *remoteWriter) Close() error {
+	close(w.stop)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for name, st := range w.states {
+		if st.ingest != nil {
+			st.ingest.close()
+		}
+		if st.wal == nil {
+			continue
+		}
+		if err := st.wal.Close(); err != nil {
+			log.Logger.Error("msg", "close wal failed", "destination", name, "err", err)
+		}
+	}
+	return nil
+}
+
+// breakerStates returns breaker state of destinations, destinations without breaker are always closed
+func (w *remoteWriter) breakerStates() map[string]breaker.State {
+	states := map[string]breaker.State{}
+	for _, d := range *w.destinations.Load() {
+		states[d.name] = breaker.Closed
+		if d.breaker != nil {
+			states[d.name] = d.breaker.State()
+		}
 	}
+	return states
 }
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/histogram"
+	"prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+const reasonLabelLength = "label_length"
+
+// validateSeries drops invalid native histogram samples and exemplars, series left with no data are dropped
+func validateSeries(conf func() *config.SeriesValidationConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vc := conf()
+		req := writeRequest(c)
+		out := req.Timeseries[:0]
+		for _, ts := range req.Timeseries {
+			receivedHistograms.Add(float64(len(ts.Histograms)))
+			receivedExemplars.Add(float64(len(ts.Exemplars)))
+			ts.Histograms = validHistograms(ts.Histograms, vc.NativeHistogramMaxBuckets)
+			ts.Exemplars = validExemplars(ts.Exemplars, vc.ExemplarMaxLabelLength)
+			// exemplar-only series are valid in remote write 1.0
+			if len(ts.Samples) == 0 && len(ts.Histograms) == 0 && len(ts.Exemplars) == 0 {
+				continue
+			}
+			out = append(out, ts)
+		}
+		req.Timeseries = out
+	}
+}
+
+func validHistograms(histograms []prompb.Histogram, maxBuckets int) []prompb.Histogram {
+	out := histograms[:0]
+	for i := range histograms {
+		if err := histogram.Validate(&histograms[i], maxBuckets); err != nil {
+			var ve *histogram.ValidationError
+			if errors.As(err, &ve) {
+				invalidHistograms.WithLabelValues(ve.Reason).Inc()
+			}
+			log.Logger.Debug("msg", "drop invalid native histogram", "err", err)
+			continue
+		}
+		out = append(out, histograms[i])
+	}
+	return out
+}
+
+func validExemplars(exemplars []prompb.Exemplar, maxLabelLength int) []prompb.Exemplar {
+	if maxLabelLength <= 0 {
+		return exemplars
+	}
+	out := exemplars[:0]
+	for _, e := range exemplars {
+		length := 0
+		for _, l := range e.Labels {
+			length += utf8.RuneCountInString(l.Name) + utf8.RuneCountInString(l.Value)
+		}
+		if length > maxLabelLength {
+			invalidExemplars.WithLabelValues(reasonLabelLength).Inc()
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// convertNativeHistograms returns a copy of req with native histograms converted to classic histograms
+func convertNativeHistograms(req *prompb.WriteRequest, destination string) *prompb.WriteRequest {
+	out := &prompb.WriteRequest{
+		Timeseries: make([]prompb.TimeSeries, 0, len(req.Timeseries)),
+		Metadata:   req.Metadata,
+	}
+	for i := range req.Timeseries {
+		ts := &req.Timeseries[i]
+		if len(ts.Histograms) == 0 {
+			out.Timeseries = append(out.Timeseries, *ts)
+			continue
+		}
+		convertedHistograms.WithLabelValues(destination).Add(float64(len(ts.Histograms)))
+		out.Timeseries = append(out.Timeseries, histogram.ToClassic(ts)...)
+	}
+	return out
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+func TestReceiveExemplarOnlySeries(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(c *config.Config)
+	}{
+		{name: "default", configure: func(c *config.Config) {}},
+		{name: "dedup", configure: func(c *config.Config) { c.DedupConfig.Enabled = true }},
+		{name: "late samples", configure: func(c *config.Config) {
+			c.LateSamplesConfig.Policy = config.LatePolicyDrop
+			c.LateSamplesConfig.MaxAge = time.Hour
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newTestUpstream(t)
+			c := newTestConfig(t, upstream.URL)
+			tt.configure(c)
+			s := newTestService(t, c)
+
+			exemplars := []prompb.Exemplar{{
+				Labels:    []prompb.Label{{Name: "trace_id", Value: "abc"}},
+				Value:     1,
+				Timestamp: time.Now().UnixMilli(),
+			}}
+			req := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+				{
+					Labels:    []prompb.Label{{Name: prompb.MetricNameLabel, Value: "requests_total"}},
+					Exemplars: exemplars,
+				},
+				{
+					Labels: []prompb.Label{{Name: prompb.MetricNameLabel, Value: "empty"}},
+				},
+			}}
+			if w := receive(s, req); w.Code/100 != 2 {
+				t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+			}
+
+			series := upstream.series()
+			if len(series) != 1 {
+				t.Fatalf("got %d series, want the exemplar-only series", len(series))
+			}
+			if !reflect.DeepEqual(series[0].Exemplars, exemplars) {
+				t.Errorf("exemplars = %+v, want %+v", series[0].Exemplars, exemplars)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"prometheus-deepflow-adapter/pkg/config"
+)
+
+// newHTTPServer builds server with tls or h2c configured
+func newHTTPServer(addr string, handler http.Handler, conf *config.ServerConfig) (*http.Server, error) {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	if conf.TLS.Enabled() {
+		reloader, err := newTLSReloader(&conf.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("load server tls config failed: %w", err)
+		}
+		srv.TLSConfig = reloader.TLSConfig()
+	} else if conf.H2C {
+		srv.Handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	return srv, nil
+}
+
+// listen creates listener of listen address, a unix domain socket left by the last run is removed
+func listen(addr string) (net.Listener, error) {
+	if path, ok := (&config.ServerConfig{ListenAddress: addr}).UnixSocket(); ok {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func serve(srv *http.Server) error {
+	ln, err := listen(srv.Addr)
+	if err != nil {
+		return err
+	}
+	if srv.TLSConfig != nil {
+		// certificates are provided by tls config
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
+
+func listenAddress(conf *config.Config) string {
+	if conf.ServerConfig.ListenAddress != "" {
+		return conf.ServerConfig.ListenAddress
+	}
+	return fmt.Sprintf(":%d", conf.Port)
+}
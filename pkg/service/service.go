@@ -4,75 +4,243 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"reflect"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
 
+	"prometheus-deepflow-adapter/pkg/auth"
 	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/graphite"
 	"prometheus-deepflow-adapter/pkg/log"
 	"prometheus-deepflow-adapter/pkg/plugins/election"
+	"prometheus-deepflow-adapter/pkg/querier"
+	"prometheus-deepflow-adapter/pkg/relabel"
+	"prometheus-deepflow-adapter/pkg/selector"
 )
 
 type Service struct {
-	conf    *config.Config
-	engine  *gin.Engine
-	elector election.Election
+	// conf is replaced on config reload
+	conf   *atomic.Pointer[config.Config]
+	engine *gin.Engine
+	// elector is nil if election is disabled, followers keep it to take the lock once it's released
+	electorMu sync.RWMutex
+	elector   election.Election
+	remote    *remoteWriter
+	// authChain is replaced on config reload
+	authChain *atomic.Pointer[auth.Chain]
+	// relabelRules are the global relabel rules, they're replaced on config reload
+	relabelRules *atomic.Pointer[[]*relabel.Rule]
+	// filter is the global filter, it's replaced on config reload
+	filter      *atomic.Pointer[selector.Filter]
+	cardinality *cardinalityLimiter
+	metadata    *metadataCache
+	dedup       *deduplicator
+	lateSamples *lateSamples
+	aggregator  *streamAggregator
+	// tenants is replaced when tenancy config changes, it's nil if tenancy is disabled
+	tenants *atomic.Pointer[tenantRouter]
+	// rateLimiter is replaced when rate limit config changes
+	rateLimiter *atomic.Pointer[rateLimiter]
+	// querier serves remote read, it's nil if remote read is disabled
+	querier *atomic.Pointer[querier.Client]
+	// graphiteParser is replaced on config reload
+	graphiteParser *atomic.Pointer[graphite.Parser]
+
+	server      *http.Server
+	adminServer *http.Server
+	// grpcServer serves OTLP/gRPC, it's nil unless otlp grpc listen address is set
+	grpcServer *grpc.Server
+	// aggregateEngine forwards aggregates emitted by stream aggregation
+	aggregateEngine *gin.Engine
+	// graphite serves graphite plaintext, it's nil unless graphite is enabled
+	graphite *graphiteServer
 
 	lastReceiveTime   int64
 	stopLivenessCheck *atomic.Bool
 	retryLock         *time.Ticker
 	keepAlive         *time.Ticker
+	livenessCheck     *time.Ticker
+
+	// forwarding is paused by admin api
+	paused *atomic.Bool
+	// leadership is not acquired again before stepDownUntil(unix nano)
+	stepDownUntil *atomic.Int64
+	flushers      map[string]func(context.Context) error
 }
 
-func NewService(config *config.Config) (*http.Server, error) {
+func NewService(conf *config.Config) (*Service, error) {
+	remote, err := newRemoteWriter(conf)
+	if err != nil {
+		return nil, err
+	}
+	relabelRules, err := relabel.Compile(conf.RelabelConfigs)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := selector.NewFilter(conf.FilterConfig.Allow, conf.FilterConfig.Deny)
+	if err != nil {
+		return nil, err
+	}
+	authChain, err := auth.NewChain(&conf.AuthConfig)
+	if err != nil {
+		return nil, err
+	}
+	q, err := newQuerier(&conf.RemoteReadConfig)
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := newTenantRouter(&conf.TenancyConfig)
+	if err != nil {
+		return nil, err
+	}
+	graphiteParser, err := graphite.NewParser(conf.GraphiteConfig.Templates)
+	if err != nil {
+		return nil, err
+	}
 	s := &Service{
 		engine:            gin.Default(),
-		conf:              config,
+		conf:              &atomic.Pointer[config.Config]{},
+		remote:            remote,
+		authChain:         &atomic.Pointer[auth.Chain]{},
+		relabelRules:      &atomic.Pointer[[]*relabel.Rule]{},
+		filter:            &atomic.Pointer[selector.Filter]{},
+		cardinality:       newCardinalityLimiter(&conf.CardinalityConfig),
+		metadata:          newMetadataCache(&conf.MetadataConfig),
+		dedup:             newDeduplicator(&conf.DedupConfig),
+		lateSamples:       newLateSamples(&conf.LateSamplesConfig),
+		rateLimiter:       &atomic.Pointer[rateLimiter]{},
+		tenants:           &atomic.Pointer[tenantRouter]{},
+		querier:           &atomic.Pointer[querier.Client]{},
+		graphiteParser:    &atomic.Pointer[graphite.Parser]{},
 		lastReceiveTime:   time.Now().UnixNano(),
 		stopLivenessCheck: &atomic.Bool{},
+		paused:            &atomic.Bool{},
+		stepDownUntil:     &atomic.Int64{},
+		flushers:          map[string]func(context.Context) error{},
 	}
+	s.conf.Store(conf)
+	s.authChain.Store(&authChain)
+	s.relabelRules.Store(&relabelRules)
+	s.filter.Store(filter)
+	s.rateLimiter.Store(newRateLimiter(&conf.RateLimitConfig))
+	s.tenants.Store(tenants)
+	s.querier.Store(q)
+	s.graphiteParser.Store(graphiteParser)
+	s.aggregateEngine = s.newAggregateEngine()
+	s.remote.identity = s.identity
+	s.aggregator, err = newStreamAggregator(conf.StreamAggregation, func() bool { return !s.isFollower() }, s.emitAggregates)
+	if err != nil {
+		return nil, err
+	}
+	s.RegisterFlusher("wal", s.remote.Flush)
 	s.injectMiddlewares()
 	s.injectRouters()
+	if err := s.injectAdminRouters(); err != nil {
+		return nil, err
+	}
 
-	if config.ElectionEnabled {
+	if conf.ElectionEnabled {
 		log.Logger.Info("msg", "election enabled, start server election")
 		// TODO: start tracing & inject span to context
 		ctx := context.Background()
-		s.elector = election.StartElection(config)
-		//current elector is nil,for a variety of reasons, so there should be a new election.
-		if s.elector == nil {
-			go s.lockerRetry(ctx)
+		elector, err := election.NewElector(conf)
+		if err != nil {
+			return nil, err
 		}
-		s.keepAlive = time.NewTicker(s.elector.HeartBeat())
-		s.retryLock = time.NewTicker(s.elector.RetryPeriod())
-		if s.elector.IsLeader() {
+		// followers keep the elector, lockerRetry tries to lead with it until the lock is released
+		s.setElector(elector)
+		if err := elector.StartLeading(ctx); err != nil {
+			log.Logger.Debug("msg", "current server is not leader, start retry for leader release", "elector", conf.Elector, "err", err)
+		} else if elector.IsLeader() {
 			log.Logger.Debug("msg", "current server is leader now, start remote write")
-			go s.lockerKeepAlive(ctx)
-		} else {
-			log.Logger.Debug("msg", "current server is not leader, start retry for leader release")
-			go s.lockerRetry(ctx)
 		}
+		s.keepAlive = time.NewTicker(elector.HeartBeat())
+		s.retryLock = time.NewTicker(elector.RetryPeriod())
+		// leadership may be lost(liveness check failed, step down) or acquired at any time, keep both loops running
+		go s.lockerKeepAlive(ctx)
+		go s.lockerRetry(ctx)
 	}
 
-	if s.conf.PrometheusScrapeInterval > 0 {
-		ctx := context.Background()
-		go s.prometheusLivenessCheck(ctx)
+	s.livenessCheck = time.NewTicker(conf.PrometheusLivenessTimeout)
+	go s.prometheusLivenessCheck(context.Background())
+
+	s.server, err = newHTTPServer(listenAddress(conf), s.engine, &conf.ServerConfig)
+	if err != nil {
+		return nil, err
+	}
+	if conf.OTLPConfig.Enabled && conf.OTLPConfig.GRPCListenAddress != "" {
+		s.grpcServer, err = newOTLPGRPCServer(s.engine, &conf.ServerConfig)
+		if err != nil {
+			return nil, err
+		}
 	}
-	svc := &http.Server{Addr: fmt.Sprintf(":%d", config.Port), Handler: s.engine}
-	svc.RegisterOnShutdown(func() {
+	if conf.GraphiteConfig.Enabled {
+		s.graphite = newGraphiteServer(s.graphiteEngine(), &conf.GraphiteConfig)
+	}
+	s.server.RegisterOnShutdown(func() {
 		ctx := context.Background()
 		err := s.Cleanup(ctx)
 		if err != nil {
 			log.Logger.Error("msg", "cleanup failed", "err", err)
 		}
 	})
-	return svc, nil
+	return s, nil
+}
+
+// ListenAndServe starts remote write server and admin server(if it's served on a dedicated port)
+func (s *Service) ListenAndServe() error {
+	if s.adminServer != nil {
+		go func() {
+			log.Logger.Info("msg", "admin server start up", "addr", s.adminServer.Addr)
+			if err := serve(s.adminServer); err != nil && err != http.ErrServerClosed {
+				log.Logger.Error("msg", "admin server start up error", "err", err)
+			}
+		}()
+	}
+	if s.grpcServer != nil {
+		go serveGRPC(s.grpcServer, s.config().OTLPConfig.GRPCListenAddress)
+	}
+	if s.graphite != nil {
+		s.graphite.ListenAndServe()
+	}
+	log.Logger.Info("msg", "remote write server start up", "addr", s.server.Addr, "tls", s.server.TLSConfig != nil)
+	return serve(s.server)
+}
+
+func (s *Service) Shutdown(ctx context.Context) error {
+	if s.adminServer != nil {
+		if err := s.adminServer.Shutdown(ctx); err != nil {
+			log.Logger.Error("msg", "admin server shutdown error", "err", err)
+		}
+	}
+	if s.grpcServer != nil {
+		s.grpcServer.GracefulStop()
+	}
+	if s.graphite != nil {
+		s.graphite.Close()
+	}
+	// leadership is released on server shutdown, so it's checked before
+	leader := !s.isFollower()
+	err := s.server.Shutdown(ctx)
+	// current windows are flushed after the last write, before remote writer is closed
+	s.aggregator.Close(leader)
+	// no more write after server is shut down
+	s.remote.Close()
+	return err
 }
 
 func (s *Service) injectMiddlewares() {
-	s.engine.Use(gin.LoggerWithWriter(log.Logger))
-	s.engine.Use(gin.LoggerWithFormatter(func(params gin.LogFormatterParams) string {
+	s.engine.Use(loggerMiddlewares()...)
+	s.engine.Use(authenticate(s.authChain))
+}
+
+func loggerMiddlewares() []gin.HandlerFunc {
+	return []gin.HandlerFunc{gin.LoggerWithWriter(log.Logger), gin.LoggerWithFormatter(func(params gin.LogFormatterParams) string {
 		return fmt.Sprintf("%s -\"%s %s %s %d %s \"%s\" %s\"",
 			params.ClientIP,
 			params.Method,
@@ -82,7 +250,7 @@ func (s *Service) injectMiddlewares() {
 			params.Latency,
 			params.Request.UserAgent(),
 			params.ErrorMessage)
-	}))
+	})}
 }
 
 func (s *Service) injectRouters() {
@@ -96,14 +264,200 @@ func (s *Service) injectRouters() {
 
 	router := s.engine.Group("")
 	router.GET("/healthz", healthz())
+	router.GET("/readyz", readyz(s.remote, func() bool { return s.config().CircuitBreakerConfig.Spool() }))
+	// with dedup, every instance forwards and duplicated samples of replicas are dropped instead
 	router.POST("/receive", prometheusLiveness(&s.lastReceiveTime,
-		func() bool { return s.conf.ElectionEnabled && !s.elector.IsLeader() }),
-		sendSamples(s.conf.RemoteWriteConfig.Url))
+		func() bool { return s.isFollower() && !s.config().DedupConfig.Enabled }),
+		forwardingGate(s.paused),
+		limitInflight(s.rateLimiter),
+		decodeWriteRequest(func() *config.ServerConfig { return &s.config().ServerConfig }),
+		filterSeries(s.filter),
+		limitRate(s.rateLimiter),
+		identifyTenant(s.tenants),
+		validateSeries(func() *config.SeriesValidationConfig { return &s.config().SeriesValidationConfig }),
+		dedupSeries(s.dedup),
+		cacheMetadata(s.metadata),
+		relabelSeries(s.relabelRules),
+		aggregateSeries(s.aggregator),
+		limitCardinality(s.cardinality),
+		splitLateSamples(s.lateSamples),
+		sendSamples(s.remote, s.tenants))
+	// OTLP pushes aren't replicated like prometheus, so they're forwarded by followers as well
+	router.POST(otlpMetricsPath, forwardingGate(s.paused),
+		limitInflight(s.rateLimiter),
+		decodeOTLP(func() *config.OTLPConfig { return &s.config().OTLPConfig },
+			func() *config.ServerConfig { return &s.config().ServerConfig }),
+		filterSeries(s.filter),
+		limitRate(s.rateLimiter),
+		identifyTenant(s.tenants),
+		validateSeries(func() *config.SeriesValidationConfig { return &s.config().SeriesValidationConfig }),
+		cacheMetadata(s.metadata),
+		relabelSeries(s.relabelRules),
+		aggregateSeries(s.aggregator),
+		limitCardinality(s.cardinality),
+		splitLateSamples(s.lateSamples),
+		sendSamples(s.remote, s.tenants),
+		otlpResponse())
+	router.POST(influxWritePath, leaderGate(s.isFollower),
+		forwardingGate(s.paused),
+		limitInflight(s.rateLimiter),
+		decodeInflux(func() *config.InfluxConfig { return &s.config().InfluxConfig },
+			func() *config.ServerConfig { return &s.config().ServerConfig }),
+		filterSeries(s.filter),
+		limitRate(s.rateLimiter),
+		identifyTenant(s.tenants),
+		validateSeries(func() *config.SeriesValidationConfig { return &s.config().SeriesValidationConfig }),
+		cacheMetadata(s.metadata),
+		relabelSeries(s.relabelRules),
+		aggregateSeries(s.aggregator),
+		limitCardinality(s.cardinality),
+		splitLateSamples(s.lateSamples),
+		sendSamples(s.remote, s.tenants),
+		influxResponse())
+	router.POST("/read", remoteRead(s.querier, func() *config.ServerConfig { return &s.config().ServerConfig }))
+	router.GET(metadataPath, listMetadata(s.metadata))
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+}
+
+// graphiteEngine handles graphite batches read by graphite server, it's not exposed on http server,
+// graphite has no authentication
+func (s *Service) graphiteEngine() *gin.Engine {
+	engine := gin.New()
+	engine.POST(graphitePath, leaderGate(s.isFollower),
+		forwardingGate(s.paused),
+		limitInflight(s.rateLimiter),
+		decodeGraphite(s.graphiteParser),
+		filterSeries(s.filter),
+		limitRate(s.rateLimiter),
+		identifyTenant(s.tenants),
+		validateSeries(func() *config.SeriesValidationConfig { return &s.config().SeriesValidationConfig }),
+		cacheMetadata(s.metadata),
+		relabelSeries(s.relabelRules),
+		aggregateSeries(s.aggregator),
+		limitCardinality(s.cardinality),
+		splitLateSamples(s.lateSamples),
+		sendSamples(s.remote, s.tenants))
+	return engine
+}
+
+// newAggregateEngine forwards aggregates emitted by stream aggregation, it's not exposed on http server.
+// Aggregates are built from processed series, so they skip validation, filter and relabeling
+func (s *Service) newAggregateEngine() *gin.Engine {
+	engine := gin.New()
+	engine.POST(aggregatePath, forwardingGate(s.paused),
+		decodeWriteRequest(func() *config.ServerConfig { return &s.config().ServerConfig }),
+		limitCardinality(s.cardinality),
+		sendSamples(s.remote, s.tenants))
+	return engine
+}
+
+// isFollower reports whether writes should be dropped as another instance is the leader
+func (s *Service) isFollower() bool {
+	if !s.config().ElectionEnabled {
+		return false
+	}
+	elector := s.getElector()
+	return elector == nil || !elector.IsLeader()
+}
+
+// getElector returns the elector, it's nil if election is disabled
+func (s *Service) getElector() election.Election {
+	s.electorMu.RLock()
+	defer s.electorMu.RUnlock()
+	return s.elector
+}
+
+func (s *Service) setElector(elector election.Election) {
+	s.electorMu.Lock()
+	defer s.electorMu.Unlock()
+	s.elector = elector
+}
+
+// identity returns the elector identity, or the hostname if the elector doesn't expose one
+func (s *Service) identity() string {
+	if inspector, ok := s.getElector().(election.Inspector); ok {
+		return inspector.Identity()
+	}
+	return hostname()
+}
+
+func (s *Service) config() *config.Config {
+	return s.conf.Load()
+}
+
+// ApplyConfig applies reloaded config to running components, config which requires restart should be rejected
+// before calling it. All components are built before any of them is swapped in, so a failed reload changes nothing
+func (s *Service) ApplyConfig(c *config.Config) error {
+	if !log.ValidLevel(c.LogLevel) {
+		return fmt.Errorf("unknown log level: %s", c.LogLevel)
+	}
+	relabelRules, err := relabel.Compile(c.RelabelConfigs)
+	if err != nil {
+		return fmt.Errorf("apply relabel config failed: %w", err)
+	}
+	filter, err := selector.NewFilter(c.FilterConfig.Allow, c.FilterConfig.Deny)
+	if err != nil {
+		return fmt.Errorf("apply filter config failed: %w", err)
+	}
+	authChain, err := auth.NewChain(&c.AuthConfig)
+	if err != nil {
+		return fmt.Errorf("apply auth config failed: %w", err)
+	}
+	q, err := newQuerier(&c.RemoteReadConfig)
+	if err != nil {
+		return fmt.Errorf("apply remote read config failed: %w", err)
+	}
+	graphiteParser, err := graphite.NewParser(c.GraphiteConfig.Templates)
+	if err != nil {
+		return fmt.Errorf("apply graphite config failed: %w", err)
+	}
+	tenants, err := newTenantRouter(&c.TenancyConfig)
+	if err != nil {
+		return fmt.Errorf("apply tenancy config failed: %w", err)
+	}
+	commitAggregator, err := s.aggregator.prepare(c.StreamAggregation)
+	if err != nil {
+		return fmt.Errorf("apply stream aggregation config failed: %w", err)
+	}
+	// remote writes are built last, since they may open wal and connections which are released on failure
+	remote, err := s.remote.prepare(c)
+	if err != nil {
+		return fmt.Errorf("apply remote write config failed: %w", err)
+	}
+
+	remote.commit()
+	commitAggregator()
+	_ = log.Logger.SetLevel(c.LogLevel)
+	s.authChain.Store(&authChain)
+	s.relabelRules.Store(&relabelRules)
+	s.filter.Store(filter)
+	s.querier.Store(q)
+	s.graphiteParser.Store(graphiteParser)
+	s.cardinality.ApplyConfig(&c.CardinalityConfig)
+	s.metadata.ApplyConfig(&c.MetadataConfig)
+	s.dedup.ApplyConfig(&c.DedupConfig)
+	s.lateSamples.ApplyConfig(&c.LateSamplesConfig)
+	if !reflect.DeepEqual(c.RateLimitConfig, s.config().RateLimitConfig) {
+		s.rateLimiter.Store(newRateLimiter(&c.RateLimitConfig))
+	}
+	if !reflect.DeepEqual(c.TenancyConfig, s.config().TenancyConfig) {
+		s.tenants.Store(tenants)
+	}
+	if c.PrometheusLivenessTimeout != s.config().PrometheusLivenessTimeout {
+		s.livenessCheck.Reset(c.PrometheusLivenessTimeout)
+	}
+	s.conf.Store(c)
+	return nil
 }
 
 func (s *Service) Cleanup(ctx context.Context) error {
 	log.Logger.Info("msg", "service cleanup start")
-	err := s.elector.Release(ctx)
+	// elector is nil if election is disabled
+	elector := s.getElector()
+	if elector == nil {
+		return nil
+	}
+	err := elector.Release(ctx)
 	if err != nil {
 		return err
 	}
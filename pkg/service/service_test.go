@@ -0,0 +1,99 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/snappy"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testUpstream records remote writes it receives
+type testUpstream struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*prompb.WriteRequest
+}
+
+func newTestUpstream(t *testing.T) *testUpstream {
+	u := &testUpstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			body, err = snappy.Decode(nil, body)
+		}
+		req := &prompb.WriteRequest{}
+		if err == nil {
+			err = req.Unmarshal(body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		u.mu.Lock()
+		u.requests = append(u.requests, req)
+		u.mu.Unlock()
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *testUpstream) series() []prompb.TimeSeries {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var out []prompb.TimeSeries
+	for _, req := range u.requests {
+		out = append(out, req.Timeseries...)
+	}
+	return out
+}
+
+// newTestConfig returns the default config forwarding to url without election
+func newTestConfig(t *testing.T, url string) *config.Config {
+	t.Helper()
+	c := config.NewConfig()
+	if err := c.ToOptions().Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	c.ElectionEnabled = false
+	c.RemoteWriteConfig.Url = url
+	return c
+}
+
+func newTestService(t *testing.T, c *config.Config) *Service {
+	t.Helper()
+	s, err := NewService(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.livenessCheck.Stop()
+		s.stopLivenessCheck.Store(true)
+	})
+	return s
+}
+
+// receive posts a snappy compressed remote write 1.0 request to /receive
+func receive(s *Service, req *prompb.WriteRequest) *httptest.ResponseRecorder {
+	return post(s, "/receive", "snappy", snappy.Encode(nil, req.Marshal()))
+}
+
+func post(s *Service, path, contentEncoding string, body []byte) *httptest.ResponseRecorder {
+	httpReq := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("Content-Encoding", contentEncoding)
+	w := httptest.NewRecorder()
+	s.engine.ServeHTTP(w, httpReq)
+	return w
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// tenantKey is the tenant of request with header or principal source
+const tenantKey = "deepflow-adapter/tenant"
+
+const (
+	rateLimitTenant = "tenant"
+
+	reasonNoTenant      = "no_tenant"
+	reasonUnknownTenant = "unknown_tenant"
+
+	// unknownTenant is the tenant label of metrics of rejected tenants
+	unknownTenant = "unknown"
+)
+
+// tenantIdleTimeout removes rate limiters of tenants which send nothing in it
+const tenantIdleTimeout = 10 * time.Minute
+
+// tenantRouter is replaced when tenancy config changes, it's nil if tenancy is disabled
+type tenantRouter struct {
+	conf   config.TenancyConfig
+	routes map[string]*tenantRoute
+
+	mu        sync.Mutex
+	limiters  map[string]*clientBuckets
+	lastPrune time.Time
+}
+
+type tenantRoute struct {
+	// destinations are names of routed destinations, nil means all destinations
+	destinations map[string]bool
+	// headers are templates of extra outbound headers, rendered like headers of destinations
+	headers map[string]*template.Template
+	limits  config.RateLimits
+}
+
+func newTenantRouter(conf *config.TenancyConfig) (*tenantRouter, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	r := &tenantRouter{
+		conf:      *conf,
+		routes:    map[string]*tenantRoute{},
+		limiters:  map[string]*clientBuckets{},
+		lastPrune: time.Now(),
+	}
+	for _, rc := range conf.Routes {
+		route := &tenantRoute{headers: map[string]*template.Template{}, limits: rc.RateLimit}
+		for name, text := range rc.Headers {
+			t, err := config.ParseOutboundTemplate(name, text)
+			if err != nil {
+				return nil, fmt.Errorf("header %s of tenants %v: %w", name, rc.Tenants, err)
+			}
+			route.headers[name] = t
+		}
+		if len(rc.RemoteWrites) > 0 {
+			route.destinations = map[string]bool{}
+			for _, name := range rc.RemoteWrites {
+				route.destinations[name] = true
+			}
+		}
+		for _, tenant := range rc.Tenants {
+			r.routes[tenant] = route
+		}
+	}
+	return r, nil
+}
+
+// route returns route of the tenant, nil if the tenant has no route
+func (r *tenantRouter) route(tenant string) *tenantRoute {
+	if route, ok := r.routes[tenant]; ok {
+		return route
+	}
+	return r.routes[config.TenantWildcard]
+}
+
+// requestTenant returns tenant of the request with header or principal source, it falls back to default tenant
+func (r *tenantRouter) requestTenant(c *gin.Context) string {
+	if tenant, ok := c.Get(tenantKey); ok {
+		return tenant.(string)
+	}
+	var tenant string
+	switch r.conf.Source {
+	case config.TenantSourceHeader:
+		tenant = c.GetHeader(r.conf.Header)
+	case config.TenantSourcePrincipal:
+		if p := principal(c); p != nil {
+			tenant = p.Name
+		}
+	}
+	if tenant == "" {
+		return r.conf.DefaultTenant
+	}
+	return tenant
+}
+
+// seriesTenant returns tenant of the series with label source, it falls back to default tenant
+func (r *tenantRouter) seriesTenant(labels []prompb.Label) string {
+	if tenant := prompb.LabelValue(labels, r.conf.Label); tenant != "" {
+		return tenant
+	}
+	return r.conf.DefaultTenant
+}
+
+// split groups series by tenant, series without routed tenant are dropped
+func (r *tenantRouter) split(c *gin.Context, req *prompb.WriteRequest) map[string]*prompb.WriteRequest {
+	if r.conf.Source != config.TenantSourceLabel {
+		tenant := r.requestTenant(c)
+		if reason := r.reject(tenant); reason != "" {
+			tenantDroppedSeries.WithLabelValues(reason).Add(float64(len(req.Timeseries)))
+			return nil
+		}
+		return map[string]*prompb.WriteRequest{tenant: req}
+	}
+	tenants := map[string]*prompb.WriteRequest{}
+	for _, ts := range req.Timeseries {
+		tenant := r.seriesTenant(ts.Labels)
+		if reason := r.reject(tenant); reason != "" {
+			tenantDroppedSeries.WithLabelValues(reason).Inc()
+			continue
+		}
+		tr, ok := tenants[tenant]
+		if !ok {
+			tr = &prompb.WriteRequest{Metadata: req.Metadata}
+			tenants[tenant] = tr
+		}
+		tr.Timeseries = append(tr.Timeseries, ts)
+	}
+	return tenants
+}
+
+// metricTenant is the tenant label of metrics. Tenants are set by clients, so only tenants with their own routes
+// are labeled by name, tenants of the wildcard route are labeled by the wildcard, and rejected ones by unknown
+func (r *tenantRouter) metricTenant(tenant string) string {
+	if _, ok := r.routes[tenant]; ok {
+		return tenant
+	}
+	if r.route(tenant) != nil {
+		return config.TenantWildcard
+	}
+	return unknownTenant
+}
+
+// reject returns the reason if series of the tenant are rejected
+func (r *tenantRouter) reject(tenant string) string {
+	switch {
+	case tenant == "":
+		return reasonNoTenant
+	case r.route(tenant) == nil:
+		return reasonUnknownTenant
+	default:
+		return ""
+	}
+}
+
+// buckets returns rate limiter of the tenant, nil if the tenant is not limited
+func (r *tenantRouter) buckets(tenant string, now time.Time) *buckets {
+	limits := r.route(tenant).limits
+	if !limits.Enabled() {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.lastPrune) > tenantIdleTimeout {
+		for k, cb := range r.limiters {
+			if now.Sub(cb.lastSeen) > tenantIdleTimeout {
+				delete(r.limiters, k)
+			}
+		}
+		r.lastPrune = now
+	}
+	cb, ok := r.limiters[tenant]
+	if !ok {
+		cb = &clientBuckets{buckets: newBuckets(&limits)}
+		r.limiters[tenant] = cb
+	}
+	cb.lastSeen = now
+	return cb.buckets
+}
+
+// identifyTenant rejects requests without routed tenant with header or principal source, and throttles tenants
+// over their rate limits. With label source, series are split by tenant when they're sent, after relabeling
+func identifyTenant(p *atomic.Pointer[tenantRouter]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r := p.Load()
+		if r == nil {
+			return
+		}
+		req := writeRequest(c)
+		samples := map[string]int{}
+		bytes := 0
+		if r.conf.Source != config.TenantSourceLabel {
+			tenant := r.requestTenant(c)
+			if reason := r.reject(tenant); reason != "" {
+				tenantRejectedRequests.WithLabelValues(unknownTenant, reason).Inc()
+				c.AbortWithError(http.StatusBadRequest, fmt.Errorf("%s: %q", strings.ReplaceAll(reason, "_", " "), tenant))
+				return
+			}
+			c.Set(tenantKey, tenant)
+			samples[tenant], bytes = countSamples(req), payloadSize(c)
+		} else {
+			for i := range req.Timeseries {
+				ts := &req.Timeseries[i]
+				if tenant := r.seriesTenant(ts.Labels); r.reject(tenant) == "" {
+					samples[tenant] += len(ts.Samples) + len(ts.Histograms)
+				}
+			}
+		}
+
+		// tenants are limited in order, so concurrent requests of several tenants reserve tokens in the same order
+		tenants := make([]string, 0, len(samples))
+		for tenant := range samples {
+			tenants = append(tenants, tenant)
+		}
+		sort.Strings(tenants)
+		now := time.Now()
+		var cancels []func()
+		for _, tenant := range tenants {
+			b := r.buckets(tenant, now)
+			if b == nil {
+				continue
+			}
+			cancel, delay, reason := b.reserve(now, samples[tenant], bytes)
+			if delay > 0 {
+				for _, cancel := range cancels {
+					cancel()
+				}
+				tenantRejectedRequests.WithLabelValues(r.metricTenant(tenant), reason).Inc()
+				throttle(c, rateLimitTenant, reason, delay)
+				return
+			}
+			cancels = append(cancels, cancel)
+		}
+		for tenant, n := range samples {
+			tenantReceivedSamples.WithLabelValues(r.metricTenant(tenant)).Add(float64(n))
+		}
+	}
+}
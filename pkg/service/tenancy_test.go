@@ -0,0 +1,64 @@
+package service
+
+import (
+	"testing"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+func TestMetricTenant(t *testing.T) {
+	conf := &config.TenancyConfig{Enabled: true, Routes: []config.TenantRoute{{Tenants: []string{"team-a"}}}}
+	wildcard := &config.TenancyConfig{Enabled: true, Routes: []config.TenantRoute{
+		{Tenants: []string{"team-a"}},
+		{Tenants: []string{config.TenantWildcard}},
+	}}
+	tests := []struct {
+		name   string
+		conf   *config.TenancyConfig
+		tenant string
+		want   string
+	}{
+		{name: "routed", conf: conf, tenant: "team-a", want: "team-a"},
+		{name: "unknown", conf: conf, tenant: "team-b", want: unknownTenant},
+		{name: "empty", conf: conf, tenant: "", want: unknownTenant},
+		{name: "own route with wildcard", conf: wildcard, tenant: "team-a", want: "team-a"},
+		{name: "wildcard route", conf: wildcard, tenant: "team-b", want: config.TenantWildcard},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newTenantRouter(tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.metricTenant(tt.tenant); got != tt.want {
+				t.Errorf("metricTenant(%q) = %q, want %q", tt.tenant, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantRouteHeaders(t *testing.T) {
+	r, err := newTenantRouter(&config.TenancyConfig{Enabled: true, Routes: []config.TenantRoute{{
+		Tenants: []string{config.TenantWildcard},
+		Headers: map[string]string{"X-Scope-OrgID": "{{.Tenant}}", "X-Env": `{{index .ExternalLabels "env"}}`},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &destination{outbound: &outbound{}}
+	req := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "env", Value: "prod"}}},
+	}}
+	// route headers are rendered like headers of destinations
+	p, err := d.params(nil, &routedWrite{tenant: "team-b", headers: r.route("team-b").headers}, req, "server-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.header.Get("X-Scope-OrgID"); got != "team-b" {
+		t.Errorf("X-Scope-OrgID = %q, want team-b", got)
+	}
+	if got := p.header.Get("X-Env"); got != "prod" {
+		t.Errorf("X-Env = %q, want prod", got)
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/config"
+	"prometheus-deepflow-adapter/pkg/log"
+)
+
+// tlsReloader loads certificates again once cert, key or client ca file changes,
+// so rotated certificates(e.g. by cert-manager) take effect without restart
+type tlsReloader struct {
+	conf *config.ServerTLSConfig
+	base *tls.Config
+
+	mu       sync.Mutex
+	modTimes [3]time.Time
+	current  *tls.Config
+}
+
+func newTLSReloader(conf *config.ServerTLSConfig) (*tlsReloader, error) {
+	base := &tls.Config{
+		MinVersion: config.TLSVersions[conf.MinVersion],
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	for _, name := range conf.CipherSuites {
+		id, _ := config.CipherSuite(name)
+		base.CipherSuites = append(base.CipherSuites, id)
+	}
+	if conf.ClientCAFile != "" {
+		base.ClientAuth = config.ClientAuthTypes[conf.ClientAuthType]
+	}
+
+	r := &tlsReloader{conf: conf, base: base}
+	if _, err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the tls config for http server, certificates are resolved per handshake
+func (r *tlsReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.base.MinVersion,
+		NextProtos: r.base.NextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.load()
+		},
+		// never called since GetConfigForClient returns certificates, but http.Server requires a certificate source
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cfg, err := r.load()
+			if err != nil {
+				return nil, err
+			}
+			return &cfg.Certificates[0], nil
+		},
+	}
+}
+
+func (r *tlsReloader) load() (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTimes, err := r.statFiles()
+	if err != nil {
+		if r.current != nil {
+			// files may be replaced at the moment, keep serving with the loaded ones
+			log.Logger.Error("msg", "stat server tls files failed", "err", err)
+			return r.current, nil
+		}
+		return nil, err
+	}
+	if r.current != nil && modTimes == r.modTimes {
+		return r.current, nil
+	}
+
+	cfg := r.base.Clone()
+	cert, err := tls.LoadX509KeyPair(r.conf.CertFile, r.conf.KeyFile)
+	if err != nil {
+		return r.fallback(modTimes, err)
+	}
+	cfg.Certificates = []tls.Certificate{cert}
+
+	if r.conf.ClientCAFile != "" {
+		ca, err := os.ReadFile(r.conf.ClientCAFile)
+		if err != nil {
+			return r.fallback(modTimes, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return r.fallback(modTimes, fmt.Errorf("no certificate found in client ca file %s", r.conf.ClientCAFile))
+		}
+		cfg.ClientCAs = pool
+	}
+
+	if r.current != nil {
+		log.Logger.Info("msg", "server tls certificates reloaded")
+	}
+	r.current = cfg
+	r.modTimes = modTimes
+	return cfg, nil
+}
+
+// fallback keeps serving with the loaded certificates, it tries again when files change next time
+func (r *tlsReloader) fallback(modTimes [3]time.Time, err error) (*tls.Config, error) {
+	if r.current == nil {
+		return nil, err
+	}
+	log.Logger.Error("msg", "reload server tls certificates failed", "err", err)
+	r.modTimes = modTimes
+	return r.current, nil
+}
+
+func (r *tlsReloader) statFiles() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, file := range []string{r.conf.CertFile, r.conf.KeyFile, r.conf.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
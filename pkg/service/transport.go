@@ -0,0 +1,125 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"prometheus-deepflow-adapter/pkg/log"
+	"prometheus-deepflow-adapter/pkg/prompb"
+)
+
+// transport sends a compressed remote write payload to destination once, retries, breaker and compression
+// fallback are up to destination. Errors carry http status codes whatever the protocol is
+type transport interface {
+	send(ctx context.Context, body []byte, contentEncoding string, params *outboundParams) *sendError
+}
+
+// httpTransport posts writes to remote write url of destination
+type httpTransport struct {
+	name     string
+	url      string
+	client   *http.Client
+	protoMsg string
+}
+
+func (t *httpTransport) send(ctx context.Context, body []byte, contentEncoding string, params *outboundParams) *sendError {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		log.Logger.Error("msg", "build http request error", "destination", t.name, "err", err)
+		return &sendError{status: http.StatusInternalServerError, err: err}
+	}
+
+	if len(params.query) > 0 {
+		query := httpReq.URL.Query()
+		for name, values := range params.query {
+			query[name] = values
+		}
+		httpReq.URL.RawQuery = query.Encode()
+	}
+	for name, values := range params.header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Content-Type", contentType(t.protoMsg))
+	httpReq.Header.Set("Content-Encoding", contentEncoding)
+	if t.protoMsg == prompb.ProtoMsgV2 {
+		httpReq.Header.Set(remoteWriteVersionHeader, remoteWriteVersion2)
+	} else {
+		httpReq.Header.Set(remoteWriteVersionHeader, remoteWriteVersion1)
+	}
+
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		log.Logger.Error("msg", "remote write error", "destination", t.name, "err", err)
+		return &sendError{status: http.StatusInternalServerError, err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			log.Logger.Error("msg", "read remote write response error", "destination", t.name, "err", err)
+			return &sendError{status: resp.StatusCode, err: err}
+		}
+		return &sendError{status: resp.StatusCode, err: fmt.Errorf("destination=%s body=%s", t.name, respBody)}
+	}
+
+	log.Logger.Debug("msg", fmt.Sprintf("remote write to %s success", t.url), "destination", t.name)
+	return nil
+}
+
+// grpcTransport streams writes to deepflow ingesters through the ingest client of destination, which is kept
+// across config reloads. Outbound headers and auth headers are sent in every message
+type grpcTransport struct {
+	name     string
+	client   *ingestClient
+	protoMsg string
+	timeout  time.Duration
+	// authenticate is nil if destination has no auth method
+	authenticate func(req *http.Request) error
+}
+
+func (t *grpcTransport) send(ctx context.Context, body []byte, contentEncoding string, params *outboundParams) *sendError {
+	header := params.header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if t.authenticate != nil {
+		// auth methods set headers only, so they're applied to a request without url and body
+		req := (&http.Request{Method: "POST", Header: header}).WithContext(ctx)
+		if err := t.authenticate(req); err != nil {
+			log.Logger.Error("msg", "authenticate remote write error", "destination", t.name, "err", err)
+			return &sendError{status: http.StatusInternalServerError, err: fmt.Errorf("authenticate remote write failed: %w", err)}
+		}
+	}
+	req := &prompb.IngestRequest{
+		ProtobufMessage: t.protoMsg,
+		ContentEncoding: contentEncoding,
+		Payload:         body,
+		Headers:         make(map[string]string, len(header)),
+	}
+	for name, values := range header {
+		req.Headers[name] = strings.Join(values, ", ")
+	}
+
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+	resp, err := t.client.write(ctx, req)
+	if err != nil {
+		log.Logger.Error("msg", "remote write error", "destination", t.name, "err", err)
+		return &sendError{status: http.StatusServiceUnavailable, err: err}
+	}
+	if resp.Status/100 != 2 {
+		return &sendError{status: int(resp.Status), err: fmt.Errorf("destination=%s body=%s", t.name, resp.Message)}
+	}
+
+	log.Logger.Debug("msg", "remote write to ingesters success", "destination", t.name)
+	return nil
+}
@@ -0,0 +1,254 @@
+// Package wal is a size bounded write ahead log of opaque records, records are replayed in order
+// and segments are removed once all of their records are replayed
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrFull = errors.New("wal is full")
+
+// record header: payload length and crc32 of payload
+const headerSize = 8
+
+// tmpSuffix is the suffix of segments being rewritten, they're left behind only if process crashes during rewrite
+const tmpSuffix = ".tmp"
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type WAL struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu sync.Mutex
+	// segments are sealed segment indexes in order, the current one is not included
+	segments    []int
+	current     *os.File
+	currentIdx  int
+	currentSize int64
+	size        int64
+
+	// replayMu serializes replays
+	replayMu sync.Mutex
+}
+
+// Open opens wal in dir, it's created if it doesn't exist
+func Open(dir string, maxBytes, segmentBytes int64) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &WAL{dir: dir, maxBytes: maxBytes, segmentBytes: segmentBytes}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), tmpSuffix) && !e.IsDir() {
+			// the segment is intact since it's replaced by rename
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		idx, err := strconv.Atoi(e.Name())
+		if err != nil || e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		w.segments = append(w.segments, idx)
+		w.size += info.Size()
+		if idx >= w.currentIdx {
+			w.currentIdx = idx + 1
+		}
+	}
+	sort.Ints(w.segments)
+	return w, nil
+}
+
+func (w *WAL) segmentPath(idx int) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%08d", idx))
+}
+
+// Append writes a record, ErrFull is returned if wal would exceed max bytes
+func (w *WAL) Append(record []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := int64(headerSize + len(record))
+	if w.size+n > w.maxBytes {
+		return ErrFull
+	}
+	if w.current != nil && w.currentSize+n > w.segmentBytes {
+		if err := w.seal(); err != nil {
+			return err
+		}
+	}
+	if w.current == nil {
+		f, err := os.OpenFile(w.segmentPath(w.currentIdx), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		w.current, w.currentSize = f, 0
+	}
+
+	buf := make([]byte, n)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(record)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(record, castagnoli))
+	copy(buf[headerSize:], record)
+	if _, err := w.current.Write(buf); err != nil {
+		return err
+	}
+	w.currentSize += n
+	w.size += n
+	return nil
+}
+
+// seal closes current segment so that it can be replayed
+func (w *WAL) seal() error {
+	if w.current == nil {
+		return nil
+	}
+	if err := w.current.Sync(); err != nil {
+		return err
+	}
+	if err := w.current.Close(); err != nil {
+		return err
+	}
+	w.segments = append(w.segments, w.currentIdx)
+	w.current = nil
+	w.currentIdx++
+	return nil
+}
+
+// Size returns bytes of all records in wal
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+// Replay calls fn with records in order and removes replayed segments, it stops at the first error
+// and replayed records of the segment are cut, so that it's replayed from the failed record next time.
+// Records appended during replay are not replayed
+func (w *WAL) Replay(fn func(record []byte) error) error {
+	w.replayMu.Lock()
+	defer w.replayMu.Unlock()
+
+	w.mu.Lock()
+	if err := w.seal(); err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	segments := append([]int(nil), w.segments...)
+	w.mu.Unlock()
+
+	for _, idx := range segments {
+		path := w.segmentPath(idx)
+		if offset, err := replaySegment(path, fn); err != nil {
+			if offset > 0 {
+				if cutErr := cutSegment(path, offset); cutErr != nil {
+					return fmt.Errorf("%w, and cut replayed records failed: %v", err, cutErr)
+				}
+				w.mu.Lock()
+				w.size -= offset
+				w.mu.Unlock()
+			}
+			return err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		w.mu.Lock()
+		w.segments = w.segments[1:]
+		w.size -= info.Size()
+		w.mu.Unlock()
+	}
+	return nil
+}
+
+// replaySegment stops silently at a torn or corrupted record, it happens when process crashes during append.
+// On error it returns offset of the failed record
+func replaySegment(path string, fn func([]byte) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, headerSize)
+	var offset int64
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, nil
+		}
+		record := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		if _, err := io.ReadFull(r, record); err != nil {
+			return 0, nil
+		}
+		if crc32.Checksum(record, castagnoli) != binary.BigEndian.Uint32(header[4:8]) {
+			return 0, nil
+		}
+		if err := fn(record); err != nil {
+			return offset, err
+		}
+		offset += int64(headerSize + len(record))
+	}
+}
+
+// cutSegment removes records before offset, the rest is written to a temporary file which replaces the segment
+func cutSegment(path string, offset int64) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	tmp := path + tmpSuffix
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Close syncs and closes current segment
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seal()
+}
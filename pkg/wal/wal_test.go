@@ -0,0 +1,85 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func appendRecords(t *testing.T, w *WAL, records ...string) {
+	t.Helper()
+	for _, r := range records {
+		if err := w.Append([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// replay replays records until fail records are sent, fail < 0 means no failure
+func replay(w *WAL, fail int) ([]string, error) {
+	var sent []string
+	err := w.Replay(func(record []byte) error {
+		if len(sent) == fail {
+			return errors.New("send failed")
+		}
+		sent = append(sent, string(record))
+		return nil
+	})
+	return sent, err
+}
+
+func TestReplayResumesAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	// 3 records of 9 bytes per segment
+	w, err := Open(dir, 1<<20, 27)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []string
+	for i := 0; i < 7; i++ {
+		records = append(records, fmt.Sprintf("r%d", i))
+	}
+	appendRecords(t, w, records...)
+
+	sent, err := replay(w, 4)
+	if err == nil {
+		t.Fatal("replay succeeded, want error")
+	}
+	if !reflect.DeepEqual(sent, records[:4]) {
+		t.Fatalf("sent = %v, want %v", sent, records[:4])
+	}
+	if got, want := w.Size(), int64(3*(headerSize+2)); got != want {
+		t.Errorf("size = %d, want %d", got, want)
+	}
+
+	// records after the failed one are replayed once, also after reopening
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(w.segmentPath(0)+tmpSuffix, []byte("torn"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err = Open(dir, 1<<20, 27)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, err = replay(w, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sent, records[4:]) {
+		t.Errorf("sent = %v, want %v", sent, records[4:])
+	}
+	if w.Size() != 0 {
+		t.Errorf("size = %d, want 0", w.Size())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d files are left in wal dir", len(entries))
+	}
+}